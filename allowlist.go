@@ -0,0 +1,63 @@
+package main
+
+import "strings"
+
+// PolicyMode values
+const (
+	PolicyDenylist  = "denylist"  // opens of files matching DisallowedPatterns are violations
+	PolicyAllowlist = "allowlist" // opens of files not matching AllowedPatterns are violations
+)
+
+// baseAllowedPatterns are the files almost every process opens just to run,
+// whatever it does. They are allowed in allowlist mode on top of
+// AllowedPatterns.
+var baseAllowedPatterns = []string{
+	// The dynamic loader's cache and shared libraries at any depth, e.g.
+	// /usr/lib/x86_64-linux-gnu/libc.so.6 and glibc's gconv modules
+	"/etc/ld.so.cache", "/etc/ld.so.preload",
+	"/lib/**", "/lib32/**", "/lib64/**",
+	"/usr/lib/**", "/usr/lib32/**", "/usr/lib64/**",
+	// Locale, time zone and terminal data
+	"/etc/localtime", "/etc/locale.alias",
+	"/usr/share/locale/**", "/usr/share/zoneinfo/**", "/usr/share/terminfo/**",
+	// What libc reads to look up users, groups and hosts
+	"/etc/nsswitch.conf", "/etc/passwd", "/etc/group",
+	"/etc/hosts", "/etc/host.conf", "/etc/resolv.conf", "/etc/gai.conf",
+	// The process's own /proc entries and the system information language
+	// runtimes read at startup, e.g. Go's cgroup CPU limit and huge page size
+	"/proc/self/**", "/proc/thread-self/**", "/proc/sys/**",
+	"/proc/cpuinfo", "/proc/meminfo", "/proc/stat",
+	"/sys/devices/system/cpu/**", "/sys/fs/cgroup/**", "/sys/kernel/mm/transparent_hugepage/**",
+	// The standard devices
+	"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom", "/dev/tty", "/dev/pts/*",
+}
+
+// allowlist decides which files a process may open in allowlist mode. Its
+// patterns are exact paths, globs and directories as for disallowed patterns,
+// /dir/ for the files directly in dir and /dir/** for those at any depth, but
+// they never match as substrings, so allowing /data does not allow
+// /tmp/data/secret.
+type allowlist struct {
+	patterns        []string
+	caseInsensitive bool
+}
+
+// newAllowlist allows the base patterns and patterns
+func newAllowlist(patterns []string, caseInsensitive bool) *allowlist {
+	all := append(append([]string(nil), baseAllowedPatterns...), patterns...)
+	if caseInsensitive {
+		for i, pattern := range all {
+			all[i] = strings.ToLower(pattern)
+		}
+	}
+	return &allowlist{patterns: all, caseInsensitive: caseInsensitive}
+}
+
+// allows reports whether filename is allowed
+func (a *allowlist) allows(filename string) bool {
+	if a.caseInsensitive {
+		filename = strings.ToLower(filename)
+	}
+	index, _ := matchRuleIndex(filename, a.patterns, true)
+	return index >= 0
+}
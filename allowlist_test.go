@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAllowlist_Allows(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		expected bool
+	}{
+		{"exact", "/etc/myapp.conf", true},
+		{"glob", "/var/lib/myapp/state.db", true},
+		{"base library", "/lib/x86_64-linux-gnu/libc.so.6", true},
+		{"base device", "/dev/null", true},
+		{"other file", "/etc/shadow", false},
+		{"no substring match", "/tmp/etc/myapp.conf", false},
+		{"glob does not cross directories", "/var/lib/myapp/keys/id_rsa", false},
+		{"base device suffix", "/tmp/dev/null", false},
+		{"base library at depth", "/usr/lib/x86_64-linux-gnu/gconv/UTF-16.so", true},
+		{"base name service", "/etc/nsswitch.conf", true},
+		{"base own proc entry", "/proc/self/cgroup", true},
+		{"other proc entry", "/proc/1/environ", false},
+		{"directory", "/srv/myapp/index.html", true},
+		{"directory is not recursive", "/srv/myapp/static/app.js", false},
+		{"recursive", "/opt/myapp/lib/python3/site.py", true},
+	}
+
+	a := newAllowlist([]string{"/etc/myapp.conf", "/var/lib/myapp/*", "/srv/myapp/", "/opt/myapp/**"}, false)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.allows(tt.filename); got != tt.expected {
+				t.Errorf("allows(%q) = %v, want %v", tt.filename, got, tt.expected)
+			}
+		})
+	}
+
+	folded := newAllowlist([]string{"/Data/*"}, true)
+	if !folded.allows("/data/REPORT.txt") {
+		t.Error("expected a case-insensitive allowlist to ignore case")
+	}
+}
+
+func TestEventHandler_AllowlistMode(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		PolicyMode:         PolicyAllowlist,
+		AllowedPatterns:    []string{"/etc/myapp/*", "/var/lib/myapp/{data,cache}/*"},
+		DisallowedPatterns: []string{"/etc/myapp/*"}, // ignored in allowlist mode
+		Rules:              []Rule{{Pattern: "/etc/myapp/master.key", Immediate: true}},
+		Threshold:          2,
+	})
+
+	tests := []struct {
+		pid      uint32
+		filename string
+		counted  bool
+		blocked  bool
+	}{
+		{1000, "/etc/myapp/config.yaml", false, false},
+		{1000, "/var/lib/myapp/cache/index", false, false},
+		{1000, "/usr/lib/x86_64-linux-gnu/libssl.so.3", false, false},
+		{1000, "/etc/shadow", true, false},
+		{1000, "/var/lib/myapp/cache/index", false, false},
+		{1000, "/home/user/.ssh/id_rsa", true, true},
+		// Immediate rules still block even allowed files
+		{2000, "/etc/myapp/master.key", true, true},
+	}
+
+	for _, tt := range tests {
+		result, err := handler.processEvent(CreateMockEvent(tt.pid, 1000, "myapp", tt.filename))
+		if err != nil {
+			t.Fatalf("processEvent(%s): %v", tt.filename, err)
+		}
+		if result.Counted != tt.counted || result.Blocked != tt.blocked {
+			t.Errorf("%s by PID %d: expected counted=%v blocked=%v, got %+v",
+				tt.filename, tt.pid, tt.counted, tt.blocked, result)
+		}
+	}
+	if got := handler.GetViolationCountForPID(1000); got != 2 {
+		t.Errorf("expected 2 violations for PID 1000, got %d", got)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord is a single JSON Lines entry in the audit log
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"` // "violation", "block" or "escalation"
+	PID       uint32    `json:"pid"`
+	UID       uint32    `json:"uid"`
+	TID       uint32    `json:"tid,omitempty"` // thread that opened the file, 0 if unknown
+	Comm      string    `json:"comm"`
+	Filename  string    `json:"filename"`
+	Count     uint32    `json:"count"`
+	Threshold uint32    `json:"threshold"`
+	Pattern   string    `json:"pattern,omitempty"`    // the disallowed pattern that matched
+	MatchKind string    `json:"match_kind,omitempty"` // "exact", "glob" or "substring"
+	Files     []string  `json:"files,omitempty"`      // distinct disallowed files accessed, for blocks
+	Event     string    `json:"event,omitempty"`      // "rename" if a file was renamed into Filename, empty for opens
+	Container string    `json:"container,omitempty"`  // container ID or mnt:<inode>, with ResolveContainer
+}
+
+// auditFile is an open audit log file
+type auditFile interface {
+	io.Writer
+	Sync() error
+	Close() error
+}
+
+// auditStore opens and rotates the audit log. It exists so tests can keep
+// the log in memory.
+type auditStore interface {
+	// Open opens the current log for appending and returns its size
+	Open() (auditFile, int64, error)
+	// Rotate moves the current log aside so the next Open starts afresh
+	Rotate() error
+}
+
+// fileAuditStore is the auditStore backed by a file on disk
+type fileAuditStore struct {
+	path string
+}
+
+func (s fileAuditStore) Open() (auditFile, int64, error) {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (s fileAuditStore) Rotate() error {
+	return os.Rename(s.path, s.path+".1")
+}
+
+// AuditLogger writes audit records as JSON Lines, rotating the file once it
+// exceeds maxBytes and reopening it on request (e.g. SIGHUP from logrotate)
+type AuditLogger struct {
+	mu       sync.Mutex
+	store    auditStore
+	file     auditFile
+	size     int64
+	maxBytes int64 // 0 disables size-based rotation
+	sync     bool  // fsync after every record
+}
+
+// NewAuditLogger creates an audit logger for the given path. The file is
+// opened lazily on the first write.
+func NewAuditLogger(path string, maxBytes int64, syncEveryWrite bool) *AuditLogger {
+	return newAuditLogger(fileAuditStore{path: path}, maxBytes, syncEveryWrite)
+}
+
+func newAuditLogger(store auditStore, maxBytes int64, syncEveryWrite bool) *AuditLogger {
+	return &AuditLogger{
+		store:    store,
+		maxBytes: maxBytes,
+		sync:     syncEveryWrite,
+	}
+}
+
+// Write appends a record to the audit log
+func (l *AuditLogger) Write(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encode audit record: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		if err := l.open(); err != nil {
+			return err
+		}
+	}
+
+	// Rotate before the write that would push the file past the limit
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+
+	if l.sync {
+		if err := l.file.Sync(); err != nil {
+			return fmt.Errorf("sync audit log: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Reopen closes and reopens the audit log, picking up a file moved by logrotate
+func (l *AuditLogger) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.closeFile(); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// Close closes the audit log. A later Write reopens it.
+func (l *AuditLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closeFile()
+}
+
+// open opens the current log file; the caller must hold l.mu
+func (l *AuditLogger) open() error {
+	file, size, err := l.store.Open()
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	l.file = file
+	l.size = size
+	return nil
+}
+
+// rotate moves the current log aside and opens a fresh one; the caller must hold l.mu
+func (l *AuditLogger) rotate() error {
+	if err := l.closeFile(); err != nil {
+		return err
+	}
+	if err := l.store.Rotate(); err != nil {
+		return fmt.Errorf("rotate audit log: %w", err)
+	}
+	return l.open()
+}
+
+// closeFile closes the open file if any; the caller must hold l.mu
+func (l *AuditLogger) closeFile() error {
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	if err != nil {
+		return fmt.Errorf("close audit log: %w", err)
+	}
+	return nil
+}
+
+// reopenOnSignal calls reopen every time a signal arrives on sigc until the
+// context is cancelled
+func reopenOnSignal(ctx context.Context, sigc <-chan os.Signal, reopen func() error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigc:
+			if err := reopen(); err != nil {
+				log.Printf("reopening audit log: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// memAuditFile is an in-memory auditFile
+type memAuditFile struct {
+	bytes.Buffer
+	syncs  int
+	closed bool
+}
+
+func (f *memAuditFile) Sync() error {
+	f.syncs++
+	return nil
+}
+
+func (f *memAuditFile) Close() error {
+	f.closed = true
+	return nil
+}
+
+// memAuditStore is an in-memory auditStore; the last file is the current log
+type memAuditStore struct {
+	files     []*memAuditFile
+	opens     int
+	rotations int
+}
+
+func (s *memAuditStore) Open() (auditFile, int64, error) {
+	s.opens++
+	if len(s.files) == 0 {
+		s.files = append(s.files, &memAuditFile{})
+	}
+	current := s.files[len(s.files)-1]
+	current.closed = false
+	return current, int64(current.Len()), nil
+}
+
+func (s *memAuditStore) Rotate() error {
+	s.rotations++
+	s.files = append(s.files, &memAuditFile{})
+	return nil
+}
+
+func decodeAuditLines(t *testing.T, data string) []AuditRecord {
+	t.Helper()
+	var records []AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(data), "\n") {
+		if line == "" {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditLogger_WritesJSONLines(t *testing.T) {
+	store := &memAuditStore{}
+	logger := newAuditLogger(store, 0, true)
+
+	for _, record := range []AuditRecord{
+		{Type: "violation", PID: 1234, Comm: "app", Filename: "/etc/passwd", Count: 1, Threshold: 2},
+		{Type: "block", PID: 1234, Comm: "app", Filename: "/etc/shadow", Count: 2, Threshold: 2},
+	} {
+		if err := logger.Write(record); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	records := decodeAuditLines(t, store.files[0].String())
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if records[0].Type != "violation" || records[1].Type != "block" {
+		t.Errorf("unexpected record types: %s, %s", records[0].Type, records[1].Type)
+	}
+	if records[1].Filename != "/etc/shadow" {
+		t.Errorf("expected filename /etc/shadow, got %s", records[1].Filename)
+	}
+
+	// Sync policy: one sync per record
+	if store.files[0].syncs != 2 {
+		t.Errorf("expected 2 syncs, got %d", store.files[0].syncs)
+	}
+}
+
+func TestAuditLogger_NoSync(t *testing.T) {
+	store := &memAuditStore{}
+	logger := newAuditLogger(store, 0, false)
+
+	if err := logger.Write(AuditRecord{Type: "violation"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if store.files[0].syncs != 0 {
+		t.Errorf("expected no syncs, got %d", store.files[0].syncs)
+	}
+}
+
+func TestAuditLogger_SizeRotation(t *testing.T) {
+	store := &memAuditStore{}
+
+	// Measure one encoded record to size the limit at two records
+	line, _ := json.Marshal(AuditRecord{Type: "violation", PID: 1})
+	logger := newAuditLogger(store, int64(2*(len(line)+1)), false)
+
+	for i := 0; i < 5; i++ {
+		if err := logger.Write(AuditRecord{Type: "violation", PID: 1}); err != nil {
+			t.Fatalf("write %d: %v", i, err)
+		}
+	}
+
+	// 5 records at 2 per file: rotations after the 2nd and 4th
+	if store.rotations != 2 {
+		t.Fatalf("expected 2 rotations, got %d", store.rotations)
+	}
+	for i, want := range []int{2, 2, 1} {
+		if got := len(decodeAuditLines(t, store.files[i].String())); got != want {
+			t.Errorf("file %d: expected %d records, got %d", i, want, got)
+		}
+	}
+	if !store.files[0].closed || !store.files[1].closed {
+		t.Error("rotated files should be closed")
+	}
+}
+
+func TestAuditLogger_ReopenOnSignal(t *testing.T) {
+	store := &memAuditStore{}
+	logger := newAuditLogger(store, 0, false)
+
+	if err := logger.Write(AuditRecord{Type: "violation"}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if store.opens != 1 {
+		t.Fatalf("expected 1 open, got %d", store.opens)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigc := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		reopenOnSignal(ctx, sigc, logger.Reopen)
+		close(done)
+	}()
+
+	sigc <- syscall.SIGHUP
+	sigc <- syscall.SIGHUP
+	cancel()
+	<-done
+
+	if store.opens != 3 {
+		t.Errorf("expected 3 opens after two signals, got %d", store.opens)
+	}
+
+	// Writes continue to the reopened file
+	if err := logger.Write(AuditRecord{Type: "block"}); err != nil {
+		t.Fatalf("write after reopen: %v", err)
+	}
+	if got := len(decodeAuditLines(t, store.files[0].String())); got != 2 {
+		t.Errorf("expected 2 records, got %d", got)
+	}
+}
+
+func TestEventHandler_AuditLog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{
+		CreateMockEvent(1234, 1000, "app", "/etc/passwd"),
+		CreateMockEvent(1234, 1000, "app", "/tmp/safe.txt"),
+		CreateMockEvent(1234, 1000, "app", "/etc/shadow"),
+	}
+
+	provider := NewMockEBPFProvider(ctx, events)
+	defer provider.Close()
+
+	config := EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          2,
+	}
+
+	handler := NewEventHandler(provider, config)
+	store := &memAuditStore{}
+	handler.auditLog = newAuditLogger(store, 0, false)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	records := decodeAuditLines(t, store.files[0].String())
+	if len(records) != 3 {
+		t.Fatalf("expected 3 audit records, got %d", len(records))
+	}
+
+	expected := []struct {
+		recordType string
+		filename   string
+		count      uint32
+	}{
+		{"violation", "/etc/passwd", 1},
+		{"violation", "/etc/shadow", 2},
+		{"block", "/etc/shadow", 2},
+	}
+	for i, want := range expected {
+		got := records[i]
+		if got.Type != want.recordType || got.Filename != want.filename || got.Count != want.count {
+			t.Errorf("record %d: got %+v, want %+v", i, got, want)
+		}
+		if got.PID != 1234 || got.Comm != "app" {
+			t.Errorf("record %d: unexpected pid/comm %d/%s", i, got.PID, got.Comm)
+		}
+		if got.Type == "block" && strings.Join(got.Files, ",") != "/etc/passwd,/etc/shadow" {
+			t.Errorf("record %d: expected the files accessed in the block record, got %v", i, got.Files)
+		}
+		if got.Pattern != "/etc/*" || got.MatchKind != MatchGlob {
+			t.Errorf("record %d: expected a glob match of /etc/*, got %q/%q", i, got.Pattern, got.MatchKind)
+		}
+	}
+
+	if !store.files[0].closed {
+		t.Error("expected audit log to be closed when Run returns")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// maxBlockBatch is how many PIDs a batch holds before it is written without
+// waiting for the batch interval
+const maxBlockBatch = 256
+
+// blockBatcher coalesces blocked_pids updates, so that thousands of PIDs
+// crossing the threshold at once are written with a few BatchUpdate calls
+// instead of one syscall each. A PID is written at most interval after it
+// was added. PIDs that fail to be written stay pending and are retried every
+// interval until written or removed, and an error writing a batch on the
+// timer is returned by the next add or flush.
+type blockBatcher struct {
+	m        bpfMap
+	interval time.Duration
+
+	mu      sync.Mutex
+	pids    []uint32
+	infos   []BlockInfo
+	index   map[uint32]int // PID -> position in pids and infos
+	timer   *time.Timer    // running while a batch is pending
+	lastErr error          // error of the last batch written on the timer
+}
+
+// newBlockBatcher returns a batcher writing to m at most interval after
+// each add
+func newBlockBatcher(m bpfMap, interval time.Duration) *blockBatcher {
+	return &blockBatcher{m: m, interval: interval, index: make(map[uint32]int)}
+}
+
+// add queues pid to be blocked, writing the batch right away once it is
+// full. Adding a pending PID again replaces its info.
+func (b *blockBatcher) add(pid uint32, info BlockInfo) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.lastErr
+	b.lastErr = nil
+
+	if i, ok := b.index[pid]; ok {
+		b.infos[i] = info
+	} else {
+		b.queueLocked(pid, info)
+	}
+
+	if len(b.pids) >= maxBlockBatch {
+		return errors.Join(err, b.flushLocked())
+	}
+	b.scheduleLocked()
+	return err
+}
+
+// scheduleLocked starts the timer writing the pending batch, unless it is
+// already running
+func (b *blockBatcher) scheduleLocked() {
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, b.flushOnTimer)
+	}
+}
+
+// queueLocked adds pid to the pending batch
+func (b *blockBatcher) queueLocked(pid uint32, info BlockInfo) {
+	b.index[pid] = len(b.pids)
+	b.pids = append(b.pids, pid)
+	b.infos = append(b.infos, info)
+}
+
+// remove drops pid from the pending batch, so unblocking a PID before its
+// batch is written does not block it afterwards
+func (b *blockBatcher) remove(pid uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	i, ok := b.index[pid]
+	if !ok {
+		return
+	}
+	last := len(b.pids) - 1
+	b.pids[i], b.infos[i] = b.pids[last], b.infos[last]
+	b.index[b.pids[i]] = i
+	b.pids, b.infos = b.pids[:last], b.infos[:last]
+	delete(b.index, pid)
+}
+
+// flush writes the pending batch now
+func (b *blockBatcher) flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := b.lastErr
+	b.lastErr = nil
+	return errors.Join(err, b.flushLocked())
+}
+
+// close writes the pending batch one last time and stops retrying what
+// still fails, as the map is about to be closed
+func (b *blockBatcher) close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	err := errors.Join(b.lastErr, b.flushLocked())
+	b.lastErr = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.pids, b.infos = nil, nil
+	b.index = make(map[uint32]int)
+	return err
+}
+
+// flushOnTimer writes the pending batch once the interval passed
+func (b *blockBatcher) flushOnTimer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.flushLocked(); err != nil {
+		log.Printf("blocked_pids batch: %v", err)
+		b.lastErr = err
+	}
+}
+
+// flushLocked writes and clears the pending batch. Kernels without batch
+// operations get one update per PID. PIDs that fail to be written are queued
+// again and retried on the timer.
+func (b *blockBatcher) flushLocked() error {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.pids) == 0 {
+		return nil
+	}
+
+	pids, infos := b.pids, b.infos
+	b.pids, b.infos = nil, nil
+	b.index = make(map[uint32]int)
+
+	written, err := b.m.BatchUpdate(pids, infos, &ebpf.BatchOptions{ElemFlags: uint64(ebpf.UpdateAny)})
+	if !errors.Is(err, ebpf.ErrNotSupported) {
+		if err != nil {
+			// The batch is written in order, up to the first failure
+			for i := written; i < len(pids); i++ {
+				b.queueLocked(pids[i], infos[i])
+			}
+			b.scheduleLocked()
+			return fmt.Errorf("failed to update blocked_pids map with %d PIDs: %w", len(pids)-written, err)
+		}
+		return nil
+	}
+
+	var errs []error
+	for i, pid := range pids {
+		if err := b.m.Update(pid, infos[i], ebpf.UpdateAny); err != nil {
+			errs = append(errs, fmt.Errorf("PID %d: %w", pid, err))
+			b.queueLocked(pid, infos[i])
+		}
+	}
+	if len(errs) > 0 {
+		b.scheduleLocked()
+		return fmt.Errorf("failed to update blocked_pids map: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+func TestBlockBatcher_AllPIDsLand(t *testing.T) {
+	m := &fakeMap{values: make(map[interface{}]interface{})}
+	b := newBlockBatcher(m, 5*time.Millisecond)
+
+	// Many PIDs crossing the threshold at once
+	const pids = 1000
+	var wg sync.WaitGroup
+	for pid := uint32(1); pid <= pids; pid++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.add(pid, BlockInfo{Reason: BlockReasonThreshold, Rule: NoRule}); err != nil {
+				t.Errorf("add(%d): %v", pid, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		m.mu.Lock()
+		landed, batches, updates := len(m.values), m.batches, m.updates
+		m.mu.Unlock()
+		if landed == pids {
+			if batches >= pids/2 || updates != 0 {
+				t.Errorf("expected the blocks coalesced into a few batches, got %d batches and %d single updates", batches, updates)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d PIDs landed in the map", landed, pids)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBlockBatcher_FullBatchWrittenAtOnce(t *testing.T) {
+	m := &fakeMap{values: make(map[interface{}]interface{})}
+	b := newBlockBatcher(m, time.Hour)
+
+	for pid := uint32(1); pid <= maxBlockBatch; pid++ {
+		if err := b.add(pid, BlockInfo{}); err != nil {
+			t.Fatalf("add(%d): %v", pid, err)
+		}
+	}
+	if _, ok := m.get(uint32(maxBlockBatch)); !ok || m.batches != 1 {
+		t.Errorf("expected a full batch written at once, got %d batches", m.batches)
+	}
+
+	if err := b.add(maxBlockBatch+1, BlockInfo{}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, ok := m.get(uint32(maxBlockBatch + 1)); ok {
+		t.Error("expected the next PID to wait for its batch")
+	}
+	if err := b.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if _, ok := m.get(uint32(maxBlockBatch + 1)); !ok {
+		t.Error("expected flush to write the pending PID")
+	}
+}
+
+func TestBlockBatcher_Remove(t *testing.T) {
+	m := &fakeMap{values: make(map[interface{}]interface{})}
+	b := newBlockBatcher(m, time.Hour)
+
+	for _, pid := range []uint32{1, 2, 3} {
+		if err := b.add(pid, BlockInfo{Reason: BlockReasonThreshold}); err != nil {
+			t.Fatalf("add(%d): %v", pid, err)
+		}
+	}
+	// Unblocked before its batch was written, so never blocked
+	b.remove(2)
+	b.remove(4)
+	// Adding a pending PID again replaces its info
+	if err := b.add(3, BlockInfo{Reason: BlockReasonManual}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := b.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	if _, ok := m.get(uint32(2)); ok {
+		t.Error("expected the removed PID not to be written")
+	}
+	if info, _ := m.get(uint32(1)); info != (BlockInfo{Reason: BlockReasonThreshold}) {
+		t.Errorf("PID 1 = %v, want a threshold block", info)
+	}
+	if info, _ := m.get(uint32(3)); info != (BlockInfo{Reason: BlockReasonManual}) {
+		t.Errorf("PID 3 = %v, want the replaced manual block", info)
+	}
+}
+
+func TestBlockBatcher_WithoutBatchOps(t *testing.T) {
+	m := &fakeMap{values: make(map[interface{}]interface{}), batchErr: ebpf.ErrNotSupported}
+	b := newBlockBatcher(m, time.Hour)
+
+	for _, pid := range []uint32{1, 2} {
+		if err := b.add(pid, BlockInfo{}); err != nil {
+			t.Fatalf("add(%d): %v", pid, err)
+		}
+	}
+	if err := b.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if len(m.values) != 2 || m.updates != 2 {
+		t.Errorf("expected one update per PID, got %d updates and values %v", m.updates, m.values)
+	}
+
+	m.updateErr = errors.New("map full")
+	if err := b.add(3, BlockInfo{}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := b.flush(); err == nil {
+		t.Error("expected the update error")
+	}
+}
+
+func TestBlockBatcher_TimerErrorReported(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	m := &fakeMap{values: make(map[interface{}]interface{}), batchErr: errors.New("map full")}
+	b := newBlockBatcher(m, time.Millisecond)
+	if err := b.add(1, BlockInfo{}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		m.mu.Lock()
+		batches := m.batches
+		m.mu.Unlock()
+		if batches > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("batch was never written")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The failure of the timer's batch surfaces on the next call
+	m.mu.Lock()
+	m.batchErr = nil
+	m.mu.Unlock()
+	if err := b.flush(); err == nil {
+		t.Error("expected the timer's batch error to be returned")
+	}
+	// and the PID that failed was kept and written on the retry
+	if _, ok := m.get(uint32(1)); !ok {
+		t.Error("expected PID 1 written after the failed batch")
+	}
+	if err := b.flush(); err != nil {
+		t.Errorf("expected the error to be returned only once, got %v", err)
+	}
+}
+
+func TestBlockBatcher_FailedPIDsRetried(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	m := &fakeMap{values: make(map[interface{}]interface{}), batchErr: ebpf.ErrNotSupported, updateErr: errors.New("map full")}
+	b := newBlockBatcher(m, time.Millisecond)
+	if err := b.add(1, BlockInfo{}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := b.flush(); err == nil {
+		t.Fatal("expected the update error")
+	}
+
+	// The map has room again: the timer retries the failed PID
+	m.mu.Lock()
+	m.updateErr = nil
+	m.mu.Unlock()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := m.get(uint32(1)); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("failed PID was never retried")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A PID removed while waiting for its retry is not written
+	m.mu.Lock()
+	m.updateErr = errors.New("map full")
+	m.mu.Unlock()
+	if err := b.add(2, BlockInfo{}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	b.flush()
+	b.remove(2)
+	m.mu.Lock()
+	m.updateErr = nil
+	m.mu.Unlock()
+	if err := b.close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, ok := m.get(uint32(2)); ok {
+		t.Error("expected the removed PID not to be retried")
+	}
+}
+
+func TestRealEBPFProvider_BlockBatching(t *testing.T) {
+	provider, err := newRealEBPFProvider(&fakeAttacher{}, providerOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	m := &fakeMap{values: make(map[interface{}]interface{})}
+	provider.blockedPids = m
+	provider.batcher = newBlockBatcher(m, time.Hour)
+
+	for _, pid := range []uint32{1, 2} {
+		if err := provider.BlockPID(pid); err != nil {
+			t.Fatalf("BlockPID(%d): %v", pid, err)
+		}
+	}
+	if err := provider.UnblockPID(2); err != nil {
+		t.Fatalf("UnblockPID: %v", err)
+	}
+	if len(m.values) != 0 {
+		t.Errorf("expected blocks to wait for their batch, got %v", m.values)
+	}
+
+	// Pending blocks are written before the map is closed
+	if err := provider.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, ok := m.values[uint32(1)]; !ok || len(m.values) != 1 {
+		t.Errorf("expected only PID 1 written on Close, got %v", m.values)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// BlockReason is why a PID was blocked, as stored in the blocked_pids map
+type BlockReason uint32
+
+// Block reasons, matching BLOCK_REASON_* in the BPF program
+const (
+	BlockReasonUnknown   BlockReason = iota // blocked without a reason, e.g. by an older version
+	BlockReasonThreshold                    // reached its violation threshold
+	BlockReasonImmediate                    // opened a file of an immediate rule
+	BlockReasonBytes                        // read more than ByteThreshold from a disallowed file
+	BlockReasonRapidOpen                    // opened more than RapidOpenThreshold files within RapidOpenWindow
+	BlockReasonBlocklist                    // on the external blocklist
+	BlockReasonManual                       // blocked with the block command
+	BlockReasonPanic                        // a process of a UID blocked with BlockAllForUID or the panic command
+)
+
+// String returns the reason as shown by -dump-maps and status
+func (r BlockReason) String() string {
+	switch r {
+	case BlockReasonThreshold:
+		return "threshold"
+	case BlockReasonImmediate:
+		return "immediate"
+	case BlockReasonBytes:
+		return "bytes"
+	case BlockReasonRapidOpen:
+		return "rapid-open"
+	case BlockReasonBlocklist:
+		return "blocklist"
+	case BlockReasonManual:
+		return "manual"
+	case BlockReasonPanic:
+		return "panic"
+	}
+	return "unknown"
+}
+
+// NoRule is the BlockInfo rule of blocks not triggered by a file pattern
+const NoRule = ^uint32(0)
+
+// blockInfoSize is the size of struct block_info in the BPF program
+const blockInfoSize = 16
+
+// BlockInfo is the blocked_pids map value, matching struct block_info in the
+// BPF program. It records why a PID was blocked, so the map alone explains
+// its contents to -dump-maps, the status command and a restarted instance.
+type BlockInfo struct {
+	Reason    BlockReason
+	Rule      uint32 // index of the pattern in DisallowedPatterns followed by Rules, NoRule if none
+	BlockedAt uint64 // Unix nanoseconds
+}
+
+// MarshalBinary encodes the value as stored in the map
+func (b BlockInfo) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, blockInfoSize)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(b.Reason))
+	binary.LittleEndian.PutUint32(buf[4:], b.Rule)
+	binary.LittleEndian.PutUint64(buf[8:], b.BlockedAt)
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a value read from the map. The one-byte values
+// older versions stored are migrated to BlockReasonUnknown.
+func (b *BlockInfo) UnmarshalBinary(data []byte) error {
+	switch len(data) {
+	case 1:
+		*b = BlockInfo{Reason: BlockReasonUnknown, Rule: NoRule}
+	case blockInfoSize:
+		*b = BlockInfo{
+			Reason:    BlockReason(binary.LittleEndian.Uint32(data[0:])),
+			Rule:      binary.LittleEndian.Uint32(data[4:]),
+			BlockedAt: binary.LittleEndian.Uint64(data[8:]),
+		}
+	default:
+		return fmt.Errorf("invalid blocked_pids value of %d bytes, want %d", len(data), blockInfoSize)
+	}
+	return nil
+}
+
+// String describes the block, e.g. "threshold rule=2 at 2024-01-02T15:04:05Z"
+func (b BlockInfo) String() string {
+	s := b.Reason.String()
+	if b.Rule != NoRule {
+		s += fmt.Sprintf(" rule=%d", b.Rule)
+	}
+	if b.BlockedAt != 0 {
+		s += " at " + time.Unix(0, int64(b.BlockedAt)).UTC().Format(time.RFC3339)
+	}
+	return s
+}
+
+// writeBlockedDump writes the entries of the blocked_pids map sorted by PID,
+// in the -dump-maps format with the value described
+func writeBlockedDump(w io.Writer, entries map[uint32]BlockInfo) error {
+	if len(entries) == 0 {
+		_, err := fmt.Fprintf(w, "blocked_pids: (empty)\n")
+		return err
+	}
+
+	pids := make([]uint32, 0, len(entries))
+	for pid := range entries {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+
+	if _, err := fmt.Fprintf(w, "blocked_pids (%d entries):\n", len(entries)); err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if _, err := fmt.Fprintf(w, "  PID %d = %s\n", pid, entries[pid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reasonBlocker is implemented by providers that record why a PID was
+// blocked
+type reasonBlocker interface {
+	// BlockPIDWithInfo blocks pid like BlockPID, storing info with it
+	BlockPIDWithInfo(pid uint32, info BlockInfo) error
+}
+
+// blockPID blocks pid in the provider, recording the reason and the
+// triggering pattern where the provider can. The caller has added pid to
+// blockedPIDs; the block info is kept there too. With VerifyBlocks the block
+// is verified in the background, see confirmBlock.
+func (h *EventHandler) blockPID(pid uint32, reason BlockReason, pattern string) error {
+	h.trackStart(pid)
+	info := BlockInfo{
+		Reason:    reason,
+		Rule:      h.ruleIndex(pattern),
+		BlockedAt: uint64(h.clock.Now().UnixNano()),
+	}
+	record := h.blockedPIDs[pid]
+	record.info = info
+	h.blockedPIDs[pid] = record
+	if h.config.EnforcementAction == ActionQuarantine {
+		if record.quarantined = h.quarantine(pid); record.quarantined {
+			h.blockedPIDs[pid] = record
+			return nil
+		}
+	}
+	if err := h.provideBlock(pid, info); err != nil {
+		return err
+	}
+	if h.config.VerifyBlocks {
+		record.verifying = true
+		h.blockedPIDs[pid] = record
+		h.verifies.Add(1)
+		go h.confirmBlock(pid)
+	}
+	return nil
+}
+
+// provideBlock blocks pid in the provider, with info where it can
+func (h *EventHandler) provideBlock(pid uint32, info BlockInfo) error {
+	blocker, ok := h.provider.(reasonBlocker)
+	if !ok {
+		return h.provider.BlockPID(pid)
+	}
+	return blocker.BlockPIDWithInfo(pid, info)
+}
+
+// ruleIndex returns the index of pattern in DisallowedPatterns followed by
+// Rules, or NoRule
+func (h *EventHandler) ruleIndex(pattern string) uint32 {
+	if pattern == "" {
+		return NoRule
+	}
+	for i, p := range h.config.DisallowedPatterns {
+		if p == pattern {
+			return uint32(i)
+		}
+	}
+	for i, rule := range h.config.Rules {
+		if rule.Pattern == pattern {
+			return uint32(len(h.config.DisallowedPatterns) + i)
+		}
+	}
+	return NoRule
+}
+
+// rulePattern returns the pattern ruleIndex numbered index, or "" for
+// NoRule and unknown indexes
+func (h *EventHandler) rulePattern(index uint32) string {
+	if index < uint32(len(h.config.DisallowedPatterns)) {
+		return h.config.DisallowedPatterns[index]
+	}
+	if i := index - uint32(len(h.config.DisallowedPatterns)); index != NoRule && i < uint32(len(h.config.Rules)) {
+		return h.config.Rules[i].Pattern
+	}
+	return ""
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBlockInfo_MarshalRoundTrip(t *testing.T) {
+	infos := []BlockInfo{
+		{Reason: BlockReasonThreshold, Rule: 2, BlockedAt: uint64(time.Unix(1700000000, 5).UnixNano())},
+		{Reason: BlockReasonManual, Rule: NoRule, BlockedAt: 1},
+		{},
+	}
+	for _, info := range infos {
+		data, err := info.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary(%+v): %v", info, err)
+		}
+		if len(data) != blockInfoSize {
+			t.Errorf("MarshalBinary(%+v) = %d bytes, want %d", info, len(data), blockInfoSize)
+		}
+
+		var decoded BlockInfo
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary: %v", err)
+		}
+		if decoded != info {
+			t.Errorf("round trip of %+v gave %+v", info, decoded)
+		}
+	}
+}
+
+func TestBlockInfo_UnmarshalLayout(t *testing.T) {
+	// struct block_info: __u32 reason, __u32 rule, __u64 blocked_at
+	data := []byte{
+		3, 0, 0, 0,
+		7, 0, 0, 0,
+		0x00, 0x01, 0, 0, 0, 0, 0, 0,
+	}
+	var info BlockInfo
+	if err := info.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	expected := BlockInfo{Reason: BlockReasonBytes, Rule: 7, BlockedAt: 256}
+	if info != expected {
+		t.Errorf("UnmarshalBinary = %+v, want %+v", info, expected)
+	}
+}
+
+func TestBlockInfo_UnmarshalLegacy(t *testing.T) {
+	var info BlockInfo
+	if err := info.UnmarshalBinary([]byte{1}); err != nil {
+		t.Fatalf("UnmarshalBinary of a legacy value: %v", err)
+	}
+	expected := BlockInfo{Reason: BlockReasonUnknown, Rule: NoRule}
+	if info != expected {
+		t.Errorf("legacy value decoded as %+v, want %+v", info, expected)
+	}
+	if info.String() != "unknown" {
+		t.Errorf("String() = %q, want %q", info.String(), "unknown")
+	}
+
+	if err := info.UnmarshalBinary(make([]byte, 8)); err == nil {
+		t.Error("expected an error for a value of the wrong size")
+	}
+}
+
+func TestWriteBlockedDump(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeBlockedDump(&buf, map[uint32]BlockInfo{
+		2000: {Reason: BlockReasonManual, Rule: NoRule},
+		1000: {Reason: BlockReasonThreshold, Rule: 1, BlockedAt: uint64(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC).UnixNano())},
+	})
+	if err != nil {
+		t.Fatalf("writeBlockedDump: %v", err)
+	}
+	expected := "blocked_pids (2 entries):\n" +
+		"  PID 1000 = threshold rule=1 at 2024-05-01T12:00:00Z\n" +
+		"  PID 2000 = manual\n"
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
+
+// reasonRecordingProvider records the BlockInfo of each blocked PID
+type reasonRecordingProvider struct {
+	*MockEBPFProvider
+	infos map[uint32]BlockInfo
+}
+
+func (p *reasonRecordingProvider) BlockPIDWithInfo(pid uint32, info BlockInfo) error {
+	p.infos[pid] = info
+	return p.BlockPID(pid)
+}
+
+func TestEventHandler_BlockReasons(t *testing.T) {
+	mock := NewMockEBPFProvider(context.Background(), nil)
+	defer mock.Close()
+	provider := &reasonRecordingProvider{MockEBPFProvider: mock, infos: make(map[uint32]BlockInfo)}
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/passwd", "/etc/hosts"},
+		Rules:              []Rule{{Pattern: "/etc/shadow", Immediate: true}},
+		Threshold:          2,
+	})
+	clock := newFakeClock(time.Unix(1000, 0))
+	handler.clock = clock
+
+	for _, event := range []*Event{
+		CreateMockEvent(1000, 1000, "cat", "/etc/passwd"),
+		CreateMockEvent(1000, 1000, "cat", "/etc/hosts"),
+		CreateMockEvent(2000, 1000, "cat", "/etc/shadow"),
+	} {
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	at := uint64(time.Unix(1000, 0).UnixNano())
+	expected := map[uint32]BlockInfo{
+		1000: {Reason: BlockReasonThreshold, Rule: 1, BlockedAt: at},
+		2000: {Reason: BlockReasonImmediate, Rule: 2, BlockedAt: at},
+	}
+	if !reflect.DeepEqual(provider.infos, expected) {
+		t.Errorf("block infos = %+v, want %+v", provider.infos, expected)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// blockChecker is implemented by providers that can look a PID up in their
+// blocked list
+type blockChecker interface {
+	// IsPIDBlocked reports whether pid is in the blocked list
+	IsPIDBlocked(pid uint32) (bool, error)
+}
+
+const (
+	defaultBlockVerifyGrace = 50 * time.Millisecond
+	blockVerifyInterval     = 5 * time.Millisecond // between lookups of a block not showing yet
+)
+
+// confirmBlock verifies the block of pid without holding the handler lock,
+// so events keep flowing and block batches fill up meanwhile, then prints the
+// block alert. A block that does not show up is dropped, so the next
+// violation of pid blocks it again, and handled as a processing error: with
+// FailClosed, Run stops before reading its next event.
+func (h *EventHandler) confirmBlock(pid uint32) {
+	defer h.verifies.Done()
+	err := h.verifyBlock(pid)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	record, ok := h.blockedPIDs[pid]
+	if !ok || !record.verifying {
+		return // lifted meanwhile
+	}
+	record.verifying = false
+	h.blockedPIDs[pid] = record
+	if err == nil {
+		h.printBlocked(pid)
+		return
+	}
+
+	delete(h.blockedPIDs, pid)
+	err = fmt.Errorf("block of PID %d not confirmed, dropped it: %w", pid, err)
+	// A block still on its way must not land after it was dropped
+	if unblockErr := h.provider.UnblockPID(pid); unblockErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to unblock PID %d: %w", pid, unblockErr))
+	}
+	if err := h.processingError(err); err != nil {
+		select {
+		case h.failures <- err:
+		default: // Run stops with an earlier failure already
+		}
+	}
+}
+
+// verifyBlock looks pid up in the provider until its block shows up, for up
+// to BlockVerifyGrace, e.g. while a block batch is pending. Providers that
+// cannot look PIDs up are taken at their word.
+func (h *EventHandler) verifyBlock(pid uint32) error {
+	checker, ok := h.provider.(blockChecker)
+	if !ok {
+		return nil
+	}
+	grace := h.config.BlockVerifyGrace
+	if grace <= 0 {
+		grace = defaultBlockVerifyGrace
+	}
+
+	for waited := time.Duration(0); ; waited += blockVerifyInterval {
+		blocked, err := checker.IsPIDBlocked(pid)
+		if err != nil {
+			return fmt.Errorf("failed to verify block: %w", err)
+		}
+		if blocked {
+			return nil
+		}
+		if waited >= grace {
+			return fmt.Errorf("PID %d is not in the blocked list %v after blocking it", pid, grace)
+		}
+		h.sleep(blockVerifyInterval)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lateBlockProvider reports blocks missing from its first misses lookups,
+// like a map update that takes a while to show
+type lateBlockProvider struct {
+	*MockEBPFProvider
+	misses  int
+	lookups int
+}
+
+func (p *lateBlockProvider) IsPIDBlocked(pid uint32) (bool, error) {
+	p.lookups++
+	if p.lookups <= p.misses {
+		return false, nil
+	}
+	return p.MockEBPFProvider.IsPIDBlocked(pid)
+}
+
+func TestEventHandler_VerifyBlocks(t *testing.T) {
+	tests := []struct {
+		name          string
+		verify        bool
+		misses        int
+		expectLookups int
+		expectSleeps  int
+		expectErr     bool
+	}{
+		{name: "verification off", misses: 100, expectLookups: 0},
+		{name: "visible at once", verify: true, expectLookups: 1},
+		{name: "first lookup misses", verify: true, misses: 1, expectLookups: 2, expectSleeps: 1},
+		{name: "never visible", verify: true, misses: 100, expectLookups: 5, expectSleeps: 4, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := NewMockEBPFProvider(context.Background(), nil)
+			defer mock.Close()
+			provider := &lateBlockProvider{MockEBPFProvider: mock, misses: tt.misses}
+
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/passwd"},
+				Threshold:          1,
+				VerifyBlocks:       tt.verify,
+				BlockVerifyGrace:   20 * time.Millisecond,
+			})
+			var sleeps int
+			handler.sleep = func(time.Duration) { sleeps++ }
+
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			out := captureStdout(t, func() {
+				result, err := handler.processEvent(CreateMockEvent(1000, 1000, "cat", "/etc/passwd"))
+				if err != nil {
+					t.Fatalf("processEvent: %v", err)
+				}
+				if !result.Blocked {
+					t.Error("expected PID 1000 to be reported blocked")
+				}
+				handler.verifies.Wait()
+			})
+			alerted := strings.Contains(out, "PID 1000 is now BLOCKED")
+			if tt.expectErr {
+				if alerted {
+					t.Error("expected no block alert for an unverified block")
+				}
+				if !strings.Contains(logs.String(), "not in the blocked list") {
+					t.Errorf("expected the verification failure to be logged, got %q", logs.String())
+				}
+				if _, ok := handler.blockedPIDs[1000]; ok || mock.IsBlocked(1000) {
+					t.Error("expected the unconfirmed block of PID 1000 to be dropped")
+				}
+			} else if !alerted {
+				t.Errorf("expected a block alert, got %q", out)
+			}
+			if provider.lookups != tt.expectLookups {
+				t.Errorf("expected %d lookups, got %d", tt.expectLookups, provider.lookups)
+			}
+			if sleeps != tt.expectSleeps {
+				t.Errorf("expected %d sleeps, got %d", tt.expectSleeps, sleeps)
+			}
+		})
+	}
+}
+
+func TestEventHandler_VerifyBlocksFailClosed(t *testing.T) {
+	mock := NewMockEBPFProvider(context.Background(), nil)
+	defer mock.Close()
+	provider := &lateBlockProvider{MockEBPFProvider: mock, misses: 100}
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/passwd"},
+		Threshold:          1,
+		VerifyBlocks:       true,
+		BlockVerifyGrace:   time.Millisecond,
+		FailClosed:         true,
+	})
+	handler.sleep = func(time.Duration) {}
+
+	captureStdout(t, func() {
+		if _, err := handler.processEvent(CreateMockEvent(1000, 1000, "cat", "/etc/passwd")); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		handler.verifies.Wait()
+	})
+
+	// Run stops with the failure
+	select {
+	case err := <-handler.failures:
+		if !strings.Contains(err.Error(), "failing closed") || !strings.Contains(err.Error(), "PID 1000 not confirmed") {
+			t.Errorf("got %v, want a fail-closed error for PID 1000", err)
+		}
+	default:
+		t.Error("expected the unconfirmed block to stop Run")
+	}
+}
+
+// gatedBlockProvider holds each block lookup until gate is closed
+type gatedBlockProvider struct {
+	*MockEBPFProvider
+	gate chan struct{}
+}
+
+func (p *gatedBlockProvider) IsPIDBlocked(pid uint32) (bool, error) {
+	<-p.gate
+	return p.MockEBPFProvider.IsPIDBlocked(pid)
+}
+
+func TestEventHandler_VerifyBlocksUnlocked(t *testing.T) {
+	mock := NewMockEBPFProvider(context.Background(), nil)
+	defer mock.Close()
+	provider := &gatedBlockProvider{MockEBPFProvider: mock, gate: make(chan struct{})}
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/passwd"},
+		Threshold:          1,
+		VerifyBlocks:       true,
+	})
+
+	out := captureStdout(t, func() {
+		if _, err := handler.processEvent(CreateMockEvent(1000, 1000, "cat", "/etc/passwd")); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		// The lookup of PID 1000 is pending, the next block goes ahead
+		// meanwhile
+		result, err := handler.processEvent(CreateMockEvent(2000, 1000, "less", "/etc/passwd"))
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		if !result.Blocked {
+			t.Error("expected PID 2000 to be blocked while PID 1000 is verified")
+		}
+		close(provider.gate)
+		handler.verifies.Wait()
+	})
+
+	for _, pid := range []string{"1000", "2000"} {
+		if !strings.Contains(out, "PID "+pid+" is now BLOCKED") {
+			t.Errorf("expected a block alert for PID %s once verified, got %q", pid, out)
+		}
+	}
+}
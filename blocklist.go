@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlocklistEntry is a process an external blocklist wants blocked: one PID,
+// or every process running a command
+type BlocklistEntry struct {
+	PID  uint32 // 0 if Comm is set
+	Comm string // as the kernel reports it, truncated to 15 bytes
+}
+
+// String returns the entry as it is written in a blocklist file
+func (e BlocklistEntry) String() string {
+	if e.Comm != "" {
+		return e.Comm
+	}
+	return strconv.FormatUint(uint64(e.PID), 10)
+}
+
+// BlocklistSource feeds processes to block from outside the fence, e.g. a
+// centrally maintained list of bad PIDs and commands. The handler blocks
+// every entry as soon as it arrives, regardless of violations.
+type BlocklistSource interface {
+	// Watch sends entries until ctx is done, then closes the channel
+	Watch(ctx context.Context) <-chan BlocklistEntry
+}
+
+// defaultBlocklistInterval is how often a FileBlocklistSource checks its file
+const defaultBlocklistInterval = 5 * time.Second
+
+// FileBlocklistSource is a BlocklistSource reading a file with one entry per
+// line, a PID or a command name; blank lines and lines starting with # are
+// ignored. The file is reread whenever its size or modification time changes,
+// and entries are sent the first time they appear. Removing an entry from the
+// file does not unblock it.
+type FileBlocklistSource struct {
+	path     string
+	interval time.Duration
+}
+
+// NewFileBlocklistSource returns a source watching the file at path, checked
+// every interval
+func NewFileBlocklistSource(path string, interval time.Duration) *FileBlocklistSource {
+	return &FileBlocklistSource{path: path, interval: interval}
+}
+
+// Watch implements BlocklistSource. A missing or unreadable file is logged
+// and retried at the next check.
+func (s *FileBlocklistSource) Watch(ctx context.Context) <-chan BlocklistEntry {
+	entries := make(chan BlocklistEntry)
+	go func() {
+		defer close(entries)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		seen := make(map[BlocklistEntry]bool)
+		var lastSize int64
+		var lastMod time.Time
+		for {
+			info, err := os.Stat(s.path)
+			if err != nil {
+				log.Printf("blocklist: %v", err)
+			} else if info.Size() != lastSize || !info.ModTime().Equal(lastMod) {
+				lastSize, lastMod = info.Size(), info.ModTime()
+				if !s.send(ctx, entries, seen) {
+					return
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return entries
+}
+
+// send reads the file and sends the entries not seen before. It reports
+// false if ctx was done first.
+func (s *FileBlocklistSource) send(ctx context.Context, entries chan<- BlocklistEntry, seen map[BlocklistEntry]bool) bool {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		log.Printf("blocklist: %v", err)
+		return true
+	}
+
+	list, err := parseBlocklist(data)
+	if err != nil {
+		log.Printf("blocklist %s: %v", s.path, err)
+	}
+	for _, entry := range list {
+		if seen[entry] {
+			continue
+		}
+		select {
+		case entries <- entry:
+			seen[entry] = true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// parseBlocklist parses a blocklist file. Invalid lines are skipped and
+// reported together in the error, with the valid entries still returned.
+func parseBlocklist(data []byte) ([]BlocklistEntry, error) {
+	var entries []BlocklistEntry
+	var invalid []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		// Commands may start with a digit, e.g. 7zip; only a line of
+		// nothing but digits is a PID
+		if strings.Trim(text, "0123456789") == "" {
+			pid, err := strconv.ParseUint(text, 10, 32)
+			if err != nil || pid == 0 {
+				invalid = append(invalid, fmt.Sprintf("line %d: invalid PID %q", line, text))
+				continue
+			}
+			entries = append(entries, BlocklistEntry{PID: uint32(pid)})
+			continue
+		}
+		if len(text) > commLen {
+			text = text[:commLen]
+		}
+		entries = append(entries, BlocklistEntry{Comm: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, err
+	}
+	if len(invalid) > 0 {
+		return entries, fmt.Errorf("%s", strings.Join(invalid, "; "))
+	}
+	return entries, nil
+}
+
+// SetBlocklistSource makes Run block every process source sends. It must be
+// called before Run.
+func (h *EventHandler) SetBlocklistSource(source BlocklistSource) {
+	h.blocklist = source
+}
+
+// watchBlocklist blocks the processes the blocklist source sends until ctx is
+// done
+func (h *EventHandler) watchBlocklist(ctx context.Context) {
+	for entry := range h.blocklist.Watch(ctx) {
+		if err := h.applyBlocklistEntry(entry); err != nil {
+			log.Printf("blocklist: %v", err)
+		}
+	}
+}
+
+// applyBlocklistEntry blocks the PID of entry, or every running process with
+// its command and any that later opens a file. A PID that is not running is
+// not blocked, so the process that later gets it is not. /proc is scanned
+// before h.mu is taken, so events are not held up meanwhile.
+func (h *EventHandler) applyBlocklistEntry(entry BlocklistEntry) error {
+	if entry.Comm == "" {
+		comm, err := h.proc.comm(entry.PID)
+		if err != nil {
+			return fmt.Errorf("not blocking PID %d, which is not running: %w", entry.PID, err)
+		}
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		_, err = h.blockListed(&Event{Pid: entry.PID}, comm, entry)
+		return err
+	}
+
+	pids, scanErr := h.proc.pids()
+	var running []uint32
+	for _, pid := range pids {
+		if comm, err := h.proc.comm(pid); err == nil && comm == entry.Comm {
+			running = append(running, pid)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.blockedComms[commKey(entry.Comm)] = true
+	if scanErr != nil {
+		return fmt.Errorf("blocking running %s processes: %w", entry.Comm, scanErr)
+	}
+	var errs []error
+	for _, pid := range running {
+		if _, err := h.blockListed(&Event{Pid: pid}, entry.Comm, entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("blocking running %s processes: %v", entry.Comm, errs)
+	}
+	return nil
+}
+
+// processBlocklistedComm blocks the PID of an event whose command is on the
+// blocklist and reports whether it did
+func (h *EventHandler) processBlocklistedComm(event *Event) (bool, error) {
+	if len(h.blockedComms) == 0 || !h.blockedComms[event.Comm] || h.isBlocked(event.Pid) {
+		return false, nil
+	}
+	comm := string(bytes.TrimRight(event.Comm[:], "\x00"))
+	return h.blockListed(event, comm, BlocklistEntry{Comm: comm})
+}
+
+// blockListed blocks the PID of event because entry is on the blocklist and
+// reports whether it did. The fence itself is never blocked.
+func (h *EventHandler) blockListed(event *Event, comm string, entry BlocklistEntry) (bool, error) {
+	if event.Pid == h.selfPID || h.isBlocked(event.Pid) {
+		return false, nil
+	}
+
+	h.blockedPIDs[event.Pid] = blockRecord{comm: comm}
+	if err := h.blockPID(event.Pid, BlockReasonBlocklist, ""); err != nil {
+		return false, fmt.Errorf("failed to block PID %d: %w", event.Pid, err)
+	}
+	fmt.Printf("%s[BLOCKLIST] PID %d (%s) is on the external blocklist as %q\n", h.relativeTime(), event.Pid, comm, entry)
+	h.printBlocked(event.Pid)
+	h.audit("block", event, comm, "", 0, 0, ProcessResult{Blocked: true})
+	return true, nil
+}
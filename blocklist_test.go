@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeBlocklist is a BlocklistSource fed by the test
+type fakeBlocklist struct {
+	entries chan BlocklistEntry
+}
+
+func (f fakeBlocklist) Watch(ctx context.Context) <-chan BlocklistEntry {
+	out := make(chan BlocklistEntry)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case entry := <-f.entries:
+				select {
+				case out <- entry:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// waitBlocked waits up to a second for pid to be blocked in the provider
+func waitBlocked(provider *MockEBPFProvider, pid uint32) bool {
+	deadline := time.Now().Add(time.Second)
+	for !provider.IsBlocked(pid) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	return provider.IsBlocked(pid)
+}
+
+func TestEventHandler_BlocklistSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A blocklisted command opening a file that is not disallowed, after the
+	// entries below arrived. The last event keeps the mock from holding its
+	// lock while out of events.
+	events := []*Event{
+		CreateMockEvent(6000, 1000, "miner", "/tmp/work"),
+		CreateMockEvent(7000, 1000, "bash", "/tmp/work"),
+	}
+	provider := NewMockEBPFProviderWithDelays(ctx, events, []time.Duration{200 * time.Millisecond, time.Hour})
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow"},
+		Threshold:          10,
+	})
+	handler.proc = fakeProc(t, map[uint32]string{5000: "miner", 5001: "bash", 4242: "nc"})
+	source := fakeBlocklist{entries: make(chan BlocklistEntry)}
+	handler.SetBlocklistSource(source)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	// A listed PID is blocked at once, with no violations
+	source.entries <- BlocklistEntry{PID: 4242}
+	if !waitBlocked(provider, 4242) {
+		t.Fatal("expected PID 4242 to be blocked")
+	}
+	if got := handler.GetViolationCountForPID(4242); got != 0 {
+		t.Errorf("expected no violations, got %d", got)
+	}
+	if summary := handler.GetBlockedSummary(); !reflect.DeepEqual(summary["nc"], []uint32{4242}) {
+		t.Errorf("expected PID 4242 to be recorded as nc, got %v", summary)
+	}
+
+	// A listed command blocks its running processes
+	source.entries <- BlocklistEntry{Comm: "miner"}
+	if !waitBlocked(provider, 5000) {
+		t.Fatal("expected the running miner to be blocked")
+	}
+	if provider.IsBlocked(5001) {
+		t.Error("expected other commands not to be blocked")
+	}
+
+	// and any that shows up later, on its first event
+	if !waitBlocked(provider, 6000) {
+		t.Error("expected a new miner to be blocked on its first open")
+	}
+
+	cancel()
+	<-done
+}
+
+func TestEventHandler_BlocklistNeverBlocksSelf(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{DisallowedPatterns: []string{"/etc/shadow"}})
+	handler.proc = fakeProc(t, map[uint32]string{handler.selfPID: "ebpfence"})
+
+	if err := handler.applyBlocklistEntry(BlocklistEntry{PID: handler.selfPID}); err != nil {
+		t.Fatalf("applyBlocklistEntry: %v", err)
+	}
+	if provider.IsBlocked(handler.selfPID) {
+		t.Error("expected the fence never to block itself")
+	}
+}
+
+func TestEventHandler_BlocklistSkipsExitedPID(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{DisallowedPatterns: []string{"/etc/shadow"}})
+	handler.proc = fakeProc(t, nil)
+
+	// The process that later gets the PID did nothing to be blocked for
+	if err := handler.applyBlocklistEntry(BlocklistEntry{PID: 4242}); err == nil {
+		t.Error("expected an error for a PID that is not running")
+	}
+	if provider.IsBlocked(4242) || handler.IsPIDBlocked(4242) {
+		t.Error("expected a PID that is not running not to be blocked")
+	}
+}
+
+func TestParseBlocklist(t *testing.T) {
+	data := []byte(`# known bad
+1234
+
+xmrig
+  kworker-lookalike-miner
+0
+12ab
+7zip
+99999999999
+`)
+	entries, err := parseBlocklist(data)
+	if err == nil {
+		t.Error("expected the invalid PIDs to be reported")
+	}
+	expected := []BlocklistEntry{
+		{PID: 1234},
+		{Comm: "xmrig"},
+		{Comm: "kworker-lookali"}, // truncated like the kernel's comm
+		{Comm: "12ab"},
+		{Comm: "7zip"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %v, got %v", expected, entries)
+	}
+}
+
+func TestFileBlocklistSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "blocklist")
+	if err := os.WriteFile(path, []byte("1234\nxmrig\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := NewFileBlocklistSource(path, 10*time.Millisecond).Watch(ctx)
+	receive := func() BlocklistEntry {
+		t.Helper()
+		select {
+		case entry := <-entries:
+			return entry
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a blocklist entry")
+			return BlocklistEntry{}
+		}
+	}
+
+	if got := []BlocklistEntry{receive(), receive()}; !reflect.DeepEqual(got, []BlocklistEntry{{PID: 1234}, {Comm: "xmrig"}}) {
+		t.Errorf("unexpected initial entries %v", got)
+	}
+
+	// Only the added entry is sent again
+	if err := os.WriteFile(path, []byte("1234\nxmrig\n5678\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(); got != (BlocklistEntry{PID: 5678}) {
+		t.Errorf("expected the added PID 5678, got %v", got)
+	}
+
+	cancel()
+	for range entries {
+	}
+}
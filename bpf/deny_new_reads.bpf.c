@@ -8,21 +8,99 @@ char LICENSE[] SEC("license") = "GPL";
 
 #define EACCES 13
 #define EPERM 1
+#define AT_FDCWD -100
+
+// Event types sent to userspace
+#define EVENT_OPEN 0
+#define EVENT_READ 1
+#define EVENT_RENAME 2
+#define EVENT_DENIED 3
+
+// Why a PID was blocked, written by userspace
+#define BLOCK_REASON_UNKNOWN 0
+#define BLOCK_REASON_THRESHOLD 1
+#define BLOCK_REASON_IMMEDIATE 2
+#define BLOCK_REASON_BYTES 3
+#define BLOCK_REASON_RAPID_OPEN 4
+#define BLOCK_REASON_BLOCKLIST 5
+#define BLOCK_REASON_MANUAL 6
+#define BLOCK_REASON_PANIC 7
+
+// Value of a blocked PID; only its presence is checked here
+struct block_info {
+    __u32 reason;           // BLOCK_REASON_*
+    __u32 rule;             // Index of the triggering pattern, 0xffffffff if none
+    __u64 blocked_at;       // Unix nanoseconds
+};
 
 // Array to hold blocked PIDs
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
     __uint(max_entries, 10240);
     __type(key, __u32);   // PID
-    __type(value, __u8);  // 1 if blocked
+    __type(value, struct block_info);
 } blocked_pids SEC(".maps");
 
+// Identifies a file independently of the path used to open it
+struct inode_key {
+    __u64 dev;              // Device of the filesystem, in kernel dev_t encoding
+    __u64 ino;              // Inode number
+};
+
+// Files that may not be opened by any process, whatever their path
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, struct inode_key);
+    __type(value, __u8);  // 1 if blocked
+} blocked_inodes SEC(".maps");
+
+// Where blocked PIDs are denied: at open, at read so they may still open
+// files (e.g. to fstat them) but not read their contents, or at both so fds
+// opened before the block can no longer be read either
+#define ENFORCE_OPEN 0
+#define ENFORCE_READ 1
+#define ENFORCE_OPEN_AND_READ 2
+
+// Single slot holding ENFORCE_OPEN, ENFORCE_READ or ENFORCE_OPEN_AND_READ
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u32);
+} enforcement_point SEC(".maps");
+
+static __always_inline __u32 enforcement(void) {
+    __u32 zero = 0;
+    __u32 *point = bpf_map_lookup_elem(&enforcement_point, &zero);
+
+    return point ? *point : ENFORCE_OPEN;
+}
+
+// Defined below, once event_t and the ring buffers are
+static __always_inline void emit_denied(struct file *file);
+
 SEC("lsm/file_open") // sleepable hook variant
 int BPF_PROG(deny_file_open, struct file *file, const struct cred *cred){
     __u64 pid_tgid = bpf_get_current_pid_tgid();
     __u32 pid = pid_tgid >> 32;
     char comm[16];
     __u8 *blocked;
+    struct inode_key key = {};
+
+    // Blocked files are denied by inode, so hardlinks and renames don't help
+    key.dev = BPF_CORE_READ(file, f_inode, i_sb, s_dev);
+    key.ino = BPF_CORE_READ(file, f_inode, i_ino);
+    if (bpf_map_lookup_elem(&blocked_inodes, &key)) {
+        bpf_get_current_comm(&comm, sizeof(comm));
+        bpf_printk("BLOCKED: PID %d (%s) denied blocked inode %llu", pid, comm, key.ino);
+        return -EPERM;
+    }
+
+    // Blocked PIDs are then denied by deny_file_read instead
+    if (enforcement() == ENFORCE_READ) {
+        return 0;
+    }
 
     // Look up the PID in the blocked_pids map
     blocked = bpf_map_lookup_elem(&blocked_pids, &pid);
@@ -34,26 +112,198 @@ int BPF_PROG(deny_file_open, struct file *file, const struct cred *cred){
     bpf_get_current_comm(&comm, sizeof(comm));
     bpf_printk("BLOCKED: PID %d (%s) denied file permission", pid, comm);
 
+    // Confirm to userspace that the block is enforced
+    emit_denied(file);
+
     // Block the access
     return -EPERM;
 }
 
+#define MAY_READ 0x00000004
+
+SEC("lsm/file_permission")
+int BPF_PROG(deny_file_read, struct file *file, int mask){
+    __u32 pid = bpf_get_current_pid_tgid() >> 32;
+    char comm[16];
+
+    if (!(mask & MAY_READ) || enforcement() == ENFORCE_OPEN) {
+        return 0;
+    }
+    if (!bpf_map_lookup_elem(&blocked_pids, &pid)) {
+        return 0;
+    }
+
+    bpf_get_current_comm(&comm, sizeof(comm));
+    bpf_printk("BLOCKED: PID %d (%s) denied file read", pid, comm);
+    emit_denied(file);
+
+    return -EACCES;
+}
+
 // Structure to hold the data we want to send to userspace
 struct event_t {
     __u32 pid;              // Process ID
     __u32 uid;              // User ID
     char comm[16];          // Process name (command)
-    char filename[256];     // File path
+    char filename[256];     // File path (the new path for EVENT_RENAME)
     int flags;              // Open flags
+    __u32 _pad;             // Explicit padding so resolve is 8-byte aligned
+    __u64 resolve;          // openat2 RESOLVE_* flags (0 for openat)
+    __u32 ns_pid;           // Process ID inside its own PID namespace
+    __u32 pid_ns;           // Inode number of that PID namespace
+    __u32 ppid;             // Parent process ID
+    __u32 type;             // EVENT_OPEN, EVENT_READ, EVENT_RENAME or EVENT_DENIED
+    __u64 bytes;            // Bytes returned by read (EVENT_READ only)
+    __u32 fd;               // File descriptor read from, or returned by a successful open
+    __u32 tid;              // Thread ID; pid is the thread group ID
+    __u64 timestamp;        // CLOCK_BOOTTIME nanoseconds when the event fired
+    __u64 start_time;       // CLOCK_BOOTTIME nanoseconds when the process started, telling reused PIDs apart
+    __u64 dev;              // Device of the file read, in kernel dev_t encoding (EVENT_READ only)
+    __u64 ino;              // Inode of the file read (EVENT_READ only)
+    __u64 cgroup_id;        // cgroup v2 ID of the process when the event fired
 };
 
+// Keep event_t in the object's BTF so userspace can check its layout
+const struct event_t *unused_event_t __attribute__((unused));
+
+// Fill in the namespace-local PID of the current process. This reads the
+// innermost PID namespace via CO-RE rather than bpf_get_ns_current_pid_tgid,
+// which would need the namespace to be known up front.
+static __always_inline void fill_ns_pid(struct event_t *e) {
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    struct pid *tgid = BPF_CORE_READ(task, group_leader, thread_pid);
+    unsigned int level = BPF_CORE_READ(tgid, level);
+    struct upid upid = {};
+
+    bpf_core_read(&upid, sizeof(upid), &tgid->numbers[level]);
+    e->ns_pid = upid.nr;
+    e->pid_ns = BPF_CORE_READ(upid.ns, ns.inum);
+}
+
+// Fill in the parent PID, the start time and the cgroup of the current
+// process
+static __always_inline void fill_ppid(struct event_t *e) {
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+
+    e->ppid = BPF_CORE_READ(task, real_parent, tgid);
+    e->start_time = BPF_CORE_READ(task, group_leader, start_boottime);
+    e->cgroup_id = bpf_get_current_cgroup_id();
+}
+
+// Mark an event as an open; the read-only fields are zeroed
+static __always_inline void set_open_event(struct event_t *e) {
+    e->type = EVENT_OPEN;
+    e->bytes = 0;
+    e->fd = 0;
+    e->dev = 0;
+    e->ino = 0;
+}
+
 // Create a ring buffer to send events to userspace
 struct {
     __uint(type, BPF_MAP_TYPE_RINGBUF);
     __uint(max_entries, 256 * 1024); // 256 KB ring buffer
 } events SEC(".maps");
 
-// Track per-PID file open count for disallowed files
+// Template for the ring buffers in event_shards, which userspace creates
+struct event_shard {
+    __uint(type, BPF_MAP_TYPE_RINGBUF);
+    __uint(max_entries, 256 * 1024);
+};
+
+// Extra ring buffers events are spread over by CPU, so busy CPUs don't
+// contend on one buffer. Userspace sizes and fills it.
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY_OF_MAPS);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __array(values, struct event_shard);
+} event_shards SEC(".maps");
+
+// Entry 0 is how many event_shards are filled in, 0 to use only events
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u32);
+} event_shard_count SEC(".maps");
+
+// Reserve an event in this CPU's shard, or in events when there are none
+static __always_inline struct event_t *reserve_event(void) {
+    __u32 zero = 0;
+    __u32 *count = bpf_map_lookup_elem(&event_shard_count, &zero);
+
+    if (count && *count) {
+        __u32 shard = bpf_get_smp_processor_id() % *count;
+        void *rb = bpf_map_lookup_elem(&event_shards, &shard);
+
+        if (rb)
+            return bpf_ringbuf_reserve(rb, sizeof(struct event_t), 0);
+    }
+    return bpf_ringbuf_reserve(&events, sizeof(struct event_t), 0);
+}
+
+// A blocked PID reports at most DENIED_PER_INTERVAL denials per
+// DENIED_INTERVAL_NS, so one retrying in a loop cannot flood the ring buffer
+#define DENIED_PER_INTERVAL 8
+#define DENIED_INTERVAL_NS 1000000000ULL
+
+// Denials reported for a PID in its current interval
+struct denied_rate {
+    __u64 interval_start;   // CLOCK_BOOTTIME nanoseconds
+    __u32 count;
+    __u32 _pad;
+};
+
+// Denial rate per PID; least recently denied PIDs are evicted
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 10240);
+    __type(key, __u32);   // PID
+    __type(value, struct denied_rate);
+} denied_rate SEC(".maps");
+
+// Whether another denial of pid may be reported in the current interval
+static __always_inline bool denial_allowed(__u32 pid, __u64 now) {
+    struct denied_rate *rate = bpf_map_lookup_elem(&denied_rate, &pid);
+
+    if (!rate || now - rate->interval_start >= DENIED_INTERVAL_NS) {
+        struct denied_rate fresh = { .interval_start = now, .count = 1 };
+
+        bpf_map_update_elem(&denied_rate, &pid, &fresh, BPF_ANY);
+        return true;
+    }
+    return __sync_fetch_and_add(&rate->count, 1) < DENIED_PER_INTERVAL;
+}
+
+// Report that a blocked PID was denied access to file. Denials bypass the
+// UID, comm and sampling filters, as they confirm a block userspace made,
+// but are rate limited per PID.
+static __always_inline void emit_denied(struct file *file) {
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    __u64 now = bpf_ktime_get_boot_ns();
+
+    if (!denial_allowed(pid_tgid >> 32, now))
+        return;
+
+    struct event_t *e = reserve_event();
+
+    if (!e)
+        return;
+    __builtin_memset(e, 0, sizeof(*e));
+    e->pid = pid_tgid >> 32;
+    e->tid = (__u32)pid_tgid;
+    e->uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
+    bpf_get_current_comm(&e->comm, sizeof(e->comm));
+    bpf_d_path(&file->f_path, e->filename, sizeof(e->filename));
+    fill_ns_pid(e);
+    fill_ppid(e);
+    e->type = EVENT_DENIED;
+    e->timestamp = now;
+    bpf_ringbuf_submit(e, 0);
+}
+
+// Per-PID violation counts, written by userspace for the status command
 struct {
     __uint(type, BPF_MAP_TYPE_HASH);
     __uint(max_entries, 10240);
@@ -61,21 +311,186 @@ struct {
     __type(value, __u32); // Count of disallowed files opened
 } pid_violation_count SEC(".maps");
 
+// UIDs whose events are sent to userspace when the UID filter is on
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 1024);
+    __type(key, __u32);   // UID
+    __type(value, __u8);  // 1 if targeted
+} target_uids SEC(".maps");
+
+// Entry 0 is 1 when only events from target_uids are sent to userspace
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u32);
+} uid_filter SEC(".maps");
+
+// Whether events from uid should be sent to userspace, so events from
+// untargeted users never cross the ring buffer
+static __always_inline bool uid_targeted(__u32 uid) {
+    __u32 zero = 0;
+    __u32 *enabled = bpf_map_lookup_elem(&uid_filter, &zero);
+
+    if (!enabled || !*enabled)
+        return true;
+    return bpf_map_lookup_elem(&target_uids, &uid) != NULL;
+}
+
+// Command names whose events are never sent to userspace, keyed by the
+// NUL-padded comm as bpf_get_current_comm returns it
+struct comm_key {
+    char comm[16];
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 256);
+    __type(key, struct comm_key);
+    __type(value, __u8); // 1 if allowed
+} allowed_comms SEC(".maps");
+
+// Whether the current command is allowlisted, so its events are dropped
+// before they cross the ring buffer
+static __always_inline bool comm_allowed(void) {
+    struct comm_key key = {};
+
+    bpf_get_current_comm(&key.comm, sizeof(key.comm));
+    return bpf_map_lookup_elem(&allowed_comms, &key) != NULL;
+}
+
+// Entry 0 is N to send only every Nth event to userspace, 0 or 1 for all
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u32);
+} sample_rate SEC(".maps");
+
+// Entry 0 counts the events that passed the filters on this CPU. Counting
+// per CPU avoids contention; overall every Nth event is still sent.
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} sample_counter SEC(".maps");
+
+// Whether this event is one of the sampled ones sent to userspace
+static __always_inline bool sampled(void) {
+    __u32 zero = 0;
+    __u32 *rate = bpf_map_lookup_elem(&sample_rate, &zero);
+    __u64 *seen;
+
+    if (!rate || *rate <= 1)
+        return true;
+    seen = bpf_map_lookup_elem(&sample_counter, &zero);
+    if (!seen)
+        return true;
+    return (*seen)++ % *rate == 0;
+}
+
+// Entry 0 is 1 when only opens that returned an fd are sent to userspace
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u32);
+} successful_opens_only SEC(".maps");
+
+// Open events held, keyed by pid_tgid, until the syscall returns and shows
+// whether the open succeeded
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, __u64);              // pid_tgid
+    __type(value, struct event_t);
+} pending_opens SEC(".maps");
+
+// Per-CPU room to build an open event that is held in pending_opens rather
+// than sent, so holding it takes no ring buffer space
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, struct event_t);
+} open_scratch SEC(".maps");
+
+// Whether open events are held until the syscall returns, because only
+// successful opens are reported
+static __always_inline bool opens_held(void) {
+    __u32 zero = 0;
+    __u32 *only = bpf_map_lookup_elem(&successful_opens_only, &zero);
+
+    return only && *only;
+}
+
+// Get room for an open event: the per-CPU scratch event when it is held,
+// ring buffer space otherwise
+static __always_inline struct event_t *open_event(bool held) {
+    __u32 zero = 0;
+
+    if (held)
+        return bpf_map_lookup_elem(&open_scratch, &zero);
+    return reserve_event();
+}
+
+// Send an open event got from open_event to userspace, or hold it in
+// pending_opens until the syscall returns
+static __always_inline void submit_open(struct event_t *e, __u64 pid_tgid, bool held) {
+    if (held) {
+        bpf_map_update_elem(&pending_opens, &pid_tgid, e, BPF_ANY);
+        return;
+    }
+    bpf_ringbuf_submit(e, 0);
+}
+
+// Send the open held by submit_open if it returned an fd, which is reported
+// in the event
+static __always_inline int submit_pending_open(struct trace_event_raw_sys_exit *ctx) {
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    struct event_t *pending, *e;
+
+    pending = bpf_map_lookup_elem(&pending_opens, &pid_tgid);
+    if (!pending)
+        return 0;
+
+    if (ctx->ret >= 0) {
+        e = reserve_event();
+        if (e) {
+            bpf_probe_read_kernel(e, sizeof(*e), pending);
+            e->fd = ctx->ret;
+            bpf_ringbuf_submit(e, 0);
+        }
+    }
+    bpf_map_delete_elem(&pending_opens, &pid_tgid);
+
+    return 0;
+}
+
 // Hook into the openat syscall tracepoint
 SEC("tracepoint/syscalls/sys_enter_openat")
 int trace_openat(struct trace_event_raw_sys_enter *ctx) {
     struct event_t *e;
+    bool held;
     __u64 pid_tgid = bpf_get_current_pid_tgid();
     __u32 pid = pid_tgid >> 32;
+    __u32 uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
+
+    if (!uid_targeted(uid) || comm_allowed() || !sampled())
+        return 0;
 
-    // Reserve space in ring buffer
-    e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    // Reserve space in ring buffer, unless the event is held
+    held = opens_held();
+    e = open_event(held);
     if (!e)
         return 0;
 
     // Get process information
     e->pid = pid;
-    e->uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
+    e->tid = (__u32)pid_tgid;
+    e->uid = uid;
 
     // Get process name
     bpf_get_current_comm(&e->comm, sizeof(e->comm));
@@ -85,9 +500,15 @@ int trace_openat(struct trace_event_raw_sys_enter *ctx) {
 
     // Get the flags (arg2 for openat)
     e->flags = (int)ctx->args[2];
+    e->_pad = 0;
+    e->resolve = 0;
+    fill_ns_pid(e);
+    fill_ppid(e);
+    e->timestamp = bpf_ktime_get_boot_ns();
+    set_open_event(e);
 
     // Submit the event to userspace
-    bpf_ringbuf_submit(e, 0);
+    submit_open(e, pid_tgid, held);
 
     return 0;
 }
@@ -96,22 +517,253 @@ int trace_openat(struct trace_event_raw_sys_enter *ctx) {
 SEC("tracepoint/syscalls/sys_enter_openat2")
 int trace_openat2(struct trace_event_raw_sys_enter *ctx) {
     struct event_t *e;
+    bool held;
     __u64 pid_tgid = bpf_get_current_pid_tgid();
     __u32 pid = pid_tgid >> 32;
+    __u32 uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
+
+    if (!uid_targeted(uid) || comm_allowed() || !sampled())
+        return 0;
 
-    e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    held = opens_held();
+    e = open_event(held);
     if (!e)
         return 0;
 
     e->pid = pid;
-    e->uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
+    e->tid = (__u32)pid_tgid;
+    e->uid = uid;
 
     bpf_get_current_comm(&e->comm, sizeof(e->comm));
     bpf_probe_read_user_str(&e->filename, sizeof(e->filename), (void *)ctx->args[1]);
-    e->flags = 0;  // openat2 has a different structure for flags
+
+    // openat2 passes flags in a struct open_how (arg2) rather than as an int;
+    // the open flags fit in an int just like openat's
+    struct open_how how = {};
+    bpf_probe_read_user(&how, sizeof(how), (void *)ctx->args[2]);
+    e->flags = (int)how.flags;
+    e->_pad = 0;
+    e->resolve = how.resolve;
+    fill_ns_pid(e);
+    fill_ppid(e);
+    e->timestamp = bpf_ktime_get_boot_ns();
+    set_open_event(e);
+
+    submit_open(e, pid_tgid, held);
+
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_openat")
+int trace_openat_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_pending_open(ctx);
+}
+
+SEC("tracepoint/syscalls/sys_exit_openat2")
+int trace_openat2_exit(struct trace_event_raw_sys_exit *ctx) {
+    return submit_pending_open(ctx);
+}
+
+
+// Report renames, so that moving a file into a disallowed path after it
+// passed the open check still counts. The event carries the new path and, in
+// fd, the directory fd a relative new path is resolved against.
+static __always_inline int submit_rename(const char *newpath, int newdirfd) {
+    struct event_t *e;
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    __u32 uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
+
+    if (!uid_targeted(uid) || comm_allowed() || !sampled())
+        return 0;
+
+    e = reserve_event();
+    if (!e)
+        return 0;
+
+    e->pid = pid_tgid >> 32;
+    e->tid = (__u32)pid_tgid;
+    e->uid = uid;
+    bpf_get_current_comm(&e->comm, sizeof(e->comm));
+
+    bpf_probe_read_user_str(&e->filename, sizeof(e->filename), newpath);
+    e->flags = 0;
+    e->_pad = 0;
+    e->resolve = 0;
+    fill_ns_pid(e);
+    fill_ppid(e);
+    e->timestamp = bpf_ktime_get_boot_ns();
+    e->type = EVENT_RENAME;
+    e->bytes = 0;
+    e->fd = (__u32)newdirfd;
+    e->dev = 0;
+    e->ino = 0;
 
     bpf_ringbuf_submit(e, 0);
 
     return 0;
 }
 
+// rename(oldpath, newpath), only on some architectures, e.g. not arm64
+SEC("tracepoint/syscalls/sys_enter_rename")
+int trace_rename(struct trace_event_raw_sys_enter *ctx) {
+    return submit_rename((const char *)ctx->args[1], AT_FDCWD);
+}
+
+// renameat(olddirfd, oldpath, newdirfd, newpath)
+SEC("tracepoint/syscalls/sys_enter_renameat")
+int trace_renameat(struct trace_event_raw_sys_enter *ctx) {
+    return submit_rename((const char *)ctx->args[3], (int)ctx->args[2]);
+}
+
+// renameat2(olddirfd, oldpath, newdirfd, newpath, flags)
+SEC("tracepoint/syscalls/sys_enter_renameat2")
+int trace_renameat2(struct trace_event_raw_sys_enter *ctx) {
+    return submit_rename((const char *)ctx->args[3], (int)ctx->args[2]);
+}
+
+// Entry 0 is the number of bytes a process may read from one file before
+// the read is reported, 0 while read tracking is off
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __uint(max_entries, 1);
+    __type(key, __u32);
+    __type(value, __u64);
+} byte_threshold SEC(".maps");
+
+// An in-flight read: the fd passed and the file behind it
+struct pending_read {
+    __u32 fd;
+    __u32 _pad;
+    struct inode_key file;
+};
+
+// In-flight reads keyed by pid_tgid, so that the number of bytes returned
+// can be attributed to the file on syscall exit
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __uint(max_entries, 10240);
+    __type(key, __u64);   // pid_tgid
+    __type(value, struct pending_read);
+} pending_reads SEC(".maps");
+
+// Identifies a file read by a process
+struct read_key {
+    __u32 pid;
+    __u32 _pad;
+    struct inode_key file;
+};
+
+// Bytes each process read from each file, so that only crossing
+// byte_threshold is reported rather than every read. LRU, as processes exit
+// without telling us.
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __uint(max_entries, 65536);
+    __type(key, struct read_key);
+    __type(value, __u64); // bytes read
+} read_bytes SEC(".maps");
+
+// Look up the file behind fd in the current process
+static __always_inline bool fd_inode(__u32 fd, struct inode_key *key) {
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    struct fdtable *fdt = BPF_CORE_READ(task, files, fdt);
+    struct file **fds = BPF_CORE_READ(fdt, fd);
+    struct file *file = NULL;
+
+    if (fd >= BPF_CORE_READ(fdt, max_fds))
+        return false;
+    bpf_probe_read_kernel(&file, sizeof(file), &fds[fd]);
+    if (!file)
+        return false;
+    key->dev = BPF_CORE_READ(file, f_inode, i_sb, s_dev);
+    key->ino = BPF_CORE_READ(file, f_inode, i_ino);
+    return true;
+}
+
+// Remember which file a read is for. Attached to read, pread64, readv and
+// preadv, which all pass the fd first.
+SEC("tracepoint/syscalls/sys_enter_read")
+int trace_read_enter(struct trace_event_raw_sys_enter *ctx) {
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    struct pending_read pending = {};
+    __u32 zero = 0;
+    __u64 *threshold = bpf_map_lookup_elem(&byte_threshold, &zero);
+
+    if (!threshold || !*threshold)
+        return 0;
+    pending.fd = (__u32)ctx->args[0];
+    if (!fd_inode(pending.fd, &pending.file))
+        return 0;
+    bpf_map_update_elem(&pending_reads, &pid_tgid, &pending, BPF_ANY);
+
+    return 0;
+}
+
+// Add the bytes a read returned to the process's total for the file, and
+// report the read that takes the total past byte_threshold. Later reads of
+// the file are only counted. Reads are not sampled, as only one event per
+// process and file crosses the ring buffer anyway.
+SEC("tracepoint/syscalls/sys_exit_read")
+int trace_read_exit(struct trace_event_raw_sys_exit *ctx) {
+    struct event_t *e;
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    __u32 uid = bpf_get_current_uid_gid() & 0xFFFFFFFF;
+    struct pending_read *pending, read;
+    struct read_key key = {};
+    __u32 zero = 0;
+    __u64 *threshold, *total;
+    __u64 bytes, before;
+
+    pending = bpf_map_lookup_elem(&pending_reads, &pid_tgid);
+    if (!pending)
+        return 0;
+    read = *pending;
+    bpf_map_delete_elem(&pending_reads, &pid_tgid);
+
+    threshold = bpf_map_lookup_elem(&byte_threshold, &zero);
+    if (!threshold || ctx->ret <= 0 || !uid_targeted(uid) || comm_allowed())
+        return 0;
+
+    key.pid = pid_tgid >> 32;
+    key.file = read.file;
+    bytes = ctx->ret;
+    total = bpf_map_lookup_elem(&read_bytes, &key);
+    if (total) {
+        before = __sync_fetch_and_add(total, bytes);
+    } else if (bpf_map_update_elem(&read_bytes, &key, &bytes, BPF_NOEXIST) == 0) {
+        before = 0;
+    } else {
+        // Another thread of the process added the entry first
+        total = bpf_map_lookup_elem(&read_bytes, &key);
+        if (!total)
+            return 0;
+        before = __sync_fetch_and_add(total, bytes);
+    }
+    if (before > *threshold || before + bytes <= *threshold)
+        return 0;
+
+    e = reserve_event();
+    if (!e)
+        return 0;
+
+    e->pid = key.pid;
+    e->tid = (__u32)pid_tgid;
+    e->uid = uid;
+    bpf_get_current_comm(&e->comm, sizeof(e->comm));
+    e->filename[0] = '\0';
+    e->flags = 0;
+    e->_pad = 0;
+    e->resolve = 0;
+    fill_ns_pid(e);
+    fill_ppid(e);
+    e->timestamp = bpf_ktime_get_boot_ns();
+    e->type = EVENT_READ;
+    e->bytes = before + bytes;
+    e->fd = read.fd;
+    e->dev = read.file.dev;
+    e->ino = read.file.ino;
+
+    bpf_ringbuf_submit(e, 0);
+
+    return 0;
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+// requiredPrograms are the programs a BPF object must define, by name, with
+// the type the provider attaches them as
+var requiredPrograms = map[string]ebpf.ProgramType{
+	"deny_file_open":     ebpf.LSM,
+	"deny_file_read":     ebpf.LSM,
+	"trace_openat":       ebpf.TracePoint,
+	"trace_openat2":      ebpf.TracePoint,
+	"trace_openat_exit":  ebpf.TracePoint,
+	"trace_openat2_exit": ebpf.TracePoint,
+	"trace_rename":       ebpf.TracePoint,
+	"trace_renameat":     ebpf.TracePoint,
+	"trace_renameat2":    ebpf.TracePoint,
+	"trace_read_enter":   ebpf.TracePoint,
+	"trace_read_exit":    ebpf.TracePoint,
+}
+
+// requiredMaps are the maps a BPF object must define, by name, with the type
+// the provider uses them as
+var requiredMaps = map[string]ebpf.MapType{
+	"blocked_pids":          ebpf.Hash,
+	"blocked_inodes":        ebpf.Hash,
+	"enforcement_point":     ebpf.Array,
+	"events":                ebpf.RingBuf,
+	"event_shards":          ebpf.ArrayOfMaps,
+	"event_shard_count":     ebpf.Array,
+	"pid_violation_count":   ebpf.Hash,
+	"target_uids":           ebpf.Hash,
+	"uid_filter":            ebpf.Array,
+	"allowed_comms":         ebpf.Hash,
+	"sample_rate":           ebpf.Array,
+	"sample_counter":        ebpf.PerCPUArray,
+	"successful_opens_only": ebpf.Array,
+	"pending_opens":         ebpf.Hash,
+	"open_scratch":          ebpf.PerCPUArray,
+	"pending_reads":         ebpf.Hash,
+	"denied_rate":           ebpf.LRUHash,
+}
+
+// validateSpec checks that a BPF object loaded from outside the binary, e.g.
+// one built separately with -bpf-object, defines every program and map the
+// provider uses, so a mismatched object is reported by name rather than
+// failing somewhere in loading or attaching
+func validateSpec(spec *ebpf.CollectionSpec) error {
+	var problems []string
+	for name, typ := range requiredPrograms {
+		prog, ok := spec.Programs[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("missing program %s", name))
+		case prog.Type != typ:
+			problems = append(problems, fmt.Sprintf("program %s is of type %s, want %s", name, prog.Type, typ))
+		}
+	}
+	for name, typ := range requiredMaps {
+		m, ok := spec.Maps[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("missing map %s", name))
+		case m.Type != typ:
+			problems = append(problems, fmt.Sprintf("map %s is of type %s, want %s", name, m.Type, typ))
+		case typ == ebpf.ArrayOfMaps && m.InnerMap == nil:
+			problems = append(problems, fmt.Sprintf("map %s has no inner map", name))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(problems)
+	return fmt.Errorf("incompatible bpf object: %s", strings.Join(problems, "; "))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cilium/ebpf"
+)
+
+// craftedSpec returns a spec defining everything the provider uses
+func craftedSpec() *ebpf.CollectionSpec {
+	spec := &ebpf.CollectionSpec{
+		Programs: make(map[string]*ebpf.ProgramSpec),
+		Maps:     make(map[string]*ebpf.MapSpec),
+	}
+	for name, typ := range requiredPrograms {
+		spec.Programs[name] = &ebpf.ProgramSpec{Name: name, Type: typ}
+	}
+	for name, typ := range requiredMaps {
+		spec.Maps[name] = &ebpf.MapSpec{Name: name, Type: typ}
+	}
+	spec.Maps["event_shards"].InnerMap = &ebpf.MapSpec{Type: ebpf.RingBuf}
+	return spec
+}
+
+func TestValidateSpec(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(spec *ebpf.CollectionSpec)
+		expected []string // substrings of the error, none for a valid spec
+	}{
+		{name: "complete", modify: func(*ebpf.CollectionSpec) {}},
+		{
+			name: "extra programs and maps are ignored",
+			modify: func(spec *ebpf.CollectionSpec) {
+				spec.Programs["trace_close"] = &ebpf.ProgramSpec{Type: ebpf.TracePoint}
+				spec.Maps["debug"] = &ebpf.MapSpec{Type: ebpf.Hash}
+			},
+		},
+		{
+			name:     "missing program",
+			modify:   func(spec *ebpf.CollectionSpec) { delete(spec.Programs, "deny_file_open") },
+			expected: []string{"missing program deny_file_open"},
+		},
+		{
+			name: "missing maps",
+			modify: func(spec *ebpf.CollectionSpec) {
+				delete(spec.Maps, "blocked_pids")
+				delete(spec.Maps, "events")
+			},
+			expected: []string{"missing map blocked_pids", "missing map events"},
+		},
+		{
+			name:     "wrong program type",
+			modify:   func(spec *ebpf.CollectionSpec) { spec.Programs["trace_openat"].Type = ebpf.Kprobe },
+			expected: []string{"program trace_openat is of type"},
+		},
+		{
+			name:     "wrong map type",
+			modify:   func(spec *ebpf.CollectionSpec) { spec.Maps["events"].Type = ebpf.PerfEventArray },
+			expected: []string{"map events is of type"},
+		},
+		{
+			name:     "no inner map",
+			modify:   func(spec *ebpf.CollectionSpec) { spec.Maps["event_shards"].InnerMap = nil },
+			expected: []string{"map event_shards has no inner map"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := craftedSpec()
+			tt.modify(spec)
+
+			err := validateSpec(spec)
+			if len(tt.expected) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.expected {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to contain %q, got %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestKernelAttacher_LoadSpecMissingObject(t *testing.T) {
+	attacher := kernelAttacher{objectPath: "/nonexistent/ebpfence.bpf.o"}
+	_, err := attacher.loadSpec()
+	if err == nil || !strings.Contains(err.Error(), "/nonexistent/ebpfence.bpf.o") {
+		t.Errorf("expected an error naming the object, got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// defaultPinPath is where the block, unblock, panic and status commands look
+// for the maps of a running instance started with -pin-path
+const defaultPinPath = "/sys/fs/bpf/ebpfence"
+
+// commands maps subcommand names to their implementations
+var commands = map[string]func(args []string) error{
+	"run":       runCommand,
+	"block":     blockCommand,
+	"unblock":   unblockCommand,
+	"status":    statusCommand,
+	"panic":     panicCommand,
+	"verify":    verifyCommand,
+	"preflight": preflightCommand,
+}
+
+// dispatch runs the subcommand named by the first argument. Without a
+// subcommand, e.g. when the first argument is a flag, run is assumed so
+// existing invocations keep working.
+func dispatch(args []string) error {
+	name, rest := splitCommand(args)
+	command, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q, expected one of: %s", name, strings.Join(commandNames(), ", "))
+	}
+	return command(rest)
+}
+
+// splitCommand separates the subcommand name from its arguments
+func splitCommand(args []string) (string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "run", args
+	}
+	return args[0], args[1:]
+}
+
+// commandNames returns the subcommand names, sorted
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parsePIDCommand parses the arguments of a command acting on one PID:
+// an optional -pin-path followed by the PID
+func parsePIDCommand(name string, args []string) (uint32, string, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	pinPath := flags.String("pin-path", defaultPinPath, "bpffs directory the running instance pinned its maps under")
+	if err := applyEnv(flags); err != nil {
+		return 0, "", fmt.Errorf("%s: %w", name, err)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 0, "", fmt.Errorf("%s: %w", name, err)
+	}
+
+	if flags.NArg() != 1 {
+		return 0, "", fmt.Errorf("usage: ebpfence %s [-pin-path dir] <pid>", name)
+	}
+	pid, err := strconv.ParseUint(flags.Arg(0), 10, 32)
+	if err != nil || pid == 0 {
+		return 0, "", fmt.Errorf("%s: invalid PID %q", name, flags.Arg(0))
+	}
+	return uint32(pid), *pinPath, nil
+}
+
+// parseStatusCommand parses the arguments of the status command
+func parseStatusCommand(args []string) (string, error) {
+	flags := flag.NewFlagSet("status", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	pinPath := flags.String("pin-path", defaultPinPath, "bpffs directory the running instance pinned its maps under")
+	if err := applyEnv(flags); err != nil {
+		return "", fmt.Errorf("status: %w", err)
+	}
+	if err := flags.Parse(args); err != nil {
+		return "", fmt.Errorf("status: %w", err)
+	}
+	if flags.NArg() != 0 {
+		return "", fmt.Errorf("usage: ebpfence status [-pin-path dir]")
+	}
+	return *pinPath, nil
+}
+
+// blockCommand blocks a PID in a running instance
+func blockCommand(args []string) error {
+	pid, pinPath, err := parsePIDCommand("block", args)
+	if err != nil {
+		return err
+	}
+
+	blocked, err := loadPinnedMap(pinPath, "blocked_pids")
+	if err != nil {
+		return err
+	}
+	defer blocked.Close()
+
+	info := BlockInfo{Reason: BlockReasonManual, Rule: NoRule, BlockedAt: uint64(time.Now().UnixNano())}
+	if err := blocked.Update(pid, info, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("block PID %d: %w", pid, err)
+	}
+	fmt.Printf("PID %d is now BLOCKED\n", pid)
+	return nil
+}
+
+// unblockCommand unblocks a PID in a running instance
+func unblockCommand(args []string) error {
+	pid, pinPath, err := parsePIDCommand("unblock", args)
+	if err != nil {
+		return err
+	}
+
+	blocked, err := loadPinnedMap(pinPath, "blocked_pids")
+	if err != nil {
+		return err
+	}
+	defer blocked.Close()
+
+	if err := blocked.Delete(pid); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return fmt.Errorf("unblock PID %d: %w", pid, err)
+	}
+	fmt.Printf("PID %d is no longer blocked\n", pid)
+	return nil
+}
+
+// statusCommand prints the blocked PIDs and violation counts of a running
+// instance
+func statusCommand(args []string) error {
+	pinPath, err := parseStatusCommand(args)
+	if err != nil {
+		return err
+	}
+	return dumpPinnedMaps(os.Stdout, pinPath)
+}
+
+// dumpPinnedMaps writes every map a running instance pinned under pinPath to
+// w, in the -dump-maps format
+func dumpPinnedMaps(w io.Writer, pinPath string) error {
+	for _, name := range pinnedMaps {
+		m, err := loadPinnedMap(pinPath, name)
+		if err != nil {
+			return err
+		}
+		if name == "blocked_pids" {
+			err = dumpPinnedBlockedPIDs(w, m)
+		} else {
+			err = dumpPinnedMap(w, m, name)
+		}
+		m.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPinnedMap opens a map pinned by a running instance
+func loadPinnedMap(pinPath, name string) (*ebpf.Map, error) {
+	m, err := ebpf.LoadPinnedMap(filepath.Join(pinPath, name), nil)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no running instance with maps pinned under %s (start it with -pin-path)", pinPath)
+		}
+		return nil, fmt.Errorf("load pinned map %s: %w", name, err)
+	}
+	return m, nil
+}
+
+// dumpPinnedBlockedPIDs writes a pinned blocked_pids map in the -dump-maps
+// format, with why each PID was blocked
+func dumpPinnedBlockedPIDs(w io.Writer, m *ebpf.Map) error {
+	entries, err := readBlockedPIDs(m)
+	if err != nil {
+		return err
+	}
+	return writeBlockedDump(w, entries)
+}
+
+// dumpPinnedMap writes a pinned PID-keyed map with integer values in the
+// -dump-maps format, widening them to uint32
+func dumpPinnedMap(w io.Writer, m *ebpf.Map, name string) error {
+	entries := make(map[uint32]uint32)
+	var pid uint32
+	value := make([]byte, m.ValueSize())
+	iter := m.Iterate()
+	for iter.Next(&pid, &value) {
+		var v uint32
+		for i := len(value) - 1; i >= 0; i-- {
+			v = v<<8 | uint32(value[i])
+		}
+		entries[pid] = v
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("iterate %s map: %w", name, err)
+	}
+	return writeMapDump(w, name, entries)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		args         []string
+		expectedName string
+		expectedRest []string
+	}{
+		{nil, "run", nil},
+		{[]string{"-disallowed", "/etc/passwd"}, "run", []string{"-disallowed", "/etc/passwd"}},
+		{[]string{"run", "-disallowed", "/etc/passwd"}, "run", []string{"-disallowed", "/etc/passwd"}},
+		{[]string{"block", "1234"}, "block", []string{"1234"}},
+		{[]string{"status"}, "status", []string{}},
+	}
+
+	for _, tt := range tests {
+		name, rest := splitCommand(tt.args)
+		if name != tt.expectedName || len(rest) != len(tt.expectedRest) ||
+			(len(rest) > 0 && !reflect.DeepEqual(rest, tt.expectedRest)) {
+			t.Errorf("splitCommand(%q) = (%q, %q), want (%q, %q)", tt.args, name, rest, tt.expectedName, tt.expectedRest)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	saved := commands
+	defer func() { commands = saved }()
+
+	var called string
+	var calledArgs []string
+	commands = make(map[string]func([]string) error)
+	for name := range saved {
+		commands[name] = func(args []string) error {
+			called, calledArgs = name, args
+			return nil
+		}
+	}
+
+	tests := []struct {
+		args         []string
+		expectedCall string
+	}{
+		{[]string{"-disallowed", "/etc/passwd"}, "run"},
+		{[]string{"run"}, "run"},
+		{[]string{"block", "1234"}, "block"},
+		{[]string{"unblock", "1234"}, "unblock"},
+		{[]string{"panic", "1000"}, "panic"},
+		{[]string{"verify", "-events", "recorded.bin"}, "verify"},
+		{[]string{"status"}, "status"},
+	}
+	for _, tt := range tests {
+		called = ""
+		if err := dispatch(tt.args); err != nil {
+			t.Fatalf("dispatch(%q): %v", tt.args, err)
+		}
+		if called != tt.expectedCall {
+			t.Errorf("dispatch(%q) called %q, want %q", tt.args, called, tt.expectedCall)
+		}
+	}
+	if len(calledArgs) != 0 {
+		t.Errorf("expected the command name to be stripped, got %q", calledArgs)
+	}
+
+	err := dispatch([]string{"frobnicate"})
+	if err == nil || !strings.Contains(err.Error(), "block, panic, preflight, run, status, unblock, verify") {
+		t.Errorf("expected an unknown command error listing the commands, got %v", err)
+	}
+}
+
+func TestParsePIDCommand(t *testing.T) {
+	tests := []struct {
+		args            []string
+		expectedPID     uint32
+		expectedPinPath string
+		expectError     bool
+	}{
+		{args: []string{"1234"}, expectedPID: 1234, expectedPinPath: defaultPinPath},
+		{args: []string{"-pin-path", "/sys/fs/bpf/other", "42"}, expectedPID: 42, expectedPinPath: "/sys/fs/bpf/other"},
+		{args: nil, expectError: true},
+		{args: []string{"1", "2"}, expectError: true},
+		{args: []string{"abc"}, expectError: true},
+		{args: []string{"0"}, expectError: true},
+		{args: []string{"-4"}, expectError: true},
+		{args: []string{"4294967296"}, expectError: true},
+		{args: []string{"-bogus", "1"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		pid, pinPath, err := parsePIDCommand("block", tt.args)
+		if tt.expectError {
+			if err == nil {
+				t.Errorf("parsePIDCommand(%q): expected an error", tt.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePIDCommand(%q): unexpected error: %v", tt.args, err)
+			continue
+		}
+		if pid != tt.expectedPID || pinPath != tt.expectedPinPath {
+			t.Errorf("parsePIDCommand(%q) = (%d, %q), want (%d, %q)", tt.args, pid, pinPath, tt.expectedPID, tt.expectedPinPath)
+		}
+	}
+}
+
+func TestParseStatusCommand(t *testing.T) {
+	if pinPath, err := parseStatusCommand(nil); err != nil || pinPath != defaultPinPath {
+		t.Errorf("expected the default pin path, got %q, %v", pinPath, err)
+	}
+	if pinPath, err := parseStatusCommand([]string{"-pin-path", "/tmp/pins"}); err != nil || pinPath != "/tmp/pins" {
+		t.Errorf("expected /tmp/pins, got %q, %v", pinPath, err)
+	}
+	if _, err := parseStatusCommand([]string{"extra"}); err == nil {
+		t.Error("expected an error for an unexpected argument")
+	}
+
+	t.Setenv("EBPFENCE_PIN_PATH", "/run/pins")
+	if pinPath, err := parseStatusCommand(nil); err != nil || pinPath != "/run/pins" {
+		t.Errorf("expected the pin path from the environment, got %q, %v", pinPath, err)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import "time"
+
+// Clock tells the time. It exists as a seam so time-dependent behavior can be
+// tested deterministically.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock backed by the system time
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// maxCachedContainers bounds the containers cached by PID and by cgroup
+const maxCachedContainers = 4096
+
+// containerInfo caches the container of a process
+type containerInfo struct {
+	comm      string // the comm it was resolved for
+	start     uint64 // start time of the process from its event, 0 if unknown
+	procStart uint64 // start time of the process in /proc when resolved
+	cgroup    uint64 // cgroup ID from its event, 0 if unknown
+	id        string
+}
+
+// containerOf returns the container of the process behind an event: the
+// container ID from its cgroup, or "mnt:<inode>" for a process in a mount
+// namespace of its own without one. It is empty for host processes, when it
+// cannot be resolved, and when neither ResolveContainer nor TargetContainers
+// is set.
+//
+// The container is resolved through /proc after the event, so a process that
+// exited since is known only by the cgroup ID the kernel captured in its
+// event, once another process of that cgroup was resolved. A failed lookup
+// is not cached, and a cached container is dropped once its PID is reused or
+// the process changes cgroup.
+func (h *EventHandler) containerOf(event *Event) string {
+	if !h.config.ResolveContainer && len(h.config.TargetContainers) == 0 {
+		return ""
+	}
+
+	comm := string(bytes.TrimRight(event.Comm[:], "\x00"))
+	if cached, ok := h.containers[event.Pid]; ok &&
+		cached.comm == comm && cached.start == event.StartTime && cached.cgroup == event.CgroupID {
+		return cached.id
+	}
+	if id, ok := h.cgContainers[event.CgroupID]; ok && event.CgroupID != 0 {
+		return id
+	}
+
+	id, fromCgroup, err := h.resolveContainer(event.Pid)
+	if err != nil {
+		return ""
+	}
+	procStart, _ := h.proc.startTime(event.Pid)
+
+	if len(h.containers) >= maxCachedContainers {
+		h.evictExitedContainers()
+	}
+	h.containers[event.Pid] = containerInfo{comm: comm, start: event.StartTime, procStart: procStart, cgroup: event.CgroupID, id: id}
+	// A container ID read from the cgroup holds for every process of it
+	if fromCgroup && event.CgroupID != 0 {
+		if len(h.cgContainers) >= maxCachedContainers {
+			h.cgContainers = make(map[uint64]string)
+		}
+		h.cgContainers[event.CgroupID] = id
+	}
+	return id
+}
+
+// resolveContainer looks up the container of a process in /proc, reporting
+// whether it came from its cgroup rather than its mount namespace
+func (h *EventHandler) resolveContainer(pid uint32) (id string, fromCgroup bool, err error) {
+	id, err = h.proc.containerID(pid)
+	if err != nil {
+		return "", false, err
+	}
+	if id != "" {
+		return id, true, nil
+	}
+	id, err = h.mountNamespaceOf(pid)
+	return id, false, err
+}
+
+// evictExitedContainers drops the cached containers of processes that
+// exited or whose PID was reused, and every cached container if that frees
+// nothing
+func (h *EventHandler) evictExitedContainers() {
+	for pid, cached := range h.containers {
+		if start, err := h.proc.startTime(pid); err != nil || start != cached.procStart {
+			delete(h.containers, pid)
+		}
+	}
+	if len(h.containers) >= maxCachedContainers {
+		h.containers = make(map[uint32]containerInfo)
+	}
+}
+
+// mountNamespaceOf returns "mnt:<inode>" if a process has a mount namespace
+// other than that of PID 1, and "" otherwise
+func (h *EventHandler) mountNamespaceOf(pid uint32) (string, error) {
+	if h.hostMountNs == 0 {
+		ns, err := h.proc.mountNamespace(1)
+		if err != nil {
+			return "", err
+		}
+		h.hostMountNs = ns
+	}
+
+	ns, err := h.proc.mountNamespace(pid)
+	if err != nil {
+		return "", err
+	}
+	if ns == h.hostMountNs {
+		return "", nil
+	}
+	return fmt.Sprintf("mnt:%d", ns), nil
+}
+
+// matchesTargetContainer reports whether an event comes from one of
+// TargetContainers, given by ID prefix as docker ps shows them, or as
+// mnt:<inode>
+func (h *EventHandler) matchesTargetContainer(event *Event) bool {
+	id := h.containerOf(event)
+	if id == "" {
+		return false
+	}
+	for _, target := range h.config.TargetContainers {
+		if target != "" && strings.HasPrefix(id, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerSuffix describes the container of an event for console output,
+// e.g. " in container 0123456789ab", or "" if there is none
+func (h *EventHandler) containerSuffix(event *Event) string {
+	id := h.containerOf(event)
+	if id == "" {
+		return ""
+	}
+	if len(id) == containerIDLen {
+		id = id[:12]
+	}
+	return " in container " + id
+}
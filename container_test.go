@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// fakeNamespace gives a fake process a mount namespace file, returning its
+// inode
+func fakeNamespace(t *testing.T, proc procFS, pid uint32) uint32 {
+	t.Helper()
+	dir := filepath.Join(proc.root, strconv.FormatUint(uint64(pid), 10), "ns")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("create fake ns: %v", err)
+	}
+	path := filepath.Join(dir, "mnt")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatalf("create fake ns file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat fake ns file: %v", err)
+	}
+	return uint32(info.Sys().(*syscall.Stat_t).Ino)
+}
+
+// fakeCgroup sets the cgroup file of a fake process
+func fakeCgroup(t *testing.T, proc procFS, pid uint32, cgroup string) {
+	t.Helper()
+	path := filepath.Join(proc.root, strconv.FormatUint(uint64(pid), 10), "cgroup")
+	if err := os.WriteFile(path, []byte(cgroup), 0644); err != nil {
+		t.Fatalf("create fake cgroup: %v", err)
+	}
+}
+
+func TestEventHandler_ContainerOf(t *testing.T) {
+	const id = "3f4e8d2c1b0a99887766554433221100ffeeddccbbaa00112233445566778899"
+	proc := fakeProc(t, map[uint32]string{1: "systemd", 100: "cat", 200: "sandboxed", 300: "bash"})
+	fakeNamespace(t, proc, 1)
+	fakeCgroup(t, proc, 100, "0::/system.slice/docker-"+id+".scope\n")
+	fakeCgroup(t, proc, 200, "0::/system.slice/sandbox.service\n")
+	sandbox := fakeNamespace(t, proc, 200)
+	fakeCgroup(t, proc, 300, "0::/user.slice/session-2.scope\n")
+	if err := os.Symlink(filepath.Join(proc.root, "1", "ns"), filepath.Join(proc.root, "300", "ns")); err != nil {
+		t.Fatalf("share host ns: %v", err)
+	}
+
+	mock := NewMockEBPFProvider(context.Background(), nil)
+	handler := NewEventHandler(mock, EventHandlerConfig{ResolveContainer: true})
+	handler.proc = proc
+
+	tests := []struct {
+		pid  uint32
+		comm string
+		want string
+	}{
+		{100, "cat", id},
+		{200, "sandboxed", "mnt:" + strconv.FormatUint(uint64(sandbox), 10)},
+		{300, "bash", ""},
+		{400, "gone", ""},
+	}
+	for _, tt := range tests {
+		event := CreateMockEvent(tt.pid, 1000, tt.comm, "/etc/shadow")
+		if got := handler.containerOf(event); got != tt.want {
+			t.Errorf("containerOf(PID %d) = %q, want %q", tt.pid, got, tt.want)
+		}
+	}
+
+	// The cached container is dropped when the PID runs another command
+	fakeCgroup(t, proc, 100, "0::/user.slice/session-2.scope\n")
+	event := CreateMockEvent(100, 1000, "cat", "/etc/shadow")
+	if got := handler.containerOf(event); got != id {
+		t.Errorf("containerOf(PID 100) = %q, want the cached %q", got, id)
+	}
+	if err := os.Symlink(filepath.Join(proc.root, "1", "ns"), filepath.Join(proc.root, "100", "ns")); err != nil {
+		t.Fatalf("share host ns: %v", err)
+	}
+	event = CreateMockEvent(100, 1000, "vim", "/etc/shadow")
+	if got := handler.containerOf(event); got != "" {
+		t.Errorf("containerOf(PID 100 after exec) = %q, want none", got)
+	}
+}
+
+func TestEventHandler_ContainerOfCgroupID(t *testing.T) {
+	const id = "3f4e8d2c1b0a99887766554433221100ffeeddccbbaa00112233445566778899"
+	proc := fakeProc(t, map[uint32]string{1: "systemd", 100: "cat"})
+	fakeNamespace(t, proc, 1)
+
+	mock := NewMockEBPFProvider(context.Background(), nil)
+	handler := NewEventHandler(mock, EventHandlerConfig{ResolveContainer: true})
+	handler.proc = proc
+
+	event := CreateMockEvent(100, 1000, "cat", "/etc/shadow")
+	event.StartTime, event.CgroupID = 5000, 77
+
+	// A failed lookup is not cached
+	if got := handler.containerOf(event); got != "" {
+		t.Errorf("containerOf(PID 100 without a cgroup) = %q, want none", got)
+	}
+	fakeCgroup(t, proc, 100, "0::/system.slice/docker-"+id+".scope\n")
+	if got := handler.containerOf(event); got != id {
+		t.Errorf("containerOf(PID 100) = %q, want %q", got, id)
+	}
+
+	// A process that exited before its event was handled is known by the
+	// cgroup ID in the event
+	exited := CreateMockEvent(200, 1000, "sh", "/etc/shadow")
+	exited.StartTime, exited.CgroupID = 6000, 77
+	if got := handler.containerOf(exited); got != id {
+		t.Errorf("containerOf(exited PID 200) = %q, want %q", got, id)
+	}
+
+	// Moved to another cgroup, or its PID reused, the process is resolved again
+	fakeCgroup(t, proc, 100, "0::/user.slice/session-2.scope\n")
+	if err := os.Symlink(filepath.Join(proc.root, "1", "ns"), filepath.Join(proc.root, "100", "ns")); err != nil {
+		t.Fatalf("share host ns: %v", err)
+	}
+	if got := handler.containerOf(event); got != id {
+		t.Errorf("containerOf(PID 100) = %q, want the cached %q", got, id)
+	}
+	moved := *event
+	moved.CgroupID = 78
+	if got := handler.containerOf(&moved); got != "" {
+		t.Errorf("containerOf(PID 100 in another cgroup) = %q, want none", got)
+	}
+	reused := *event
+	reused.StartTime = 9000
+	if got := handler.containerOf(&reused); got != id {
+		t.Errorf("containerOf(reused PID 100 in the same cgroup) = %q, want %q", got, id)
+	}
+	reused.CgroupID = 79
+	if got := handler.containerOf(&reused); got != "" {
+		t.Errorf("containerOf(reused PID 100) = %q, want none", got)
+	}
+}
+
+func TestEventHandler_TargetContainers(t *testing.T) {
+	const id = "3f4e8d2c1b0a99887766554433221100ffeeddccbbaa00112233445566778899"
+	proc := fakeProc(t, map[uint32]string{1: "systemd", 100: "cat", 300: "cat"})
+	fakeNamespace(t, proc, 1)
+	fakeCgroup(t, proc, 100, "0::/system.slice/docker-"+id+".scope\n")
+	fakeCgroup(t, proc, 300, "0::/system.slice/docker-"+strings.Repeat("ab", 32)+".scope\n")
+
+	mock := NewMockEBPFProvider(context.Background(), nil)
+	handler := NewEventHandler(mock, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow"},
+		Threshold:          1,
+		TargetContainers:   []string{id[:12]},
+	})
+	handler.proc = proc
+
+	event := CreateMockEvent(100, 1000, "cat", "/etc/shadow")
+	if result, err := handler.processEvent(event); err != nil || !result.Blocked {
+		t.Errorf("process in the target container: got %+v, %v, want blocked", result, err)
+	}
+	event = CreateMockEvent(300, 1000, "cat", "/etc/shadow")
+	if result, err := handler.processEvent(event); err != nil || result.Matched {
+		t.Errorf("process in another container: got %+v, %v, want not monitored", result, err)
+	}
+}
@@ -1,136 +1,1235 @@
 package main
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/btf"
 	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/ringbuf"
+	"golang.org/x/sys/unix"
 )
 
 // RealEBPFProvider is the production implementation of EBPFProvider
 type RealEBPFProvider struct {
 	objs          *BpfObjects
-	reader        *ringbuf.Reader
 	lsmLink       link.Link
 	tpLinkOpenat  link.Link
 	tpLinkOpenat2 link.Link
+	lsmLinkRead   link.Link // attached by SetEnforcementPoint(EnforceRead)
+
+	// renameLinks holds the tracepoint of each of renameSyscalls the kernel
+	// has, nil if it failed to attach
+	renameLinks map[string]link.Link
+
+	// Attached by SetCountFailedOpens(false)
+	tpLinkOpenatExit  link.Link
+	tpLinkOpenat2Exit link.Link
+
+	pinPath     string // bpffs directory the maps are pinned under, empty if not pinned
+	enforceOnly bool   // only the LSM hook is attached; there are no events to read
+	blockedPids bpfMap // objs.BlockedPids, replaced by a fake in tests
+
+	// With WithBlockBatching, BlockPID queues PIDs here instead of updating
+	// blocked_pids directly
+	batcher *blockBatcher
+
+	// Read tracking is attached on demand by EnableReadTracking: the enter
+	// and exit tracepoints of each of readSyscalls, in that order
+	attacher  bpfAttacher
+	readLinks []link.Link
+
+	// ReopenReader replaces reader while ReadEvent may be blocked on it
+	readerMu     sync.Mutex
+	reader       ringReader
+	readDeadline time.Time // reapplied to a reopened reader
+
+	// With event shards, ReadEvent merges the events ring buffer with one
+	// ring buffer per shard
+	shardMaps    []*ebpf.Map
+	shardReaders []ringReader
+	merger       *eventMerger
+
+	// With WithReadBuffer, a goroutine reads events ahead into buffer and
+	// ReadEvent takes them from there
+	buffer *eventBuffer
+}
+
+// bpfAttacher loads BPF objects and attaches programs. It exists as a seam so
+// the provider's failure and cleanup paths can be tested without a kernel.
+type bpfAttacher interface {
+	LoadObjects(objs *BpfObjects) error
+	AttachLSM(prog *ebpf.Program) (link.Link, error)
+	AttachTracepoint(group, name string, prog *ebpf.Program) (link.Link, error)
+	OpenReader(events *ebpf.Map) (ringReader, error)
+	CreateEventShards(objs *BpfObjects, n int) ([]*ebpf.Map, error)
+}
+
+// ringReader reads records from a ring buffer. It is implemented by
+// *ringbuf.Reader and faked in tests, like bpfAttacher.
+type ringReader interface {
+	Read() (ringbuf.Record, error)
+	SetDeadline(t time.Time)
+	Close() error
+}
+
+// bpfMap is the part of *ebpf.Map the provider updates and looks up blocks
+// through, so tests can fail map updates without a kernel
+type bpfMap interface {
+	Update(key, value interface{}, flags ebpf.MapUpdateFlags) error
+	BatchUpdate(keys, values interface{}, opts *ebpf.BatchOptions) (int, error)
+	Delete(key interface{}) error
+	Lookup(key, valueOut interface{}) error
+}
+
+// kernelAttacher is the bpfAttacher backed by the running kernel
+type kernelAttacher struct {
+	pinPath      string // pin pinnedMaps under this bpffs directory, empty to not pin
+	ringbufBytes uint32 // size of the events ring buffer, 0 for the size the BPF program declares
+	eventShards  int    // size of the event_shards map, 0 for the size the BPF program declares
+	objectPath   string // load the BPF object from this file, empty for the embedded one
+}
+
+// pinnedMaps are the maps shared with the block, unblock and status commands
+var pinnedMaps = []string{"blocked_pids", "pid_violation_count"}
+
+func (a kernelAttacher) LoadObjects(objs *BpfObjects) error {
+	spec, err := a.loadSpec()
+	if err != nil {
+		return err
+	}
+	size, err := bpfEventSize(spec)
+	if err != nil {
+		return err
+	}
+	if err := validateEventLayout(size); err != nil {
+		return err
+	}
+	if a.ringbufBytes != 0 {
+		spec.Maps["events"].MaxEntries = a.ringbufBytes
+	}
+	if a.eventShards != 0 {
+		spec.Maps["event_shards"].MaxEntries = uint32(a.eventShards)
+	}
+	if a.pinPath == "" {
+		return spec.LoadAndAssign(objs, &ebpf.CollectionOptions{})
+	}
+
+	for _, name := range pinnedMaps {
+		spec.Maps[name].Pinning = ebpf.PinByName
+	}
+	if err := os.MkdirAll(a.pinPath, 0700); err != nil {
+		return fmt.Errorf("create pin path: %w", err)
+	}
+	legacy, migrated, err := loadLegacyBlockedPIDs(a.pinPath)
+	if err != nil {
+		return err
+	}
+	if legacy == nil {
+		return spec.LoadAndAssign(objs, &ebpf.CollectionOptions{
+			Maps: ebpf.MapOptions{PinPath: a.pinPath},
+		})
+	}
+	defer legacy.Close()
+
+	// The legacy map makes way for the new one, and is pinned again if the
+	// new one cannot take over its entries, so no block is lost either way
+	if err := legacy.Unpin(); err != nil {
+		return fmt.Errorf("unpin legacy blocked_pids map: %w", err)
+	}
+	if err := a.loadMigrated(spec, objs, migrated); err != nil {
+		if pinErr := legacy.Pin(filepath.Join(a.pinPath, "blocked_pids")); pinErr != nil {
+			return errors.Join(err, fmt.Errorf("restore legacy blocked_pids map: %w", pinErr))
+		}
+		return err
+	}
+	return nil
+}
+
+// loadMigrated loads spec with its maps pinned under pinPath and writes the
+// entries of a legacy blocked_pids map to the new one. On failure nothing
+// stays pinned as blocked_pids.
+func (a kernelAttacher) loadMigrated(spec *ebpf.CollectionSpec, objs *BpfObjects, migrated map[uint32]BlockInfo) error {
+	if err := spec.LoadAndAssign(objs, &ebpf.CollectionOptions{
+		Maps: ebpf.MapOptions{PinPath: a.pinPath},
+	}); err != nil {
+		return err
+	}
+	for pid, info := range migrated {
+		if err := objs.BlockedPids.Update(pid, info, ebpf.UpdateAny); err != nil {
+			err = fmt.Errorf("migrate blocked PID %d: %w", pid, err)
+			if unpinErr := objs.BlockedPids.Unpin(); unpinErr != nil {
+				err = errors.Join(err, fmt.Errorf("unpin blocked_pids map: %w", unpinErr))
+			}
+			return errors.Join(err, objs.Close())
+		}
+	}
+	return nil
+}
+
+// loadLegacyBlockedPIDs opens a blocked_pids map pinned by a version storing
+// one-byte values, which the new program cannot reuse, and reads its
+// entries, so they can be written to the map loaded in its place. It returns
+// a nil map if no such map is pinned.
+func loadLegacyBlockedPIDs(pinPath string) (*ebpf.Map, map[uint32]BlockInfo, error) {
+	m, err := ebpf.LoadPinnedMap(filepath.Join(pinPath, "blocked_pids"), nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("load pinned blocked_pids map: %w", err)
+	}
+	if m.ValueSize() == blockInfoSize {
+		m.Close()
+		return nil, nil, nil
+	}
+
+	entries, err := readBlockedPIDs(m)
+	if err != nil {
+		m.Close()
+		return nil, nil, err
+	}
+	return m, entries, nil
+}
+
+// loadSpec returns the spec of the BPF object at objectPath, checked to
+// define what the provider uses, or of the object embedded at build time
+func (a kernelAttacher) loadSpec() (*ebpf.CollectionSpec, error) {
+	if a.objectPath == "" {
+		spec, err := LoadBpf()
+		if err != nil {
+			return nil, fmt.Errorf("load bpf spec: %w", err)
+		}
+		return spec, nil
+	}
+
+	spec, err := ebpf.LoadCollectionSpec(a.objectPath)
+	if err != nil {
+		return nil, fmt.Errorf("load bpf object %s: %w", a.objectPath, err)
+	}
+	if err := validateSpec(spec); err != nil {
+		return nil, fmt.Errorf("%s: %w", a.objectPath, err)
+	}
+	return spec, nil
+}
+
+// bpfEventSize returns the size of struct event_t in the BPF object's BTF
+func bpfEventSize(spec *ebpf.CollectionSpec) (int, error) {
+	if spec.Types == nil {
+		return 0, fmt.Errorf("find event_t in BTF: bpf object has no BTF")
+	}
+
+	var event *btf.Struct
+	if err := spec.Types.TypeByName("event_t", &event); err != nil {
+		return 0, fmt.Errorf("find event_t in BTF: %w", err)
+	}
+	return int(event.Size), nil
+}
+
+func (kernelAttacher) AttachLSM(prog *ebpf.Program) (link.Link, error) {
+	return link.AttachLSM(link.LSMOptions{Program: prog})
 }
 
-// NewRealEBPFProvider creates and initializes a new RealEBPFProvider
-func NewRealEBPFProvider() (*RealEBPFProvider, error) {
+func (kernelAttacher) AttachTracepoint(group, name string, prog *ebpf.Program) (link.Link, error) {
+	return link.Tracepoint(group, name, prog, nil)
+}
+
+func (kernelAttacher) OpenReader(events *ebpf.Map) (ringReader, error) {
+	reader, err := ringbuf.NewReader(events)
+	if err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// CreateEventShards creates n ring buffers the size of the events ring
+// buffer and installs them in the event_shards map, which the BPF programs
+// then spread events over
+func (a kernelAttacher) CreateEventShards(objs *BpfObjects, n int) (_ []*ebpf.Map, err error) {
+	spec, err := a.loadSpec()
+	if err != nil {
+		return nil, err
+	}
+	shardSpec := spec.Maps["event_shards"].InnerMap.Copy()
+	if a.ringbufBytes != 0 {
+		shardSpec.MaxEntries = a.ringbufBytes
+	}
+
+	var shards []*ebpf.Map
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, shard := range shards {
+			shard.Close()
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		shard, err := ebpf.NewMap(shardSpec)
+		if err != nil {
+			return nil, fmt.Errorf("create ring buffer %d: %w", i, err)
+		}
+		shards = append(shards, shard)
+		if err := objs.EventShards.Put(uint32(i), shard); err != nil {
+			return nil, fmt.Errorf("install ring buffer %d: %w", i, err)
+		}
+	}
+	if err := objs.EventShardCount.Put(uint32(0), uint32(n)); err != nil {
+		return nil, fmt.Errorf("update event_shard_count map: %w", err)
+	}
+	return shards, nil
+}
+
+// NewRealEBPFProvider creates and initializes a new RealEBPFProvider. Without
+// options it loads the BPF programs as built and pins nothing.
+func NewRealEBPFProvider(opts ...ProviderOption) (*RealEBPFProvider, error) {
+	o, err := newProviderOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newRealEBPFProvider(kernelAttacher{
+		pinPath:      o.pinPath,
+		ringbufBytes: o.ringbufBytes,
+		eventShards:  o.eventShards,
+		objectPath:   o.objectPath,
+	}, o)
+}
+
+// newRealEBPFProvider builds a provider using the given attacher, which pins
+// maps under o.pinPath if set. On any failure every resource acquired so far
+// is released exactly once.
+func newRealEBPFProvider(attacher bpfAttacher, o providerOptions) (_ *RealEBPFProvider, err error) {
 	provider := &RealEBPFProvider{
-		objs: &BpfObjects{},
+		objs:        &BpfObjects{},
+		attacher:    attacher,
+		pinPath:     o.pinPath,
+		enforceOnly: o.enforceOnly,
 	}
 
 	// Load BPF objects
-	if err := LoadBpfObjects(provider.objs, &ebpf.CollectionOptions{}); err != nil {
+	if err := attacher.LoadObjects(provider.objs); err != nil {
 		return nil, fmt.Errorf("load bpf objects: %w", err)
 	}
+	provider.blockedPids = provider.objs.BlockedPids
+	if o.blockBatch > 0 {
+		provider.batcher = newBlockBatcher(provider.blockedPids, o.blockBatch)
+	}
+
+	// From here on, Close releases whatever has been acquired
+	defer func() {
+		if err == nil {
+			return
+		}
+		if closeErr := provider.Close(); closeErr != nil {
+			err = fmt.Errorf("%w (cleanup: %v)", err, closeErr)
+		}
+	}()
 
 	// Attach LSM hook for blocking
-	lsmLink, err := link.AttachLSM(link.LSMOptions{Program: provider.objs.DenyFileOpen})
+	lsmLink, err := attacher.AttachLSM(provider.objs.DenyFileOpen)
 	if err != nil {
-		provider.objs.Close()
 		return nil, fmt.Errorf("attach LSM hook: %w", err)
 	}
 	provider.lsmLink = lsmLink
 
+	// Without event collection there is nothing else to attach
+	if o.enforceOnly {
+		return provider, nil
+	}
+
 	// Attach tracepoint for openat
-	tpLinkOpenat, err := link.Tracepoint("syscalls", "sys_enter_openat", provider.objs.TraceOpenat, nil)
+	tpLinkOpenat, err := attacher.AttachTracepoint("syscalls", "sys_enter_openat", provider.objs.TraceOpenat)
 	if err != nil {
-		provider.Close()
 		return nil, fmt.Errorf("attach openat tracepoint: %w", err)
 	}
 	provider.tpLinkOpenat = tpLinkOpenat
 
 	// Attach tracepoint for openat2 (optional)
-	tpLinkOpenat2, err := link.Tracepoint("syscalls", "sys_enter_openat2", provider.objs.TraceOpenat2, nil)
-	if err != nil {
+	tpLinkOpenat2, attachErr := attacher.AttachTracepoint("syscalls", "sys_enter_openat2", provider.objs.TraceOpenat2)
+	if attachErr != nil {
 		// openat2 might not be available on older kernels, so just log a warning
-		fmt.Printf("Warning: could not attach openat2 tracepoint: %v\n", err)
+		fmt.Printf("Warning: could not attach openat2 tracepoint: %v\n", attachErr)
 	} else {
 		provider.tpLinkOpenat2 = tpLinkOpenat2
 	}
 
+	// Attach the rename tracepoints (optional)
+	provider.renameLinks = make(map[string]link.Link, len(renameSyscalls))
+	renamePrograms := map[string]*ebpf.Program{
+		"rename":    provider.objs.TraceRename,
+		"renameat":  provider.objs.TraceRenameat,
+		"renameat2": provider.objs.TraceRenameat2,
+	}
+	for _, syscall := range renameSyscalls {
+		l, attachErr := attacher.AttachTracepoint("syscalls", "sys_enter_"+syscall, renamePrograms[syscall])
+		if errors.Is(attachErr, os.ErrNotExist) {
+			// Not a syscall on this architecture, so there is nothing to miss
+			continue
+		}
+		if attachErr != nil {
+			fmt.Printf("Warning: could not attach %s tracepoint, renames through it are not monitored: %v\n", syscall, attachErr)
+		}
+		provider.renameLinks[syscall] = l
+	}
+
 	// Open the ring buffer
-	reader, err := ringbuf.NewReader(provider.objs.Events)
+	reader, err := attacher.OpenReader(provider.objs.Events)
 	if err != nil {
-		provider.Close()
 		return nil, fmt.Errorf("open ring buffer: %w", err)
 	}
 	provider.reader = reader
 
+	if o.eventShards > 1 {
+		if err := provider.openShards(o.eventShards); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.readBuffer > 0 {
+		provider.buffer = newEventBuffer(provider.readEvent, o.readBuffer)
+	}
+
 	return provider, nil
 }
 
-// ReadEvent reads the next event from the ring buffer
+// openShards sets up n ring buffers the BPF programs spread events over by
+// CPU, and merges their events with those of the events ring buffer
+func (p *RealEBPFProvider) openShards(n int) error {
+	shards, err := p.attacher.CreateEventShards(p.objs, n)
+	if err != nil {
+		return fmt.Errorf("create event shards: %w", err)
+	}
+	p.shardMaps = shards
+
+	sources := []eventSource{ringbufSource(p.reader)}
+	for i, shard := range shards {
+		reader, err := p.attacher.OpenReader(shard)
+		if err != nil {
+			return fmt.Errorf("open ring buffer of shard %d: %w", i, err)
+		}
+		p.shardReaders = append(p.shardReaders, reader)
+		sources = append(sources, ringbufSource(reader))
+	}
+	p.merger = newEventMerger(sources, defaultMergeWindow)
+	return nil
+}
+
+// ringbufSource reads the events of one ring buffer
+func ringbufSource(reader ringReader) eventSource {
+	return func() (*Event, error) {
+		if reader == nil {
+			return nil, fmt.Errorf("ring buffer closed: %w", ringbuf.ErrClosed)
+		}
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) {
+				return nil, fmt.Errorf("ring buffer closed: %w", err)
+			}
+			return nil, fmt.Errorf("reading from ring buffer: %w", err)
+		}
+		return parseEvent(record.RawSample)
+	}
+}
+
+// renameSyscalls are the syscalls reported as renames, in the order their
+// tracepoints are attached. rename only exists on some architectures, e.g.
+// not on arm64, and renameat2 needs Linux 3.15.
+var renameSyscalls = []string{"rename", "renameat", "renameat2"}
+
+// readSyscalls are the syscalls read tracking counts the bytes of. Data read
+// otherwise, e.g. with sendfile(2), splice(2), copy_file_range(2), io_uring
+// or through a mapping made with mmap(2), is not counted.
+var readSyscalls = []string{"read", "pread64", "readv", "preadv"}
+
+// EnableReadTracking attaches the read tracepoints of readSyscalls, so that
+// EventRead events are reported once SetByteThreshold is set. It is opt-in
+// because every read on the system is traced.
+func (p *RealEBPFProvider) EnableReadTracking() (err error) {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+	if p.enforceOnly {
+		return fmt.Errorf("track reads: %w", ErrEventsDisabled)
+	}
+	if len(p.readLinks) > 0 {
+		return nil
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		for i := len(p.readLinks) - 1; i >= 0; i-- {
+			if closeErr := p.readLinks[i].Close(); closeErr != nil {
+				err = fmt.Errorf("%w (cleanup: %v)", err, closeErr)
+			}
+		}
+		p.readLinks = nil
+	}()
+
+	for _, syscall := range readSyscalls {
+		for _, tp := range []struct {
+			name string
+			prog *ebpf.Program
+		}{
+			{"sys_enter_" + syscall, p.objs.TraceReadEnter},
+			{"sys_exit_" + syscall, p.objs.TraceReadExit},
+		} {
+			l, err := p.attacher.AttachTracepoint("syscalls", tp.name, tp.prog)
+			if err != nil {
+				return fmt.Errorf("attach %s tracepoint: %w", tp.name, err)
+			}
+			p.readLinks = append(p.readLinks, l)
+		}
+	}
+	return nil
+}
+
+// SetByteThreshold makes the kernel report a process's reads of a file once
+// it read more than bytes from it, summing the reads in the kernel so only
+// that one event reaches userspace. 0 stops counting.
+func (p *RealEBPFProvider) SetByteThreshold(bytes uint64) error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+	if err := p.objs.ByteThreshold.Update(uint32(0), bytes, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update byte_threshold map: %w", err)
+	}
+	return nil
+}
+
+// ReadEvent reads the next event from the ring buffer, or from the read
+// buffer the ring buffer is drained into
 func (p *RealEBPFProvider) ReadEvent() (*Event, error) {
-	record, err := p.reader.Read()
+	if p.enforceOnly {
+		return nil, ErrEventsDisabled
+	}
+	if p.buffer != nil {
+		return p.buffer.next(p.currentDeadline())
+	}
+	return p.readEvent()
+}
+
+// readEvent reads the next event from the ring buffers
+func (p *RealEBPFProvider) readEvent() (*Event, error) {
+	if p.merger != nil {
+		return p.merger.Next()
+	}
+
+	for {
+		reader := p.currentReader()
+		if reader == nil {
+			return nil, fmt.Errorf("ring buffer closed: %w", ringbuf.ErrClosed)
+		}
+
+		record, err := reader.Read()
+		if err != nil {
+			if errors.Is(err, ringbuf.ErrClosed) {
+				// ReopenReader closed the reader under us; carry on with
+				// the new one
+				if current := p.currentReader(); current != nil && current != reader {
+					continue
+				}
+				return nil, fmt.Errorf("ring buffer closed: %w", err)
+			}
+			// A deadline error left over from the non-blocking reads of
+			// ReadEvents, not from the deadline of SetReadDeadline
+			if errors.Is(err, os.ErrDeadlineExceeded) && !p.deadlinePassed() {
+				continue
+			}
+			return nil, fmt.Errorf("reading from ring buffer: %w", err)
+		}
+
+		return parseEvent(record.RawSample)
+	}
+}
+
+// ReadEvents implements batchReader. The events after the first are read
+// with the reader's deadline in the past, so it returns instead of blocking
+// once the ring buffer is drained. Sharded ring buffers are read one event
+// at a time, unless there is a read buffer to take them from.
+func (p *RealEBPFProvider) ReadEvents(max int) ([]*Event, error) {
+	event, err := p.ReadEvent()
+	if err != nil {
+		return nil, err
+	}
+	events := []*Event{event}
+	if p.buffer != nil {
+		return p.buffer.drain(events, max), nil
+	}
+	if p.merger != nil {
+		return events, nil
+	}
+
+	p.readerMu.Lock()
+	defer p.readerMu.Unlock()
+	if p.reader == nil {
+		return events, nil
+	}
+	p.reader.SetDeadline(time.Unix(0, 1))
+	defer p.reader.SetDeadline(p.readDeadline)
+
+	for len(events) < max {
+		record, err := p.reader.Read()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			break
+		}
+		if err != nil {
+			return events, fmt.Errorf("reading from ring buffer: %w", err)
+		}
+		event, err := parseEvent(record.RawSample)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// deadlinePassed reports whether the deadline of SetReadDeadline passed
+func (p *RealEBPFProvider) deadlinePassed() bool {
+	p.readerMu.Lock()
+	defer p.readerMu.Unlock()
+	return !p.readDeadline.IsZero() && !time.Now().Before(p.readDeadline)
+}
+
+// currentDeadline returns the deadline of SetReadDeadline
+func (p *RealEBPFProvider) currentDeadline() time.Time {
+	p.readerMu.Lock()
+	defer p.readerMu.Unlock()
+	return p.readDeadline
+}
+
+// EventBufferDepth implements eventBufferReporter, returning 0, 0 without a
+// read buffer
+func (p *RealEBPFProvider) EventBufferDepth() (int, int) {
+	if p.buffer == nil {
+		return 0, 0
+	}
+	return p.buffer.depth()
+}
+
+// currentReader returns the ring buffer reader, nil once closed
+func (p *RealEBPFProvider) currentReader() ringReader {
+	p.readerMu.Lock()
+	defer p.readerMu.Unlock()
+	return p.reader
+}
+
+// ReopenReader replaces the ring buffer reader with a new one, recovering
+// from a reader that stopped delivering events. A ReadEvent blocked on the
+// old reader continues on the new one.
+func (p *RealEBPFProvider) ReopenReader() error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+	if p.enforceOnly {
+		return ErrEventsDisabled
+	}
+	if p.merger != nil {
+		return fmt.Errorf("reopening sharded ring buffers is not supported")
+	}
+
+	p.readerMu.Lock()
+	defer p.readerMu.Unlock()
+
+	if p.reader == nil {
+		return fmt.Errorf("provider is closed")
+	}
+	reader, err := p.attacher.OpenReader(p.objs.Events)
 	if err != nil {
-		if errors.Is(err, ringbuf.ErrClosed) {
-			return nil, fmt.Errorf("ring buffer closed: %w", err)
+		return fmt.Errorf("open ring buffer: %w", err)
+	}
+	if !p.readDeadline.IsZero() {
+		reader.SetDeadline(p.readDeadline)
+	}
+
+	old := p.reader
+	p.reader = reader
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("close old reader: %w", err)
+	}
+	return nil
+}
+
+// SetReadDeadline makes ReadEvent give up waiting for the ring buffer at t.
+// With a read buffer, ReadEvent gives up waiting for the buffer instead and
+// the ring buffer keeps being read.
+func (p *RealEBPFProvider) SetReadDeadline(t time.Time) {
+	p.readerMu.Lock()
+	defer p.readerMu.Unlock()
+
+	p.readDeadline = t
+	if p.buffer != nil {
+		return
+	}
+	if p.reader != nil {
+		p.reader.SetDeadline(t)
+	}
+	for _, reader := range p.shardReaders {
+		if reader != nil {
+			reader.SetDeadline(t)
 		}
-		return nil, fmt.Errorf("reading from ring buffer: %w", err)
 	}
+}
+
+// eventSize is the size of an encoded event_t record
+var eventSize = binary.Size(Event{})
 
-	// Parse the event
-	var event Event
-	if err := binary.Read(bytes.NewReader(record.RawSample), binary.LittleEndian, &event); err != nil {
-		return nil, fmt.Errorf("parsing event: %w", err)
+// validateEventLayout checks that the BPF program's event records are the
+// size of Event. A mismatch means the C struct and the Go struct drifted
+// apart, and decoding would silently produce wrong PIDs and filenames.
+func validateEventLayout(valueSize int) error {
+	if valueSize != eventSize {
+		return fmt.Errorf("event layout mismatch: BPF event_t is %d bytes but Event is %d bytes; "+
+			"regenerate the BPF objects or update Event to match", valueSize, eventSize)
 	}
+	return nil
+}
 
-	return &event, nil
+// parseEvent decodes a raw ring buffer sample into an Event. Fields are read
+// at their event_t offsets directly, avoiding binary.Read's reflection and
+// allocations on the hot path.
+func parseEvent(raw []byte) (*Event, error) {
+	if len(raw) < eventSize {
+		return nil, fmt.Errorf("parsing event: sample is %d bytes, want %d", len(raw), eventSize)
+	}
+
+	le := binary.LittleEndian
+	event := &Event{
+		Pid:       le.Uint32(raw[0:]),
+		Uid:       le.Uint32(raw[4:]),
+		Flags:     int32(le.Uint32(raw[280:])),
+		Resolve:   le.Uint64(raw[288:]),
+		NsPid:     le.Uint32(raw[296:]),
+		PidNs:     le.Uint32(raw[300:]),
+		Ppid:      le.Uint32(raw[304:]),
+		Type:      le.Uint32(raw[308:]),
+		Bytes:     le.Uint64(raw[312:]),
+		Fd:        le.Uint32(raw[320:]),
+		Tid:       le.Uint32(raw[324:]),
+		Timestamp: le.Uint64(raw[328:]),
+		StartTime: le.Uint64(raw[336:]),
+		Dev:       userDev(le.Uint64(raw[344:])),
+		Ino:       le.Uint64(raw[352:]),
+		CgroupID:  le.Uint64(raw[360:]),
+	}
+	copy(event.Comm[:], raw[8:24])
+	copy(event.Filename[:], raw[24:280])
+
+	return event, nil
+}
+
+// userDev converts a kernel dev_t, as BPF programs see it, to the encoding
+// stat(2) reports
+func userDev(dev uint64) uint64 {
+	if dev == 0 {
+		return 0
+	}
+	return unix.Mkdev(uint32(dev>>20), uint32(dev&0xfffff))
 }
 
 // BlockPID adds a PID to the blocked list
 func (p *RealEBPFProvider) BlockPID(pid uint32) error {
-	blockedValue := uint8(1)
-	if err := p.objs.BlockedPids.Update(pid, &blockedValue, ebpf.UpdateAny); err != nil {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+
+	return p.BlockPIDWithInfo(pid, BlockInfo{Reason: BlockReasonUnknown, Rule: NoRule, BlockedAt: uint64(time.Now().UnixNano())})
+}
+
+// BlockPIDWithInfo adds a PID to the blocked list with why it was blocked
+func (p *RealEBPFProvider) BlockPIDWithInfo(pid uint32, info BlockInfo) error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+	if p.batcher != nil {
+		return p.batcher.add(pid, info)
+	}
+
+	if err := p.blockedPids.Update(pid, info, ebpf.UpdateAny); err != nil {
 		return fmt.Errorf("failed to update blocked_pids map: %w", err)
 	}
 	return nil
 }
 
-// Close cleans up all resources
+// UnblockPID removes a PID from the blocked list
+func (p *RealEBPFProvider) UnblockPID(pid uint32) error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+	if p.batcher != nil {
+		p.batcher.remove(pid)
+	}
+
+	if err := p.blockedPids.Delete(pid); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+		return fmt.Errorf("failed to delete from blocked_pids map: %w", err)
+	}
+	return nil
+}
+
+// IsPIDBlocked implements blockChecker with a lookup in the blocked_pids
+// map. A PID still waiting in a block batch is not blocked yet.
+func (p *RealEBPFProvider) IsPIDBlocked(pid uint32) (bool, error) {
+	if p.objs == nil {
+		return false, fmt.Errorf("provider is closed")
+	}
+
+	var info BlockInfo
+	err := p.blockedPids.Lookup(pid, &info)
+	if errors.Is(err, ebpf.ErrKeyNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up blocked_pids map: %w", err)
+	}
+	return true, nil
+}
+
+// inodeKey matches struct inode_key in the BPF program
+type inodeKey struct {
+	Dev uint64
+	Ino uint64
+}
+
+// BlockInode adds a file to the blocked list. stat(2) reports devices in the
+// userspace encoding, which is converted to the kernel's internal dev_t.
+func (p *RealEBPFProvider) BlockInode(dev, ino uint64) error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+
+	key := inodeKey{
+		Dev: uint64(unix.Major(dev))<<20 | uint64(unix.Minor(dev)),
+		Ino: ino,
+	}
+	blockedValue := uint8(1)
+	if err := p.objs.BlockedInodes.Update(&key, &blockedValue, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update blocked_inodes map: %w", err)
+	}
+	return nil
+}
+
+// SetAllowedComms makes the kernel drop events from processes whose comm is
+// one of comms before they reach the ring buffer, replacing any previous
+// list. The kernel keeps only the first 15 bytes of a comm, so longer names
+// are truncated and allow every command sharing that prefix.
+func (p *RealEBPFProvider) SetAllowedComms(comms []string) error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+
+	var key [commLen + 1]byte
+	var stale [][commLen + 1]byte
+	var allowed uint8
+	iter := p.objs.AllowedComms.Iterate()
+	for iter.Next(&key, &allowed) {
+		stale = append(stale, key)
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("iterate allowed_comms map: %w", err)
+	}
+	for _, key := range stale {
+		if err := p.objs.AllowedComms.Delete(key); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("failed to delete from allowed_comms map: %w", err)
+		}
+	}
+
+	allowed = 1
+	for _, comm := range comms {
+		key := commKey(comm)
+		if err := p.objs.AllowedComms.Update(key, &allowed, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("failed to update allowed_comms map: %w", err)
+		}
+	}
+	return nil
+}
+
+// commKey encodes comm the way bpf_get_current_comm reports it: at most
+// commLen bytes, padded with nulls
+func commKey(comm string) [commLen + 1]byte {
+	var key [commLen + 1]byte
+	copy(key[:commLen], comm)
+	return key
+}
+
+// SetSampleRate makes the kernel send only every rate-th event that passed
+// the UID and comm filters, counted per CPU. 0 or 1 sends every event.
+func (p *RealEBPFProvider) SetSampleRate(rate uint32) error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+	if err := p.objs.SampleRate.Update(uint32(0), rate, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update sample_rate map: %w", err)
+	}
+	return nil
+}
+
+// SetViolationCount writes the handler's violation count for pid to the
+// pid_violation_count map, deleting the entry when count is 0
+func (p *RealEBPFProvider) SetViolationCount(pid, count uint32) error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+	if count == 0 {
+		if err := p.objs.PidViolationCount.Delete(pid); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("failed to delete from pid_violation_count map: %w", err)
+		}
+		return nil
+	}
+	if err := p.objs.PidViolationCount.Update(pid, count, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update pid_violation_count map: %w", err)
+	}
+	return nil
+}
+
+// SetTargetUIDs makes the kernel drop events from UIDs other than uids
+// before they reach the ring buffer. An empty list turns the filter off.
+func (p *RealEBPFProvider) SetTargetUIDs(uids []uint32) error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+
+	// Turn the filter off while the list changes, so no targeted event is
+	// dropped in between
+	zero, off, on := uint32(0), uint32(0), uint32(1)
+	if err := p.objs.UidFilter.Update(zero, off, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update uid_filter map: %w", err)
+	}
+
+	var uid uint32
+	var stale []uint32
+	var targeted uint8
+	iter := p.objs.TargetUids.Iterate()
+	for iter.Next(&uid, &targeted) {
+		stale = append(stale, uid)
+	}
+	if err := iter.Err(); err != nil {
+		return fmt.Errorf("iterate target_uids map: %w", err)
+	}
+	for _, uid := range stale {
+		if err := p.objs.TargetUids.Delete(uid); err != nil && !errors.Is(err, ebpf.ErrKeyNotExist) {
+			return fmt.Errorf("failed to delete from target_uids map: %w", err)
+		}
+	}
+
+	if len(uids) == 0 {
+		return nil
+	}
+	targeted = 1
+	for _, uid := range uids {
+		if err := p.objs.TargetUids.Update(uid, &targeted, ebpf.UpdateAny); err != nil {
+			return fmt.Errorf("failed to update target_uids map: %w", err)
+		}
+	}
+	if err := p.objs.UidFilter.Update(zero, on, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update uid_filter map: %w", err)
+	}
+	return nil
+}
+
+// SetEnforcementPoint chooses whether blocked PIDs are denied at open, at
+// read or at both. The file_permission LSM hook that denies reads is attached
+// the first time EnforceRead or EnforceOpenAndRead is chosen.
+func (p *RealEBPFProvider) SetEnforcementPoint(point string) error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+
+	var value uint32
+	switch point {
+	case EnforceOpen:
+	case EnforceRead, EnforceOpenAndRead:
+		value = 1
+		if point == EnforceOpenAndRead {
+			value = 2
+		}
+		if p.lsmLinkRead == nil {
+			lsmLinkRead, err := p.attacher.AttachLSM(p.objs.DenyFileRead)
+			if err != nil {
+				return fmt.Errorf("attach file_permission LSM hook: %w", err)
+			}
+			p.lsmLinkRead = lsmLinkRead
+		}
+	default:
+		return fmt.Errorf("unknown enforcement point %q", point)
+	}
+
+	zero := uint32(0)
+	if err := p.objs.EnforcementPoint.Update(zero, value, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update enforcement_point map: %w", err)
+	}
+	return nil
+}
+
+// SetCountFailedOpens chooses whether opens are reported as they are
+// attempted or only once they returned a file descriptor. Reporting on exit
+// attaches the openat and openat2 exit tracepoints the first time.
+func (p *RealEBPFProvider) SetCountFailedOpens(count bool) error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+	// No opens are reported either way
+	if p.enforceOnly {
+		return nil
+	}
+
+	var only uint32
+	if !count {
+		only = 1
+		if p.tpLinkOpenatExit == nil {
+			tpLinkOpenatExit, err := p.attacher.AttachTracepoint("syscalls", "sys_exit_openat", p.objs.TraceOpenatExit)
+			if err != nil {
+				return fmt.Errorf("attach openat exit tracepoint: %w", err)
+			}
+			p.tpLinkOpenatExit = tpLinkOpenatExit
+		}
+		// Without the exit tracepoint, held openat2 events would never be sent
+		if p.tpLinkOpenat2 != nil && p.tpLinkOpenat2Exit == nil {
+			tpLinkOpenat2Exit, err := p.attacher.AttachTracepoint("syscalls", "sys_exit_openat2", p.objs.TraceOpenat2Exit)
+			if err != nil {
+				return fmt.Errorf("attach openat2 exit tracepoint: %w", err)
+			}
+			p.tpLinkOpenat2Exit = tpLinkOpenat2Exit
+		}
+	}
+
+	zero := uint32(0)
+	if err := p.objs.SuccessfulOpensOnly.Update(zero, only, ebpf.UpdateAny); err != nil {
+		return fmt.Errorf("failed to update successful_opens_only map: %w", err)
+	}
+	return nil
+}
+
+// DumpBlockedPIDs writes the contents of the blocked_pids map to w, pending
+// batched blocks included
+func (p *RealEBPFProvider) DumpBlockedPIDs(w io.Writer) error {
+	if p.objs == nil {
+		return fmt.Errorf("provider is closed")
+	}
+	if p.batcher != nil {
+		if err := p.batcher.flush(); err != nil {
+			return err
+		}
+	}
+
+	entries, err := readBlockedPIDs(p.objs.BlockedPids)
+	if err != nil {
+		return err
+	}
+	return writeBlockedDump(w, entries)
+}
+
+// ListBlockedPIDs returns every PID in the blocked_pids map, pending batched
+// blocks included
+func (p *RealEBPFProvider) ListBlockedPIDs() ([]uint32, error) {
+	if p.objs == nil {
+		return nil, fmt.Errorf("provider is closed")
+	}
+	if p.batcher != nil {
+		if err := p.batcher.flush(); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := readBlockedPIDs(p.objs.BlockedPids)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]uint32, 0, len(entries))
+	for pid := range entries {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids, nil
+}
+
+// readBlockedPIDs reads every entry of a blocked_pids map, old one-byte
+// values included
+func readBlockedPIDs(m *ebpf.Map) (map[uint32]BlockInfo, error) {
+	entries := make(map[uint32]BlockInfo)
+	var pid uint32
+	var info BlockInfo
+	iter := m.Iterate()
+	for iter.Next(&pid, &info) {
+		entries[pid] = info
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("iterate blocked_pids map: %w", err)
+	}
+	return entries, nil
+}
+
+// EnforcementActive reports whether the LSM hook denying blocked PIDs is
+// attached
+func (p *RealEBPFProvider) EnforcementActive() (bool, string) {
+	return p.lsmLink != nil, "lsm"
+}
+
+// AttachmentStatus reports by name whether each hook is attached: the LSM
+// hook and, unless only enforcing, the openat and openat2 tracepoints and
+// those of renameSyscalls the kernel has. openat2 and renameat2 may be missing
+// on older kernels. Hooks attached on demand are included once attached.
+func (p *RealEBPFProvider) AttachmentStatus() map[string]bool {
+	status := map[string]bool{"lsm": p.lsmLink != nil}
+	if !p.enforceOnly {
+		status["openat"] = p.tpLinkOpenat != nil
+		status["openat2"] = p.tpLinkOpenat2 != nil
+		for syscall, l := range p.renameLinks {
+			status[syscall] = l != nil
+		}
+	}
+	for name, l := range map[string]link.Link{
+		"lsm_read":     p.lsmLinkRead,
+		"openat_exit":  p.tpLinkOpenatExit,
+		"openat2_exit": p.tpLinkOpenat2Exit,
+	} {
+		if l != nil {
+			status[name] = true
+		}
+	}
+	// Read tracking attaches all its tracepoints or none
+	if len(p.readLinks) > 0 {
+		for _, syscall := range readSyscalls {
+			status[syscall+"_enter"] = true
+			status[syscall+"_exit"] = true
+		}
+	}
+	return status
+}
+
+// Close cleans up all resources. Each resource is released at most once, so
+// calling Close again after a failure or successful close is safe.
 func (p *RealEBPFProvider) Close() error {
 	var errs []error
 
+	// Blocks still pending are written while the map is open; pinned, it
+	// may outlive the provider
+	if p.batcher != nil && p.objs != nil {
+		if err := p.batcher.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if p.buffer != nil {
+		p.buffer.Close()
+	}
+	if p.merger != nil {
+		p.merger.Close()
+	}
+	for i, reader := range p.shardReaders {
+		if reader == nil {
+			continue
+		}
+		if err := reader.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close reader of shard %d: %w", i, err))
+		}
+	}
+	p.shardReaders = nil
+	for i, shard := range p.shardMaps {
+		if err := shard.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close ring buffer of shard %d: %w", i, err))
+		}
+	}
+	p.shardMaps = nil
+
+	p.readerMu.Lock()
 	if p.reader != nil {
 		if err := p.reader.Close(); err != nil {
 			errs = append(errs, fmt.Errorf("close reader: %w", err))
 		}
+		p.reader = nil
+	}
+	p.readerMu.Unlock()
+
+	for i := len(p.readLinks) - 1; i >= 0; i-- {
+		if err := p.readLinks[i].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close read link: %w", err))
+		}
+	}
+	p.readLinks = nil
+
+	if p.tpLinkOpenat2Exit != nil {
+		if err := p.tpLinkOpenat2Exit.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close openat2 exit link: %w", err))
+		}
+		p.tpLinkOpenat2Exit = nil
+	}
+
+	if p.tpLinkOpenatExit != nil {
+		if err := p.tpLinkOpenatExit.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close openat exit link: %w", err))
+		}
+		p.tpLinkOpenatExit = nil
+	}
+
+	for i := len(renameSyscalls) - 1; i >= 0; i-- {
+		if l := p.renameLinks[renameSyscalls[i]]; l != nil {
+			if err := l.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("close %s link: %w", renameSyscalls[i], err))
+			}
+		}
 	}
+	p.renameLinks = nil
 
 	if p.tpLinkOpenat2 != nil {
 		if err := p.tpLinkOpenat2.Close(); err != nil {
 			errs = append(errs, fmt.Errorf("close openat2 link: %w", err))
 		}
+		p.tpLinkOpenat2 = nil
 	}
 
 	if p.tpLinkOpenat != nil {
 		if err := p.tpLinkOpenat.Close(); err != nil {
 			errs = append(errs, fmt.Errorf("close openat link: %w", err))
 		}
+		p.tpLinkOpenat = nil
+	}
+
+	if p.lsmLinkRead != nil {
+		if err := p.lsmLinkRead.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close read lsm link: %w", err))
+		}
+		p.lsmLinkRead = nil
 	}
 
 	if p.lsmLink != nil {
 		if err := p.lsmLink.Close(); err != nil {
 			errs = append(errs, fmt.Errorf("close lsm link: %w", err))
 		}
+		p.lsmLink = nil
+	}
+
+	// Pins would outlive the process and let commands talk to a dead instance
+	if p.pinPath != "" && p.objs != nil {
+		for _, m := range []*ebpf.Map{p.objs.BlockedPids, p.objs.PidViolationCount} {
+			if m == nil {
+				continue
+			}
+			if err := m.Unpin(); err != nil {
+				errs = append(errs, fmt.Errorf("unpin map: %w", err))
+			}
+		}
 	}
 
 	if p.objs != nil {
 		if err := p.objs.Close(); err != nil {
 			errs = append(errs, fmt.Errorf("close bpf objects: %w", err))
 		}
+		p.objs = nil
 	}
 
 	if len(errs) > 0 {
-		return fmt.Errorf("errors closing provider: %v", errs)
+		return fmt.Errorf("errors closing provider: %w", errors.Join(errs...))
 	}
 
 	return nil
@@ -0,0 +1,1004 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/ringbuf"
+	"golang.org/x/sys/unix"
+)
+
+// fakeLink is a link.Link that records how often it was closed
+type fakeLink struct {
+	link.Link
+	name   string
+	closes int
+	order  *[]string // appended the link's name on close
+}
+
+func (l *fakeLink) Close() error {
+	l.closes++
+	*l.order = append(*l.order, l.name)
+	return nil
+}
+
+// fakeReader is a ringReader returning queued samples and errors, then
+// blocking until it is closed like an idle ring buffer
+type fakeReader struct {
+	name     string
+	mu       sync.Mutex
+	samples  [][]byte
+	errs     []error
+	deadline time.Time
+	closes   int
+	closeErr error
+	closed   chan struct{}
+	order    *[]string // appended the reader's name on close
+}
+
+func (r *fakeReader) Read() (ringbuf.Record, error) {
+	r.mu.Lock()
+	if len(r.errs) > 0 {
+		err := r.errs[0]
+		r.errs = r.errs[1:]
+		r.mu.Unlock()
+		return ringbuf.Record{}, err
+	}
+	if len(r.samples) > 0 {
+		sample := r.samples[0]
+		r.samples = r.samples[1:]
+		r.mu.Unlock()
+		return ringbuf.Record{RawSample: sample}, nil
+	}
+	if !r.deadline.IsZero() && !time.Now().Before(r.deadline) {
+		r.mu.Unlock()
+		return ringbuf.Record{}, os.ErrDeadlineExceeded
+	}
+	r.mu.Unlock()
+
+	<-r.closed
+	return ringbuf.Record{}, ringbuf.ErrClosed
+}
+
+func (r *fakeReader) SetDeadline(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deadline = t
+}
+
+func (r *fakeReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closes == 0 {
+		close(r.closed)
+	}
+	r.closes++
+	*r.order = append(*r.order, r.name)
+	return r.closeErr
+}
+
+// fakeMap is a bpfMap storing values in memory, failing with updateErr,
+// batchErr and deleteErr if set
+type fakeMap struct {
+	mu        sync.Mutex
+	values    map[interface{}]interface{}
+	updates   int // calls to Update
+	batches   int // calls to BatchUpdate
+	updateErr error
+	batchErr  error
+	deleteErr error
+}
+
+func (m *fakeMap) Update(key, value interface{}, flags ebpf.MapUpdateFlags) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updates++
+	if m.updateErr != nil {
+		return m.updateErr
+	}
+	m.values[key] = value
+	return nil
+}
+
+func (m *fakeMap) BatchUpdate(keys, values interface{}, opts *ebpf.BatchOptions) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.batches++
+	if m.batchErr != nil {
+		return 0, m.batchErr
+	}
+	pids, infos := keys.([]uint32), values.([]BlockInfo)
+	for i, pid := range pids {
+		m.values[pid] = infos[i]
+	}
+	return len(pids), nil
+}
+
+// get returns the value stored for key
+func (m *fakeMap) get(key interface{}) (interface{}, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.values[key]
+	return value, ok
+}
+
+func (m *fakeMap) Lookup(key, valueOut interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	value, ok := m.values[key]
+	if !ok {
+		return ebpf.ErrKeyNotExist
+	}
+	if info, ok := valueOut.(*BlockInfo); ok {
+		*info = value.(BlockInfo)
+	}
+	return nil
+}
+
+func (m *fakeMap) Delete(key interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.deleteErr != nil {
+		return m.deleteErr
+	}
+	if _, ok := m.values[key]; !ok {
+		return ebpf.ErrKeyNotExist
+	}
+	delete(m.values, key)
+	return nil
+}
+
+// fakeAttacher is a bpfAttacher that can fail at a chosen stage
+type fakeAttacher struct {
+	failAt      string // "load", "lsm", "openat", "openat2", "<rename syscall>", "reader", "<read syscall>_enter" or "_exit", "lsm_read", "openat_exit", "openat2_exit", "shards" or "shard_reader"
+	missing     string // a rename syscall whose tracepoint the kernel does not have
+	links       []*fakeLink
+	lsmAttaches int
+	readers     int
+	shards      int
+
+	opened  []*fakeReader // readers in the order they were opened
+	samples [][][]byte    // samples queued on the i-th reader opened
+	closed  []string      // links and readers in the order they were closed
+}
+
+func (a *fakeAttacher) LoadObjects(objs *BpfObjects) error {
+	if a.failAt == "load" {
+		return errors.New("load failed")
+	}
+	return nil
+}
+
+func (a *fakeAttacher) attach(name string) (link.Link, error) {
+	if a.failAt == name {
+		return nil, errors.New(name + " failed")
+	}
+	l := &fakeLink{name: name, order: &a.closed}
+	a.links = append(a.links, l)
+	return l, nil
+}
+
+func (a *fakeAttacher) AttachLSM(prog *ebpf.Program) (link.Link, error) {
+	// The file_open hook is attached first, file_permission on demand
+	a.lsmAttaches++
+	if a.lsmAttaches > 1 {
+		return a.attach("lsm_read")
+	}
+	return a.attach("lsm")
+}
+
+func (a *fakeAttacher) AttachTracepoint(group, name string, prog *ebpf.Program) (link.Link, error) {
+	switch name {
+	case "sys_enter_openat2":
+		return a.attach("openat2")
+	case "sys_exit_openat":
+		return a.attach("openat_exit")
+	case "sys_exit_openat2":
+		return a.attach("openat2_exit")
+	}
+	for _, syscall := range renameSyscalls {
+		if name != "sys_enter_"+syscall {
+			continue
+		}
+		if a.missing == syscall {
+			return nil, fmt.Errorf("tracepoint %s/%s: %w", group, name, os.ErrNotExist)
+		}
+		return a.attach(syscall)
+	}
+	for _, syscall := range readSyscalls {
+		switch name {
+		case "sys_enter_" + syscall:
+			return a.attach(syscall + "_enter")
+		case "sys_exit_" + syscall:
+			return a.attach(syscall + "_exit")
+		}
+	}
+	return a.attach("openat")
+}
+
+func (a *fakeAttacher) OpenReader(events *ebpf.Map) (ringReader, error) {
+	// The events ring buffer is opened first, then those of the shards
+	a.readers++
+	if a.failAt == "reader" || (a.failAt == "shard_reader" && a.readers > 1) {
+		return nil, errors.New("reader failed")
+	}
+	r := &fakeReader{name: fmt.Sprintf("reader%d", a.readers), closed: make(chan struct{}), order: &a.closed}
+	if len(a.opened) < len(a.samples) {
+		r.samples = a.samples[len(a.opened)]
+	}
+	a.opened = append(a.opened, r)
+	return r, nil
+}
+
+func (a *fakeAttacher) CreateEventShards(objs *BpfObjects, n int) ([]*ebpf.Map, error) {
+	if a.failAt == "shards" {
+		return nil, errors.New("shards failed")
+	}
+	a.shards = n
+	return make([]*ebpf.Map, n), nil
+}
+
+func TestNewRealEBPFProvider_FailureCleanup(t *testing.T) {
+	tests := []struct {
+		failAt        string
+		expectedLinks []string
+		expectError   bool
+	}{
+		{failAt: "load", expectedLinks: nil, expectError: true},
+		{failAt: "lsm", expectedLinks: nil, expectError: true},
+		{failAt: "openat", expectedLinks: []string{"lsm"}, expectError: true},
+		{failAt: "reader", expectedLinks: []string{"lsm", "openat", "openat2", "rename", "renameat", "renameat2"}, expectError: true},
+		// openat2 and the renames are optional, so their failure must not abort construction
+		{failAt: "openat2", expectedLinks: []string{"lsm", "openat", "rename", "renameat", "renameat2"}, expectError: false},
+		{failAt: "rename", expectedLinks: []string{"lsm", "openat", "openat2", "renameat", "renameat2"}, expectError: false},
+		{failAt: "renameat2", expectedLinks: []string{"lsm", "openat", "openat2", "rename", "renameat"}, expectError: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.failAt, func(t *testing.T) {
+			attacher := &fakeAttacher{failAt: tt.failAt}
+			provider, err := newRealEBPFProvider(attacher, providerOptions{})
+
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				if provider != nil {
+					t.Error("expected nil provider on failure")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if err := provider.Close(); err != nil {
+					t.Fatalf("close: %v", err)
+				}
+				// A second Close must not release anything again
+				if err := provider.Close(); err != nil {
+					t.Fatalf("second close: %v", err)
+				}
+			}
+
+			if len(attacher.links) != len(tt.expectedLinks) {
+				t.Fatalf("expected %d links attached, got %d", len(tt.expectedLinks), len(attacher.links))
+			}
+			for i, l := range attacher.links {
+				if l.name != tt.expectedLinks[i] {
+					t.Errorf("link %d: expected %s, got %s", i, tt.expectedLinks[i], l.name)
+				}
+				if l.closes != 1 {
+					t.Errorf("link %s closed %d times, want exactly 1", l.name, l.closes)
+				}
+			}
+		})
+	}
+}
+
+func TestRealEBPFProvider_EnableReadTracking(t *testing.T) {
+	tests := []struct {
+		failAt      string
+		expectError bool
+	}{
+		{failAt: "", expectError: false},
+		{failAt: "read_enter", expectError: true},
+		{failAt: "read_exit", expectError: true},
+		{failAt: "preadv_exit", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run("fail_"+tt.failAt, func(t *testing.T) {
+			attacher := &fakeAttacher{failAt: tt.failAt}
+			provider, err := newRealEBPFProvider(attacher, providerOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			err = provider.EnableReadTracking()
+			if tt.expectError != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tt.expectError, err)
+			}
+			// Enabling twice must not attach again
+			if !tt.expectError {
+				if err := provider.EnableReadTracking(); err != nil {
+					t.Fatalf("second enable: %v", err)
+				}
+			}
+
+			if err := provider.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+			for _, l := range attacher.links {
+				if l.closes != 1 {
+					t.Errorf("link %s closed %d times, want exactly 1", l.name, l.closes)
+				}
+			}
+		})
+	}
+}
+
+func TestRealEBPFProvider_SetEnforcementPointErrors(t *testing.T) {
+	attacher := &fakeAttacher{failAt: "lsm_read"}
+	provider, err := newRealEBPFProvider(attacher, providerOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := provider.SetEnforcementPoint("write"); err == nil {
+		t.Error("expected an unknown enforcement point to be rejected")
+	}
+	if err := provider.SetEnforcementPoint(EnforceRead); err == nil {
+		t.Error("expected attaching the file_permission hook to fail")
+	}
+	// Denying existing fds needs the same hook
+	if err := provider.SetEnforcementPoint(EnforceOpenAndRead); err == nil {
+		t.Error("expected attaching the file_permission hook to fail for existing fds")
+	}
+
+	if err := provider.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	for _, l := range attacher.links {
+		if l.closes != 1 {
+			t.Errorf("link %s closed %d times, want exactly 1", l.name, l.closes)
+		}
+	}
+	if err := provider.SetEnforcementPoint(EnforceOpen); err == nil {
+		t.Error("expected SetEnforcementPoint to fail after Close")
+	}
+}
+
+func TestRealEBPFProvider_SetCountFailedOpensErrors(t *testing.T) {
+	for _, failAt := range []string{"openat_exit", "openat2_exit"} {
+		t.Run("fail_"+failAt, func(t *testing.T) {
+			attacher := &fakeAttacher{failAt: failAt}
+			provider, err := newRealEBPFProvider(attacher, providerOptions{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if err := provider.SetCountFailedOpens(false); err == nil {
+				t.Fatal("expected attaching the exit tracepoints to fail")
+			}
+
+			if err := provider.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+			for _, l := range attacher.links {
+				if l.closes != 1 {
+					t.Errorf("link %s closed %d times, want exactly 1", l.name, l.closes)
+				}
+			}
+		})
+	}
+}
+
+func TestNewRealEBPFProvider_EnforceOnly(t *testing.T) {
+	// Attaching any tracepoint fails, so only the LSM hook may be attached
+	attacher := &fakeAttacher{failAt: "openat"}
+	provider, err := newRealEBPFProvider(attacher, providerOptions{enforceOnly: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(attacher.links) != 1 || attacher.links[0].name != "lsm" {
+		t.Fatalf("expected only the LSM hook to be attached, got %d links", len(attacher.links))
+	}
+
+	if _, err := provider.ReadEvent(); !errors.Is(err, ErrEventsDisabled) {
+		t.Errorf("expected ErrEventsDisabled from ReadEvent, got %v", err)
+	}
+	if err := provider.EnableReadTracking(); !errors.Is(err, ErrEventsDisabled) {
+		t.Errorf("expected ErrEventsDisabled from EnableReadTracking, got %v", err)
+	}
+	if err := provider.ReopenReader(); !errors.Is(err, ErrEventsDisabled) {
+		t.Errorf("expected ErrEventsDisabled from ReopenReader, got %v", err)
+	}
+	if err := provider.SetCountFailedOpens(false); err != nil {
+		t.Errorf("SetCountFailedOpens: %v", err)
+	}
+	if len(attacher.links) != 1 {
+		t.Errorf("expected nothing more to be attached, got %d links", len(attacher.links))
+	}
+
+	if err := provider.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if attacher.links[0].closes != 1 {
+		t.Errorf("LSM link closed %d times, want exactly 1", attacher.links[0].closes)
+	}
+}
+
+func TestNewRealEBPFProvider_EventShards(t *testing.T) {
+	attacher := &fakeAttacher{}
+	provider, err := newRealEBPFProvider(attacher, providerOptions{eventShards: 4})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attacher.shards != 4 || attacher.readers != 5 {
+		t.Errorf("expected 4 shards and 5 readers, got %d and %d", attacher.shards, attacher.readers)
+	}
+	if err := provider.ReopenReader(); err == nil {
+		t.Error("expected ReopenReader to fail with event shards")
+	}
+
+	if err := provider.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := provider.ReadEvent(); !errors.Is(err, ringbuf.ErrClosed) {
+		t.Errorf("expected ErrClosed from ReadEvent after Close, got %v", err)
+	}
+}
+
+func TestNewRealEBPFProvider_EventShardsFailureCleanup(t *testing.T) {
+	for _, failAt := range []string{"shards", "shard_reader"} {
+		t.Run(failAt, func(t *testing.T) {
+			attacher := &fakeAttacher{failAt: failAt}
+			provider, err := newRealEBPFProvider(attacher, providerOptions{eventShards: 2})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if provider != nil {
+				t.Error("expected nil provider on failure")
+			}
+			for _, l := range attacher.links {
+				if l.closes != 1 {
+					t.Errorf("link %s closed %d times, want exactly 1", l.name, l.closes)
+				}
+			}
+		})
+	}
+}
+
+func TestRealEBPFProvider_EnforcementActive(t *testing.T) {
+	provider, err := newRealEBPFProvider(&fakeAttacher{}, providerOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if active, backend := provider.EnforcementActive(); !active || backend != "lsm" {
+		t.Errorf("expected active enforcement by lsm, got %v and %q", active, backend)
+	}
+
+	provider.Close()
+	if active, _ := provider.EnforcementActive(); active {
+		t.Error("expected enforcement to be inactive after Close")
+	}
+}
+
+func TestRealEBPFProvider_AttachmentStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		failAt   string
+		missing  string
+		opts     providerOptions
+		expected map[string]bool
+	}{
+		{
+			name:     "all attached",
+			expected: map[string]bool{"lsm": true, "openat": true, "openat2": true, "rename": true, "renameat": true, "renameat2": true},
+		},
+		{
+			name:     "openat2 missing",
+			failAt:   "openat2",
+			expected: map[string]bool{"lsm": true, "openat": true, "openat2": false, "rename": true, "renameat": true, "renameat2": true},
+		},
+		{
+			name:     "renameat2 missing",
+			failAt:   "renameat2",
+			expected: map[string]bool{"lsm": true, "openat": true, "openat2": true, "rename": true, "renameat": true, "renameat2": false},
+		},
+		{
+			// e.g. arm64, which has no rename syscall
+			name:     "rename not a syscall",
+			missing:  "rename",
+			expected: map[string]bool{"lsm": true, "openat": true, "openat2": true, "renameat": true, "renameat2": true},
+		},
+		{
+			name:     "enforce only",
+			opts:     providerOptions{enforceOnly: true},
+			expected: map[string]bool{"lsm": true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := newRealEBPFProvider(&fakeAttacher{failAt: tt.failAt, missing: tt.missing}, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer provider.Close()
+			if got := provider.AttachmentStatus(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRealEBPFProvider_AttachmentStatusOnDemand(t *testing.T) {
+	provider, err := newRealEBPFProvider(&fakeAttacher{failAt: "openat2"}, providerOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := provider.EnableReadTracking(); err != nil {
+		t.Fatalf("EnableReadTracking: %v", err)
+	}
+
+	expected := map[string]bool{
+		"lsm": true, "openat": true, "openat2": false, "rename": true, "renameat": true, "renameat2": true,
+		"read_enter": true, "read_exit": true, "pread64_enter": true, "pread64_exit": true,
+		"readv_enter": true, "readv_exit": true, "preadv_enter": true, "preadv_exit": true,
+	}
+	if got := provider.AttachmentStatus(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	handler := NewEventHandler(provider, EventHandlerConfig{DisallowedPatterns: []string{"/etc/shadow"}})
+	if stats := handler.Stats(); !reflect.DeepEqual(stats.detached(), []string{"openat2"}) {
+		t.Errorf("expected openat2 to be reported detached, got %v", stats.detached())
+	}
+
+	if err := provider.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if got := provider.AttachmentStatus(); got["lsm"] || got["openat"] || got["read_exit"] {
+		t.Errorf("expected nothing to be attached after Close, got %v", got)
+	}
+}
+
+func TestRealEBPFProvider_UseAfterClose(t *testing.T) {
+	provider, err := newRealEBPFProvider(&fakeAttacher{}, providerOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	provider.Close()
+
+	if err := provider.BlockPID(1234); err == nil {
+		t.Error("expected BlockPID to fail after Close")
+	}
+
+	if _, err := provider.ReadEvent(); !errors.Is(err, ringbuf.ErrClosed) {
+		t.Errorf("expected ErrClosed from ReadEvent after Close, got %v", err)
+	}
+}
+
+func TestRealEBPFProvider_ReadEvent(t *testing.T) {
+	attacher := &fakeAttacher{samples: [][][]byte{{
+		rawEvent(1234, 1000, "cat", "/etc/passwd", 0, 0),
+		make([]byte, 8),
+	}}}
+	provider, err := newRealEBPFProvider(attacher, providerOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer provider.Close()
+
+	event, err := provider.ReadEvent()
+	if err != nil {
+		t.Fatalf("ReadEvent: %v", err)
+	}
+	if event.Pid != 1234 || string(bytes.TrimRight(event.Filename[:], "\x00")) != "/etc/passwd" {
+		t.Errorf("unexpected event: pid=%d filename=%q", event.Pid, event.Filename[:16])
+	}
+
+	// A short record is reported, not decoded
+	if _, err := provider.ReadEvent(); err == nil {
+		t.Error("expected an error for a short record")
+	}
+
+	// Read errors are wrapped and keep their cause, which the handler uses
+	// to tell transient errors apart
+	attacher.opened[0].errs = []error{syscall.EINTR}
+	_, err = provider.ReadEvent()
+	if !errors.Is(err, syscall.EINTR) || errors.Is(err, ringbuf.ErrClosed) {
+		t.Errorf("expected a wrapped EINTR, got %v", err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "reading from ring buffer") {
+		t.Errorf("expected the error to name the ring buffer, got %v", err)
+	}
+}
+
+func TestRealEBPFProvider_ReadEvents(t *testing.T) {
+	attacher := &fakeAttacher{samples: [][][]byte{{
+		rawEvent(1, 1000, "cat", "/etc/passwd", 0, 0),
+		rawEvent(2, 1000, "cat", "/etc/group", 0, 0),
+		rawEvent(3, 1000, "cat", "/etc/hosts", 0, 0),
+	}}}
+	provider, err := newRealEBPFProvider(attacher, providerOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer provider.Close()
+	deadline := time.Now().Add(time.Hour)
+	provider.SetReadDeadline(deadline)
+
+	// A batch stops at max
+	events, err := provider.ReadEvents(2)
+	if err != nil || len(events) != 2 || events[0].Pid != 1 || events[1].Pid != 2 {
+		t.Fatalf("ReadEvents(2) = %d events, %v, want PIDs 1 and 2", len(events), err)
+	}
+
+	// and at the events waiting, without blocking for more
+	events, err = provider.ReadEvents(10)
+	if err != nil || len(events) != 1 || events[0].Pid != 3 {
+		t.Fatalf("ReadEvents(10) = %d events, %v, want PID 3", len(events), err)
+	}
+
+	// The deadline of SetReadDeadline is put back
+	reader := attacher.opened[0]
+	reader.mu.Lock()
+	defer reader.mu.Unlock()
+	if !reader.deadline.Equal(deadline) {
+		t.Errorf("reader deadline = %v, want %v", reader.deadline, deadline)
+	}
+}
+
+func TestRealEBPFProvider_ReadBuffer(t *testing.T) {
+	attacher := &fakeAttacher{samples: [][][]byte{{
+		rawEvent(1, 1000, "cat", "/etc/passwd", 0, 0),
+		rawEvent(2, 1000, "cat", "/etc/group", 0, 0),
+		rawEvent(3, 1000, "cat", "/etc/hosts", 0, 0),
+	}}}
+	provider, err := newRealEBPFProvider(attacher, providerOptions{readBuffer: 8})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer provider.Close()
+
+	// The ring buffer is drained before anything is processed
+	waitFor(t, func() bool { depth, _ := provider.EventBufferDepth(); return depth == 3 })
+	handler := NewEventHandler(provider, EventHandlerConfig{DisallowedPatterns: []string{"/etc/*"}})
+	if stats := handler.Stats(); stats.EventBufferDepth != 3 || stats.EventBufferSize != 8 {
+		t.Errorf("expected a buffer of 3/8 in Stats, got %d/%d", stats.EventBufferDepth, stats.EventBufferSize)
+	}
+
+	events, err := provider.ReadEvents(10)
+	if err != nil || len(events) != 3 || events[0].Pid != 1 || events[2].Pid != 3 {
+		t.Fatalf("ReadEvents(10) = %d events, %v, want PIDs 1 to 3", len(events), err)
+	}
+
+	// The deadline applies to waiting for the buffer, not to the reader
+	provider.SetReadDeadline(time.Now())
+	if _, err := provider.ReadEvent(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected os.ErrDeadlineExceeded, got %v", err)
+	}
+	reader := attacher.opened[0]
+	reader.mu.Lock()
+	if !reader.deadline.IsZero() {
+		t.Errorf("expected the reader to have no deadline, got %v", reader.deadline)
+	}
+	reader.mu.Unlock()
+
+	if err := provider.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	provider.SetReadDeadline(time.Time{})
+	if _, err := provider.ReadEvent(); !errors.Is(err, ringbuf.ErrClosed) {
+		t.Errorf("expected ringbuf.ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestRealEBPFProvider_ReadEventAcrossReopen(t *testing.T) {
+	attacher := &fakeAttacher{samples: [][][]byte{nil, {rawEvent(42, 0, "cat", "/etc/shadow", 0, 0)}}}
+	provider, err := newRealEBPFProvider(attacher, providerOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer provider.Close()
+
+	deadline := time.Unix(2000, 0)
+	provider.SetReadDeadline(deadline)
+
+	// ReadEvent blocks on the idle first reader until it is replaced
+	type result struct {
+		event *Event
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		event, err := provider.ReadEvent()
+		done <- result{event, err}
+	}()
+
+	if err := provider.ReopenReader(); err != nil {
+		t.Fatalf("ReopenReader: %v", err)
+	}
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("ReadEvent: %v", r.err)
+		}
+		if r.event.Pid != 42 {
+			t.Errorf("expected the event of the new reader, got PID %d", r.event.Pid)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadEvent did not continue on the reopened reader")
+	}
+
+	if attacher.opened[0].closes != 1 {
+		t.Errorf("old reader closed %d times, want exactly 1", attacher.opened[0].closes)
+	}
+	if !attacher.opened[1].deadline.Equal(deadline) {
+		t.Errorf("expected the read deadline to be reapplied, got %v", attacher.opened[1].deadline)
+	}
+
+	// Once closed for good, ReadEvent reports it
+	if err := provider.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := provider.ReadEvent(); !errors.Is(err, ringbuf.ErrClosed) {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestRealEBPFProvider_BlockPIDMap(t *testing.T) {
+	provider, err := newRealEBPFProvider(&fakeAttacher{}, providerOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer provider.Close()
+	blocked := &fakeMap{values: make(map[interface{}]interface{})}
+	provider.blockedPids = blocked
+
+	info := BlockInfo{Reason: BlockReasonThreshold, Rule: 1, BlockedAt: 5}
+	if err := provider.BlockPIDWithInfo(1234, info); err != nil {
+		t.Fatalf("BlockPIDWithInfo: %v", err)
+	}
+	if blocked.values[uint32(1234)] != info {
+		t.Errorf("expected %+v stored for PID 1234, got %v", info, blocked.values[uint32(1234)])
+	}
+	if ok, err := provider.IsPIDBlocked(1234); err != nil || !ok {
+		t.Errorf("expected PID 1234 to be found blocked, got %v, %v", ok, err)
+	}
+	if err := provider.BlockPID(5678); err != nil {
+		t.Fatalf("BlockPID: %v", err)
+	}
+	if stored, ok := blocked.values[uint32(5678)].(BlockInfo); !ok || stored.Reason != BlockReasonUnknown || stored.Rule != NoRule || stored.BlockedAt == 0 {
+		t.Errorf("expected an unknown-reason block with a timestamp for PID 5678, got %v", blocked.values[uint32(5678)])
+	}
+
+	// Unblocking a PID that is not blocked is not an error
+	if err := provider.UnblockPID(1234); err != nil {
+		t.Fatalf("UnblockPID: %v", err)
+	}
+	if err := provider.UnblockPID(1234); err != nil {
+		t.Errorf("expected unblocking an unblocked PID to succeed, got %v", err)
+	}
+	if ok, err := provider.IsPIDBlocked(1234); err != nil || ok {
+		t.Errorf("expected PID 1234 to be found unblocked, got %v, %v", ok, err)
+	}
+
+	blocked.updateErr = errors.New("map full")
+	if err := provider.BlockPID(1); err == nil || !strings.Contains(err.Error(), "blocked_pids") {
+		t.Errorf("expected a blocked_pids update error, got %v", err)
+	}
+	blocked.deleteErr = errors.New("permission denied")
+	if err := provider.UnblockPID(5678); err == nil || !strings.Contains(err.Error(), "blocked_pids") {
+		t.Errorf("expected a blocked_pids delete error, got %v", err)
+	}
+}
+
+func TestRealEBPFProvider_CloseOrder(t *testing.T) {
+	attacher := &fakeAttacher{}
+	provider, err := newRealEBPFProvider(attacher, providerOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := provider.EnableReadTracking(); err != nil {
+		t.Fatalf("EnableReadTracking: %v", err)
+	}
+	attacher.opened[0].closeErr = errors.New("reader busy")
+
+	// Readers stop before the programs feeding them are detached, in the
+	// reverse order of attaching; a failure does not stop the rest
+	err = provider.Close()
+	if err == nil || !strings.Contains(err.Error(), "reader busy") {
+		t.Errorf("expected the reader's close error, got %v", err)
+	}
+	expected := []string{"reader1", "preadv_exit", "preadv_enter", "readv_exit", "readv_enter", "pread64_exit", "pread64_enter",
+		"read_exit", "read_enter", "renameat2", "renameat", "rename", "openat2", "openat", "lsm"}
+	if strings.Join(attacher.closed, ",") != strings.Join(expected, ",") {
+		t.Errorf("closed %v, want %v", attacher.closed, expected)
+	}
+
+	if err := provider.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+	if len(attacher.closed) != len(expected) {
+		t.Errorf("expected nothing closed again, closed %v", attacher.closed)
+	}
+}
+
+// rawEvent encodes an event in the C event_t layout used by the BPF programs
+func rawEvent(pid, uid uint32, comm, filename string, flags int32, resolve uint64) []byte {
+	raw := make([]byte, eventSize)
+	binary.LittleEndian.PutUint32(raw[0:], pid)
+	binary.LittleEndian.PutUint32(raw[4:], uid)
+	copy(raw[8:24], comm)
+	copy(raw[24:280], filename)
+	binary.LittleEndian.PutUint32(raw[280:], uint32(flags))
+	binary.LittleEndian.PutUint64(raw[288:], resolve)
+	return raw
+}
+
+func TestParseEvent_Tid(t *testing.T) {
+	raw := rawEvent(1234, 1000, "nginx", "/etc/shadow", 0, 0)
+	binary.LittleEndian.PutUint32(raw[320:], 7)
+	binary.LittleEndian.PutUint32(raw[324:], 1240)
+	binary.LittleEndian.PutUint64(raw[328:], 99)
+	binary.LittleEndian.PutUint64(raw[344:], 8<<20|1) // kernel dev_t of sda1
+	binary.LittleEndian.PutUint64(raw[352:], 131074)
+	binary.LittleEndian.PutUint64(raw[360:], 4242)
+
+	event, err := parseEvent(raw)
+	if err != nil {
+		t.Fatalf("parseEvent: %v", err)
+	}
+	if event.Pid != 1234 || event.Tid != 1240 || event.Fd != 7 || event.Timestamp != 99 {
+		t.Errorf("got pid=%d tid=%d fd=%d timestamp=%d, want 1234, 1240, 7, 99", event.Pid, event.Tid, event.Fd, event.Timestamp)
+	}
+	if event.Dev != unix.Mkdev(8, 1) || event.Ino != 131074 {
+		t.Errorf("got dev=%#x ino=%d, want %#x, 131074", event.Dev, event.Ino, unix.Mkdev(8, 1))
+	}
+	if event.CgroupID != 4242 {
+		t.Errorf("got cgroup ID %d, want 4242", event.CgroupID)
+	}
+}
+
+func TestParseEvent_OpenFlagsConsistent(t *testing.T) {
+	flags := int32(syscall.O_RDONLY | syscall.O_CLOEXEC)
+	const resolveNoSymlinks = 0x04
+
+	// The same open issued through openat and openat2
+	openat, err := parseEvent(rawEvent(1234, 1000, "app", "/etc/passwd", flags, 0))
+	if err != nil {
+		t.Fatalf("parse openat event: %v", err)
+	}
+	openat2, err := parseEvent(rawEvent(1234, 1000, "app", "/etc/passwd", flags, resolveNoSymlinks))
+	if err != nil {
+		t.Fatalf("parse openat2 event: %v", err)
+	}
+
+	if openat.Flags != flags || openat2.Flags != flags {
+		t.Errorf("expected flags %#x for both syscalls, got openat=%#x openat2=%#x", flags, openat.Flags, openat2.Flags)
+	}
+	if openat.Resolve != 0 {
+		t.Errorf("expected no resolve flags for openat, got %#x", openat.Resolve)
+	}
+	if openat2.Resolve != resolveNoSymlinks {
+		t.Errorf("expected resolve %#x for openat2, got %#x", resolveNoSymlinks, openat2.Resolve)
+	}
+	if openat2.Pid != 1234 || openat2.Uid != 1000 {
+		t.Errorf("unexpected pid/uid %d/%d", openat2.Pid, openat2.Uid)
+	}
+}
+
+func TestParseEvent_ReadEvent(t *testing.T) {
+	raw := rawEvent(1234, 1000, "cat", "", 0, 0)
+	binary.LittleEndian.PutUint32(raw[308:], EventRead)
+	binary.LittleEndian.PutUint64(raw[312:], 4096)
+	binary.LittleEndian.PutUint32(raw[320:], 3)
+
+	event, err := parseEvent(raw)
+	if err != nil {
+		t.Fatalf("parseEvent: %v", err)
+	}
+	if event.Type != EventRead || event.Bytes != 4096 || event.Fd != 3 {
+		t.Errorf("expected a 4096 byte read from fd 3, got type=%d bytes=%d fd=%d", event.Type, event.Bytes, event.Fd)
+	}
+}
+
+func TestValidateEventLayout(t *testing.T) {
+	if err := validateEventLayout(eventSize); err != nil {
+		t.Errorf("expected matching sizes to validate, got %v", err)
+	}
+
+	// e.g. a field added to the C struct but not to Event
+	for _, size := range []int{eventSize - 8, eventSize + 8, 0} {
+		err := validateEventLayout(size)
+		if err == nil {
+			t.Errorf("expected an error for a %d byte event_t", size)
+			continue
+		}
+		if !strings.Contains(err.Error(), "event layout mismatch") {
+			t.Errorf("expected a layout mismatch error, got %v", err)
+		}
+	}
+}
+
+func TestParseEvent_ShortSample(t *testing.T) {
+	if _, err := parseEvent(make([]byte, 100)); err == nil {
+		t.Error("expected an error for a truncated sample")
+	}
+}
+
+// parseEventReflect is the binary.Read based decoder parseEvent replaced,
+// kept as a reference for the event layout
+func parseEventReflect(raw []byte) (*Event, error) {
+	var event Event
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func TestParseEvent_MatchesBinaryRead(t *testing.T) {
+	raw := rawEvent(4321, 1000, "systemd-journald", "/var/log/journal/system.journal",
+		int32(syscall.O_RDWR|syscall.O_CREAT|syscall.O_CLOEXEC), 0x0c)
+	binary.LittleEndian.PutUint32(raw[296:], 17)         // ns_pid
+	binary.LittleEndian.PutUint32(raw[300:], 4026532000) // pid_ns
+	binary.LittleEndian.PutUint32(raw[304:], 1)          // ppid
+	binary.LittleEndian.PutUint32(raw[308:], EventRead)  // type
+	binary.LittleEndian.PutUint64(raw[312:], 65536)      // bytes
+	binary.LittleEndian.PutUint32(raw[320:], 7)          // fd
+	binary.LittleEndian.PutUint64(raw[328:], 123456789)  // timestamp
+	binary.LittleEndian.PutUint64(raw[336:], 987654321)  // start_time
+
+	// Garbage in the padding must not leak into any field
+	binary.LittleEndian.PutUint32(raw[284:], 0xdeadbeef)
+	binary.LittleEndian.PutUint32(raw[324:], 0xdeadbeef)
+
+	want, err := parseEventReflect(raw)
+	if err != nil {
+		t.Fatalf("binary.Read: %v", err)
+	}
+	got, err := parseEvent(raw)
+	if err != nil {
+		t.Fatalf("parseEvent: %v", err)
+	}
+
+	if *got != *want {
+		t.Errorf("parseEvent = %+v, want %+v", *got, *want)
+	}
+	if len(raw) != eventSize {
+		t.Errorf("encoded event is %d bytes, event size is %d", len(raw), eventSize)
+	}
+}
+
+func BenchmarkParseEvent(b *testing.B) {
+	raw := rawEvent(1234, 1000, "app", "/etc/passwd", syscall.O_RDONLY, 0)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseEvent(raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseEventBinaryRead(b *testing.B) {
+	raw := rawEvent(1234, 1000, "app", "/etc/passwd", syscall.O_RDONLY, 0)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseEventReflect(raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
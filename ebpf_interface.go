@@ -1,14 +1,55 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"syscall"
+	"time"
+)
+
 // Event structure matching the BPF C struct
 type Event struct {
-	Pid      uint32
-	Uid      uint32
-	Comm     [16]byte
-	Filename [256]byte
-	Flags    int32
+	Pid       uint32
+	Uid       uint32
+	Comm      [16]byte
+	Filename  [256]byte
+	Flags     int32  // open(2) flags, for both openat and openat2
+	_         uint32 // padding matching the C struct
+	Resolve   uint64 // openat2 RESOLVE_* flags, 0 for openat
+	NsPid     uint32 // PID inside the process's own PID namespace
+	PidNs     uint32 // inode number of that PID namespace
+	Ppid      uint32 // parent process ID
+	Type      uint32 // EventOpen, EventRead, EventRename or EventDenied
+	Bytes     uint64 // bytes returned by read, EventRead only
+	Fd        uint32 // file descriptor read from (EventRead), or returned by the open when only successful opens are reported
+	Tid       uint32 // ID of the thread within process Pid, which is its thread group ID
+	Timestamp uint64 // CLOCK_BOOTTIME nanoseconds when the event fired, 0 if unknown
+	StartTime uint64 // CLOCK_BOOTTIME nanoseconds when the process started, telling reused PIDs apart, 0 if unknown
+	Dev       uint64 // device of the file read, as reported by stat(2) (EventRead), or opened or renamed to with DedupByInode, 0 if unknown
+	Ino       uint64 // inode of that file, 0 if unknown
+	CgroupID  uint64 // cgroup v2 ID of the process when the event fired, 0 if unknown
 }
 
+// Event types, matching EVENT_* in the BPF program
+const (
+	EventOpen   uint32 = iota // a file was opened, Filename is set
+	EventRead                 // reads took the bytes read from a file past the byte threshold, Bytes, Fd, Dev and Ino are set
+	EventRename               // a file was renamed, Filename is the new path
+	EventDenied               // the kernel denied a blocked PID access to Filename, confirming the block
+)
+
+// IsDirectoryOpen reports whether the open was for a directory, as done by
+// opendir(3), which always passes O_DIRECTORY
+func (e *Event) IsDirectoryOpen() bool {
+	return e.Flags&syscall.O_DIRECTORY != 0
+}
+
+// ErrEventsDisabled is returned by ReadEvent when the provider enforces
+// blocks without collecting events
+var ErrEventsDisabled = errors.New("event collection is disabled")
+
 // EBPFProvider defines the interface for eBPF operations
 type EBPFProvider interface {
 	// ReadEvent reads the next event from the ring buffer
@@ -18,6 +59,129 @@ type EBPFProvider interface {
 	// BlockPID adds a PID to the blocked list
 	BlockPID(pid uint32) error
 
+	// UnblockPID removes a PID from the blocked list. Unblocking a PID that
+	// is not blocked is not an error.
+	UnblockPID(pid uint32) error
+
+	// BlockInode denies every open of the file with the given device and
+	// inode number, as reported by stat(2)
+	BlockInode(dev, ino uint64) error
+
+	// SetTargetUIDs restricts events to processes running as one of uids,
+	// dropping the rest before they reach userspace. An empty list lifts
+	// the restriction.
+	SetTargetUIDs(uids []uint32) error
+
+	// DumpBlockedPIDs writes the contents of the blocked list to w
+	DumpBlockedPIDs(w io.Writer) error
+
+	// EnforcementActive reports whether blocks are actually enforced, i.e.
+	// the hook denying blocked PIDs is attached, and by which backend
+	EnforcementActive() (bool, string)
+
 	// Close cleans up resources
 	Close() error
 }
+
+// readDeadliner is implemented by providers whose ReadEvent can be told to
+// stop waiting for events at a deadline
+type readDeadliner interface {
+	// SetReadDeadline makes ReadEvent fail with os.ErrDeadlineExceeded
+	// instead of blocking past t
+	SetReadDeadline(t time.Time)
+}
+
+// batchReader is implemented by providers that can read several events at
+// once, saving the per-event overhead at high rates
+type batchReader interface {
+	// ReadEvents blocks like ReadEvent until an event arrives, then returns
+	// it with up to max-1 more that are already waiting, without blocking
+	// for them. An error after the first event is returned with the events
+	// read before it.
+	ReadEvents(max int) ([]*Event, error)
+}
+
+// readerReopener is implemented by providers that can replace a ring buffer
+// reader that stopped delivering events
+type readerReopener interface {
+	ReopenReader() error
+}
+
+// enforcementSetter is implemented by providers that can deny blocked PIDs
+// at read rather than at open
+type enforcementSetter interface {
+	// SetEnforcementPoint makes blocked PIDs fail at open (EnforceOpen), at
+	// read (EnforceRead) or at both (EnforceOpenAndRead). Blocked files are
+	// always denied at open.
+	SetEnforcementPoint(point string) error
+}
+
+// commAllowlister is implemented by providers that can drop the events of
+// known-good commands before they reach userspace
+type commAllowlister interface {
+	// SetAllowedComms replaces the commands whose events are dropped; an
+	// empty list drops none
+	SetAllowedComms(comms []string) error
+}
+
+// eventSampler is implemented by providers that can send only a sample of
+// events to userspace
+type eventSampler interface {
+	// SetSampleRate sends only every rate-th event, 0 or 1 to send all
+	SetSampleRate(rate uint32) error
+}
+
+// openOutcomeFilter is implemented by providers that can report opens on
+// syscall exit, only once they returned a file descriptor
+type openOutcomeFilter interface {
+	// SetCountFailedOpens reports every open attempt (true) or only opens
+	// that succeeded (false)
+	SetCountFailedOpens(count bool) error
+}
+
+// attachmentReporter is implemented by providers that attach hooks which
+// may fail to attach without failing the provider
+type attachmentReporter interface {
+	// AttachmentStatus reports by hook name whether it is attached
+	AttachmentStatus() map[string]bool
+}
+
+// blockLister is implemented by providers that can list their blocked list,
+// PIDs blocked outside the handler included, e.g. by the block command or by
+// an earlier run sharing a pinned map
+type blockLister interface {
+	// ListBlockedPIDs returns every blocked PID, sorted
+	ListBlockedPIDs() ([]uint32, error)
+}
+
+// violationRecorder is implemented by providers that keep a per-PID
+// violation count where other tools can read it, e.g. the pinned
+// pid_violation_count map the status command prints
+type violationRecorder interface {
+	// SetViolationCount records count for pid, removing pid when it is 0
+	SetViolationCount(pid, count uint32) error
+}
+
+// writeMapDump writes the entries of a PID-keyed map sorted by PID
+func writeMapDump(w io.Writer, name string, entries map[uint32]uint32) error {
+	if len(entries) == 0 {
+		_, err := fmt.Fprintf(w, "%s: (empty)\n", name)
+		return err
+	}
+
+	pids := make([]uint32, 0, len(entries))
+	for pid := range entries {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+
+	if _, err := fmt.Fprintf(w, "%s (%d entries):\n", name, len(entries)); err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if _, err := fmt.Fprintf(w, "  PID %d = %d\n", pid, entries[pid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"math/rand/v2"
+	"sort"
 	"sync"
+	"time"
 )
 
 // MockEBPFProvider is a mock implementation of EBPFProvider for testing
@@ -12,16 +17,91 @@ type MockEBPFProvider struct {
 	events       []*Event
 	currentIndex int
 	blockedPIDs  map[uint32]bool
+	blockedFiles map[FileID]bool
+	targetUIDs   map[uint32]bool // nil when events from every UID are returned
+	violations   map[uint32]uint32
+	enforcement  string          // set by SetEnforcementPoint, empty if never called
+	failedOpens  map[*Event]bool // opens that did not return an fd
+	onlySuccess  bool            // drop failedOpens, as the kernel would
+	reopens      int
+	reopenErr    error
+	readErrors   []error
 	closed       bool
 	ctx          context.Context
+
+	// allowedComms are the comms whose events are dropped, as the kernel would
+	allowedComms map[[commLen + 1]byte]bool
+	// sampler drops all but every Nth event that passed the filters
+	sampler sampler
+
+	// delays[i] is waited out before event i is returned
+	delays []time.Duration
+	// loop replays events from the start once they run out
+	loop bool
+	// jitter adds a random wait of up to this long before each event
+	jitter time.Duration
+	// wait waits out a delay; tests can replace it to advance a fake clock
+	// instead of sleeping
+	wait func(time.Duration)
 }
 
 // NewMockEBPFProvider creates a new mock provider with predefined events
 func NewMockEBPFProvider(ctx context.Context, events []*Event) *MockEBPFProvider {
 	return &MockEBPFProvider{
-		events:      events,
-		blockedPIDs: make(map[uint32]bool),
-		ctx:         ctx,
+		events:       events,
+		blockedPIDs:  make(map[uint32]bool),
+		blockedFiles: make(map[FileID]bool),
+		violations:   make(map[uint32]uint32),
+		ctx:          ctx,
+	}
+}
+
+// NewMockEBPFProviderWithDelays creates a mock provider that waits delays[i]
+// before returning events[i], so tests can space events out in time. Missing
+// delays are zero. By default the delays are slept; setting wait to a fake
+// clock's Advance makes them instant and deterministic.
+func NewMockEBPFProviderWithDelays(ctx context.Context, events []*Event, delays []time.Duration) *MockEBPFProvider {
+	m := NewMockEBPFProvider(ctx, events)
+	m.delays = delays
+	m.wait = func(d time.Duration) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	return m
+}
+
+// NewLoopingMockEBPFProvider creates a mock provider that replays events
+// over and over until ctx is cancelled, for sustained-load tests and
+// benchmarks. A random wait of up to jitter precedes each event; 0 returns
+// them as fast as they are read.
+func NewLoopingMockEBPFProvider(ctx context.Context, events []*Event, jitter time.Duration) *MockEBPFProvider {
+	m := NewMockEBPFProviderWithDelays(ctx, events, nil)
+	m.loop = true
+	m.jitter = jitter
+	return m
+}
+
+// delayFor returns how long to wait before returning event i
+func (m *MockEBPFProvider) delayFor(i int) time.Duration {
+	var delay time.Duration
+	if i < len(m.delays) {
+		delay = m.delays[i]
+	}
+	if m.jitter > 0 {
+		delay += rand.N(m.jitter)
+	}
+	return delay
+}
+
+// skipUnsubmitted advances past events the kernel's filters would not have
+// submitted
+func (m *MockEBPFProvider) skipUnsubmitted() {
+	for m.currentIndex < len(m.events) && !m.submitted(m.events[m.currentIndex]) {
+		m.currentIndex++
 	}
 }
 
@@ -41,6 +121,19 @@ func (m *MockEBPFProvider) ReadEvent() (*Event, error) {
 	default:
 	}
 
+	if len(m.readErrors) > 0 {
+		err := m.readErrors[0]
+		m.readErrors = m.readErrors[1:]
+		return nil, err
+	}
+
+	// Drop events the kernel's filters would not have submitted
+	m.skipUnsubmitted()
+	if m.loop && m.currentIndex >= len(m.events) {
+		m.currentIndex = 0
+		m.skipUnsubmitted()
+	}
+
 	if m.currentIndex >= len(m.events) {
 		// No more events, wait for context cancellation
 		<-m.ctx.Done()
@@ -48,10 +141,29 @@ func (m *MockEBPFProvider) ReadEvent() (*Event, error) {
 	}
 
 	event := m.events[m.currentIndex]
+	delay := m.delayFor(m.currentIndex)
 	m.currentIndex++
+
+	// Wait without holding the lock, so blocking calls aren't held up
+	if delay > 0 {
+		m.mu.Unlock()
+		m.wait(delay)
+		m.mu.Lock()
+		if m.ctx.Err() != nil {
+			return nil, context.Canceled
+		}
+	}
 	return event, nil
 }
 
+// QueueReadErrors makes the next calls to ReadEvent fail with errs, in order,
+// before any remaining events are returned (for testing purposes)
+func (m *MockEBPFProvider) QueueReadErrors(errs ...error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readErrors = append(m.readErrors, errs...)
+}
+
 // BlockPID adds a PID to the blocked list
 func (m *MockEBPFProvider) BlockPID(pid uint32) error {
 	m.mu.Lock()
@@ -65,6 +177,38 @@ func (m *MockEBPFProvider) BlockPID(pid uint32) error {
 	return nil
 }
 
+// UnblockPID removes a PID from the blocked list
+func (m *MockEBPFProvider) UnblockPID(pid uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("provider is closed")
+	}
+
+	delete(m.blockedPIDs, pid)
+	return nil
+}
+
+// ListBlockedPIDs implements blockLister
+func (m *MockEBPFProvider) ListBlockedPIDs() ([]uint32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, fmt.Errorf("provider is closed")
+	}
+
+	pids := make([]uint32, 0, len(m.blockedPIDs))
+	for pid, blocked := range m.blockedPIDs {
+		if blocked {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids, nil
+}
+
 // IsBlocked checks if a PID is blocked (for testing purposes)
 func (m *MockEBPFProvider) IsBlocked(pid uint32) bool {
 	m.mu.Lock()
@@ -72,6 +216,259 @@ func (m *MockEBPFProvider) IsBlocked(pid uint32) bool {
 	return m.blockedPIDs[pid]
 }
 
+// IsPIDBlocked implements blockChecker
+func (m *MockEBPFProvider) IsPIDBlocked(pid uint32) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return false, fmt.Errorf("provider is closed")
+	}
+	return m.blockedPIDs[pid], nil
+}
+
+// BlockInode adds a file to the blocked list
+func (m *MockEBPFProvider) BlockInode(dev, ino uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("provider is closed")
+	}
+
+	m.blockedFiles[FileID{Dev: dev, Ino: ino}] = true
+	return nil
+}
+
+// SetViolationCount records the violation count of pid, removing it when 0
+func (m *MockEBPFProvider) SetViolationCount(pid, count uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if count == 0 {
+		delete(m.violations, pid)
+	} else {
+		m.violations[pid] = count
+	}
+	return nil
+}
+
+// ViolationCount returns the violation count recorded for pid
+func (m *MockEBPFProvider) ViolationCount(pid uint32) uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.violations[pid]
+}
+
+// SetTargetUIDs makes ReadEvent skip events from other UIDs, as the kernel
+// would
+func (m *MockEBPFProvider) SetTargetUIDs(uids []uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("provider is closed")
+	}
+
+	m.targetUIDs = nil
+	if len(uids) > 0 {
+		m.targetUIDs = make(map[uint32]bool)
+		for _, uid := range uids {
+			m.targetUIDs[uid] = true
+		}
+	}
+	return nil
+}
+
+// TargetUIDs returns the UIDs events are restricted to, sorted, or nil if
+// unrestricted (for testing purposes)
+func (m *MockEBPFProvider) TargetUIDs() []uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var uids []uint32
+	for uid := range m.targetUIDs {
+		uids = append(uids, uid)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+	return uids
+}
+
+// uidTargeted reports whether events from uid pass the UID filter
+func (m *MockEBPFProvider) uidTargeted(uid uint32) bool {
+	return m.targetUIDs == nil || m.targetUIDs[uid]
+}
+
+// SetAllowedComms makes ReadEvent skip events from comms, as the kernel
+// would, matching on their first 15 bytes
+func (m *MockEBPFProvider) SetAllowedComms(comms []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("provider is closed")
+	}
+
+	m.allowedComms = make(map[[commLen + 1]byte]bool)
+	for _, comm := range comms {
+		m.allowedComms[commKey(comm)] = true
+	}
+	return nil
+}
+
+// AllowedComms returns the comms whose events are dropped, truncated as the
+// kernel would and sorted (for testing purposes)
+func (m *MockEBPFProvider) AllowedComms() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var comms []string
+	for key := range m.allowedComms {
+		comms = append(comms, string(bytes.TrimRight(key[:], "\x00")))
+	}
+	sort.Strings(comms)
+	return comms
+}
+
+// SetSampleRate makes ReadEvent return only every rate-th event, as the
+// kernel would
+func (m *MockEBPFProvider) SetSampleRate(rate uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("provider is closed")
+	}
+	m.sampler = sampler{rate: rate}
+	return nil
+}
+
+// SampleRate returns the rate set by SetSampleRate (for testing purposes)
+func (m *MockEBPFProvider) SampleRate() uint32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sampler.rate
+}
+
+// SetEnforcementPoint records where blocked PIDs would be denied
+func (m *MockEBPFProvider) SetEnforcementPoint(point string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("provider is closed")
+	}
+	if point != EnforceOpen && point != EnforceRead && point != EnforceOpenAndRead {
+		return fmt.Errorf("unknown enforcement point %q", point)
+	}
+	m.enforcement = point
+	return nil
+}
+
+// EnforcementPoint returns the last point passed to SetEnforcementPoint, empty
+// if it was never called (for testing purposes)
+func (m *MockEBPFProvider) EnforcementPoint() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.enforcement
+}
+
+// submitted reports whether the kernel would have sent event to userspace
+func (m *MockEBPFProvider) submitted(event *Event) bool {
+	if m.onlySuccess && m.failedOpens[event] {
+		return false
+	}
+	if m.allowedComms[event.Comm] || !m.uidTargeted(event.Uid) {
+		return false
+	}
+	return m.sampler.keep()
+}
+
+// MarkOpenFailed makes events opens that failed, e.g. with ENOENT, so they
+// are dropped once only successful opens are reported (for testing purposes)
+func (m *MockEBPFProvider) MarkOpenFailed(events ...*Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.failedOpens == nil {
+		m.failedOpens = make(map[*Event]bool)
+	}
+	for _, event := range events {
+		m.failedOpens[event] = true
+	}
+}
+
+// SetCountFailedOpens makes ReadEvent skip opens marked by MarkOpenFailed
+// unless count is set, as the kernel would
+func (m *MockEBPFProvider) SetCountFailedOpens(count bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("provider is closed")
+	}
+	m.onlySuccess = !count
+	return nil
+}
+
+// ReopenReader counts reopens, failing with the error set by
+// FailReopen (for testing purposes)
+func (m *MockEBPFProvider) ReopenReader() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("provider is closed")
+	}
+	m.reopens++
+	return m.reopenErr
+}
+
+// FailReopen makes ReopenReader fail with err, nil to succeed (for testing
+// purposes)
+func (m *MockEBPFProvider) FailReopen(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reopenErr = err
+}
+
+// Reopens returns how often ReopenReader was called (for testing purposes)
+func (m *MockEBPFProvider) Reopens() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reopens
+}
+
+// IsInodeBlocked checks if a file is blocked (for testing purposes)
+func (m *MockEBPFProvider) IsInodeBlocked(id FileID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.blockedFiles[id]
+}
+
+// EnforcementActive always reports the mock's blocks as enforced
+func (m *MockEBPFProvider) EnforcementActive() (bool, string) {
+	return true, "mock"
+}
+
+// DumpBlockedPIDs writes the blocked PIDs to w in the same format as the real provider
+func (m *MockEBPFProvider) DumpBlockedPIDs(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return fmt.Errorf("provider is closed")
+	}
+
+	entries := make(map[uint32]uint32, len(m.blockedPIDs))
+	for pid, blocked := range m.blockedPIDs {
+		if blocked {
+			entries[pid] = 1
+		}
+	}
+	return writeMapDump(w, "blocked_pids", entries)
+}
+
 // Close cleans up resources
 func (m *MockEBPFProvider) Close() error {
 	m.mu.Lock()
@@ -95,3 +492,32 @@ func CreateMockEvent(pid uint32, uid uint32, comm string, filename string) *Even
 
 	return event
 }
+
+// CreateMockReadEvent is a helper function to create mock read events for testing
+func CreateMockReadEvent(pid uint32, uid uint32, comm string, fd uint32, n uint64) *Event {
+	event := &Event{
+		Pid:   pid,
+		Uid:   uid,
+		Type:  EventRead,
+		Bytes: n,
+		Fd:    fd,
+	}
+	copy(event.Comm[:], comm)
+	return event
+}
+
+// CreateMockDeniedEvent is a helper function to create mock events for the
+// kernel denying a blocked PID access to filename, for testing
+func CreateMockDeniedEvent(pid uint32, uid uint32, comm string, filename string) *Event {
+	event := CreateMockEvent(pid, uid, comm, filename)
+	event.Type = EventDenied
+	return event
+}
+
+// CreateMockRenameEvent is a helper function to create mock events for a
+// file renamed to newPath, for testing
+func CreateMockRenameEvent(pid uint32, uid uint32, comm string, newPath string) *Event {
+	event := CreateMockEvent(pid, uid, comm, newPath)
+	event.Type = EventRename
+	return event
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMockEBPFProvider_DumpBlockedPIDs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := NewMockEBPFProvider(ctx, nil)
+
+	var buf bytes.Buffer
+	if err := provider.DumpBlockedPIDs(&buf); err != nil {
+		t.Fatalf("dump empty map: %v", err)
+	}
+	if buf.String() != "blocked_pids: (empty)\n" {
+		t.Errorf("unexpected empty dump: %q", buf.String())
+	}
+
+	for _, pid := range []uint32{3000, 1000, 2000} {
+		provider.BlockPID(pid)
+	}
+
+	buf.Reset()
+	if err := provider.DumpBlockedPIDs(&buf); err != nil {
+		t.Fatalf("dump: %v", err)
+	}
+	expected := "blocked_pids (3 entries):\n" +
+		"  PID 1000 = 1\n" +
+		"  PID 2000 = 1\n" +
+		"  PID 3000 = 1\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected dump:\n%s\nwant:\n%s", buf.String(), expected)
+	}
+
+	provider.Close()
+	if err := provider.DumpBlockedPIDs(&buf); err == nil {
+		t.Error("expected an error dumping a closed provider")
+	}
+}
+
+func TestMockEBPFProvider_Delays(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{
+		CreateMockEvent(1000, 1000, "app", "/tmp/a"),
+		CreateMockEvent(2000, 1000, "app", "/tmp/b"),
+		CreateMockEvent(3000, 1000, "app", "/tmp/c"),
+	}
+	// The last event has no delay
+	provider := NewMockEBPFProviderWithDelays(ctx, events, []time.Duration{0, time.Second})
+
+	clock := newFakeClock(time.Unix(1000, 0))
+	provider.wait = clock.Advance
+
+	var elapsed []time.Duration
+	for _, want := range events {
+		event, err := provider.ReadEvent()
+		if err != nil {
+			t.Fatalf("ReadEvent: %v", err)
+		}
+		if event != want {
+			t.Fatalf("expected event for PID %d, got PID %d", want.Pid, event.Pid)
+		}
+		elapsed = append(elapsed, clock.Now().Sub(time.Unix(1000, 0)))
+	}
+
+	expected := []time.Duration{0, time.Second, time.Second}
+	for i := range expected {
+		if elapsed[i] != expected[i] {
+			t.Errorf("event %d: expected the clock at +%v, got +%v", i, expected[i], elapsed[i])
+		}
+	}
+}
+
+func TestMockEBPFProvider_DelayCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	events := []*Event{CreateMockEvent(1000, 1000, "app", "/tmp/a")}
+	provider := NewMockEBPFProviderWithDelays(ctx, events, []time.Duration{time.Hour})
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := provider.ReadEvent(); err != context.Canceled {
+		t.Errorf("expected a cancelled delay to return context.Canceled, got %v", err)
+	}
+}
+
+func TestMockEBPFProvider_SetTargetUIDs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{
+		CreateMockEvent(1000, 0, "app", "/tmp/a"),
+		CreateMockEvent(2000, 1000, "app", "/tmp/b"),
+		CreateMockEvent(3000, 33, "app", "/tmp/c"),
+		CreateMockEvent(4000, 1000, "app", "/tmp/d"),
+	}
+	provider := NewMockEBPFProvider(ctx, events)
+
+	if err := provider.SetTargetUIDs([]uint32{1000}); err != nil {
+		t.Fatalf("SetTargetUIDs: %v", err)
+	}
+	for _, pid := range []uint32{2000, 4000} {
+		event, err := provider.ReadEvent()
+		if err != nil {
+			t.Fatalf("ReadEvent: %v", err)
+		}
+		if event.Pid != pid {
+			t.Errorf("expected PID %d, got %d", pid, event.Pid)
+		}
+	}
+
+	// An empty list lifts the filter
+	if err := provider.SetTargetUIDs(nil); err != nil {
+		t.Fatalf("SetTargetUIDs: %v", err)
+	}
+	if uids := provider.TargetUIDs(); uids != nil {
+		t.Errorf("expected no UID filter, got %v", uids)
+	}
+
+	provider.Close()
+	if err := provider.SetTargetUIDs([]uint32{1000}); err == nil {
+		t.Error("expected an error filtering on a closed provider")
+	}
+}
+
+func TestMockEBPFProvider_SetAllowedComms(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{
+		CreateMockEvent(1000, 0, "systemd-journal", "/tmp/a"),
+		CreateMockEvent(2000, 0, "cat", "/tmp/b"),
+		CreateMockEvent(3000, 0, "chronyd", "/tmp/c"),
+		CreateMockEvent(4000, 0, "chrony", "/tmp/d"),
+	}
+	provider := NewMockEBPFProvider(ctx, events)
+
+	// Names are truncated to the kernel's 15 bytes, so systemd-journald
+	// also allows systemd-journal; chronyd must not allow chrony
+	if err := provider.SetAllowedComms([]string{"systemd-journald", "chronyd"}); err != nil {
+		t.Fatalf("SetAllowedComms: %v", err)
+	}
+	if comms, want := provider.AllowedComms(), []string{"chronyd", "systemd-journal"}; !reflect.DeepEqual(comms, want) {
+		t.Errorf("expected allowed comms %v, got %v", want, comms)
+	}
+	for _, pid := range []uint32{2000, 4000} {
+		event, err := provider.ReadEvent()
+		if err != nil {
+			t.Fatalf("ReadEvent: %v", err)
+		}
+		if event.Pid != pid {
+			t.Errorf("expected PID %d, got %d", pid, event.Pid)
+		}
+	}
+
+	// An empty list drops nothing
+	if err := provider.SetAllowedComms(nil); err != nil {
+		t.Fatalf("SetAllowedComms: %v", err)
+	}
+	if comms := provider.AllowedComms(); comms != nil {
+		t.Errorf("expected no allowed comms, got %v", comms)
+	}
+
+	provider.Close()
+	if err := provider.SetAllowedComms([]string{"cat"}); err == nil {
+		t.Error("expected an error on a closed provider")
+	}
+}
+
+func TestMockEBPFProvider_SetSampleRate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{
+		CreateMockEvent(1000, 0, "app", "/tmp/a"),
+		CreateMockEvent(2000, 33, "app", "/tmp/b"),
+		CreateMockEvent(3000, 0, "app", "/tmp/c"),
+		CreateMockEvent(4000, 0, "app", "/tmp/d"),
+		CreateMockEvent(5000, 0, "app", "/tmp/e"),
+	}
+	provider := NewMockEBPFProvider(ctx, events)
+
+	// Only events that pass the filters count towards the sample
+	if err := provider.SetTargetUIDs([]uint32{0}); err != nil {
+		t.Fatalf("SetTargetUIDs: %v", err)
+	}
+	if err := provider.SetSampleRate(2); err != nil {
+		t.Fatalf("SetSampleRate: %v", err)
+	}
+	for _, pid := range []uint32{1000, 4000} {
+		event, err := provider.ReadEvent()
+		if err != nil {
+			t.Fatalf("ReadEvent: %v", err)
+		}
+		if event.Pid != pid {
+			t.Errorf("expected PID %d, got %d", pid, event.Pid)
+		}
+	}
+
+	provider.Close()
+	if err := provider.SetSampleRate(2); err == nil {
+		t.Error("expected an error on a closed provider")
+	}
+}
+
+func TestMockEBPFProvider_EnforcementActive(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	if active, backend := provider.EnforcementActive(); !active || backend != "mock" {
+		t.Errorf("expected active enforcement by mock, got %v and %q", active, backend)
+	}
+
+	handler := NewEventHandler(provider, EventHandlerConfig{DisallowedPatterns: []string{"/etc/*"}})
+	stats := handler.Stats()
+	if !stats.EnforcementActive || stats.EnforcementBackend != "mock" || !strings.Contains(stats.String(), "enforcement=mock") {
+		t.Errorf("expected stats to report enforcement by mock, got %s", stats)
+	}
+}
+
+func TestMockEBPFProvider_Loop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{
+		CreateMockEvent(1000, 1000, "app", "/tmp/a"),
+		CreateMockEvent(2000, 1000, "app", "/tmp/b"),
+		CreateMockEvent(3000, 1000, "app", "/tmp/c"),
+	}
+	provider := NewLoopingMockEBPFProvider(ctx, events, 0)
+
+	for i := 0; i < 3*len(events)+1; i++ {
+		event, err := provider.ReadEvent()
+		if err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		if want := events[i%len(events)].Pid; event.Pid != want {
+			t.Fatalf("read %d: expected PID %d, got %d", i, want, event.Pid)
+		}
+	}
+
+	cancel()
+	if _, err := provider.ReadEvent(); err != context.Canceled {
+		t.Errorf("expected context.Canceled after cancel, got %v", err)
+	}
+}
+
+func TestMockEBPFProvider_LoopJitter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{CreateMockEvent(1000, 1000, "app", "/tmp/a")}
+	provider := NewLoopingMockEBPFProvider(ctx, events, 10*time.Millisecond)
+
+	var waits []time.Duration
+	provider.wait = func(d time.Duration) { waits = append(waits, d) }
+
+	for i := 0; i < 100; i++ {
+		if _, err := provider.ReadEvent(); err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+	}
+	for _, d := range waits {
+		if d <= 0 || d >= 10*time.Millisecond {
+			t.Errorf("wait %v outside the jitter range", d)
+		}
+	}
+}
+
+func TestMockEBPFProvider_LoopNothingSubmitted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Every event is filtered out, so looping must not spin
+	events := []*Event{CreateMockEvent(1000, 1000, "app", "/tmp/a")}
+	provider := NewLoopingMockEBPFProvider(ctx, events, 0)
+	provider.SetTargetUIDs([]uint32{0})
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := provider.ReadEvent(); err != context.Canceled {
+		t.Errorf("expected ReadEvent to wait for cancellation, got %v", err)
+	}
+}
+
+// BenchmarkEventHandler_SustainedLoad processes an endless stream of opens
+// by many processes, a quarter of them of disallowed files
+func BenchmarkEventHandler_SustainedLoad(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var events []*Event
+	for pid := uint32(1000); pid < 1256; pid++ {
+		filename := "/usr/lib/libc.so.6"
+		if pid%4 == 0 {
+			filename = "/etc/shadow"
+		}
+		events = append(events, CreateMockEvent(pid, 1000, "app", filename))
+	}
+	provider := NewLoopingMockEBPFProvider(ctx, events, 0)
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*", "/root/.ssh/*"},
+		Threshold:          1 << 30,
+	})
+
+	// Keep the violation lines out of the benchmark output
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		event, err := provider.ReadEvent()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := handler.processEvent(event); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkEventHandler_TargetUIDs measures what dropping the events of other
+// UIDs before they leave the kernel saves: one op handles one event of the
+// target UID, among seven of other UIDs. With the filter in userspace every
+// event is read and processed; the mock's UID filter stands in for the
+// kernel's. events/op is how many events reached userspace per op.
+func BenchmarkEventHandler_TargetUIDs(b *testing.B) {
+	for _, kernel := range []bool{false, true} {
+		name := "userspace"
+		if kernel {
+			name = "kernel"
+		}
+		b.Run(name, func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var events []*Event
+			for pid := uint32(1000); pid < 1256; pid++ {
+				uid := pid % 8
+				events = append(events, CreateMockEvent(pid, uid, "app", "/etc/shadow"))
+			}
+			provider := NewLoopingMockEBPFProvider(ctx, events, 0)
+			config := EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/*"},
+				Threshold:          1 << 30,
+				TargetUIDs:         []uint32{0},
+			}
+			handler := NewEventHandler(provider, config)
+			if kernel {
+				if err := provider.SetTargetUIDs(config.TargetUIDs); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			// Keep the violation lines out of the benchmark output
+			devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer devNull.Close()
+			stdout := os.Stdout
+			os.Stdout = devNull
+			defer func() { os.Stdout = stdout }()
+
+			var read int
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for {
+					event, err := provider.ReadEvent()
+					if err != nil {
+						b.Fatal(err)
+					}
+					read++
+					if _, err := handler.processEvent(event); err != nil {
+						b.Fatal(err)
+					}
+					if event.Uid == 0 {
+						break
+					}
+				}
+			}
+			b.ReportMetric(float64(read)/float64(b.N), "events/op")
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// recordDenied counts an access the kernel denied a blocked PID, confirming
+// that its block is enforced. The first denial of each PID is printed.
+// Denials are all userspace sees of a blocked PID's opens, as opens that
+// fail are not reported by default, so they are its still-blocked attempts.
+func (h *EventHandler) recordDenied(event *Event) {
+	filename := string(bytes.TrimRight(event.Filename[:], "\x00"))
+	if h.config.StillBlockedInterval > 0 && h.isBlocked(event.Pid) && h.matchesRules(event, cleanFilename(filename)) {
+		h.blockedAttempts[event.Pid]++
+	}
+
+	h.confirmedBlocks[event.Pid]++
+	if h.confirmedBlocks[event.Pid] > 1 {
+		return
+	}
+
+	comm := h.commOf(event)
+	if !h.isBlocked(event.Pid) {
+		// Blocked from outside, e.g. with the block command
+		fmt.Printf("%s[ENFORCED] PID %d (%s), not blocked by this instance, was denied %s\n", h.relativeTime(), event.Pid, comm, h.displayPath(filename))
+		return
+	}
+	fmt.Printf("%s[ENFORCED] PID %d (%s) was denied %s; the block is in effect\n", h.relativeTime(), event.Pid, comm, h.displayPath(filename))
+}
+
+// matchesRules reports whether filename matches the rules of the process's
+// policy or an immediate rule, without counting pattern hits
+func (h *EventHandler) matchesRules(event *Event, filename string) bool {
+	if h.allowlist != nil {
+		if !h.allowlist.allows(filename) {
+			return true
+		}
+	} else if h.policyFor(event).matcher.Matches(filename) {
+		return true
+	}
+	return h.immediateRules.Matches(filename)
+}
+
+// GetConfirmedBlocks returns how many accesses by pid the kernel denied
+// since it was blocked, 0 if its block has not been seen enforced yet. Only
+// providers enforcing blocks in the kernel report denials.
+func (h *EventHandler) GetConfirmedBlocks(pid uint32) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.confirmedBlocks[pid]
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEventHandler_ConfirmedBlocks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// PID 1234 is blocked on its second violation, after which the kernel
+	// denies its next opens
+	events := []*Event{
+		CreateMockEvent(1234, 1000, "cat", "/etc/passwd"),
+		CreateMockEvent(1234, 1000, "cat", "/etc/shadow"),
+		CreateMockDeniedEvent(1234, 1000, "cat", "/etc/group"),
+		CreateMockDeniedEvent(1234, 1000, "cat", "/etc/hosts"),
+		// Blocked from outside the handler, e.g. with the block command
+		CreateMockDeniedEvent(5678, 1000, "curl", "/etc/passwd"),
+	}
+	provider := NewMockEBPFProvider(ctx, events)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          2,
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for handler.Stats().EventsRead < uint64(len(events)) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if got := handler.GetConfirmedBlocks(1234); got != 2 {
+		t.Errorf("expected 2 confirmed blocks for PID 1234, got %d", got)
+	}
+	if got := handler.GetConfirmedBlocks(5678); got != 1 {
+		t.Errorf("expected 1 confirmed block for PID 5678, got %d", got)
+	}
+	if got := handler.GetConfirmedBlocks(9999); got != 0 {
+		t.Errorf("expected no confirmed blocks for an unknown PID, got %d", got)
+	}
+
+	// Denials are not violations
+	if got := handler.GetViolationCountForPID(1234); got != 2 {
+		t.Errorf("expected denials not to count as violations, got %d violations", got)
+	}
+	if got := handler.GetViolationCountForPID(5678); got != 0 {
+		t.Errorf("expected no violations for PID 5678, got %d", got)
+	}
+}
+
+func TestEventHandler_ConfirmedBlocksReset(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+	})
+	if _, err := handler.processEvent(CreateMockDeniedEvent(1234, 1000, "cat", "/etc/passwd")); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if got := handler.GetConfirmedBlocks(1234); got != 1 {
+		t.Fatalf("expected 1 confirmed block, got %d", got)
+	}
+
+	if err := handler.Reset(false); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if got := handler.GetConfirmedBlocks(1234); got != 0 {
+		t.Errorf("expected Reset to clear confirmed blocks, got %d", got)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrEscalated is returned by Run when more than MaxBlocksBeforeEscalate
+// PIDs were blocked within EscalationWindow and ExitOnEscalate is set
+var ErrEscalated = errors.New("block storm escalated")
+
+// recordBlock counts a new block of event's PID towards
+// MaxBlocksBeforeEscalate. Many PIDs blocked in a short time are a broader
+// incident than any one of them, so crossing the limit is escalated: logged,
+// written to the audit log and exported as an "escalation" record, at most
+// once per EscalationWindow. With ExitOnEscalate it returns ErrEscalated.
+func (h *EventHandler) recordBlock(event *Event, comm, filename string) error {
+	if h.config.MaxBlocksBeforeEscalate == 0 {
+		return nil
+	}
+
+	now := h.clock.Now()
+	blocks := h.blockTimes.add(now)
+	if blocks <= int(h.config.MaxBlocksBeforeEscalate) {
+		return nil
+	}
+	if !h.escalatedAt.IsZero() && now.Sub(h.escalatedAt) < h.config.EscalationWindow {
+		return nil
+	}
+	h.escalatedAt = now
+
+	log.Printf("ESCALATION: %d PIDs blocked within %v, more than the %d allowed; latest PID %d (%s)",
+		blocks, h.config.EscalationWindow, h.config.MaxBlocksBeforeEscalate, event.Pid, comm)
+	h.audit("escalation", event, comm, filename, uint32(blocks), h.config.MaxBlocksBeforeEscalate, ProcessResult{Blocked: true})
+	if h.config.ExitOnEscalate {
+		return fmt.Errorf("%d PIDs blocked within %v: %w", blocks, h.config.EscalationWindow, ErrEscalated)
+	}
+	return nil
+}
+
+// BlocksInWindow returns how many PIDs were blocked within the last
+// EscalationWindow, or 0 if MaxBlocksBeforeEscalate is not set
+func (h *EventHandler) BlocksInWindow() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.blockTimes.count(h.clock.Now())
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventHandler_EscalatesBlockStorm(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns:      []string{"/etc/shadow"},
+		Threshold:               1,
+		MaxBlocksBeforeEscalate: 3,
+		EscalationWindow:        time.Minute,
+	})
+	clock := newFakeClock(time.Unix(1000, 0))
+	handler.clock = clock
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	pid := uint32(1000)
+	block := func() {
+		t.Helper()
+		pid++
+		result, err := handler.processEvent(CreateMockEvent(pid, 1000, "worm", "/etc/shadow"))
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		if !result.Blocked {
+			t.Fatalf("expected PID %d to be blocked", pid)
+		}
+		clock.Advance(time.Second)
+	}
+	escalations := func() int {
+		return strings.Count(logs.String(), "ESCALATION")
+	}
+
+	// Up to the limit is not a storm
+	for i := 0; i < 3; i++ {
+		block()
+	}
+	if escalations() != 0 {
+		t.Fatalf("expected no escalation at the limit, got:\n%s", logs.String())
+	}
+
+	// Crossing it escalates once, however many more blocks follow
+	for i := 0; i < 10; i++ {
+		block()
+	}
+	if escalations() != 1 {
+		t.Errorf("expected exactly 1 escalation in the window, got %d:\n%s", escalations(), logs.String())
+	}
+	if got := handler.BlocksInWindow(); got != 13 {
+		t.Errorf("expected 13 blocks in the window, got %d", got)
+	}
+
+	// Once the window passes, old blocks no longer count
+	clock.Advance(2 * time.Minute)
+	if got := handler.BlocksInWindow(); got != 0 {
+		t.Errorf("expected no blocks in the window, got %d", got)
+	}
+
+	// and a new storm escalates again
+	for i := 0; i < 4; i++ {
+		block()
+	}
+	if escalations() != 2 {
+		t.Errorf("expected a second escalation in the next window, got %d:\n%s", escalations(), logs.String())
+	}
+}
+
+func TestEventHandler_ExitOnEscalate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var events []*Event
+	for pid := uint32(1000); pid < 1010; pid++ {
+		events = append(events, CreateMockEvent(pid, 1000, "worm", "/etc/shadow"))
+	}
+	provider := NewMockEBPFProvider(ctx, events)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns:      []string{"/etc/shadow"},
+		Threshold:               1,
+		MaxBlocksBeforeEscalate: 2,
+		EscalationWindow:        time.Minute,
+		ExitOnEscalate:          true,
+	})
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrEscalated) {
+			t.Errorf("expected ErrEscalated, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to return on escalation")
+	}
+	if !provider.IsBlocked(1002) || provider.IsBlocked(1003) {
+		t.Error("expected Run to stop right after the block that escalated")
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+// readEvents reads the next events: up to EventBatchSize of them when the
+// provider can read batches, one otherwise
+func (h *EventHandler) readEvents() ([]*Event, error) {
+	if reader, ok := h.provider.(batchReader); ok && h.config.EventBatchSize > 1 {
+		return reader.ReadEvents(h.config.EventBatchSize)
+	}
+
+	event, err := h.provider.ReadEvent()
+	if err != nil {
+		return nil, err
+	}
+	return []*Event{event}, nil
+}
+
+// processEvents handles a batch of events in order under one lock, saving
+// the locking of processing them one at a time. Errors are handled as Run
+// handles them; the one Run stops with is returned, leaving the rest of the
+// batch unprocessed.
+func (h *EventHandler) processEvents(events []*Event) error {
+	for _, event := range events {
+		h.prepareEvent(event)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, event := range events {
+		if _, err := h.processEventLocked(event); err != nil {
+			if err := h.processingError(err); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
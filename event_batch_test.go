@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// batchProvider is a mock provider returning its events in fixed batches
+// from ReadEvents
+type batchProvider struct {
+	*MockEBPFProvider
+	ctx     context.Context
+	batches [][]*Event
+	maxes   []int // max of each ReadEvents call
+}
+
+func (p *batchProvider) ReadEvents(max int) ([]*Event, error) {
+	p.maxes = append(p.maxes, max)
+	if len(p.batches) == 0 {
+		<-p.ctx.Done()
+		return nil, context.Canceled
+	}
+	batch := p.batches[0]
+	p.batches = p.batches[1:]
+	return batch, nil
+}
+
+func TestEventHandler_ProcessEventsThresholdMidBatch(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow", "/etc/passwd", "/etc/group"},
+		Threshold:          2,
+	})
+
+	// PID 100 crosses the threshold at the second of its events; the events
+	// after that in the batch are still counted, as they would be one by one
+	events := []*Event{
+		CreateMockEvent(100, 1000, "cat", "/etc/shadow"),
+		CreateMockEvent(200, 1000, "ls", "/etc/shadow"),
+		CreateMockEvent(100, 1000, "cat", "/etc/passwd"),
+		CreateMockEvent(100, 1000, "cat", "/etc/group"),
+		CreateMockEvent(200, 1000, "ls", "/tmp/x"),
+	}
+	if err := handler.processEvents(events); err != nil {
+		t.Fatalf("processEvents: %v", err)
+	}
+
+	if !provider.IsBlocked(100) {
+		t.Error("expected PID 100 to be blocked mid-batch")
+	}
+	if provider.IsBlocked(200) {
+		t.Error("expected PID 200 below the threshold not to be blocked")
+	}
+	if got := handler.GetViolationCountForPID(100); got != 3 {
+		t.Errorf("PID 100 violations = %d, want 3", got)
+	}
+	if got := handler.GetViolationCountForPID(200); got != 1 {
+		t.Errorf("PID 200 violations = %d, want 1", got)
+	}
+	if stats := handler.Stats(); stats.EventsRead != 5 || stats.EventsProcessed != 5 {
+		t.Errorf("stats = %+v, want 5 events read and processed", stats)
+	}
+}
+
+func TestEventHandler_RunBatches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := &batchProvider{
+		MockEBPFProvider: NewMockEBPFProvider(ctx, nil),
+		ctx:              ctx,
+		batches: [][]*Event{
+			{CreateMockEvent(100, 1000, "cat", "/etc/shadow"), CreateMockEvent(100, 1000, "cat", "/etc/passwd")},
+			{CreateMockEvent(300, 1000, "cat", "/etc/shadow")},
+		},
+	}
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow", "/etc/passwd"},
+		Threshold:          2,
+		EventBatchSize:     64,
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+	if !waitBlocked(provider.MockEBPFProvider, 100) {
+		t.Fatal("expected PID 100 to be blocked")
+	}
+	deadline := time.Now().Add(time.Second)
+	for handler.Stats().EventsRead < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if got := handler.GetViolationCountForPID(300); got != 1 {
+		t.Errorf("PID 300 violations = %d, want 1", got)
+	}
+	if len(provider.maxes) == 0 || provider.maxes[0] != 64 {
+		t.Errorf("ReadEvents called with max %v, want 64", provider.maxes)
+	}
+}
+
+// benchmarkEvents returns a mix of allowed and disallowed opens by 256 PIDs
+func benchmarkEvents() []*Event {
+	var events []*Event
+	for pid := uint32(1000); pid < 1256; pid++ {
+		filename := "/usr/lib/libc.so.6"
+		if pid%4 == 0 {
+			filename = "/etc/shadow"
+		}
+		events = append(events, CreateMockEvent(pid, 1000, "app", filename))
+	}
+	return events
+}
+
+// benchmarkHandler returns a handler that never blocks, with stdout
+// discarded until the benchmark ends
+func benchmarkHandler(b *testing.B) *EventHandler {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	b.Cleanup(func() {
+		os.Stdout = stdout
+		devNull.Close()
+	})
+
+	return NewEventHandler(NewMockEBPFProvider(context.Background(), nil), EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*", "/root/.ssh/*"},
+		Threshold:          1 << 30,
+	})
+}
+
+func BenchmarkEventHandler_ProcessEventSingle(b *testing.B) {
+	handler := benchmarkHandler(b)
+	events := benchmarkEvents()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, event := range events {
+			if _, err := handler.processEvent(event); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkEventHandler_ProcessEventsBatch(b *testing.B) {
+	handler := benchmarkHandler(b)
+	events := benchmarkEvents()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := handler.processEvents(events); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// maxReadBuffer bounds WithReadBuffer
+const maxReadBuffer = 1 << 20
+
+// errEventBufferClosed is returned by reads of a closed eventBuffer
+var errEventBufferClosed = fmt.Errorf("event buffer closed: %w", ringbuf.ErrClosed)
+
+// eventBufferReporter is implemented by providers that read events ahead
+// into a buffer
+type eventBufferReporter interface {
+	// EventBufferDepth returns how many events are waiting in the buffer
+	// and how many it holds
+	EventBufferDepth() (depth, size int)
+}
+
+// eventBuffer reads a source in its own goroutine into a buffered channel,
+// so that slow processing does not hold up draining the ring buffer until
+// the channel is full. Read errors are queued like events. An error wrapping
+// ringbuf.ErrClosed ends the reading, and is returned for every read once
+// the events queued before it were taken, as errEventBufferClosed is once
+// the buffer is closed.
+type eventBuffer struct {
+	results chan sourcedEvent
+	err     error         // the error reading stopped with, set before results is closed
+	held    *sourcedEvent // error taken from results by drain, for the next call to next
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newEventBuffer starts reading from source into a buffer of size events
+func newEventBuffer(source eventSource, size int) *eventBuffer {
+	b := &eventBuffer{
+		results: make(chan sourcedEvent, size),
+		done:    make(chan struct{}),
+	}
+	go b.read(source)
+	return b
+}
+
+// read queues the events of source until it is closed
+func (b *eventBuffer) read(source eventSource) {
+	defer close(b.results)
+	for {
+		event, err := source()
+		if errors.Is(err, ringbuf.ErrClosed) {
+			b.err = err
+			return
+		}
+		select {
+		case b.results <- sourcedEvent{event: event, err: err}:
+		case <-b.done:
+			b.err = errEventBufferClosed
+			return
+		}
+	}
+}
+
+// next returns the next queued event or error, waiting for one until
+// deadline. A zero deadline waits forever. next and drain must not be called
+// concurrently.
+func (b *eventBuffer) next(deadline time.Time) (*Event, error) {
+	if b.held != nil {
+		held := *b.held
+		b.held = nil
+		return held.event, held.err
+	}
+
+	// Queued events are returned even once the deadline passed
+	select {
+	case result, ok := <-b.results:
+		return b.result(result, ok)
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case result, ok := <-b.results:
+		return b.result(result, ok)
+	case <-b.done:
+		return nil, errEventBufferClosed
+	case <-timeout:
+		return nil, fmt.Errorf("reading from event buffer: %w", os.ErrDeadlineExceeded)
+	}
+}
+
+// result unpacks what was received from results
+func (b *eventBuffer) result(result sourcedEvent, ok bool) (*Event, error) {
+	if !ok {
+		return nil, b.err
+	}
+	return result.event, result.err
+}
+
+// drain appends queued events to events, without waiting, until it holds
+// max. It stops at a queued error, which the next call to next returns.
+func (b *eventBuffer) drain(events []*Event, max int) []*Event {
+	for len(events) < max && b.held == nil {
+		select {
+		case result, ok := <-b.results:
+			if !ok {
+				result.err = b.err
+			}
+			if result.err != nil {
+				b.held = &result
+				return events
+			}
+			events = append(events, result.event)
+		default:
+			return events
+		}
+	}
+	return events
+}
+
+// depth returns how many events are queued and how many fit
+func (b *eventBuffer) depth() (int, int) {
+	return len(b.results), cap(b.results)
+}
+
+// Close stops reading once the source returns
+func (b *eventBuffer) Close() {
+	b.closeOnce.Do(func() { close(b.done) })
+}
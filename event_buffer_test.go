@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// countingSource returns an eventSource handing out PIDs 1 to n, then waiting
+// for release and failing with ringbuf.ErrClosed. reads counts its calls.
+func countingSource(n int, release <-chan struct{}, reads *atomic.Int32) eventSource {
+	return func() (*Event, error) {
+		i := reads.Add(1)
+		if int(i) > n {
+			<-release
+			return nil, ringbuf.ErrClosed
+		}
+		return CreateMockEvent(uint32(i), 1000, "cat", "/etc/passwd"), nil
+	}
+}
+
+// waitFor polls cond for up to a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestEventBuffer_SlowConsumer(t *testing.T) {
+	release := make(chan struct{})
+	var reads atomic.Int32
+	buffer := newEventBuffer(countingSource(5, release, &reads), 3)
+	defer buffer.Close()
+
+	// Reading goes on without the consumer until the buffer is full, with
+	// one more event read and waiting for room
+	waitFor(t, func() bool {
+		depth, _ := buffer.depth()
+		return depth == 3 && reads.Load() == 4
+	})
+	time.Sleep(10 * time.Millisecond)
+	if got := reads.Load(); got != 4 {
+		t.Errorf("expected reading to stop at a full buffer, got %d reads", got)
+	}
+
+	// A slow consumer gets every event, in order
+	for pid := uint32(1); pid <= 5; pid++ {
+		time.Sleep(5 * time.Millisecond)
+		event, err := buffer.next(time.Time{})
+		if err != nil || event.Pid != pid {
+			t.Fatalf("next() = %v, %v, want PID %d", event, err, pid)
+		}
+	}
+	if depth, size := buffer.depth(); depth != 0 || size != 3 {
+		t.Errorf("depth() = %d, %d, want 0, 3", depth, size)
+	}
+
+	// Once the source is closed, every read fails
+	close(release)
+	for i := 0; i < 2; i++ {
+		if _, err := buffer.next(time.Time{}); !errors.Is(err, ringbuf.ErrClosed) {
+			t.Errorf("expected ringbuf.ErrClosed, got %v", err)
+		}
+	}
+}
+
+func TestEventBuffer_Deadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	var reads atomic.Int32
+	buffer := newEventBuffer(countingSource(1, release, &reads), 4)
+	defer buffer.Close()
+	waitFor(t, func() bool { return reads.Load() == 2 })
+
+	// A queued event is returned even past the deadline
+	past := time.Now().Add(-time.Second)
+	if event, err := buffer.next(past); err != nil || event.Pid != 1 {
+		t.Fatalf("next() = %v, %v, want PID 1", event, err)
+	}
+
+	start := time.Now()
+	if _, err := buffer.next(time.Now().Add(20 * time.Millisecond)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected os.ErrDeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected next to wait for the deadline, returned after %v", elapsed)
+	}
+}
+
+func TestEventBuffer_DrainStopsAtError(t *testing.T) {
+	readErr := errors.New("parsing event: short sample")
+	results := []sourcedEvent{
+		{event: CreateMockEvent(1, 1000, "cat", "/etc/passwd")},
+		{event: CreateMockEvent(2, 1000, "cat", "/etc/passwd")},
+		{err: readErr},
+		{event: CreateMockEvent(3, 1000, "cat", "/etc/passwd")},
+	}
+	var reads atomic.Int32
+	block := make(chan struct{})
+	defer close(block)
+	buffer := newEventBuffer(func() (*Event, error) {
+		i := int(reads.Add(1)) - 1
+		if i >= len(results) {
+			<-block
+			return nil, ringbuf.ErrClosed
+		}
+		return results[i].event, results[i].err
+	}, 8)
+	defer buffer.Close()
+	waitFor(t, func() bool { depth, _ := buffer.depth(); return depth == len(results) })
+
+	first, err := buffer.next(time.Time{})
+	if err != nil {
+		t.Fatalf("next: %v", err)
+	}
+	events := buffer.drain([]*Event{first}, 10)
+	if len(events) != 2 || events[1].Pid != 2 {
+		t.Fatalf("drain() = %d events, want PIDs 1 and 2", len(events))
+	}
+	if _, err := buffer.next(time.Time{}); !errors.Is(err, readErr) {
+		t.Errorf("expected the queued error next, got %v", err)
+	}
+	if event, err := buffer.next(time.Time{}); err != nil || event.Pid != 3 {
+		t.Errorf("next() = %v, %v, want PID 3", event, err)
+	}
+}
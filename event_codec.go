@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// eventHeaderSize is the size of the fixed part of a binary encoded event
+const eventHeaderSize = 92
+
+// MarshalBinary encodes the event compactly, trimming the trailing NULs of
+// Comm and Filename. All integers are little-endian:
+//
+//	offset  size  field
+//	0       4     Pid
+//	4       4     Uid
+//	8       4     Flags
+//	12      8     Resolve
+//	20      4     NsPid
+//	24      4     PidNs
+//	28      4     Ppid
+//	32      4     Type
+//	36      8     Bytes
+//	44      4     Fd
+//	48      8     Timestamp
+//	56      4     Tid
+//	60      8     StartTime
+//	68      8     Dev
+//	76      8     Ino
+//	84      8     CgroupID
+//	92      1     length of Comm, n
+//	93      n     Comm
+//	93+n    2     length of Filename, m
+//	95+n    m     Filename
+func (e *Event) MarshalBinary() ([]byte, error) {
+	comm := bytes.TrimRight(e.Comm[:], "\x00")
+	filename := bytes.TrimRight(e.Filename[:], "\x00")
+
+	buf := make([]byte, eventHeaderSize, eventHeaderSize+1+len(comm)+2+len(filename))
+	le := binary.LittleEndian
+	le.PutUint32(buf[0:], e.Pid)
+	le.PutUint32(buf[4:], e.Uid)
+	le.PutUint32(buf[8:], uint32(e.Flags))
+	le.PutUint64(buf[12:], e.Resolve)
+	le.PutUint32(buf[20:], e.NsPid)
+	le.PutUint32(buf[24:], e.PidNs)
+	le.PutUint32(buf[28:], e.Ppid)
+	le.PutUint32(buf[32:], e.Type)
+	le.PutUint64(buf[36:], e.Bytes)
+	le.PutUint32(buf[44:], e.Fd)
+	le.PutUint64(buf[48:], e.Timestamp)
+	le.PutUint32(buf[56:], e.Tid)
+	le.PutUint64(buf[60:], e.StartTime)
+	le.PutUint64(buf[68:], e.Dev)
+	le.PutUint64(buf[76:], e.Ino)
+	le.PutUint64(buf[84:], e.CgroupID)
+
+	buf = append(buf, byte(len(comm)))
+	buf = append(buf, comm...)
+	buf = le.AppendUint16(buf, uint16(len(filename)))
+	buf = append(buf, filename...)
+	return buf, nil
+}
+
+// UnmarshalBinary decodes an event encoded by MarshalBinary
+func (e *Event) UnmarshalBinary(data []byte) error {
+	if len(data) < eventHeaderSize+1 {
+		return fmt.Errorf("decoding event: %d bytes is too short", len(data))
+	}
+
+	le := binary.LittleEndian
+	*e = Event{
+		Pid:       le.Uint32(data[0:]),
+		Uid:       le.Uint32(data[4:]),
+		Flags:     int32(le.Uint32(data[8:])),
+		Resolve:   le.Uint64(data[12:]),
+		NsPid:     le.Uint32(data[20:]),
+		PidNs:     le.Uint32(data[24:]),
+		Ppid:      le.Uint32(data[28:]),
+		Type:      le.Uint32(data[32:]),
+		Bytes:     le.Uint64(data[36:]),
+		Fd:        le.Uint32(data[44:]),
+		Timestamp: le.Uint64(data[48:]),
+		Tid:       le.Uint32(data[56:]),
+		StartTime: le.Uint64(data[60:]),
+		Dev:       le.Uint64(data[68:]),
+		Ino:       le.Uint64(data[76:]),
+		CgroupID:  le.Uint64(data[84:]),
+	}
+
+	rest := data[eventHeaderSize:]
+	commLen := int(rest[0])
+	rest = rest[1:]
+	if commLen > len(e.Comm) || len(rest) < commLen+2 {
+		return fmt.Errorf("decoding event: invalid comm length %d", commLen)
+	}
+	copy(e.Comm[:], rest[:commLen])
+	rest = rest[commLen:]
+
+	filenameLen := int(le.Uint16(rest))
+	rest = rest[2:]
+	if filenameLen > len(e.Filename) || len(rest) != filenameLen {
+		return fmt.Errorf("decoding event: invalid filename length %d", filenameLen)
+	}
+	copy(e.Filename[:], rest)
+	return nil
+}
+
+// EventEncoder writes a stream of binary encoded events, each prefixed with
+// its length as a little-endian uint16
+type EventEncoder struct {
+	w io.Writer
+}
+
+// NewEventEncoder returns an encoder writing to w. Wrap w in a bufio.Writer
+// when writing many events.
+func NewEventEncoder(w io.Writer) *EventEncoder {
+	return &EventEncoder{w: w}
+}
+
+// Encode writes one event to the stream
+func (enc *EventEncoder) Encode(e *Event) error {
+	data, err := e.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	record := binary.LittleEndian.AppendUint16(make([]byte, 0, 2+len(data)), uint16(len(data)))
+	_, err = enc.w.Write(append(record, data...))
+	return err
+}
+
+// EventDecoder reads a stream written by an EventEncoder
+type EventDecoder struct {
+	r   io.Reader
+	buf []byte
+}
+
+// NewEventDecoder returns a decoder reading from r
+func NewEventDecoder(r io.Reader) *EventDecoder {
+	return &EventDecoder{r: r}
+}
+
+// Decode reads the next event from the stream. It returns io.EOF at the end
+// of the stream and io.ErrUnexpectedEOF if the stream ends mid-event.
+func (dec *EventDecoder) Decode() (*Event, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(dec.r, length[:]); err != nil {
+		return nil, err
+	}
+
+	n := int(binary.LittleEndian.Uint16(length[:]))
+	if cap(dec.buf) < n {
+		dec.buf = make([]byte, n)
+	}
+	dec.buf = dec.buf[:n]
+	if _, err := io.ReadFull(dec.r, dec.buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	event := &Event{}
+	if err := event.UnmarshalBinary(dec.buf); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// eventRecorder writes the events Run reads to a file, in the stream format
+// verify replays
+type eventRecorder struct {
+	file *os.File
+	buf  *bufio.Writer
+	enc  *EventEncoder
+}
+
+// createEventRecorder creates, or truncates, the recording at path
+func createEventRecorder(path string) (*eventRecorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("creating recording: %w", err)
+	}
+	buf := bufio.NewWriter(file)
+	return &eventRecorder{file: file, buf: buf, enc: NewEventEncoder(buf)}, nil
+}
+
+// Record appends events to the recording, flushing them so a recording cut
+// short by a crash holds every batch read before it
+func (r *eventRecorder) Record(events []*Event) error {
+	for _, event := range events {
+		if err := r.enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	return r.buf.Flush()
+}
+
+// Close flushes and closes the recording
+func (r *eventRecorder) Close() error {
+	flushErr := r.buf.Flush()
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// sampleEvent returns an event with every field set
+func sampleEvent() *Event {
+	event := CreateMockEvent(1234, 1000, "systemd-journal", "/var/log/journal/system.journal")
+	event.Flags = 0x80000
+	event.Resolve = 0x08
+	event.NsPid = 7
+	event.PidNs = 4026532000
+	event.Ppid = 1
+	event.Type = EventRead
+	event.Bytes = 1 << 40
+	event.Fd = 42
+	event.Timestamp = 123456789012345
+	event.Tid = 1240
+	event.StartTime = 5000000000
+	event.Dev = 0x10302
+	event.Ino = 131074
+	event.CgroupID = 9876
+	return event
+}
+
+func TestEvent_BinaryRoundTrip(t *testing.T) {
+	tests := []*Event{
+		sampleEvent(),
+		{},
+		// Fields filled to capacity, with no trailing NUL to trim
+		CreateMockEvent(1, 0, strings.Repeat("c", 16), "/"+strings.Repeat("f", 255)),
+	}
+
+	for _, want := range tests {
+		data, err := want.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary: %v", err)
+		}
+
+		var got Event
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("UnmarshalBinary: %v", err)
+		}
+		if got != *want {
+			t.Errorf("round trip changed the event:\n%+v\nwant:\n%+v", got, *want)
+		}
+	}
+}
+
+func TestEvent_MarshalBinarySize(t *testing.T) {
+	event := sampleEvent()
+	data, err := event.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	expected := eventHeaderSize + 1 + len("systemd-journal") + 2 + len("/var/log/journal/system.journal")
+	if len(data) != expected {
+		t.Errorf("expected %d bytes, got %d", expected, len(data))
+	}
+}
+
+func TestEvent_UnmarshalBinaryInvalid(t *testing.T) {
+	data, err := sampleEvent().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+
+	tests := map[string][]byte{
+		"empty":             nil,
+		"header only":       data[:eventHeaderSize],
+		"truncated":         data[:len(data)-1],
+		"trailing bytes":    append(bytes.Clone(data), 0),
+		"comm too long":     append(append(bytes.Clone(data[:eventHeaderSize]), 17), make([]byte, 19)...),
+		"filename too long": append(bytes.Clone(data[:eventHeaderSize]), 0, 0x01, 0x01),
+	}
+	for name, input := range tests {
+		var event Event
+		if err := event.UnmarshalBinary(input); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestEventEncoderDecoder(t *testing.T) {
+	events := []*Event{
+		sampleEvent(),
+		CreateMockEvent(2000, 0, "cat", "/etc/shadow"),
+		CreateMockRenameEvent(3000, 1000, "mv", "/etc/profile"),
+	}
+
+	var buf bytes.Buffer
+	enc := NewEventEncoder(&buf)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+	stream := buf.Bytes()
+
+	dec := NewEventDecoder(bytes.NewReader(stream))
+	for i, want := range events {
+		got, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("Decode %d: %v", i, err)
+		}
+		if *got != *want {
+			t.Errorf("event %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	if _, err := dec.Decode(); err != io.EOF {
+		t.Errorf("expected io.EOF at the end of the stream, got %v", err)
+	}
+
+	// A stream cut short mid-event is an error, not a clean end
+	dec = NewEventDecoder(bytes.NewReader(stream[:len(stream)-3]))
+	for i := 0; i < len(events)-1; i++ {
+		if _, err := dec.Decode(); err != nil {
+			t.Fatalf("Decode %d: %v", i, err)
+		}
+	}
+	if _, err := dec.Decode(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF for a truncated stream, got %v", err)
+	}
+}
+
+func BenchmarkEventMarshalBinary(b *testing.B) {
+	event := sampleEvent()
+	var data []byte
+	for i := 0; i < b.N; i++ {
+		data, _ = event.MarshalBinary()
+	}
+	b.ReportMetric(float64(len(data)), "bytes/event")
+}
+
+func BenchmarkEventMarshalJSON(b *testing.B) {
+	event := sampleEvent()
+	var data []byte
+	for i := 0; i < b.N; i++ {
+		data, _ = json.Marshal(event)
+	}
+	b.ReportMetric(float64(len(data)), "bytes/event")
+}
+
+func BenchmarkEventUnmarshalBinary(b *testing.B) {
+	data, _ := sampleEvent().MarshalBinary()
+	var event Event
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		event.UnmarshalBinary(data)
+	}
+}
+
+func BenchmarkEventUnmarshalJSON(b *testing.B) {
+	data, _ := json.Marshal(sampleEvent())
+	var event Event
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		json.Unmarshal(data, &event)
+	}
+}
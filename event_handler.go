@@ -6,143 +6,2094 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cilium/ebpf/ringbuf"
 )
 
+// Rule is a disallowed file pattern with its own enforcement policy
+type Rule struct {
+	Pattern   string
+	Immediate bool // block on the first match regardless of Threshold
+}
+
 // EventHandlerConfig holds configuration for the event handler
 type EventHandlerConfig struct {
-	DisallowedPatterns []string
-	Threshold          uint32
-	TargetPID          uint32 // 0 means all PIDs
+	DisallowedPatterns    []string
+	PolicyMode            string            // PolicyDenylist (default) or PolicyAllowlist, where opens of anything but AllowedPatterns are violations
+	AllowedPatterns       []string          // exact paths or globs monitored processes may open in allowlist mode, on top of a base list
+	Rules                 []Rule            // additional patterns, matched like DisallowedPatterns
+	Threshold             uint32            // violations before blocking; main rejects 0, which would act like 1
+	WarnThreshold         uint32            // violations that trigger a one-time warning before blocking, 0 to disable
+	TargetPID             uint32            // 0 means all PIDs
+	TargetUIDs            []uint32          // only monitor these UIDs, empty for all
+	TargetComms           []string          // only monitor these command names, empty for all
+	TargetContainers      []string          // only monitor processes in these containers, by ID prefix or mnt:<inode>; empty for all
+	TargetTIDs            []uint32          // only monitor opens by these host thread IDs, empty for all; blocks still apply to the whole process
+	FilterMode            string            // how TargetPID, TargetUIDs, TargetComms, TargetContainers and TargetTIDs combine: FilterAll (default) or FilterAny
+	PIDNamespace          uint32            // PID namespace inode TargetPID belongs to, 0 for host PIDs
+	PIDMin                uint32            // lowest host PID monitored, 0 for no lower bound
+	PIDMax                uint32            // highest host PID monitored, 0 for no upper bound
+	ExcludePIDs           []uint32          // host PIDs never monitored
+	ReportOnlyPIDs        []uint32          // host PIDs whose violations are counted and logged but never blocked
+	TrustedParentComms    []string          // violations are not counted for children of these commands
+	AllowedComms          []string          // commands never monitored, dropped in the kernel where the provider can; matched on the first 15 bytes
+	MonitorSelf           bool              // count violations by the fence's own process, except routine opens
+	IgnoreDirectoryOpens  bool              // skip opens of directories (O_DIRECTORY), e.g. opendir("/etc")
+	IgnoreFlags           []string          // skip opens carrying any of these open(2) flags, e.g. O_PATH, or with these access modes, e.g. O_WRONLY
+	MountFilter           []string          // only monitor files under these mount points, e.g. /data, by path prefix; empty for all. Relative filenames are always monitored
+	MaxEventsPerSecond    uint32            // 0 disables the defensive-mode circuit breaker
+	AuditLogPath          string            // JSON Lines audit log of violations and blocks, empty to disable
+	AuditMaxBytes         int64             // rotate the audit log past this size, 0 to disable
+	AuditSync             bool              // fsync the audit log after every record
+	RecordPath            string            // file every event read is written to, for verify to replay; empty to disable
+	StatsInterval         time.Duration     // log a stats summary this often, 0 to disable
+	StillBlockedInterval  time.Duration     // print how many more matching opens each blocked PID attempted this often, 0 to disable
+	OTLPEndpoint          string            // OTLP/HTTP collector receiving violations and blocks, empty to disable
+	BlocklistFile         string            // file of PIDs and commands to block immediately, watched for additions; empty to disable
+	BlocklistInterval     time.Duration     // how often BlocklistFile is checked for changes, 0 for every 5s
+	ByteThreshold         uint64            // block a PID after reading more than this from one disallowed file, 0 to disable
+	BlockedFiles          []string          // files no process may open, enforced by inode
+	ResolveFullComm       bool              // replace truncated 15-character comms with the name from /proc/<pid>/cmdline
+	ResolveContainer      bool              // show the container of violating processes and add it to audit records; implied by TargetContainers
+	CaseInsensitive       bool              // match patterns ignoring case; custom matchers are not affected
+	TrackPIDReuse         bool              // forget a PID's state, and lift its block, once a new process has it, told apart by start time; reads /proc/<pid>/stat for every event
+	GlobOnly              bool              // match patterns only exactly or as globs, never as substrings; custom matchers are not affected
+	Policies              []Policy          // per-process-group patterns and thresholds, tried before the top-level ones
+	UnblockOnExit         bool              // unblock every PID the handler blocked when Run returns
+	MaxReadErrors         uint32            // consecutive unexpected read errors before Run gives up, 0 to never give up
+	FailClosed            bool              // return from Run on the first provider error instead of logging it and carrying on unmonitored
+	PIDThresholdOverrides map[uint32]uint32 // host PID -> threshold replacing its policy's or the global one; 0 is ignored
+	CommThresholds        map[string]uint32 // command -> threshold replacing its policy's or the global one; PID overrides win, 0 is ignored
+	GracePeriodOpens      uint32            // opens of any file by a PID before its violations count, 0 to count from the first; immediate rules still apply
+	WatchdogTimeout       time.Duration     // reopen the ring buffer reader after this long without reading an event, 0 to disable
+	Learn                 bool              // record matched files per command for LearnReport instead of counting and blocking
+	EnforcementPoint      string            // where blocked PIDs are denied: EnforceOpen (default) or EnforceRead
+	EnforceExistingFDs    bool              // with EnforceOpen, also deny reads through fds opened before the block; hooks every read
+	EnforcementAction     string            // what blocking a PID does: ActionDeny (default) or ActionQuarantine
+	QuarantineCgroup      string            // cgroup directory ActionQuarantine moves blocked PIDs into, e.g. a frozen one
+	ReleaseCgroup         string            // cgroup directory quarantined PIDs are moved into when unblocked; empty leaves them quarantined
+	MaxPathDisplay        int               // shorten filenames in console output to this many characters, 0 to show them in full; audit records keep full paths
+	QuotePaths            bool              // quote filenames in text output, escaping control characters such as newlines
+	RelativeTime          bool              // prefix alert lines with the time since Run started, e.g. "+1.2s"
+	SuccessfulOpensOnly   bool              // count only opens that returned an fd, not those that failed (e.g. ENOENT, EACCES)
+	DedupByInode          bool              // count each file once per PID, whichever path, symlink or hardlink it was reached through
+	LogFormat             string            // format of the Report: LogFormatText (default) or LogFormatJSON
+	RapidOpenThreshold    uint32            // block a PID that opens more than this many files, any files, within RapidOpenWindow; 0 to disable
+	RapidOpenWindow       time.Duration     // window RapidOpenThreshold is counted over
+	SampleRate            uint32            // process only every Nth event, in the kernel where the provider can; 0 or 1 for all. Violations are undercounted, for observability only
+	EventBatchSize        int               // read and process up to this many waiting events at once where the provider can; 0 or 1 for one at a time
+	MetricsTopK           int               // commands and UIDs whose violations Stats reports by name, the rest as "other"; 0 for 10
+	VerifyBlocks          bool              // look each blocked PID up in the provider before reporting the block, where the provider can
+	BlockVerifyGrace      time.Duration     // how long VerifyBlocks waits for a block to show up, 0 for 50ms
+
+	MaxBlocksBeforeEscalate uint32        // escalate when more than this many PIDs are blocked within EscalationWindow; 0 to disable
+	EscalationWindow        time.Duration // window MaxBlocksBeforeEscalate is counted over
+	ExitOnEscalate          bool          // make Run return ErrEscalated on escalation
+}
+
+// FilterMode values
+const (
+	FilterAll = "all" // an event must match every target filter that is set
+	FilterAny = "any" // an event must match at least one target filter that is set
+)
+
+// EnforcementPoint values
+const (
+	EnforceOpen = "open" // blocked PIDs cannot open files
+	EnforceRead = "read" // blocked PIDs can open files, but reading them fails with EACCES
+
+	// EnforceOpenAndRead denies blocked PIDs at open, and at read for files
+	// they opened before being blocked. It is set by EnforceExistingFDs.
+	EnforceOpenAndRead = "open+read"
+)
+
+// HandlerStats is a point-in-time snapshot of the handler's counters
+type HandlerStats struct {
+	EventsRead      uint64
+	EventsProcessed uint64 // events that passed filtering and were matched against the patterns
+	TotalViolations uint32
+	BlockedPIDs     int
+	MalformedEvents uint64
+	DefensiveMode   bool
+	LatencyP50      time.Duration // median time from kernel event to processing
+	LatencyP99      time.Duration
+
+	EnforcementActive  bool   // blocks are actually enforced by the provider
+	EnforcementBackend string // what enforces them, e.g. "lsm"
+
+	ViolationsByComm map[string]uint64 // violations of the MetricsTopK commands with the most, the rest under "other"
+	ViolationsByUID  map[string]uint64 // violations of the MetricsTopK UIDs with the most, the rest under "other"
+
+	EventBufferDepth int // events read ahead and waiting to be processed, where the provider reads ahead
+	EventBufferSize  int // events the read-ahead buffer holds, 0 without one
+
+	Attachments map[string]bool // hook name -> attached, where the provider reports it
+
+	Policies map[string]PolicyStats // violations and blocks per policy and "default", nil without Policies
+}
+
+// detached returns the hooks that failed to attach, sorted
+func (s HandlerStats) detached() []string {
+	var names []string
+	for name, attached := range s.Attachments {
+		if !attached {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String summarises the counters on one line
+func (s HandlerStats) String() string {
+	return fmt.Sprintf("events=%d violations=%d blocked=%d malformed=%d latency_p50=%v latency_p99=%v enforcement=%s processed=%d",
+		s.EventsRead, s.TotalViolations, s.BlockedPIDs, s.MalformedEvents, s.LatencyP50, s.LatencyP99, s.enforcement(), s.EventsProcessed)
+}
+
+// enforcement describes whether and by what blocks are enforced, e.g. "lsm"
+// or "none(proc)"
+func (s HandlerStats) enforcement() string {
+	if !s.EnforcementActive {
+		return "none(" + s.EnforcementBackend + ")"
+	}
+	return s.EnforcementBackend
+}
+
+// WatchdogState describes the read loop watchdog
+type WatchdogState struct {
+	LastRead       time.Time // when an event was last read, or Run started
+	Stale          bool      // nothing was read for WatchdogTimeout; cleared by the next event
+	Reopens        uint64    // ring buffer reader reopens
+	ReopenFailures uint64    // reopens that failed
+}
+
+// blockRecord is the process a PID belonged to when it was blocked. A later
+// exec can change the PID's comm; the block stays attributed to this one.
+type blockRecord struct {
+	comm        string
+	info        BlockInfo // why and when, set by blockPID
+	quarantined bool      // moved into QuarantineCgroup, with ActionQuarantine
+	verifying   bool      // blocked but not yet verified, with VerifyBlocks
+}
+
+// parentInfo caches whether a PID's parent is trusted
+type parentInfo struct {
+	ppid    uint32
+	start   uint64 // start time of the process it was resolved for, see Event.StartTime
+	trusted bool
+}
+
+// maxCachedParents bounds the parents cache; processes exit without telling
+// us, so their entries are evicted to make room instead
+const maxCachedParents = 8192
+
+// fullComm caches the untruncated name of a process with a truncated comm
+type fullComm struct {
+	comm string // the truncated comm it was resolved for
+	full string
+}
+
+// ProcessResult describes the fate of a single event
+type ProcessResult struct {
+	Matched        bool   // the filename matched a disallowed pattern or rule
+	Counted        bool   // the match was counted as a violation
+	Blocked        bool   // the PID was blocked as a result of this event
+	MatchedPattern string // the pattern that matched, if known
+	MatchKind      string // how the pattern matched (MatchExact, MatchGlob, MatchSubstring, MatchDirectory or MatchRecursive), if known
 }
 
 // EventHandler manages the core logic of processing events and blocking PIDs
 type EventHandler struct {
+	mu sync.Mutex // guards the state below against concurrent readers
+
 	provider        EBPFProvider
 	config          EventHandlerConfig
-	violationCounts map[uint32]uint32 // PID -> violation count
-	blockedPIDs     map[uint32]bool   // PID -> blocked status
+	matcher         Matcher
+	policies        []*activePolicy // configured policies, in order
+	defaultPolicy   *activePolicy   // the top-level patterns and threshold
+	immediate       []string        // patterns of Immediate rules
+	immediateRules  *PatternMatcher // matcher over immediate, honouring CaseInsensitive and GlobOnly
+	allowlist       *allowlist      // files that may be opened in allowlist mode, nil in denylist mode
+	excludedPIDs    map[uint32]bool
+	reportOnlyPIDs  map[uint32]bool
+	targetUIDs      map[uint32]bool
+	targetComms     map[string]bool
+	targetTIDs      map[uint32]bool
+	allowedComms    map[[commLen + 1]byte]bool
+	blockedComms    map[[commLen + 1]byte]bool // commands on the external blocklist, blocked on sight
+	selfPID         uint32
+	proc            procFS
+	parents         map[uint32]parentInfo // PID -> cached parent lookup
+	auditLog        *AuditLogger
+	exporter        *OTLPExporter
+	blocklist       BlocklistSource
+	learner         *learner                       // nil unless Learn is set
+	violationCounts map[uint32]uint32              // PID -> violation count
+	accessedFiles   map[uint32]map[string]struct{} // PID -> distinct disallowed files opened
+	openCounts      map[uint32]uint32              // PID -> opens of any file, tracked for GracePeriodOpens
+	countedInodes   map[uint32]map[FileID]struct{} // PID -> files counted as violations, tracked for DedupByInode
+	openTimes       map[uint32]*slidingWindow      // PID -> times of its latest opens, tracked for RapidOpenThreshold
+	blockTimes      slidingWindow                  // times of recent blocks, tracked for MaxBlocksBeforeEscalate
+	escalatedAt     time.Time                      // when blocks were last escalated
+	pidThresholds   map[uint32]uint32              // PID -> threshold override
+	blockedPIDs     map[uint32]blockRecord         // blocked PID -> who it was when blocked
+	blockedAttempts map[uint32]uint64              // blocked PID -> denied matching opens since the last still-blocked summary
+	confirmedBlocks map[uint32]uint64              // PID -> accesses the kernel denied it
+	warnedPIDs      map[uint32]bool                // PID -> approaching-block warning emitted
+	patternHits     map[string]uint64              // pattern -> number of matching events
+	bytesRead       map[uint32]map[string]uint64   // PID -> disallowed file -> bytes read
+	blockedInodes   map[FileID]string              // blocked file -> path it was blocked by
+	fullComms       map[uint32]fullComm            // PID -> cached untruncated comm
+	containers      map[uint32]containerInfo       // PID -> cached container
+	cgContainers    map[uint64]string              // cgroup ID -> container ID read from that cgroup
+	pidStarts       map[uint32]uint64              // PID -> /proc start time of the process its state is for, from its first violation or block
+	watchedMounts   []string                       // cleaned MountFilter
+	commLabels      *labelSet                      // violations per command, for Stats
+	uidLabels       *labelSet                      // violations per UID, for Stats
+	ignoredFlags    openFlagFilter                 // opens skipped for IgnoreFlags
+	hostMountNs     uint32                         // mount namespace of PID 1, resolved on first use
+	malformedEvents uint64                         // events skipped due to empty or invalid filenames
+	eventsRead      uint64                         // events read from the provider
+	eventsProcessed uint64                         // opens and renames that passed filtering, matching or not
+	bootTime        time.Time                      // wall clock time of boot, for event timestamps
+	latency         latencySummary                 // recent kernel-to-processing latencies
+	watchdog        WatchdogState
+	lastReopen      time.Time // when the watchdog last tried to reopen the reader
+	startedAt       time.Time // when Run started, for the Report's uptime
+	sampler         *sampler  // samples events in userspace when the provider cannot, nil otherwise
+	configErr       error     // invalid patterns or open flags in the config, returned by Run
+
+	verifies sync.WaitGroup // block verifications in flight, see confirmBlock
+	failures chan error     // errors Run stops with that arise outside event processing, e.g. in confirmBlock
+
+	// Circuit breaker state for MaxEventsPerSecond
+	clock            Clock
+	newTicker        func(time.Duration) (<-chan time.Time, func())
+	sleep            func(time.Duration)
+	rateWindowStart  time.Time
+	rateWindowEvents uint32
+	defensiveMode    bool
 }
 
-// NewEventHandler creates a new event handler with the given provider and config
+// NewEventHandler creates a new event handler with the given provider and
+// config. Brace groups in patterns are expanded, e.g. /etc/{passwd,shadow},
+// and absolute patterns are cleaned, e.g. /etc//passwd to /etc/passwd.
 func NewEventHandler(provider EBPFProvider, config EventHandlerConfig) *EventHandler {
-	return &EventHandler{
+	config.DisallowedPatterns = expandPatterns(config.DisallowedPatterns)
+	var rules []Rule
+	for _, rule := range config.Rules {
+		for _, pattern := range expandBraces(rule.Pattern) {
+			rules = append(rules, Rule{Pattern: cleanPattern(pattern), Immediate: rule.Immediate})
+		}
+	}
+	config.Rules = rules
+	policies := make([]Policy, len(config.Policies))
+	for i, policy := range config.Policies {
+		policy.Patterns = expandPatterns(policy.Patterns)
+		policies[i] = policy
+	}
+	config.Policies = policies
+
+	patterns := append([]string{}, config.DisallowedPatterns...)
+	for _, rule := range config.Rules {
+		patterns = append(patterns, rule.Pattern)
+	}
+	return NewEventHandlerWithMatcher(provider, config, newPatternMatcher(patterns, config.CaseInsensitive, config.GlobOnly))
+}
+
+// NewEventHandlerWithMatcher creates a new event handler that uses a custom
+// matcher instead of the default glob/substring matching of DisallowedPatterns.
+// Immediate rules are still matched in addition to the custom matcher.
+func NewEventHandlerWithMatcher(provider EBPFProvider, config EventHandlerConfig, matcher Matcher) *EventHandler {
+	ignoredFlags, flagsErr := parseOpenFlags(config.IgnoreFlags)
+	h := &EventHandler{
 		provider:        provider,
 		config:          config,
+		matcher:         matcher,
 		violationCounts: make(map[uint32]uint32),
-		blockedPIDs:     make(map[uint32]bool),
+		accessedFiles:   make(map[uint32]map[string]struct{}),
+		openCounts:      make(map[uint32]uint32),
+		countedInodes:   make(map[uint32]map[FileID]struct{}),
+		openTimes:       make(map[uint32]*slidingWindow),
+		blockTimes:      slidingWindow{window: config.EscalationWindow},
+		pidThresholds:   make(map[uint32]uint32),
+		blockedPIDs:     make(map[uint32]blockRecord),
+		blockedAttempts: make(map[uint32]uint64),
+		confirmedBlocks: make(map[uint32]uint64),
+		warnedPIDs:      make(map[uint32]bool),
+		excludedPIDs:    make(map[uint32]bool),
+		reportOnlyPIDs:  make(map[uint32]bool),
+		targetUIDs:      make(map[uint32]bool),
+		targetComms:     make(map[string]bool),
+		targetTIDs:      make(map[uint32]bool),
+		allowedComms:    make(map[[commLen + 1]byte]bool),
+		blockedComms:    make(map[[commLen + 1]byte]bool),
+		selfPID:         uint32(os.Getpid()),
+		proc:            hostProc,
+		parents:         make(map[uint32]parentInfo),
+		patternHits:     make(map[string]uint64),
+		bytesRead:       make(map[uint32]map[string]uint64),
+		blockedInodes:   make(map[FileID]string),
+		fullComms:       make(map[uint32]fullComm),
+		containers:      make(map[uint32]containerInfo),
+		cgContainers:    make(map[uint64]string),
+		pidStarts:       make(map[uint32]uint64),
+		watchedMounts:   cleanMounts(config.MountFilter),
+		commLabels:      newLabelSet(config.MetricsTopK),
+		failures:        make(chan error, 1),
+		uidLabels:       newLabelSet(config.MetricsTopK),
+		bootTime:        bootTime(),
+		clock:           realClock{},
+		newTicker:       newRealTicker,
+		sleep:           time.Sleep,
+		ignoredFlags:    ignoredFlags,
+		configErr:       errors.Join(config.ValidatePatterns(), flagsErr),
+	}
+
+	for _, pid := range config.ExcludePIDs {
+		h.excludedPIDs[pid] = true
+	}
+	for pid, threshold := range config.PIDThresholdOverrides {
+		if threshold != 0 {
+			h.pidThresholds[pid] = threshold
+		}
+	}
+	for i, policy := range config.Policies {
+		h.policies = append(h.policies, newActivePolicy(i, policy, config.CaseInsensitive, config.GlobOnly))
+	}
+	h.defaultPolicy = &activePolicy{name: defaultPolicyName, matcher: matcher, threshold: config.Threshold}
+
+	for _, pid := range config.ReportOnlyPIDs {
+		h.reportOnlyPIDs[pid] = true
+	}
+	for _, uid := range config.TargetUIDs {
+		h.targetUIDs[uid] = true
+	}
+	for _, comm := range config.TargetComms {
+		h.targetComms[comm] = true
+	}
+	for _, tid := range config.TargetTIDs {
+		h.targetTIDs[tid] = true
+	}
+	for _, comm := range config.AllowedComms {
+		h.allowedComms[commKey(comm)] = true
+	}
+
+	for _, rule := range config.Rules {
+		if rule.Immediate {
+			h.immediate = append(h.immediate, rule.Pattern)
+		}
+	}
+	if config.PolicyMode == PolicyAllowlist {
+		h.allowlist = newAllowlist(expandPatterns(config.AllowedPatterns), config.CaseInsensitive)
+	}
+	h.immediateRules = newPatternMatcher(h.immediate, config.CaseInsensitive, config.GlobOnly)
+
+	if config.AuditLogPath != "" {
+		h.auditLog = NewAuditLogger(config.AuditLogPath, config.AuditMaxBytes, config.AuditSync)
+	}
+
+	if config.OTLPEndpoint != "" {
+		h.exporter = NewOTLPExporter(config.OTLPEndpoint)
+	}
+	if config.BlocklistFile != "" {
+		interval := config.BlocklistInterval
+		if interval == 0 {
+			interval = defaultBlocklistInterval
+		}
+		h.blocklist = NewFileBlocklistSource(config.BlocklistFile, interval)
+	}
+
+	if config.Learn {
+		h.learner = newLearner()
 	}
+
+	return h
 }
 
 // Run starts processing events from the ring buffer
 func (h *EventHandler) Run(ctx context.Context) error {
-	fmt.Printf("Disallowed files: %v\n", h.config.DisallowedPatterns)
+	// A pattern that can never match would leave files silently unprotected
+	if h.configErr != nil {
+		return h.configErr
+	}
+
+	if h.allowlist != nil {
+		fmt.Printf("Allowed files (allowlist mode, everything else is a violation): %v\n", h.config.AllowedPatterns)
+	} else {
+		fmt.Printf("Disallowed files: %v\n", h.config.DisallowedPatterns)
+	}
 	fmt.Printf("Threshold: %d file(s)\n", h.config.Threshold)
+	if len(h.immediate) > 0 {
+		fmt.Printf("Immediate-block files: %v\n", h.immediate)
+	}
+	if h.learner != nil {
+		fmt.Println("Learning mode: recording accessed files, nothing is blocked")
+	}
+	for i, policy := range h.config.Policies {
+		fmt.Printf("Policy %s: files=%v threshold=%d\n", h.policies[i].name, policy.Patterns, policy.Threshold)
+	}
 	if h.config.TargetPID != 0 {
 		fmt.Printf("Target PID: %d\n", h.config.TargetPID)
 	}
+	if len(h.config.BlockedFiles) > 0 {
+		fmt.Printf("Blocked files: %v\n", h.config.BlockedFiles)
+	}
+	if len(h.watchedMounts) > 0 {
+		fmt.Printf("Watched mounts: %v\n", h.watchedMounts)
+	}
+	if h.config.EnforcementAction == ActionQuarantine {
+		fmt.Printf("Enforcement action: quarantine (blocked PIDs are moved into cgroup %s)\n", h.config.QuarantineCgroup)
+	}
+	switch h.enforcementPoint() {
+	case EnforceRead:
+		fmt.Println("Enforcement point: read (blocked PIDs can open files but not read them)")
+	case EnforceOpenAndRead:
+		fmt.Println("Enforcement point: open and read (files opened before a block can no longer be read)")
+	}
+	if active, backend := h.provider.EnforcementActive(); !active && h.learner == nil {
+		fmt.Printf("WARNING: enforcement is not active (%s), blocked PIDs are only recorded\n", backend)
+	}
+	if h.config.RapidOpenThreshold > 0 {
+		fmt.Printf("Rapid-open limit: %d files within %v\n", h.config.RapidOpenThreshold, h.config.RapidOpenWindow)
+	}
+	if h.config.MaxBlocksBeforeEscalate > 0 {
+		fmt.Printf("Escalating after more than %d blocks within %v\n", h.config.MaxBlocksBeforeEscalate, h.config.EscalationWindow)
+	}
+	if h.config.SampleRate > 1 {
+		fmt.Printf("Sampling 1 in %d events: most accesses go unseen, so violation counts are estimates and blocking is unreliable\n", h.config.SampleRate)
+	}
 	fmt.Println("Press Ctrl+C to stop")
 	fmt.Println()
 
+	h.mu.Lock()
+	h.startedAt = h.clock.Now()
+	h.mu.Unlock()
+
+	// Nothing is blocked while learning
+	if h.learner == nil {
+		for _, path := range h.config.BlockedFiles {
+			if err := h.BlockFile(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if uids := h.kernelTargetUIDs(); len(uids) > 0 {
+		if err := h.provider.SetTargetUIDs(uids); err != nil {
+			return fmt.Errorf("failed to filter events by UID in the kernel: %w", err)
+		}
+	}
+
+	// Known-good commands are best dropped before they cross the ring
+	// buffer; monitorsPID drops them otherwise
+	if len(h.config.AllowedComms) > 0 {
+		if allowlister, ok := h.provider.(commAllowlister); !ok {
+			log.Printf("provider cannot drop allowed commands in the kernel, dropping them in userspace")
+		} else if err := allowlister.SetAllowedComms(h.config.AllowedComms); err != nil {
+			return fmt.Errorf("failed to drop allowed commands in the kernel: %w", err)
+		}
+	}
+
+	// Sampling in the kernel saves the cost of sending events to userspace
+	if h.config.SampleRate > 1 {
+		if s, ok := h.provider.(eventSampler); !ok {
+			log.Printf("provider cannot sample events in the kernel, sampling them in userspace")
+			h.sampler = &sampler{rate: h.config.SampleRate}
+		} else if err := s.SetSampleRate(h.config.SampleRate); err != nil {
+			return fmt.Errorf("failed to sample events in the kernel: %w", err)
+		}
+	}
+
+	if point := h.enforcementPoint(); point != EnforceOpen {
+		setter, ok := h.provider.(enforcementSetter)
+		if !ok {
+			return fmt.Errorf("provider cannot deny reads, use the %q enforcement point", EnforceOpen)
+		}
+		if err := setter.SetEnforcementPoint(point); err != nil {
+			return fmt.Errorf("failed to deny blocked PIDs at read: %w", err)
+		}
+	}
+
+	// Attempts that never got an fd accessed no data
+	if h.config.SuccessfulOpensOnly {
+		if filter, ok := h.provider.(openOutcomeFilter); !ok {
+			log.Printf("provider cannot report only successful opens, counting every open attempt")
+		} else if err := filter.SetCountFailedOpens(false); err != nil {
+			return fmt.Errorf("failed to report only successful opens: %w", err)
+		}
+	}
+
+	// Lift blocks on the way out so they don't outlive the session. Only the
+	// provider's list is cleared: the violations and blocks stay recorded for
+	// the shutdown report and state file written after Run returns
+	if h.config.UnblockOnExit {
+		defer func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if _, err := h.unblockAll(); err != nil {
+				log.Printf("unblocking PIDs on exit: %v", err)
+			}
+		}()
+	}
+
+	// Let pending block verifications report before blocks are lifted
+	defer h.verifies.Wait()
+
+	// Tee the events read into a recording verify can replay
+	var recorder *eventRecorder
+	if h.config.RecordPath != "" {
+		rec, err := createEventRecorder(h.config.RecordPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := rec.Close(); err != nil {
+				log.Printf("closing recording: %v", err)
+			}
+		}()
+		recorder = rec
+	}
+
+	if h.auditLog != nil {
+		defer func() {
+			if err := h.auditLog.Close(); err != nil {
+				log.Printf("closing audit log: %v", err)
+			}
+		}()
+	}
+
+	// Export to the OTLP collector until Run returns, flushing on the way out
+	if h.exporter != nil {
+		exportCtx, stopExport := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.exporter.Run(exportCtx)
+		}()
+		defer wg.Wait()
+		defer stopExport()
+	}
+
+	// Block what the external blocklist sends until Run returns
+	if h.blocklist != nil {
+		blocklistCtx, stopBlocklist := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.watchBlocklist(blocklistCtx)
+		}()
+		defer wg.Wait()
+		defer stopBlocklist()
+	}
+
+	// Log a periodic stats summary until Run returns
+	if h.config.StatsInterval > 0 {
+		statsCtx, stopStats := context.WithCancel(ctx)
+		start := h.clock.Now()
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.logStats(statsCtx, h.config.StatsInterval, start)
+		}()
+		defer wg.Wait()
+		defer stopStats()
+	}
+
+	// Remind that blocked PIDs keep trying until Run returns
+	if h.config.StillBlockedInterval > 0 {
+		stillBlockedCtx, stopStillBlocked := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.logStillBlocked(stillBlockedCtx, h.config.StillBlockedInterval)
+		}()
+		defer wg.Wait()
+		defer stopStillBlocked()
+	}
+
+	// Reopen the ring buffer reader if events stop arriving
+	if h.config.WatchdogTimeout > 0 {
+		h.markRead()
+		watchdogCtx, stopWatchdog := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.runWatchdog(watchdogCtx)
+		}()
+		defer wg.Wait()
+		defer stopWatchdog()
+	}
+
+	// Stop waiting for events once the context's deadline passes, so a
+	// bounded run ends on time even when no events arrive
+	if deadline, ok := ctx.Deadline(); ok {
+		if d, ok := h.provider.(readDeadliner); ok {
+			d.SetReadDeadline(deadline)
+		}
+	}
+
 	// Process events in a loop
+	var readErrors uint32
 	for {
 		select {
 		case <-ctx.Done():
+			// Running out of time is a planned stop, not an error
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil
+			}
 			return ctx.Err()
+		case err := <-h.failures:
+			return err
 		default:
-			event, err := h.provider.ReadEvent()
+			events, err := h.readEvents()
+			if len(events) > 0 {
+				readErrors = 0
+				if h.config.WatchdogTimeout > 0 {
+					h.markRead()
+				}
+				// A failed recording must not stop enforcement
+				if recorder != nil {
+					if err := recorder.Record(events); err != nil {
+						log.Printf("recording events, stopped recording: %v", err)
+						recorder = nil
+					}
+				}
+				if err := h.processEvents(events); err != nil {
+					return err
+				}
+			}
 			if err != nil {
-				if errors.Is(err, context.Canceled) {
+				switch {
+				case errors.Is(err, context.Canceled):
 					return nil
+				case errors.Is(err, ringbuf.ErrClosed):
+					// Closed under a running handler, nothing is monitored anymore
+					if h.config.FailClosed && ctx.Err() == nil {
+						return fmt.Errorf("failing closed: %w", err)
+					}
+					return nil
+				case errors.Is(err, ErrEventsDisabled):
+					// Blocks are enforced by the kernel alone until stopped
+					fmt.Println("Event collection is disabled, enforcing the blocked PID list only")
+					<-ctx.Done()
+				case isTransientReadError(err):
+					h.sleep(readErrorBackoff)
+				default:
+					if h.config.FailClosed {
+						return fmt.Errorf("failing closed on read error: %w", err)
+					}
+					readErrors++
+					log.Printf("reading event: %v", err)
+					if h.config.MaxReadErrors != 0 && readErrors >= h.config.MaxReadErrors {
+						return fmt.Errorf("giving up after %d consecutive read errors: %w", readErrors, err)
+					}
 				}
-				log.Printf("reading event: %v", err)
-				continue
 			}
+		}
+	}
+}
 
-			if err := h.processEvent(event); err != nil {
-				log.Printf("processing event: %v", err)
-			}
+// processingError returns the error Run stops with for an error processing
+// an event, or logs it and returns nil if Run carries on
+func (h *EventHandler) processingError(err error) error {
+	if errors.Is(err, ErrEscalated) {
+		return err
+	}
+	if h.config.FailClosed {
+		return fmt.Errorf("failing closed on processing error: %w", err)
+	}
+	log.Printf("processing event: %v", err)
+	return nil
+}
+
+// enforcementPoint returns where the provider should deny blocked PIDs,
+// combining EnforcementPoint and EnforceExistingFDs. Enforcing at read alone
+// already covers existing fds.
+func (h *EventHandler) enforcementPoint() string {
+	if h.config.EnforcementPoint == EnforceRead {
+		return EnforceRead
+	}
+	if h.config.EnforceExistingFDs {
+		return EnforceOpenAndRead
+	}
+	return EnforceOpen
+}
+
+// markRead records that the read loop is alive
+func (h *EventHandler) markRead() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.watchdog.LastRead = h.clock.Now()
+	h.watchdog.Stale = false
+}
+
+// runWatchdog checks for a stale read loop every half WatchdogTimeout until
+// the context is cancelled
+func (h *EventHandler) runWatchdog(ctx context.Context) {
+	ticks, stop := h.newTicker(h.config.WatchdogTimeout / 2)
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+			h.checkWatchdog()
 		}
 	}
 }
 
-// processEvent handles a single event
-func (h *EventHandler) processEvent(event *Event) error {
-	// Filter by PID if specified
-	if h.config.TargetPID != 0 && event.Pid != h.config.TargetPID {
-		return nil
+// checkWatchdog reopens the provider's ring buffer reader if nothing was read
+// for WatchdogTimeout. Opens happen constantly on a running system, so a
+// silent ring buffer means the reader is stuck rather than the system idle.
+func (h *EventHandler) checkWatchdog() {
+	if !h.watchdogExpired() {
+		return
 	}
 
-	// Extract null-terminated strings
-	comm := string(bytes.TrimRight(event.Comm[:], "\x00"))
-	filename := string(bytes.TrimRight(event.Filename[:], "\x00"))
+	reopener, ok := h.provider.(readerReopener)
+	if !ok {
+		log.Printf("watchdog: no events read for %v and the provider cannot reopen its reader", h.config.WatchdogTimeout)
+		return
+	}
+	log.Printf("watchdog: no events read for %v, reopening the ring buffer reader", h.config.WatchdogTimeout)
+	err := reopener.ReopenReader()
 
-	// Check if the file matches any disallowed pattern
-	if !matchesPattern(filename, h.config.DisallowedPatterns) {
-		return nil
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		h.watchdog.ReopenFailures++
+		log.Printf("watchdog: reopening the ring buffer reader: %v", err)
+		return
 	}
+	h.watchdog.Reopens++
+}
 
-	// Process violation for this PID
-	h.violationCounts[event.Pid]++
-	pidViolations := h.violationCounts[event.Pid]
+// watchdogExpired reports whether WatchdogTimeout passed since the last read
+// and since the last reopen attempt, marking the read loop stale if so
+func (h *EventHandler) watchdogExpired() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.clock.Now()
+	since := h.watchdog.LastRead
+	if h.lastReopen.After(since) {
+		since = h.lastReopen
+	}
+	if now.Sub(since) < h.config.WatchdogTimeout {
+		return false
+	}
+	h.watchdog.Stale = true
+	h.lastReopen = now
+	return true
+}
 
-	fmt.Printf("[VIOLATION %d/%d] PID %d (%s) opened disallowed file: %s\n",
-		pidViolations, h.config.Threshold, event.Pid, comm, filename)
+// LearnReport returns the files each command accessed in learning mode and
+// the allowed patterns suggested to cover them. It is empty unless Learn is
+// set.
+func (h *EventHandler) LearnReport() LearnReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	// Check if this PID has reached the threshold and is not already blocked
-	if pidViolations >= h.config.Threshold && !h.blockedPIDs[event.Pid] {
-		h.blockedPIDs[event.Pid] = true
-		if err := h.provider.BlockPID(event.Pid); err != nil {
-			return fmt.Errorf("failed to block PID: %w", err)
-		}
-		fmt.Printf("\n*** PID %d is now BLOCKED from opening any further files! ***\n\n", event.Pid)
+	if h.learner == nil {
+		return LearnReport{Processes: []LearnedProcess{}}
 	}
+	return h.learner.report()
+}
 
-	return nil
+// Watchdog returns a snapshot of the read loop watchdog's state
+func (h *EventHandler) Watchdog() WatchdogState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.watchdog
 }
 
-// GetViolationCount returns the total violation count across all PIDs
-func (h *EventHandler) GetViolationCount() uint32 {
-	var total uint32
-	for _, count := range h.violationCounts {
-		total += count
+// describeProcess names the process behind an event for output, e.g.
+// "PID 100 (nginx) TID 105": the thread is shown when it is not the main one
+func (h *EventHandler) describeProcess(event *Event, comm string) string {
+	s := fmt.Sprintf("PID %d (%s)", event.Pid, comm)
+	if event.Tid != 0 && event.Tid != event.Pid {
+		s += fmt.Sprintf(" TID %d", event.Tid)
 	}
-	return total
+	return s + h.containerSuffix(event)
 }
 
-// GetViolationCountForPID returns the violation count for a specific PID
-func (h *EventHandler) GetViolationCountForPID(pid uint32) uint32 {
-	return h.violationCounts[pid]
+// violationAction describes what a process did to violate, for output
+func violationAction(event *Event) string {
+	if event.Type == EventRename {
+		return "renamed a file into disallowed path"
+	}
+	return "opened disallowed file"
 }
 
-// IsBlocked returns whether any PID has been blocked
-func (h *EventHandler) IsBlocked() bool {
-	return len(h.blockedPIDs) > 0
+// readErrorBackoff is how long Run waits after a transient read error
+const readErrorBackoff = 100 * time.Millisecond
+
+// isTransientReadError reports whether a read error is expected to clear up
+// by itself, such as an interrupted or timed out read
+func isTransientReadError(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, os.ErrDeadlineExceeded)
 }
 
-// IsPIDBlocked returns whether a specific PID is blocked
-func (h *EventHandler) IsPIDBlocked(pid uint32) bool {
-	return h.blockedPIDs[pid]
+// processEvent handles a single event and reports what happened to it
+func (h *EventHandler) processEvent(event *Event) (ProcessResult, error) {
+	h.prepareEvent(event)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.processEventLocked(event)
 }
 
-// GetBlockedPIDs returns a slice of all blocked PIDs
-func (h *EventHandler) GetBlockedPIDs() []uint32 {
-	pids := make([]uint32, 0, len(h.blockedPIDs))
-	for pid := range h.blockedPIDs {
-		pids = append(pids, pid)
+// prepareEvent does the procfs lookups an event may need before h.mu is
+// taken, so slow reads never hold up the handling of other events
+func (h *EventHandler) prepareEvent(event *Event) {
+	h.resolveRenamePath(event)
+	h.resolveFileInode(event)
+}
+
+// resolveFileInode sets Dev and Ino of an open or rename event that may be a
+// violation to the file it names, for DedupByInode: the file the open
+// returned as Fd if it is still open, otherwise the file at the path as the
+// process sees it. They stay 0 if the file cannot be stat'ed, e.g. because
+// it was removed.
+func (h *EventHandler) resolveFileInode(event *Event) {
+	if !h.config.DedupByInode || (event.Type != EventOpen && event.Type != EventRename) {
+		return
+	}
+	filename := string(bytes.TrimRight(event.Filename[:], "\x00"))
+	if !validFilename(filename) || !h.mayMatch(event, cleanFilename(filename)) {
+		return
+	}
+
+	if event.Type == EventOpen && event.Fd != 0 {
+		if id, err := h.proc.fdInode(event.Pid, event.Fd); err == nil {
+			event.Dev, event.Ino = id.Dev, id.Ino
+			return
+		}
+	}
+	if id, err := h.proc.fileInode(event.Pid, filename); err == nil {
+		event.Dev, event.Ino = id.Dev, id.Ino
 	}
-	return pids
 }
 
-// matchesPattern checks if a filename matches any of the disallowed patterns
-func matchesPattern(filename string, patterns []string) bool {
-	for _, pattern := range patterns {
-		// Support both exact match and wildcard match
-		matched, _ := filepath.Match(pattern, filename)
-		if matched || strings.Contains(filename, pattern) {
-			return true
+// mayMatch reports whether filename matches an immediate rule or the patterns
+// of the event's policy. Unlike matchFile it credits no pattern, so it can
+// run without h.mu held.
+func (h *EventHandler) mayMatch(event *Event, filename string) bool {
+	if h.immediateRules.Matches(filename) {
+		return true
+	}
+	if h.allowlist != nil {
+		return !h.allowlist.allows(filename)
+	}
+	return h.policyFor(event).matcher.Matches(filename)
+}
+
+// resolveRenamePath makes the relative new path of a rename event absolute,
+// joining it to the directory the process resolved it against, so it is
+// matched like an opened path. The path is left as it is if the directory
+// cannot be read, e.g. because the process exited or moved it since. It reads
+// procfs, so it is called before h.mu is taken.
+func (h *EventHandler) resolveRenamePath(event *Event) {
+	if event.Type != EventRename {
+		return
+	}
+	filename := string(bytes.TrimRight(event.Filename[:], "\x00"))
+	if filename == "" || filepath.IsAbs(filename) {
+		return
+	}
+	dir, err := h.proc.dirPath(event.Pid, event.Fd)
+	if err != nil {
+		return
+	}
+	resolved := filepath.Join(dir, filename)
+	if len(resolved) >= len(event.Filename) {
+		return
+	}
+	event.Filename = [len(event.Filename)]byte{}
+	copy(event.Filename[:], resolved)
+}
+
+// processEventLocked is processEvent with h.mu held
+func (h *EventHandler) processEventLocked(event *Event) (ProcessResult, error) {
+	var result ProcessResult
+	h.eventsRead++
+
+	// Checked before denials: a new process on a reused PID that is still
+	// blocked produces nothing but denials, as its failed opens are not
+	// reported by default
+	if h.config.TrackPIDReuse {
+		lifted, err := h.checkPIDReuse(event.Pid)
+		if err != nil {
+			return result, err
+		}
+		// The denial was the old process's block, which is lifted now
+		if lifted && event.Type == EventDenied {
+			return result, nil
 		}
 	}
-	return false
+
+	// Denials confirm a block and are never sampled away
+	if event.Type == EventDenied {
+		h.recordDenied(event)
+		return result, nil
+	}
+
+	if h.sampler != nil && !h.sampler.keep() {
+		return result, nil
+	}
+	h.updateEventRate()
+	h.recordLatency(event)
+
+	// Commands on the external blocklist are blocked on sight
+	if blocked, err := h.processBlocklistedComm(event); blocked || err != nil {
+		result.Blocked = blocked
+		return result, err
+	}
+
+	// Filter by PID if specified
+	if !h.monitorsPID(event) {
+		return result, nil
+	}
+
+	if event.Type == EventRead {
+		return h.processRead(event)
+	}
+
+	// Extract null-terminated strings
+	comm := h.commOf(event)
+	filename := string(bytes.TrimRight(event.Filename[:], "\x00"))
+
+	// Skip events whose filename could not be read or is not valid UTF-8,
+	// otherwise garbage bytes may spuriously substring-match a pattern
+	if !validFilename(filename) {
+		h.malformedEvents++
+		return result, nil
+	}
+
+	// Listing a directory is not the same as reading a sensitive file
+	if h.config.IgnoreDirectoryOpens && event.IsDirectoryOpen() {
+		return result, nil
+	}
+	// e.g. O_PATH opens, which cannot read the file. Renames carry no open
+	// flags.
+	if event.Type == EventOpen && !h.ignoredFlags.empty() && h.ignoredFlags.matches(event.Flags) {
+		return result, nil
+	}
+	if !h.onWatchedMount(filename) {
+		return result, nil
+	}
+	h.eventsProcessed++
+
+	// Bursts of opens are blocked whichever files they are
+	blocked, err := h.processRapidOpen(event, comm, filename)
+	if err != nil {
+		return result, err
+	}
+	result.Blocked = blocked
+
+	// Every open counts towards the grace period, matching or not
+	var opens uint32
+	if h.config.GracePeriodOpens > 0 && event.Type == EventOpen {
+		h.openCounts[event.Pid]++
+		opens = h.openCounts[event.Pid]
+	}
+
+	// Check if the file matches any disallowed pattern of the process's
+	// policy or any immediate rule. Patterns are matched against the
+	// cleaned filename, e.g. /etc/passwd for /etc/./passwd, while output
+	// keeps the filename as the process passed it.
+	policy := h.policyFor(event)
+	cleaned := cleanFilename(filename)
+	matched, pattern, kind := h.matchFile(policy, cleaned)
+	immediate, immediatePattern, immediateKind := h.immediateRules.MatchRule(cleaned)
+	if !matched && !immediate {
+		return result, nil
+	}
+	if pattern == "" {
+		pattern, kind = immediatePattern, immediateKind
+	}
+	result.Matched = true
+	result.MatchedPattern = pattern
+	result.MatchKind = kind
+
+	// Never let the fence count (and block) itself
+	if event.Pid == h.selfPID && (!h.config.MonitorSelf || h.isRoutineSelfOpen(filename)) {
+		return result, nil
+	}
+
+	// Children of trusted parents (e.g. admin sessions) are not fenced
+	if h.hasTrustedParent(event) {
+		return result, nil
+	}
+
+	// While learning, accesses are only recorded
+	if h.learner != nil {
+		h.learner.record(comm, filename)
+		return result, nil
+	}
+
+	// A process's first opens are free, as it reads its configuration
+	if opens != 0 && opens <= h.config.GracePeriodOpens && !immediate {
+		return result, nil
+	}
+
+	// Another path to a file already counted, e.g. through a symlink, is
+	// not a new violation
+	if h.config.DedupByInode && h.inodeCounted(event) {
+		return result, nil
+	}
+
+	// Process violation for this PID
+	if h.violationCounts[event.Pid] == 0 {
+		h.trackStart(event.Pid)
+	}
+	h.violationCounts[event.Pid]++
+	pidViolations := h.violationCounts[event.Pid]
+	h.recordViolationCount(event.Pid)
+	policy.stats.Violations++
+	h.commLabels.add(comm)
+	h.uidLabels.add(strconv.FormatUint(uint64(event.Uid), 10))
+	h.recordAccessedFile(event.Pid, filename)
+	result.Counted = true
+
+	// In defensive mode detailed logging is paused and any violation blocks
+	pidThreshold := h.thresholdFor(event, comm, policy)
+	threshold := pidThreshold
+	if immediate {
+		threshold = 1
+	}
+	if h.defensiveMode {
+		threshold = 1
+	} else {
+		fmt.Printf("%s[VIOLATION %d/%d] %s %s: %s\n", h.relativeTime(), pidViolations, pidThreshold,
+			h.describeProcess(event, comm), violationAction(event), h.displayPath(filename))
+	}
+	h.audit("violation", event, comm, filename, pidViolations, pidThreshold, result)
+
+	// Warn once when a PID gets close to, but has not yet reached, the threshold
+	if h.config.WarnThreshold != 0 && pidViolations >= h.config.WarnThreshold &&
+		pidViolations < threshold && !h.warnedPIDs[event.Pid] {
+		h.warnedPIDs[event.Pid] = true
+		fmt.Printf("%s[WARNING] PID %d (%s) approaching block: %d/%d violations\n", h.relativeTime(),
+			event.Pid, comm, pidViolations, threshold)
+	}
+
+	// Report-only PIDs are canaries: note when they would have been blocked
+	if h.reportOnlyPIDs[event.Pid] {
+		if pidViolations == threshold {
+			fmt.Printf("%s[REPORT-ONLY] PID %d (%s) reached the block threshold and is not blocked\n",
+				h.relativeTime(), event.Pid, comm)
+		}
+		return result, nil
+	}
+
+	// Check if this PID has reached the threshold and is not already blocked
+	if pidViolations >= threshold && !h.isBlocked(event.Pid) {
+		reason := BlockReasonThreshold
+		if immediate && pidViolations < pidThreshold {
+			reason = BlockReasonImmediate
+		}
+		h.blockedPIDs[event.Pid] = blockRecord{comm: comm}
+		if err := h.blockPID(event.Pid, reason, pattern); err != nil {
+			return result, fmt.Errorf("failed to block PID: %w", err)
+		}
+		result.Blocked = true
+		policy.stats.Blocks++
+		h.printBlocked(event.Pid)
+		h.audit("block", event, comm, filename, pidViolations, pidThreshold, result)
+		if err := h.recordBlock(event, comm, filename); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// processRead accumulates the bytes a PID has read from each disallowed
+// file and blocks it once more than ByteThreshold bytes were read from any
+// one of them. The real provider sums reads in the kernel and reports only
+// the one taking a file past ByteThreshold, with the total so far. The file
+// is resolved from the read's fd through procfs and checked against the
+// inode the kernel saw.
+func (h *EventHandler) processRead(event *Event) (ProcessResult, error) {
+	var result ProcessResult
+	if h.config.ByteThreshold == 0 || event.Pid == h.selfPID {
+		return result, nil
+	}
+
+	filename, err := h.proc.fdPath(event.Pid, event.Fd)
+	if err != nil {
+		// The process exited or closed the fd before we got to it
+		return result, nil
+	}
+	// The fd was closed and reused for another file since the read
+	if event.Ino != 0 {
+		if id, err := h.proc.fdInode(event.Pid, event.Fd); err != nil || id != (FileID{Dev: event.Dev, Ino: event.Ino}) {
+			return result, nil
+		}
+	}
+	// The fd's path is the file itself, with symlinks resolved
+	if !h.onWatchedMount(filename) {
+		return result, nil
+	}
+
+	policy := h.policyFor(event)
+	matched, pattern, kind := h.matchFile(policy, filename)
+	if !matched {
+		return result, nil
+	}
+	result.Matched = true
+	result.MatchedPattern = pattern
+	result.MatchKind = kind
+
+	// Nothing is blocked while learning, and the file was recorded when opened
+	if h.hasTrustedParent(event) || h.learner != nil {
+		return result, nil
+	}
+
+	files := h.bytesRead[event.Pid]
+	if files == nil {
+		files = make(map[string]uint64)
+		h.bytesRead[event.Pid] = files
+	}
+	files[filename] += event.Bytes
+	h.recordAccessedFile(event.Pid, filename)
+	result.Counted = true
+
+	if files[filename] > h.config.ByteThreshold && !h.isBlocked(event.Pid) && !h.reportOnlyPIDs[event.Pid] {
+		comm := h.commOf(event)
+		h.blockedPIDs[event.Pid] = blockRecord{comm: comm}
+		if err := h.blockPID(event.Pid, BlockReasonBytes, ""); err != nil {
+			return result, fmt.Errorf("failed to block PID: %w", err)
+		}
+		result.Blocked = true
+		fmt.Printf("%s[EXFILTRATION] PID %d (%s) read %d bytes from disallowed file: %s\n", h.relativeTime(),
+			event.Pid, comm, files[filename], h.displayPath(filename))
+		h.printBlocked(event.Pid)
+		policy.stats.Blocks++
+		h.audit("block", event, comm, filename, h.violationCounts[event.Pid], policy.threshold, result)
+		if err := h.recordBlock(event, comm, filename); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// BlockFile denies every open of the file at path by any process. The file is
+// blocked by inode, so other hardlinks to it and later renames are covered.
+func (h *EventHandler) BlockFile(path string) error {
+	id, err := resolveInode(path)
+	if err != nil {
+		return fmt.Errorf("block file: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Another name of a file that is already blocked
+	if _, ok := h.blockedInodes[id]; ok {
+		return nil
+	}
+
+	if err := h.provider.BlockInode(id.Dev, id.Ino); err != nil {
+		return fmt.Errorf("block file %s: %w", path, err)
+	}
+	h.blockedInodes[id] = path
+	return nil
+}
+
+// maxAccessedFiles bounds the distinct files remembered per PID, so a process
+// sweeping the filesystem cannot grow the set without limit
+const maxAccessedFiles = 64
+
+// recordViolationCount copies the violation count of pid to the provider,
+// where it keeps one, so the status command sees it. Failures are logged
+// rather than returned: the count is informational.
+func (h *EventHandler) recordViolationCount(pid uint32) {
+	recorder, ok := h.provider.(violationRecorder)
+	if !ok {
+		return
+	}
+	if err := recorder.SetViolationCount(pid, h.violationCounts[pid]); err != nil {
+		log.Printf("recording violation count of PID %d: %v", pid, err)
+	}
+}
+
+// recordAccessedFile remembers a disallowed file a PID accessed
+func (h *EventHandler) recordAccessedFile(pid uint32, filename string) {
+	files := h.accessedFiles[pid]
+	if files == nil {
+		files = make(map[string]struct{})
+		h.accessedFiles[pid] = files
+	}
+	if len(files) < maxAccessedFiles {
+		files[filename] = struct{}{}
+	}
+}
+
+// inodeCounted reports whether the file of the event, as resolved by
+// resolveFileInode, was already counted as a violation by its PID, and
+// otherwise remembers it. Files that could not be resolved are never treated
+// as counted.
+func (h *EventHandler) inodeCounted(event *Event) bool {
+	if event.Ino == 0 {
+		return false
+	}
+	id := FileID{Dev: event.Dev, Ino: event.Ino}
+
+	files := h.countedInodes[event.Pid]
+	if files == nil {
+		files = make(map[FileID]struct{})
+		h.countedInodes[event.Pid] = files
+	}
+	if _, ok := files[id]; ok {
+		return true
+	}
+	if len(files) < maxAccessedFiles {
+		files[id] = struct{}{}
+	}
+	return false
+}
+
+// sortedAccessedFiles returns the distinct disallowed files a PID accessed
+func (h *EventHandler) sortedAccessedFiles(pid uint32) []string {
+	files := make([]string, 0, len(h.accessedFiles[pid]))
+	for filename := range h.accessedFiles[pid] {
+		files = append(files, filename)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// printBlocked prints the alert for a newly blocked PID. A PID that could
+// not be quarantined was denied instead and gets the block alert.
+func (h *EventHandler) printBlocked(pid uint32) {
+	if h.blockedPIDs[pid].verifying {
+		return // confirmBlock prints it once verified
+	}
+	if h.blockedPIDs[pid].quarantined {
+		fmt.Printf("\n%s*** PID %d is now QUARANTINED in %s! ***\n", h.relativeTime(), pid, h.config.QuarantineCgroup)
+	} else {
+		fmt.Printf("\n%s*** PID %d is now BLOCKED from opening any further files! ***\n", h.relativeTime(), pid)
+	}
+	files := h.sortedAccessedFiles(pid)
+	for i, filename := range files {
+		files[i] = h.displayPath(filename)
+	}
+	fmt.Printf("Files accessed: %s\n\n", strings.Join(files, ", "))
+}
+
+// relativeTime returns the prefix for an alert line when RelativeTime is
+// set: the time since Run started, e.g. "+1.2s ". It is empty otherwise, or
+// before Run.
+func (h *EventHandler) relativeTime() string {
+	if !h.config.RelativeTime || h.startedAt.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("+%.1fs ", h.clock.Now().Sub(h.startedAt).Seconds())
+}
+
+// displayPath shortens a filename for console output to MaxPathDisplay
+func (h *EventHandler) displayPath(filename string) string {
+	return h.quotePath(truncatePath(filename, h.config.MaxPathDisplay))
+}
+
+// quotePath quotes a filename for text output if QuotePaths is set. A
+// filename may contain newlines or terminal escape sequences, which would
+// otherwise forge log lines or garble the terminal; printable Unicode is
+// kept as is.
+func (h *EventHandler) quotePath(filename string) string {
+	if !h.config.QuotePaths {
+		return filename
+	}
+	return strconv.Quote(filename)
+}
+
+// commLen is the longest comm the kernel reports, TASK_COMM_LEN without the
+// terminating null
+const commLen = 15
+
+// commOf returns the command name of the process behind an event. With
+// ResolveFullComm, a comm the kernel truncated is replaced by the basename of
+// argv[0] if that starts with it, e.g. systemd-journal by systemd-journald.
+func (h *EventHandler) commOf(event *Event) string {
+	comm := string(bytes.TrimRight(event.Comm[:], "\x00"))
+	if !h.config.ResolveFullComm || len(comm) != commLen {
+		return comm
+	}
+
+	// The cached name is only valid while the PID keeps the same comm
+	if cached, ok := h.fullComms[event.Pid]; ok && cached.comm == comm {
+		return cached.full
+	}
+
+	full := comm
+	if name, err := h.proc.cmdlineName(event.Pid); err == nil && strings.HasPrefix(name, comm) {
+		full = name
+	}
+	h.fullComms[event.Pid] = fullComm{comm: comm, full: full}
+	return full
+}
+
+// recordLatency records how long ago the kernel emitted an event, which
+// grows when the handler falls behind the ring buffer
+func (h *EventHandler) recordLatency(event *Event) {
+	if event.Timestamp == 0 || h.bootTime.IsZero() {
+		return
+	}
+
+	eventTime := h.bootTime.Add(time.Duration(event.Timestamp))
+	h.latency.add(max(h.clock.Now().Sub(eventTime), 0))
+}
+
+// monitorsPID applies the PID filters. The PIDMin/PIDMax range, ExcludePIDs
+// and AllowedComms always apply, winning over everything else. The target
+// filters (TargetPID, TargetUIDs, TargetComms, TargetContainers and
+// TargetTIDs) are combined as FilterMode says; when none is set every
+// process is a target.
+func (h *EventHandler) monitorsPID(event *Event) bool {
+	if h.excludedPIDs[event.Pid] || h.allowedComms[event.Comm] {
+		return false
+	}
+	if h.config.PIDMin != 0 && event.Pid < h.config.PIDMin {
+		return false
+	}
+	if h.config.PIDMax != 0 && event.Pid > h.config.PIDMax {
+		return false
+	}
+	return h.matchesTargets(event)
+}
+
+// kernelTargetUIDs returns the UIDs the kernel may drop other events by,
+// saving the work of sending them to userspace. That is only safe when every
+// monitored event must come from a target UID: with FilterAny another target
+// filter can admit events from any UID. Userspace filtering still applies.
+func (h *EventHandler) kernelTargetUIDs() []uint32 {
+	if h.config.FilterMode == FilterAny && (h.config.TargetPID != 0 || len(h.config.TargetComms) > 0 ||
+		len(h.config.TargetContainers) > 0 || len(h.config.TargetTIDs) > 0) {
+		return nil
+	}
+	return h.config.TargetUIDs
+}
+
+// matchesTargets applies the target filters according to FilterMode
+func (h *EventHandler) matchesTargets(event *Event) bool {
+	var active, matched int
+	if h.config.TargetPID != 0 {
+		active++
+		if h.matchesTargetPID(event) {
+			matched++
+		}
+	}
+	if len(h.targetUIDs) > 0 {
+		active++
+		if h.targetUIDs[event.Uid] {
+			matched++
+		}
+	}
+	if len(h.targetComms) > 0 {
+		active++
+		if h.targetComms[h.commOf(event)] {
+			matched++
+		}
+	}
+	if len(h.config.TargetContainers) > 0 {
+		active++
+		if h.matchesTargetContainer(event) {
+			matched++
+		}
+	}
+	if len(h.targetTIDs) > 0 {
+		active++
+		if h.targetTIDs[event.Tid] {
+			matched++
+		}
+	}
+
+	if active == 0 {
+		return true
+	}
+	if h.config.FilterMode == FilterAny {
+		return matched > 0
+	}
+	return matched == active
+}
+
+// matchesTargetPID reports whether an event comes from TargetPID. The PID is
+// compared against the namespace-local PID when PIDNamespace is set, and
+// against the host PID otherwise.
+func (h *EventHandler) matchesTargetPID(event *Event) bool {
+	if h.config.PIDNamespace == 0 {
+		return event.Pid == h.config.TargetPID
+	}
+	return event.PidNs == h.config.PIDNamespace && event.NsPid == h.config.TargetPID
+}
+
+// selfOpenPrefixes are paths the fence itself opens while running: BTF and
+// bpffs when loading programs, securityfs and procfs for lookups
+var selfOpenPrefixes = []string{"/sys/kernel/btf/", "/sys/fs/bpf/", "/sys/kernel/security/", "/proc/"}
+
+// isRoutineSelfOpen reports whether the fence routinely opens filename itself
+func (h *EventHandler) isRoutineSelfOpen(filename string) bool {
+	for _, prefix := range selfOpenPrefixes {
+		if strings.HasPrefix(filename, prefix) {
+			return true
+		}
+	}
+
+	auditPath := h.config.AuditLogPath
+	return auditPath != "" && (filename == auditPath || filename == auditPath+".1")
+}
+
+// hasTrustedParent reports whether the event's parent process runs one of
+// TrustedParentComms. The answer is cached per process and refreshed if it
+// is reparented or its PID is reused by a process with another start time.
+// A parent that has already exited is not trusted.
+func (h *EventHandler) hasTrustedParent(event *Event) bool {
+	if len(h.config.TrustedParentComms) == 0 || event.Ppid == 0 {
+		return false
+	}
+
+	cached, ok := h.parents[event.Pid]
+	if ok && cached.ppid == event.Ppid && cached.start == event.StartTime {
+		return cached.trusted
+	}
+
+	trusted := false
+	parentComm, err := h.proc.comm(event.Ppid)
+	if err == nil {
+		for _, comm := range h.config.TrustedParentComms {
+			if parentComm == comm {
+				trusted = true
+				break
+			}
+		}
+	}
+
+	if !ok && len(h.parents) >= maxCachedParents {
+		for pid := range h.parents {
+			delete(h.parents, pid) // an arbitrary one
+			break
+		}
+	}
+	h.parents[event.Pid] = parentInfo{ppid: event.Ppid, start: event.StartTime, trusted: trusted}
+	return trusted
+}
+
+// policyFor returns the first policy selecting the process behind an event,
+// or the default policy
+func (h *EventHandler) policyFor(event *Event) *activePolicy {
+	for _, policy := range h.policies {
+		if policy.selects(event) {
+			return policy
+		}
+	}
+	return h.defaultPolicy
+}
+
+// thresholdFor returns the violations after which the event's PID is
+// blocked: its override if it has one, then its command's threshold,
+// otherwise its policy's threshold
+func (h *EventHandler) thresholdFor(event *Event, comm string, policy *activePolicy) uint32 {
+	if threshold, ok := h.pidThresholds[event.Pid]; ok {
+		return threshold
+	}
+	if threshold := h.config.CommThresholds[comm]; threshold != 0 {
+		return threshold
+	}
+	// comm may be the full name from /proc; thresholds can also be keyed by
+	// the truncated one the kernel reports
+	if threshold := h.config.CommThresholds[string(bytes.TrimRight(event.Comm[:], "\x00"))]; threshold != 0 {
+		return threshold
+	}
+	return policy.threshold
+}
+
+// SetPIDThreshold overrides the threshold of pid from its next violation
+// on, e.g. to tighten it on a suspicious process. A threshold of 0 removes
+// the override.
+func (h *EventHandler) SetPIDThreshold(pid, threshold uint32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if threshold == 0 {
+		delete(h.pidThresholds, pid)
+		return
+	}
+	h.pidThresholds[pid] = threshold
+}
+
+// PolicyStats returns the violations and blocks attributed to each policy,
+// keyed by policy name, with the top-level patterns under "default"
+func (h *EventHandler) PolicyStats() map[string]PolicyStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.policyStats()
+}
+
+// policyStats is PolicyStats with h.mu held
+func (h *EventHandler) policyStats() map[string]PolicyStats {
+	stats := make(map[string]PolicyStats, len(h.policies)+1)
+	for _, policy := range h.policies {
+		stats[policy.name] = policy.stats
+	}
+	stats[h.defaultPolicy.name] = h.defaultPolicy.stats
+	return stats
+}
+
+// matchFile reports whether accessing filename is a violation under policy: in
+// denylist mode if it matches the policy's patterns, in allowlist mode if it
+// matches no allowed pattern. Policies only contribute their thresholds in
+// allowlist mode.
+func (h *EventHandler) matchFile(policy *activePolicy, filename string) (bool, string, string) {
+	if h.allowlist == nil {
+		return h.matchWith(policy.matcher, filename)
+	}
+	return !h.allowlist.allows(filename), "", ""
+}
+
+// matchWith runs a matcher, recording which pattern fired when the matcher
+// can report it. The pattern and kind are empty if the matcher cannot report
+// them.
+func (h *EventHandler) matchWith(matcher Matcher, filename string) (bool, string, string) {
+	reporter, ok := matcher.(PatternReporter)
+	if !ok {
+		return matcher.Matches(filename), "", ""
+	}
+
+	matched, pattern, kind := reporter.MatchRule(filename)
+	if matched {
+		h.patternHits[pattern]++
+	}
+	return matched, pattern, kind
+}
+
+// PatternHits returns how many events each pattern has matched. Only the
+// first matching pattern is credited for an event.
+func (h *EventHandler) PatternHits() map[string]uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	hits := make(map[string]uint64, len(h.patternHits))
+	for pattern, count := range h.patternHits {
+		hits[pattern] = count
+	}
+	return hits
+}
+
+// audit writes a record to the audit log and OTLP exporter if configured,
+// explaining it with the match in result. Failures are logged rather than
+// returned so they never prevent blocking.
+func (h *EventHandler) audit(recordType string, event *Event, comm, filename string, count, threshold uint32, result ProcessResult) {
+	if h.auditLog == nil && h.exporter == nil {
+		return
+	}
+
+	record := AuditRecord{
+		Time:      h.clock.Now(),
+		Type:      recordType,
+		PID:       event.Pid,
+		UID:       event.Uid,
+		TID:       event.Tid,
+		Comm:      comm,
+		Filename:  filename,
+		Count:     count,
+		Threshold: threshold,
+		Pattern:   result.MatchedPattern,
+		MatchKind: result.MatchKind,
+		Container: h.containerOf(event),
+	}
+	if event.Type == EventRename {
+		record.Event = "rename"
+	}
+	if recordType == "block" {
+		record.Files = h.sortedAccessedFiles(event.Pid)
+	}
+	if h.exporter != nil {
+		h.exporter.Export(record)
+	}
+	if h.auditLog == nil {
+		return
+	}
+	if err := h.auditLog.Write(record); err != nil {
+		log.Printf("writing audit log: %v", err)
+	}
+}
+
+// ReopenAuditLog reopens the audit log file, e.g. after logrotate moved it
+func (h *EventHandler) ReopenAuditLog() error {
+	if h.auditLog == nil {
+		return nil
+	}
+	return h.auditLog.Reopen()
+}
+
+// updateEventRate counts an event against the current one-second window and
+// enters or leaves defensive mode when the rate crosses MaxEventsPerSecond
+func (h *EventHandler) updateEventRate() {
+	if h.config.MaxEventsPerSecond == 0 {
+		return
+	}
+
+	now := h.clock.Now()
+	if now.Sub(h.rateWindowStart) >= time.Second {
+		// Leave defensive mode once a full window stays under the ceiling
+		if h.defensiveMode && h.rateWindowEvents <= h.config.MaxEventsPerSecond {
+			h.defensiveMode = false
+			log.Printf("event rate back under %d/s, leaving defensive mode", h.config.MaxEventsPerSecond)
+		}
+		h.rateWindowStart = now
+		h.rateWindowEvents = 0
+	}
+
+	h.rateWindowEvents++
+	if !h.defensiveMode && h.rateWindowEvents > h.config.MaxEventsPerSecond {
+		h.defensiveMode = true
+		log.Printf("WARNING: event rate exceeded %d/s, entering defensive mode (blocking on first violation)",
+			h.config.MaxEventsPerSecond)
+	}
+}
+
+// InDefensiveMode returns whether the event rate circuit breaker is tripped
+func (h *EventHandler) InDefensiveMode() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.defensiveMode
+}
+
+// Stats returns a snapshot of the handler's counters
+func (h *EventHandler) Stats() HandlerStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var total uint32
+	for _, count := range h.violationCounts {
+		total += count
+	}
+
+	active, backend := h.provider.EnforcementActive()
+	stats := HandlerStats{
+		EventsRead:         h.eventsRead,
+		EventsProcessed:    h.eventsProcessed,
+		TotalViolations:    total,
+		BlockedPIDs:        len(h.blockedPIDs),
+		MalformedEvents:    h.malformedEvents,
+		DefensiveMode:      h.defensiveMode,
+		LatencyP50:         h.latency.percentile(50),
+		LatencyP99:         h.latency.percentile(99),
+		EnforcementActive:  active,
+		EnforcementBackend: backend,
+		ViolationsByComm:   h.commLabels.top(),
+		ViolationsByUID:    h.uidLabels.top(),
+	}
+	if reporter, ok := h.provider.(eventBufferReporter); ok {
+		stats.EventBufferDepth, stats.EventBufferSize = reporter.EventBufferDepth()
+	}
+	if reporter, ok := h.provider.(attachmentReporter); ok {
+		stats.Attachments = reporter.AttachmentStatus()
+	}
+	if len(h.policies) > 0 {
+		stats.Policies = h.policyStats()
+	}
+	return stats
+}
+
+// logStats logs a stats summary every interval until the context is
+// cancelled. Rates are measured from start, when Run began.
+func (h *EventHandler) logStats(ctx context.Context, interval time.Duration, start time.Time) {
+	ticks, stop := h.newTicker(interval)
+	defer stop()
+
+	var last HandlerStats
+	lastTime := start
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+			stats := h.Stats()
+			now := h.clock.Now()
+
+			var eventsPerSec float64
+			if elapsed := now.Sub(lastTime).Seconds(); elapsed > 0 {
+				eventsPerSec = float64(stats.EventsRead-last.EventsRead) / elapsed
+			}
+			var extra string
+			if stats.EventBufferSize > 0 {
+				extra = fmt.Sprintf(" buffered=%d/%d", stats.EventBufferDepth, stats.EventBufferSize)
+			}
+			if detached := stats.detached(); len(detached) > 0 {
+				extra += " detached=" + strings.Join(detached, ",")
+			}
+			if stats.Policies != nil {
+				extra += " policies=" + formatPolicyStats(stats.Policies)
+			}
+			log.Printf("stats: events=%d events/sec=%.1f violations=%d blocked=%d malformed=%d latency_p50=%v latency_p99=%v enforcement=%s processed=%d comms=%s uids=%s%s",
+				stats.EventsRead, eventsPerSec, stats.TotalViolations, stats.BlockedPIDs, stats.MalformedEvents,
+				stats.LatencyP50, stats.LatencyP99, stats.enforcement(), stats.EventsProcessed,
+				formatLabelCounts(stats.ViolationsByComm), formatLabelCounts(stats.ViolationsByUID), extra)
+
+			last, lastTime = stats, now
+		}
+	}
+}
+
+// newRealTicker starts a time.Ticker, returning its channel and stop function
+func newRealTicker(interval time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(interval)
+	return ticker.C, ticker.Stop
+}
+
+// Reset clears all violation and block state. When unblock is true every
+// blocked PID is also removed from the provider's blocked list: those the
+// handler blocked and, where the provider can list them, those blocked by
+// other means, e.g. the block command or an earlier run. PIDs that fail to
+// unblock stay recorded as blocked and their errors are returned.
+func (h *EventHandler) Reset(unblock bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var err error
+	remaining := make(map[uint32]blockRecord)
+	if unblock {
+		remaining, err = h.unblockAll()
+	}
+	for pid := range h.violationCounts {
+		delete(h.violationCounts, pid)
+		h.recordViolationCount(pid)
+	}
+
+	h.violationCounts = make(map[uint32]uint32)
+	h.accessedFiles = make(map[uint32]map[string]struct{})
+	h.openCounts = make(map[uint32]uint32)
+	h.countedInodes = make(map[uint32]map[FileID]struct{})
+	h.openTimes = make(map[uint32]*slidingWindow)
+	h.blockTimes.times = nil
+	h.escalatedAt = time.Time{}
+	h.blockedPIDs = remaining
+	h.blockedAttempts = make(map[uint32]uint64)
+	h.confirmedBlocks = make(map[uint32]uint64)
+	h.warnedPIDs = make(map[uint32]bool)
+	h.bytesRead = make(map[uint32]map[string]uint64)
+	h.fullComms = make(map[uint32]fullComm)
+	h.containers = make(map[uint32]containerInfo)
+	h.cgContainers = make(map[uint64]string)
+	h.pidStarts = make(map[uint32]uint64)
+	h.commLabels = newLabelSet(h.config.MetricsTopK)
+	h.uidLabels = newLabelSet(h.config.MetricsTopK)
+	h.parents = make(map[uint32]parentInfo)
+	h.defensiveMode = false
+
+	return err
+}
+
+// unblockAll removes every PID from the provider's blocked list, see Reset,
+// and releases quarantined ones, leaving the handler's state alone. It returns the records of the PIDs the
+// handler blocked that failed to unblock. h.mu must be held.
+func (h *EventHandler) unblockAll() (map[uint32]blockRecord, error) {
+	var errs []error
+	remaining := make(map[uint32]blockRecord)
+	pids, err := h.providerBlockedPIDs()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, pid := range pids {
+		err := h.provider.UnblockPID(pid)
+		if err == nil {
+			err = h.release(pid)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("unblock PID %d: %w", pid, err))
+			if record, ok := h.blockedPIDs[pid]; ok {
+				remaining[pid] = record
+			}
+		}
+	}
+	return remaining, errors.Join(errs...)
+}
+
+// providerBlockedPIDs returns the PIDs the handler blocked, along with every
+// other PID in the provider's blocked list where the provider can list it,
+// sorted
+func (h *EventHandler) providerBlockedPIDs() ([]uint32, error) {
+	seen := make(map[uint32]bool, len(h.blockedPIDs))
+	for pid := range h.blockedPIDs {
+		seen[pid] = true
+	}
+	var err error
+	if lister, ok := h.provider.(blockLister); ok {
+		var listed []uint32
+		if listed, err = lister.ListBlockedPIDs(); err != nil {
+			err = fmt.Errorf("list blocked PIDs: %w", err)
+		}
+		for _, pid := range listed {
+			seen[pid] = true
+		}
+	}
+
+	pids := make([]uint32, 0, len(seen))
+	for pid := range seen {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids, err
+}
+
+// GetViolationCount returns the total violation count across all PIDs
+func (h *EventHandler) GetViolationCount() uint32 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var total uint32
+	for _, count := range h.violationCounts {
+		total += count
+	}
+	return total
+}
+
+// IsInodeBlocked returns whether a file is blocked by inode
+func (h *EventHandler) IsInodeBlocked(id FileID) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.blockedInodes[id]
+	return ok
+}
+
+// GetBytesReadForPID returns how many bytes a PID has read from a disallowed file
+func (h *EventHandler) GetBytesReadForPID(pid uint32, filename string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.bytesRead[pid][filename]
+}
+
+// GetAccessedFiles returns the distinct disallowed files a PID accessed,
+// sorted, up to maxAccessedFiles of them
+func (h *EventHandler) GetAccessedFiles(pid uint32) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sortedAccessedFiles(pid)
+}
+
+// GetViolationCountForPID returns the violation count for a specific PID
+func (h *EventHandler) GetViolationCountForPID(pid uint32) uint32 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.violationCounts[pid]
+}
+
+// GetEventsProcessed returns how many opens and renames passed filtering and
+// were matched against the patterns, whether they matched or not. It is the
+// denominator for the violation rate.
+func (h *EventHandler) GetEventsProcessed() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.eventsProcessed
+}
+
+// GetMalformedEventCount returns the number of events skipped due to empty or invalid filenames
+func (h *EventHandler) GetMalformedEventCount() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.malformedEvents
+}
+
+// IsBlocked returns whether any PID has been blocked
+func (h *EventHandler) IsBlocked() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.blockedPIDs) > 0
+}
+
+// IsPIDWarned returns whether the approaching-block warning was emitted for a PID
+func (h *EventHandler) IsPIDWarned(pid uint32) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.warnedPIDs[pid]
+}
+
+// IsPIDBlocked returns whether a specific PID is blocked
+func (h *EventHandler) IsPIDBlocked(pid uint32) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.isBlocked(pid)
+}
+
+// isBlocked reports whether the handler blocked pid
+func (h *EventHandler) isBlocked(pid uint32) bool {
+	_, blocked := h.blockedPIDs[pid]
+	return blocked
+}
+
+// GetBlockedPIDs returns a slice of all blocked PIDs
+func (h *EventHandler) GetBlockedPIDs() []uint32 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pids := make([]uint32, 0, len(h.blockedPIDs))
+	for pid := range h.blockedPIDs {
+		pids = append(pids, pid)
+	}
+	return pids
+}
+
+// GetBlockedSummary groups the blocked PIDs by the comm they had when they
+// were blocked, each list sorted
+func (h *EventHandler) GetBlockedSummary() map[string][]uint32 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	summary := make(map[string][]uint32)
+	for pid, record := range h.blockedPIDs {
+		summary[record.comm] = append(summary[record.comm], pid)
+	}
+	for _, pids := range summary {
+		sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	}
+	return summary
+}
+
+// BlockRecord is a blocked PID as of a BlockedSnapshot
+type BlockRecord struct {
+	PID       uint32
+	Comm      string      // comm when the PID was blocked
+	Count     uint32      // violations counted for the PID
+	BlockedAt time.Time   // zero if unknown
+	Reason    BlockReason // why the PID was blocked
+}
+
+// BlockedSnapshot returns the blocked PIDs sorted by PID, copied under the
+// handler lock so they stay consistent while events keep being processed
+func (h *EventHandler) BlockedSnapshot() []BlockRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	records := make([]BlockRecord, 0, len(h.blockedPIDs))
+	for pid, record := range h.blockedPIDs {
+		var blockedAt time.Time
+		if record.info.BlockedAt != 0 {
+			blockedAt = time.Unix(0, int64(record.info.BlockedAt))
+		}
+		records = append(records, BlockRecord{
+			PID:       pid,
+			Comm:      record.comm,
+			Count:     h.violationCounts[pid],
+			BlockedAt: blockedAt,
+			Reason:    record.info.Reason,
+		})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].PID < records[j].PID })
+	return records
+}
+
+// pathEllipsis replaces the middle of paths shortened by truncatePath
+const pathEllipsis = "..."
+
+// truncatePath shortens path to at most maxLen characters by replacing its
+// middle with an ellipsis, keeping as much of the leading directories as fits
+// in front of the basename, e.g. /var/lib/.../shadow. A basename too long to
+// keep whole is itself cut in the middle. maxLen <= 0 disables truncation.
+func truncatePath(path string, maxLen int) string {
+	runes := []rune(path)
+	if maxLen <= 0 || len(runes) <= maxLen {
+		return path
+	}
+	if maxLen <= len(pathEllipsis) {
+		return string(runes[:maxLen])
+	}
+
+	keep := maxLen - len(pathEllipsis)
+	tailLen := utf8.RuneCountInString(path[strings.LastIndex(path, "/")+1:]) + 1 // basename and its slash
+	if tailLen >= keep {
+		// No room for the whole basename and some directory: keep both ends
+		tailLen = keep / 2
+	}
+	return string(runes[:keep-tailLen]) + pathEllipsis + string(runes[len(runes)-tailLen:])
+}
+
+// beneath reports whether filename is in a directory matching the glob dir,
+// or in any of its subdirectories
+func beneath(dir, filename string) bool {
+	for parent := filepath.Dir(filename); ; parent = filepath.Dir(parent) {
+		if matched, _ := filepath.Match(dir, parent); matched {
+			return true
+		}
+		if parent == "/" || parent == "." {
+			return dir == ""
+		}
+	}
+}
+
+// validFilename reports whether a filename read from an event is usable for matching
+func validFilename(filename string) bool {
+	if filename == "" {
+		return false
+	}
+	return utf8.ValidString(filename)
+}
+
+// matchesPattern checks if a filename matches any of the disallowed patterns
+func matchesPattern(filename string, patterns []string) bool {
+	matched, _, _ := matchRule(filename, patterns)
+	return matched
+}
+
+// matchRule returns the first pattern, in the order given, that matches the
+// filename, and whether it matched exactly, as a glob or as a substring.
+// Earlier patterns take precedence when several overlap.
+func matchRule(filename string, patterns []string) (matched bool, pattern, kind string) {
+	index, kind := matchRuleIndex(filename, patterns, false)
+	if index < 0 {
+		return false, "", ""
+	}
+	return true, patterns[index], kind
+}
+
+// matchRuleIndex is matchRule reporting the index of the matching pattern,
+// or -1 if none matches. A pattern ending in / matches the files directly in
+// that directory and one ending in /** those at any depth beneath it; the
+// directory part may be a glob. Neither matches as a substring, and no
+// pattern does when globOnly is set.
+func matchRuleIndex(filename string, patterns []string, globOnly bool) (int, string) {
+	for i, pattern := range patterns {
+		if pattern == filename {
+			return i, MatchExact
+		}
+		if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+			if beneath(dir, filename) {
+				return i, MatchRecursive
+			}
+			continue
+		}
+		if dir, ok := strings.CutSuffix(pattern, "/"); ok && dir != "" {
+			if matched, _ := filepath.Match(dir, filepath.Dir(filename)); matched {
+				return i, MatchDirectory
+			}
+			continue
+		}
+		if matched, _ := filepath.Match(pattern, filename); matched {
+			return i, MatchGlob
+		}
+		if !globOnly && strings.Contains(filename, pattern) {
+			return i, MatchSubstring
+		}
+	}
+	return -1, ""
 }
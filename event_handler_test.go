@@ -1,9 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
+	"unicode/utf8"
+
+	"github.com/cilium/ebpf/ringbuf"
 )
 
 func TestEventHandler_ViolationCounting(t *testing.T) {
@@ -248,6 +262,249 @@ func TestEventHandler_MultipleProcesses(t *testing.T) {
 	}
 }
 
+func TestEventHandler_GetBlockedSummary(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+	})
+
+	events := []*Event{
+		CreateMockEvent(3000, 1000, "curl", "/etc/passwd"),
+		CreateMockEvent(1000, 1000, "curl", "/etc/shadow"),
+		CreateMockEvent(2000, 0, "nc", "/etc/passwd"),
+		// PID 1000 exec'd after its block; it stays under its old comm
+		CreateMockEvent(1000, 1000, "bash", "/etc/hosts"),
+	}
+	for _, event := range events {
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	expected := map[string][]uint32{
+		"curl": {1000, 3000},
+		"nc":   {2000},
+	}
+	summary := handler.GetBlockedSummary()
+	if !reflect.DeepEqual(summary, expected) {
+		t.Errorf("expected %v, got %v", expected, summary)
+	}
+}
+
+func TestEventHandler_BlockedSnapshot(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/passwd", "/etc/hosts"},
+		Rules:              []Rule{{Pattern: "/etc/shadow", Immediate: true}},
+		Threshold:          2,
+	})
+	handler.clock = newFakeClock(time.Unix(1000, 0))
+
+	for _, event := range []*Event{
+		CreateMockEvent(2000, 1000, "sh", "/etc/shadow"),
+		CreateMockEvent(1000, 1000, "cat", "/etc/passwd"),
+		CreateMockEvent(1000, 1000, "cat", "/etc/hosts"),
+	} {
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	expected := []BlockRecord{
+		{PID: 1000, Comm: "cat", Count: 2, BlockedAt: time.Unix(1000, 0), Reason: BlockReasonThreshold},
+		{PID: 2000, Comm: "sh", Count: 1, BlockedAt: time.Unix(1000, 0), Reason: BlockReasonImmediate},
+	}
+	snapshot := handler.BlockedSnapshot()
+	if !reflect.DeepEqual(snapshot, expected) {
+		t.Errorf("expected %+v, got %+v", expected, snapshot)
+	}
+}
+
+func TestEventHandler_BlockedSnapshotConcurrent(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/passwd"},
+		Threshold:          1,
+	})
+
+	const pids = 500
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for pid := uint32(1); pid <= pids; pid++ {
+			if _, err := handler.processEvent(CreateMockEvent(pid, 1000, "cat", "/etc/passwd")); err != nil {
+				t.Errorf("processEvent: %v", err)
+				return
+			}
+		}
+	}()
+
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+
+		snapshot := handler.BlockedSnapshot()
+		before := append([]BlockRecord{}, snapshot...)
+		for i, record := range snapshot {
+			if i > 0 && snapshot[i-1].PID >= record.PID {
+				t.Fatalf("snapshot not sorted by PID at %d: %+v", i, snapshot)
+			}
+			if record.Count != 1 || record.Reason != BlockReasonThreshold {
+				t.Fatalf("inconsistent record %+v", record)
+			}
+		}
+		// Later blocks do not change a snapshot already taken
+		runtime.Gosched()
+		if !reflect.DeepEqual(snapshot, before) {
+			t.Fatal("snapshot changed after it was taken")
+		}
+	}
+
+	if got := len(handler.BlockedSnapshot()); got != pids {
+		t.Errorf("expected %d blocked PIDs, got %d", pids, got)
+	}
+}
+
+func TestEventHandler_RenameViolations(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          2,
+		GracePeriodOpens:   5,
+	})
+	store := &memAuditStore{}
+	handler.auditLog = newAuditLogger(store, 0, false)
+
+	events := []struct {
+		event   *Event
+		counted bool
+	}{
+		// Renaming within allowed paths is fine
+		{CreateMockRenameEvent(1234, 1000, "app", "/tmp/b"), false},
+		// Renaming into a disallowed path counts, even during the grace period
+		{CreateMockRenameEvent(1234, 1000, "app", "/etc/profile"), true},
+		// An open during the grace period does not
+		{CreateMockEvent(1234, 1000, "app", "/etc/passwd"), false},
+		{CreateMockRenameEvent(1234, 1000, "app", "/etc/ld.so.preload"), true},
+	}
+	for i, tt := range events {
+		result, err := handler.processEvent(tt.event)
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		if result.Counted != tt.counted {
+			t.Errorf("event %d: expected counted=%v, got %v", i, tt.counted, result.Counted)
+		}
+	}
+
+	if !handler.IsPIDBlocked(1234) {
+		t.Error("expected two renames into /etc to block PID 1234")
+	}
+
+	records := decodeAuditLines(t, store.files[0].String())
+	if len(records) != 3 {
+		t.Fatalf("expected 2 violations and a block, got %d records", len(records))
+	}
+	for i, record := range records {
+		if record.Event != "rename" {
+			t.Errorf("record %d: expected a rename record, got %+v", i, record)
+		}
+	}
+}
+
+func TestEventHandler_PIDThresholdOverrides(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns:    []string{"/etc/*"},
+		Threshold:             3,
+		PIDThresholdOverrides: map[uint32]uint32{1000: 1, 3000: 0},
+	})
+
+	for _, pid := range []uint32{1000, 2000, 3000} {
+		if _, err := handler.processEvent(CreateMockEvent(pid, 1000, "app", "/etc/passwd")); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+	if !handler.IsPIDBlocked(1000) {
+		t.Error("expected PID 1000 to block at its override of 1")
+	}
+	if handler.IsPIDBlocked(2000) || handler.IsPIDBlocked(3000) {
+		t.Error("expected PIDs without an override to use the global threshold")
+	}
+
+	// Overrides can be set and removed at runtime
+	handler.SetPIDThreshold(2000, 2)
+	handler.SetPIDThreshold(1000, 0)
+	for _, pid := range []uint32{2000, 3000} {
+		if _, err := handler.processEvent(CreateMockEvent(pid, 1000, "app", "/etc/shadow")); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+	if !handler.IsPIDBlocked(2000) {
+		t.Error("expected PID 2000 to block at its new override of 2")
+	}
+	if handler.IsPIDBlocked(3000) {
+		t.Error("expected PID 3000 to still use the global threshold")
+	}
+}
+
+func TestEventHandler_CommThresholds(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns:    []string{"/etc/*"},
+		Threshold:             3,
+		CommThresholds:        map[string]uint32{"curl": 1, "postgres": 5, "app": 2},
+		PIDThresholdOverrides: map[uint32]uint32{4000: 1},
+	})
+
+	// Counts stay per PID: two curl processes each block on their own first
+	// violation, while postgres gets further than the global threshold
+	files := []string{"/etc/a", "/etc/b", "/etc/c", "/etc/d", "/etc/e"}
+	for i, file := range files {
+		for _, event := range []*Event{
+			CreateMockEvent(1000, 1000, "curl", file),
+			CreateMockEvent(1001, 1000, "curl", file),
+			CreateMockEvent(2000, 1000, "postgres", file),
+			CreateMockEvent(3000, 1000, "other", file),
+			CreateMockEvent(4000, 1000, "app", file),
+		} {
+			if _, err := handler.processEvent(event); err != nil {
+				t.Fatalf("processEvent: %v", err)
+			}
+		}
+
+		violations := i + 1
+		if !handler.IsPIDBlocked(1000) || !handler.IsPIDBlocked(1001) {
+			t.Errorf("after %d violations: expected both curl PIDs blocked at 1", violations)
+		}
+		if got, want := handler.IsPIDBlocked(2000), violations >= 5; got != want {
+			t.Errorf("after %d violations: postgres blocked = %v, want %v", violations, got, want)
+		}
+		if got, want := handler.IsPIDBlocked(3000), violations >= 3; got != want {
+			t.Errorf("after %d violations: other blocked = %v, want %v (global threshold)", violations, got, want)
+		}
+		if !handler.IsPIDBlocked(4000) {
+			t.Errorf("after %d violations: expected the PID override to win over app's threshold", violations)
+		}
+	}
+}
+
 func TestEventHandler_PIDFiltering(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -352,7 +609,7 @@ func TestEventHandler_PatternMatching(t *testing.T) {
 		},
 		{
 			name:     "multiple patterns - second matches",
-			patterns: []string{"/etc/*", "/var/"},
+			patterns: []string{"/etc/*", "/var/**"},
 			filename: "/var/log/syslog",
 			expected: true,
 		},
@@ -452,3 +709,2709 @@ func TestEventHandler_EmptyEventStream(t *testing.T) {
 		t.Error("handler should not be in blocked state")
 	}
 }
+
+func TestEventHandler_EventsProcessed(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow"},
+		Threshold:          10,
+		TargetUIDs:         []uint32{1000},
+	})
+
+	events := []*Event{
+		// Matching and non-matching opens both count
+		CreateMockEvent(1234, 1000, "app", "/etc/shadow"),
+		CreateMockEvent(1234, 1000, "app", "/tmp/notes.txt"),
+		CreateMockEvent(1234, 1000, "app", "/usr/lib/libc.so.6"),
+		// Filtered out by UID
+		CreateMockEvent(5678, 0, "root", "/etc/shadow"),
+		// Malformed
+		CreateMockEvent(1234, 1000, "app", ""),
+	}
+	for _, event := range events {
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	if got := handler.GetEventsProcessed(); got != 3 {
+		t.Errorf("expected 3 events processed, got %d", got)
+	}
+	if got := handler.GetViolationCount(); got != 1 {
+		t.Errorf("expected 1 violation, got %d", got)
+	}
+	stats := handler.Stats()
+	if stats.EventsRead != 5 || stats.EventsProcessed != 3 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestEventHandler_MalformedFilenames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{
+		// Empty filename (e.g. bpf_probe_read_user_str failed)
+		CreateMockEvent(1234, 1000, "app", ""),
+		// Invalid UTF-8 that would otherwise substring-match "secret"
+		CreateMockEvent(1234, 1000, "app", "/secret/\xff\xfe"),
+		CreateMockEvent(1234, 1000, "app", "/secret/valid.txt"),
+	}
+
+	provider := NewMockEBPFProvider(ctx, events)
+	defer provider.Close()
+
+	config := EventHandlerConfig{
+		DisallowedPatterns: []string{"secret"},
+		Threshold:          2,
+		TargetPID:          0,
+	}
+
+	handler := NewEventHandler(provider, config)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	if handler.GetMalformedEventCount() != 2 {
+		t.Errorf("expected 2 malformed events, got %d", handler.GetMalformedEventCount())
+	}
+
+	if handler.GetViolationCount() != 1 {
+		t.Errorf("expected 1 violation, got %d", handler.GetViolationCount())
+	}
+
+	if handler.IsPIDBlocked(1234) {
+		t.Error("PID 1234 should not be blocked by malformed events")
+	}
+}
+
+func TestEventHandler_DefensiveMode(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := NewMockEBPFProvider(ctx, nil)
+	defer provider.Close()
+
+	config := EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          5,
+		MaxEventsPerSecond: 3,
+	}
+
+	handler := NewEventHandler(provider, config)
+
+	// Drive the handler's clock manually, 100ms per event
+	clock := newFakeClock(time.Unix(1000, 0))
+	handler.clock = clock
+	send := func(pid uint32, filename string) ProcessResult {
+		result, err := handler.processEvent(CreateMockEvent(pid, 1000, "app", filename))
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		clock.Advance(100 * time.Millisecond)
+		return result
+	}
+
+	// Three events within one second stay under the ceiling
+	send(1000, "/tmp/a")
+	send(1000, "/tmp/b")
+	send(1000, "/etc/passwd")
+	if handler.InDefensiveMode() {
+		t.Fatal("should not be in defensive mode at the ceiling")
+	}
+
+	// The fourth event in the same window trips the breaker
+	send(1000, "/tmp/c")
+	if !handler.InDefensiveMode() {
+		t.Fatal("expected defensive mode after exceeding the ceiling")
+	}
+
+	// A single violation now blocks a fresh PID
+	if result := send(2000, "/etc/shadow"); !result.Blocked {
+		t.Error("expected the first violation to block in defensive mode")
+	}
+	if !handler.IsPIDBlocked(2000) {
+		t.Error("expected PID 2000 to be blocked at first violation in defensive mode")
+	}
+	if handler.IsPIDBlocked(1000) {
+		t.Error("PID 1000 violated before defensive mode and should not be blocked")
+	}
+
+	// A quiet window brings the handler back to normal thresholds
+	clock.Advance(2 * time.Second)
+	send(3000, "/tmp/d")
+	clock.Advance(2 * time.Second)
+	send(3000, "/etc/hosts")
+	if handler.InDefensiveMode() {
+		t.Fatal("expected defensive mode to end after a quiet window")
+	}
+	if handler.IsPIDBlocked(3000) {
+		t.Error("PID 3000 should use the normal threshold after defensive mode ends")
+	}
+}
+
+func TestEventHandler_RateWindowBoundary(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          5,
+		MaxEventsPerSecond: 1,
+	})
+	clock := newFakeClock(time.Unix(1000, 0))
+	handler.clock = clock
+
+	send := func() {
+		if _, err := handler.processEvent(CreateMockEvent(1000, 1000, "app", "/tmp/a")); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	// Events exactly one second apart each start a new window
+	send()
+	clock.Advance(time.Second)
+	send()
+	if handler.InDefensiveMode() {
+		t.Fatal("events one second apart should not trip the breaker")
+	}
+
+	// One nanosecond short of a second is still the same window
+	clock.Advance(time.Second - time.Nanosecond)
+	send()
+	if !handler.InDefensiveMode() {
+		t.Fatal("expected a second event within the window to trip the breaker")
+	}
+}
+
+func TestEventHandler_ProcessingLatency(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          100,
+	})
+	boot := time.Unix(1000, 0)
+	handler.bootTime = boot
+	clock := newFakeClock(boot.Add(time.Hour))
+	handler.clock = clock
+
+	// Events emitted 1ms..10ms before they are processed
+	for i := 1; i <= 10; i++ {
+		event := CreateMockEvent(1234, 1000, "app", "/tmp/file")
+		event.Timestamp = uint64(time.Hour - time.Duration(i)*time.Millisecond)
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	// Events without a timestamp are not measured
+	if _, err := handler.processEvent(CreateMockEvent(1234, 1000, "app", "/tmp/file")); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+
+	stats := handler.Stats()
+	if stats.LatencyP50 != 5*time.Millisecond {
+		t.Errorf("expected p50 of 5ms, got %v", stats.LatencyP50)
+	}
+	if stats.LatencyP99 != 10*time.Millisecond {
+		t.Errorf("expected p99 of 10ms, got %v", stats.LatencyP99)
+	}
+}
+
+func TestEventHandler_PatternHits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// "/etc/*" and "passwd" overlap on /etc/passwd; the first configured
+	// pattern is credited
+	events := []*Event{
+		CreateMockEvent(1234, 1000, "app", "/etc/passwd"),
+		CreateMockEvent(1234, 1000, "app", "/backup/passwd"),
+		CreateMockEvent(1234, 1000, "app", "/etc/hosts"),
+		CreateMockEvent(1234, 1000, "app", "/tmp/safe.txt"),
+	}
+
+	provider := NewMockEBPFProvider(ctx, events)
+	defer provider.Close()
+
+	config := EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*", "passwd", "/never/*"},
+		Threshold:          10,
+	}
+
+	handler := NewEventHandler(provider, config)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	hits := handler.PatternHits()
+	expected := map[string]uint64{"/etc/*": 2, "passwd": 1}
+	if len(hits) != len(expected) {
+		t.Errorf("expected %d patterns with hits, got %v", len(expected), hits)
+	}
+	for pattern, count := range expected {
+		if hits[pattern] != count {
+			t.Errorf("pattern %q: expected %d hits, got %d", pattern, count, hits[pattern])
+		}
+	}
+	if _, ok := hits["/never/*"]; ok {
+		t.Error("pattern /never/* should have no hits")
+	}
+
+	// The returned map is a copy
+	hits["/etc/*"] = 100
+	if handler.PatternHits()["/etc/*"] != 2 {
+		t.Error("PatternHits should return a copy")
+	}
+}
+
+func TestEventHandler_WarnThreshold(t *testing.T) {
+	tests := []struct {
+		name        string
+		events      []*Event
+		shouldWarn  bool
+		shouldBlock bool
+	}{
+		{
+			name: "warn then block",
+			events: []*Event{
+				CreateMockEvent(1234, 1000, "app", "/etc/passwd"),
+				CreateMockEvent(1234, 1000, "app", "/etc/shadow"),
+				CreateMockEvent(1234, 1000, "app", "/etc/group"),
+				CreateMockEvent(1234, 1000, "app", "/etc/hosts"),
+			},
+			shouldWarn:  true,
+			shouldBlock: true,
+		},
+		{
+			name: "warn without reaching block",
+			events: []*Event{
+				CreateMockEvent(1234, 1000, "app", "/etc/passwd"),
+				CreateMockEvent(1234, 1000, "app", "/etc/shadow"),
+				CreateMockEvent(1234, 1000, "app", "/etc/group"),
+			},
+			shouldWarn:  true,
+			shouldBlock: false,
+		},
+		{
+			name: "below warn threshold",
+			events: []*Event{
+				CreateMockEvent(1234, 1000, "app", "/etc/passwd"),
+			},
+			shouldWarn:  false,
+			shouldBlock: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			provider := NewMockEBPFProvider(ctx, tt.events)
+			defer provider.Close()
+
+			config := EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/*"},
+				Threshold:          4,
+				WarnThreshold:      2,
+			}
+
+			handler := NewEventHandler(provider, config)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			<-done
+
+			if handler.IsPIDWarned(1234) != tt.shouldWarn {
+				t.Errorf("expected warned=%v, got %v", tt.shouldWarn, handler.IsPIDWarned(1234))
+			}
+			if handler.IsPIDBlocked(1234) != tt.shouldBlock {
+				t.Errorf("expected blocked=%v, got %v", tt.shouldBlock, handler.IsPIDBlocked(1234))
+			}
+		})
+	}
+}
+
+func TestEventHandler_ImmediateRules(t *testing.T) {
+	tests := []struct {
+		name               string
+		events             []*Event
+		expectedViolations uint32
+		shouldBlock        bool
+	}{
+		{
+			name: "immediate rule blocks on first match",
+			events: []*Event{
+				CreateMockEvent(1234, 1000, "app", "/etc/shadow"),
+			},
+			expectedViolations: 1,
+			shouldBlock:        true,
+		},
+		{
+			name: "normal rules keep threshold behavior",
+			events: []*Event{
+				CreateMockEvent(1234, 1000, "app", "/etc/hosts"),
+				CreateMockEvent(1234, 1000, "app", "/etc/group"),
+			},
+			expectedViolations: 2,
+			shouldBlock:        false,
+		},
+		{
+			name: "immediate rule after normal violations",
+			events: []*Event{
+				CreateMockEvent(1234, 1000, "app", "/etc/hosts"),
+				CreateMockEvent(1234, 1000, "app", "/etc/shadow"),
+			},
+			expectedViolations: 2,
+			shouldBlock:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			provider := NewMockEBPFProvider(ctx, tt.events)
+			defer provider.Close()
+
+			config := EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/*"},
+				Rules:              []Rule{{Pattern: "/etc/shadow", Immediate: true}},
+				Threshold:          3,
+			}
+
+			handler := NewEventHandler(provider, config)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			<-done
+
+			if handler.GetViolationCountForPID(1234) != tt.expectedViolations {
+				t.Errorf("expected %d violations, got %d", tt.expectedViolations, handler.GetViolationCountForPID(1234))
+			}
+			if provider.IsBlocked(1234) != tt.shouldBlock {
+				t.Errorf("expected blocked=%v, got %v", tt.shouldBlock, provider.IsBlocked(1234))
+			}
+		})
+	}
+}
+
+func TestEventHandler_GracePeriodOpens(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		Rules: []Rule{
+			{Pattern: "/etc/*"},
+			{Pattern: "id_rsa", Immediate: true},
+		},
+		Threshold:        1,
+		GracePeriodOpens: 2,
+	})
+
+	tests := []struct {
+		pid      uint32
+		filename string
+		counted  bool
+	}{
+		// The first two matching opens of PID 1000 are free, the third counts
+		{1000, "/etc/hosts", false},
+		{1000, "/etc/resolv.conf", false},
+		{1000, "/etc/passwd", true},
+		// Opens of allowed files use up the grace period too
+		{2000, "/tmp/a", false},
+		{2000, "/tmp/b", false},
+		{2000, "/etc/passwd", true},
+		// Immediate rules apply during the grace period
+		{3000, "/root/.ssh/id_rsa", true},
+	}
+
+	for _, tt := range tests {
+		result, err := handler.processEvent(CreateMockEvent(tt.pid, 1000, "app", tt.filename))
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		if result.Counted != tt.counted {
+			t.Errorf("PID %d opening %s: expected counted=%v, got %v", tt.pid, tt.filename, tt.counted, result.Counted)
+		}
+	}
+
+	for _, pid := range []uint32{1000, 2000, 3000} {
+		if handler.GetViolationCountForPID(pid) != 1 || !handler.IsPIDBlocked(pid) {
+			t.Errorf("PID %d: expected 1 violation and a block, got %d, blocked=%v",
+				pid, handler.GetViolationCountForPID(pid), handler.IsPIDBlocked(pid))
+		}
+	}
+}
+
+func TestEventHandler_ImmediateRuleOnly(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A non-immediate rule behaves like a disallowed pattern
+	events := []*Event{
+		CreateMockEvent(1234, 1000, "app", "/srv/secret.key"),
+		CreateMockEvent(5678, 1000, "app", "/root/.ssh/id_rsa"),
+	}
+
+	provider := NewMockEBPFProvider(ctx, events)
+	defer provider.Close()
+
+	config := EventHandlerConfig{
+		Rules: []Rule{
+			{Pattern: "/srv/*"},
+			{Pattern: "id_rsa", Immediate: true},
+		},
+		Threshold: 2,
+	}
+
+	handler := NewEventHandler(provider, config)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	if handler.GetViolationCountForPID(1234) != 1 || handler.IsPIDBlocked(1234) {
+		t.Errorf("PID 1234: expected 1 violation and no block, got %d, blocked=%v",
+			handler.GetViolationCountForPID(1234), handler.IsPIDBlocked(1234))
+	}
+	if !handler.IsPIDBlocked(5678) {
+		t.Error("expected PID 5678 to be blocked by immediate rule")
+	}
+}
+
+func TestEventHandler_CaseInsensitive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := NewMockEBPFProvider(ctx, nil)
+	defer provider.Close()
+
+	config := EventHandlerConfig{
+		Rules: []Rule{
+			{Pattern: "/Secrets/*"},
+			{Pattern: "ID_RSA", Immediate: true},
+		},
+		Policies:        []Policy{{Name: "web", UIDs: []uint32{33}, Patterns: []string{"/VAR/www/*.KEY"}, Threshold: 5}},
+		Threshold:       5,
+		CaseInsensitive: true,
+	}
+
+	handler := NewEventHandler(provider, config)
+
+	tests := []struct {
+		uid      uint32
+		filename string
+		pattern  string
+	}{
+		{1000, "/secrets/DB.txt", "/Secrets/*"},
+		{33, "/var/WWW/site.key", "/VAR/www/*.KEY"},
+		{1000, "/home/alice/.ssh/Id_Rsa", "ID_RSA"},
+	}
+
+	for _, tt := range tests {
+		result, err := handler.processEvent(CreateMockEvent(1234, tt.uid, "app", tt.filename))
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		if !result.Matched || result.MatchedPattern != tt.pattern {
+			t.Errorf("%s: expected a match on %q, got %+v", tt.filename, tt.pattern, result)
+		}
+	}
+	if !handler.IsPIDBlocked(1234) {
+		t.Error("expected the mixed-case immediate rule to block PID 1234")
+	}
+}
+
+func TestEventHandler_PIDNamespaceFiltering(t *testing.T) {
+	// createNsEvent builds an event from a process with host PID pid that is
+	// nsPid inside PID namespace pidNs
+	createNsEvent := func(pid, nsPid, pidNs uint32, filename string) *Event {
+		event := CreateMockEvent(pid, 1000, "app", filename)
+		event.NsPid = nsPid
+		event.PidNs = pidNs
+		return event
+	}
+
+	events := []*Event{
+		// PID 1 inside container namespace 4026532000
+		createNsEvent(5000, 1, 4026532000, "/etc/passwd"),
+		// PID 1 inside a different container
+		createNsEvent(6000, 1, 4026532999, "/etc/passwd"),
+		// Host PID 1 (init), in the host namespace
+		createNsEvent(1, 1, 4026531836, "/etc/passwd"),
+	}
+
+	tests := []struct {
+		name         string
+		pidNamespace uint32
+		expected     map[uint32]uint32
+	}{
+		{
+			name:         "host PID space",
+			pidNamespace: 0,
+			expected:     map[uint32]uint32{1: 1, 5000: 0, 6000: 0},
+		},
+		{
+			name:         "container PID space",
+			pidNamespace: 4026532000,
+			expected:     map[uint32]uint32{1: 0, 5000: 1, 6000: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			provider := NewMockEBPFProvider(ctx, events)
+			defer provider.Close()
+
+			config := EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/*"},
+				Threshold:          1,
+				TargetPID:          1,
+				PIDNamespace:       tt.pidNamespace,
+			}
+
+			handler := NewEventHandler(provider, config)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			<-done
+
+			for pid, want := range tt.expected {
+				if got := handler.GetViolationCountForPID(pid); got != want {
+					t.Errorf("PID %d: expected %d violations, got %d", pid, want, got)
+				}
+			}
+
+			// Blocking always uses the host PID
+			for pid, want := range tt.expected {
+				if provider.IsBlocked(pid) != (want > 0) {
+					t.Errorf("PID %d: expected blocked=%v", pid, want > 0)
+				}
+			}
+		})
+	}
+}
+
+func TestEventHandler_PIDRangeAndExclusions(t *testing.T) {
+	events := []*Event{
+		CreateMockEvent(2, 0, "kthreadd", "/etc/passwd"),
+		CreateMockEvent(1500, 1000, "svc", "/etc/passwd"),
+		CreateMockEvent(1600, 1000, "svc", "/etc/passwd"),
+		CreateMockEvent(2500, 1000, "other", "/etc/passwd"),
+	}
+
+	tests := []struct {
+		name      string
+		targetPID uint32
+		pidMin    uint32
+		pidMax    uint32
+		exclude   []uint32
+		expected  []uint32 // PIDs that should accrue a violation
+	}{
+		{
+			name:     "no filters",
+			expected: []uint32{2, 1500, 1600, 2500},
+		},
+		{
+			name:     "range",
+			pidMin:   1000,
+			pidMax:   2000,
+			expected: []uint32{1500, 1600},
+		},
+		{
+			name:     "lower bound only",
+			pidMin:   100,
+			expected: []uint32{1500, 1600, 2500},
+		},
+		{
+			name:     "exclusions",
+			exclude:  []uint32{2, 2500},
+			expected: []uint32{1500, 1600},
+		},
+		{
+			name:     "range with exclusion",
+			pidMin:   1000,
+			pidMax:   2000,
+			exclude:  []uint32{1600},
+			expected: []uint32{1500},
+		},
+		{
+			name:      "target PID outside range",
+			targetPID: 2500,
+			pidMax:    2000,
+			expected:  nil,
+		},
+		{
+			name:      "excluded target PID",
+			targetPID: 1500,
+			exclude:   []uint32{1500},
+			expected:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			provider := NewMockEBPFProvider(ctx, events)
+			defer provider.Close()
+
+			config := EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/*"},
+				Threshold:          5,
+				TargetPID:          tt.targetPID,
+				PIDMin:             tt.pidMin,
+				PIDMax:             tt.pidMax,
+				ExcludePIDs:        tt.exclude,
+			}
+
+			handler := NewEventHandler(provider, config)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			<-done
+
+			expected := make(map[uint32]bool)
+			for _, pid := range tt.expected {
+				expected[pid] = true
+			}
+			for _, event := range events {
+				got := handler.GetViolationCountForPID(event.Pid) > 0
+				if got != expected[event.Pid] {
+					t.Errorf("PID %d: expected monitored=%v, got %v", event.Pid, expected[event.Pid], got)
+				}
+			}
+		})
+	}
+}
+
+func TestEventHandler_PeriodicStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{
+		CreateMockEvent(1234, 1000, "app", "/etc/passwd"),
+		CreateMockEvent(1234, 1000, "app", "/tmp/safe.txt"),
+		CreateMockEvent(1234, 1000, "app", "/etc/shadow"),
+	}
+
+	provider := NewMockEBPFProvider(ctx, events)
+	defer provider.Close()
+
+	config := EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          2,
+		StatsInterval:      time.Second,
+	}
+
+	handler := NewEventHandler(provider, config)
+
+	// Drive the ticker and clock by hand
+	ticks := make(chan time.Time)
+	stopped := make(chan struct{})
+	handler.newTicker = func(time.Duration) (<-chan time.Time, func()) {
+		return ticks, func() { close(stopped) }
+	}
+	clock := newFakeClock(time.Unix(1000, 0))
+	handler.clock = clock
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	// Wait for every event to be processed before ticking
+	deadline := time.Now().Add(time.Second)
+	for handler.Stats().EventsRead < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	clock.Advance(2 * time.Second)
+	ticks <- time.Now()
+	cancel()
+	<-done
+
+	select {
+	case <-stopped:
+	default:
+		t.Error("expected the stats ticker to be stopped when Run returns")
+	}
+
+	expected := "stats: events=3 events/sec=1.5 violations=2 blocked=1 malformed=0"
+	if !strings.Contains(logs.String(), expected) {
+		t.Errorf("expected log line %q, got:\n%s", expected, logs.String())
+	}
+
+	stats := handler.Stats()
+	if stats.EventsRead != 3 || stats.TotalViolations != 2 || stats.BlockedPIDs != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// fakeProc creates a fake proc tree with the given PID -> comm entries
+func fakeProc(t *testing.T, comms map[uint32]string) procFS {
+	t.Helper()
+	root := t.TempDir()
+	for pid, comm := range comms {
+		dir := filepath.Join(root, strconv.FormatUint(uint64(pid), 10))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("create fake proc: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "comm"), []byte(comm+"\n"), 0644); err != nil {
+			t.Fatalf("create fake comm: %v", err)
+		}
+	}
+	return procFS{root: root}
+}
+
+func TestEventHandler_FilterMode(t *testing.T) {
+	// The event under test: PID 1234, UID 1000, comm "app"
+	tests := []struct {
+		name   string
+		config EventHandlerConfig
+		all    bool // monitored with FilterAll
+		any    bool // monitored with FilterAny
+	}{
+		{"no filters", EventHandlerConfig{}, true, true},
+		{"pid matches", EventHandlerConfig{TargetPID: 1234}, true, true},
+		{"pid differs", EventHandlerConfig{TargetPID: 99}, false, false},
+		{"uid matches", EventHandlerConfig{TargetUIDs: []uint32{0, 1000}}, true, true},
+		{"uid differs", EventHandlerConfig{TargetUIDs: []uint32{0}}, false, false},
+		{"comm matches", EventHandlerConfig{TargetComms: []string{"app"}}, true, true},
+		{"comm differs", EventHandlerConfig{TargetComms: []string{"sshd"}}, false, false},
+		{"pid and uid match", EventHandlerConfig{TargetPID: 1234, TargetUIDs: []uint32{1000}}, true, true},
+		{"pid matches, uid differs", EventHandlerConfig{TargetPID: 1234, TargetUIDs: []uint32{0}}, false, true},
+		{"pid differs, uid matches", EventHandlerConfig{TargetPID: 99, TargetUIDs: []uint32{1000}}, false, true},
+		{"uid matches, comm differs", EventHandlerConfig{TargetUIDs: []uint32{1000}, TargetComms: []string{"sshd"}}, false, true},
+		{"all three match", EventHandlerConfig{TargetPID: 1234, TargetUIDs: []uint32{1000}, TargetComms: []string{"app"}}, true, true},
+		{"only comm matches", EventHandlerConfig{TargetPID: 99, TargetUIDs: []uint32{0}, TargetComms: []string{"app"}}, false, true},
+		{"none match", EventHandlerConfig{TargetPID: 99, TargetUIDs: []uint32{0}, TargetComms: []string{"sshd"}}, false, false},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []string{"", FilterAll, FilterAny} {
+			t.Run(tt.name+"/"+mode, func(t *testing.T) {
+				provider := NewMockEBPFProvider(context.Background(), nil)
+				defer provider.Close()
+
+				config := tt.config
+				config.DisallowedPatterns = []string{"/etc/*"}
+				config.Threshold = 1
+				config.FilterMode = mode
+				handler := NewEventHandler(provider, config)
+
+				result, err := handler.processEvent(CreateMockEvent(1234, 1000, "app", "/etc/passwd"))
+				if err != nil {
+					t.Fatalf("processEvent: %v", err)
+				}
+				expected := tt.all
+				if mode == FilterAny {
+					expected = tt.any
+				}
+				if result.Counted != expected {
+					t.Errorf("expected monitored=%v, got %v", expected, result.Counted)
+				}
+			})
+		}
+	}
+}
+
+func TestEventHandler_FilterModeKeepsExclusions(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	// Range and exclusions are not target filters and apply in any mode
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+		TargetUIDs:         []uint32{1000},
+		ExcludePIDs:        []uint32{1234},
+		PIDMax:             5000,
+		FilterMode:         FilterAny,
+	})
+
+	for _, pid := range []uint32{1234, 6000} {
+		result, err := handler.processEvent(CreateMockEvent(pid, 1000, "app", "/etc/passwd"))
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		if result.Counted {
+			t.Errorf("PID %d should not be monitored", pid)
+		}
+	}
+}
+
+func TestEventHandler_TargetTIDs(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          2,
+		TargetTIDs:         []uint32{1240},
+	})
+
+	// Only the opens of the target thread count
+	thread := func(tid uint32, filename string) *Event {
+		event := CreateMockEvent(1234, 1000, "nginx", filename)
+		event.Tid = tid
+		return event
+	}
+	for _, event := range []*Event{
+		thread(1241, "/etc/passwd"),
+		thread(1240, "/etc/shadow"),
+		thread(1241, "/etc/group"),
+	} {
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+	if got := handler.GetViolationCountForPID(1234); got != 1 {
+		t.Errorf("expected only the target thread's violation, got %d", got)
+	}
+
+	// but the block applies to the whole process
+	result, err := handler.processEvent(thread(1240, "/etc/hosts"))
+	if err != nil || !result.Blocked || !provider.IsBlocked(1234) {
+		t.Errorf("expected PID 1234 to be blocked, got %+v, %v", result, err)
+	}
+}
+
+func TestEventHandler_DescribeProcess(t *testing.T) {
+	handler := NewEventHandler(NewMockEBPFProvider(context.Background(), nil), EventHandlerConfig{})
+
+	event := CreateMockEvent(1234, 1000, "nginx", "/etc/shadow")
+	for tid, want := range map[uint32]string{
+		0:    "PID 1234 (nginx)",
+		1234: "PID 1234 (nginx)",
+		1240: "PID 1234 (nginx) TID 1240",
+	} {
+		event.Tid = tid
+		if got := handler.describeProcess(event, "nginx"); got != want {
+			t.Errorf("describeProcess(TID %d) = %q, want %q", tid, got, want)
+		}
+	}
+}
+
+func TestEventHandler_KernelUIDFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   EventHandlerConfig
+		expected []uint32
+	}{
+		{"no UID filter", EventHandlerConfig{}, nil},
+		{"UIDs only", EventHandlerConfig{TargetUIDs: []uint32{1000, 0}}, []uint32{0, 1000}},
+		{"UIDs and PID, all", EventHandlerConfig{TargetUIDs: []uint32{1000}, TargetPID: 1234}, []uint32{1000}},
+		{"UIDs only, any", EventHandlerConfig{TargetUIDs: []uint32{1000}, FilterMode: FilterAny}, []uint32{1000}},
+		// Another filter may admit events from any UID
+		{"UIDs and PID, any", EventHandlerConfig{TargetUIDs: []uint32{1000}, TargetPID: 1234, FilterMode: FilterAny}, nil},
+		{"UIDs and comm, any", EventHandlerConfig{TargetUIDs: []uint32{1000}, TargetComms: []string{"app"}, FilterMode: FilterAny}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			provider := NewMockEBPFProvider(ctx, nil)
+			defer provider.Close()
+
+			config := tt.config
+			config.DisallowedPatterns = []string{"/etc/*"}
+			config.Threshold = 1
+			handler := NewEventHandler(provider, config)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+			<-done
+
+			if got := provider.TargetUIDs(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected kernel UID filter %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEventHandler_EnforcementPoint(t *testing.T) {
+	tests := []struct {
+		name        string
+		point       string
+		existingFDs bool
+		expected    string
+	}{
+		// The provider denies at open unless told otherwise
+		{"default", "", false, ""},
+		{"open", EnforceOpen, false, ""},
+		{"read", EnforceRead, false, EnforceRead},
+		{"existing fds", "", true, EnforceOpenAndRead},
+		{"open with existing fds", EnforceOpen, true, EnforceOpenAndRead},
+		// Denying every read already covers existing fds
+		{"read with existing fds", EnforceRead, true, EnforceRead},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			provider := NewMockEBPFProvider(ctx, nil)
+			defer provider.Close()
+
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/*"},
+				Threshold:          1,
+				EnforcementPoint:   tt.point,
+				EnforceExistingFDs: tt.existingFDs,
+			})
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+			<-done
+
+			if got := provider.EnforcementPoint(); got != tt.expected {
+				t.Errorf("expected enforcement point %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEventHandler_EnforceReadUnsupported(t *testing.T) {
+	// Embedding only the interface hides SetEnforcementPoint
+	provider := struct{ EBPFProvider }{NewMockEBPFProvider(context.Background(), nil)}
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+		EnforcementPoint:   EnforceRead,
+	})
+
+	if err := handler.Run(context.Background()); err == nil {
+		t.Error("expected Run to fail when the provider cannot deny reads")
+	}
+}
+
+func TestEventHandler_EnforceExistingFDsUnsupported(t *testing.T) {
+	provider := struct{ EBPFProvider }{NewMockEBPFProvider(context.Background(), nil)}
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+		EnforceExistingFDs: true,
+	})
+
+	if err := handler.Run(context.Background()); err == nil {
+		t.Error("expected Run to fail when the provider cannot deny reads through existing fds")
+	}
+}
+
+func TestEventHandler_FailedOpens(t *testing.T) {
+	tests := []struct {
+		name                string
+		successfulOpensOnly bool
+		expectedViolations  uint32
+		expectedBlocked     bool
+	}{
+		{"only successful opens", true, 1, false},
+		{"failed opens counted", false, 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// The first open fails with ENOENT, the second returns an fd
+			missing := CreateMockEvent(1234, 1000, "cat", "/etc/missing")
+			events := []*Event{missing, CreateMockEvent(1234, 1000, "cat", "/etc/passwd")}
+			provider := NewMockEBPFProvider(ctx, events)
+			provider.MarkOpenFailed(missing)
+			defer provider.Close()
+
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns:  []string{"/etc/*"},
+				Threshold:           2,
+				SuccessfulOpensOnly: tt.successfulOpensOnly,
+			})
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+			<-done
+
+			if got := handler.GetViolationCountForPID(1234); got != tt.expectedViolations {
+				t.Errorf("expected %d violations, got %d", tt.expectedViolations, got)
+			}
+			if got := provider.IsBlocked(1234); got != tt.expectedBlocked {
+				t.Errorf("expected blocked=%v, got %v", tt.expectedBlocked, got)
+			}
+		})
+	}
+}
+
+func TestEventHandler_EventsDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{CreateMockEvent(1234, 1000, "cat", "/etc/passwd")}
+	provider := NewMockEBPFProvider(ctx, events)
+	provider.QueueReadErrors(ErrEventsDisabled)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+		MaxReadErrors:      1,
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	// The sentinel is not a read error, and reading stops until cancelled
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	if err := <-done; err != nil && err != context.Canceled {
+		t.Errorf("expected a clean stop, got %v", err)
+	}
+	if got := handler.Stats().EventsRead; got != 0 {
+		t.Errorf("expected no events to be read, got %d", got)
+	}
+}
+
+func TestEventHandler_AllowedComms(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := NewMockEBPFProvider(ctx, nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+		AllowedComms:       []string{"systemd-journald"},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	// The provider drops the events in the kernel
+	if comms := provider.AllowedComms(); !reflect.DeepEqual(comms, []string{"systemd-journal"}) {
+		t.Errorf("expected the provider to drop systemd-journal, got %v", comms)
+	}
+
+	// and the handler drops any that get through
+	if _, err := handler.processEvent(CreateMockEvent(1234, 0, "systemd-journal", "/etc/shadow")); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if provider.IsBlocked(1234) {
+		t.Error("expected an allowed command not to be blocked")
+	}
+	if _, err := handler.processEvent(CreateMockEvent(5678, 0, "cat", "/etc/shadow")); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if !provider.IsBlocked(5678) {
+		t.Error("expected other commands to still be blocked")
+	}
+}
+
+func TestEventHandler_SampleRate(t *testing.T) {
+	events := []*Event{
+		CreateMockEvent(1, 0, "cat", "/etc/shadow"),
+		CreateMockEvent(2, 0, "cat", "/etc/shadow"),
+		CreateMockEvent(3, 0, "cat", "/etc/shadow"),
+		CreateMockEvent(4, 0, "cat", "/etc/shadow"),
+	}
+	config := EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+		SampleRate:         2,
+	}
+
+	t.Run("kernel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		provider := NewMockEBPFProvider(ctx, events)
+		defer provider.Close()
+
+		handler := NewEventHandler(provider, config)
+		go handler.Run(ctx)
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+
+		if rate := provider.SampleRate(); rate != 2 {
+			t.Errorf("expected the provider to sample 1 in 2, got %d", rate)
+		}
+		if got := handler.Stats().EventsRead; got != 2 {
+			t.Errorf("expected 2 sampled events to be read, got %d", got)
+		}
+	})
+
+	t.Run("userspace", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		mock := NewMockEBPFProvider(ctx, events)
+		defer mock.Close()
+
+		// Hide SetSampleRate, so the handler has to sample
+		handler := NewEventHandler(struct{ EBPFProvider }{mock}, config)
+		go handler.Run(ctx)
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+
+		for pid, blocked := range map[uint32]bool{1: true, 2: false, 3: true, 4: false} {
+			if mock.IsBlocked(pid) != blocked {
+				t.Errorf("PID %d: expected blocked=%v", pid, blocked)
+			}
+		}
+	})
+}
+
+func TestEventHandler_Policies(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          10,
+		Policies: []Policy{
+			{Name: "web", Patterns: []string{"/etc/*", "/var/www/secrets/*"}, Threshold: 1, UIDs: []uint32{33}},
+			{Name: "batch", Patterns: []string{"/etc/shadow"}, Threshold: 3, PIDs: []uint32{2000, 2001}},
+		},
+	})
+
+	send := func(pid, uid uint32, filename string) ProcessResult {
+		t.Helper()
+		result, err := handler.processEvent(CreateMockEvent(pid, uid, "app", filename))
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		return result
+	}
+
+	// The strict web policy blocks on the first violation, including its
+	// own patterns
+	if result := send(1000, 33, "/var/www/secrets/db.key"); !result.Blocked {
+		t.Error("expected the web policy to block on the first violation")
+	}
+
+	// The lenient batch policy only cares about /etc/shadow, three times
+	if result := send(2000, 1000, "/etc/passwd"); result.Matched {
+		t.Error("/etc/passwd is not disallowed by the batch policy")
+	}
+	for i := 0; i < 2; i++ {
+		send(2000, 1000, "/etc/shadow")
+	}
+	if handler.IsPIDBlocked(2000) {
+		t.Error("batch PID blocked before its threshold")
+	}
+	if result := send(2000, 1000, "/etc/shadow"); !result.Blocked {
+		t.Error("expected the batch PID to be blocked at its threshold")
+	}
+	send(2001, 1000, "/etc/shadow")
+	if handler.IsPIDBlocked(2001) {
+		t.Error("each batch PID tracks its own violations")
+	}
+
+	// Everything else falls back to the top-level patterns and threshold
+	if result := send(3000, 1000, "/var/www/secrets/db.key"); result.Matched {
+		t.Error("the default policy does not disallow web secrets")
+	}
+	send(3000, 1000, "/etc/passwd")
+	if handler.IsPIDBlocked(3000) {
+		t.Error("the default policy should not block below its threshold")
+	}
+
+	expected := map[string]PolicyStats{
+		"web":     {Violations: 1, Blocks: 1},
+		"batch":   {Violations: 4, Blocks: 1},
+		"default": {Violations: 1, Blocks: 0},
+	}
+	stats := handler.PolicyStats()
+	if len(stats) != len(expected) {
+		t.Errorf("expected stats for %d policies, got %v", len(expected), stats)
+	}
+	for name, want := range expected {
+		if got := stats[name]; got != want {
+			t.Errorf("policy %s: expected %+v, got %+v", name, want, got)
+		}
+	}
+}
+
+func TestEventHandler_PoliciesFirstMatchWins(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	// Both policies select UID 1000; the first one configured applies
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		Threshold: 10,
+		Policies: []Policy{
+			{Name: "first", Patterns: []string{"/etc/*"}, Threshold: 5, UIDs: []uint32{1000}},
+			{Name: "second", Patterns: []string{"/etc/*"}, Threshold: 1, UIDs: []uint32{1000}},
+		},
+	})
+
+	result, err := handler.processEvent(CreateMockEvent(1234, 1000, "app", "/etc/passwd"))
+	if err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if result.Blocked {
+		t.Error("expected the first policy's threshold to apply")
+	}
+	if stats := handler.PolicyStats(); stats["first"].Violations != 1 || stats["second"].Violations != 0 {
+		t.Errorf("expected the violation to be attributed to the first policy, got %v", stats)
+	}
+}
+
+// fakeFd makes fd of pid in a fakeProc tree point at target
+func fakeFd(t *testing.T, proc procFS, pid, fd uint32, target string) {
+	t.Helper()
+	dir := filepath.Join(proc.root, strconv.FormatUint(uint64(pid), 10), "fd")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("create fake fd dir: %v", err)
+	}
+	if err := os.Symlink(target, filepath.Join(dir, strconv.FormatUint(uint64(fd), 10))); err != nil {
+		t.Fatalf("create fake fd: %v", err)
+	}
+}
+
+// fakeCmdline sets the cmdline of pid in a fakeProc tree
+func fakeCmdline(t *testing.T, proc procFS, pid uint32, cmdline string) {
+	t.Helper()
+	path := filepath.Join(proc.root, strconv.FormatUint(uint64(pid), 10), "cmdline")
+	if err := os.WriteFile(path, []byte(cmdline), 0644); err != nil {
+		t.Fatalf("create fake cmdline: %v", err)
+	}
+}
+
+func TestEventHandler_ResolveFullComm(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          2,
+		ResolveFullComm:    true,
+	})
+	handler.proc = fakeProc(t, map[uint32]string{
+		1000: "systemd-journal",
+		2000: "unrelated-name1",
+	})
+	fakeCmdline(t, handler.proc, 1000, "/usr/lib/systemd/systemd-journald\x00")
+	fakeCmdline(t, handler.proc, 2000, "/usr/bin/python3\x00script.py\x00")
+
+	tests := []struct {
+		pid      uint32
+		comm     string
+		expected string
+	}{
+		// Truncated comms are enriched from argv[0]
+		{1000, "systemd-journal", "systemd-journald"},
+		// argv[0] must extend the comm, otherwise the comm is kept
+		{2000, "unrelated-name1", "unrelated-name1"},
+		// Short comms were never truncated
+		{1000, "cat", "cat"},
+		// Missing processes keep their comm
+		{3000, "long-process-na", "long-process-na"},
+	}
+	for _, tt := range tests {
+		if got := handler.commOf(CreateMockEvent(tt.pid, 0, tt.comm, "/etc/passwd")); got != tt.expected {
+			t.Errorf("commOf(%d, %q) = %q, want %q", tt.pid, tt.comm, got, tt.expected)
+		}
+	}
+
+	// The resolved name is cached per PID
+	fakeCmdline(t, handler.proc, 1000, "/usr/bin/other\x00")
+	if got := handler.commOf(CreateMockEvent(1000, 0, "systemd-journal", "/etc/passwd")); got != "systemd-journald" {
+		t.Errorf("expected the cached name, got %q", got)
+	}
+
+	// Without the option the comm is used as reported
+	handler.config.ResolveFullComm = false
+	if got := handler.commOf(CreateMockEvent(1000, 0, "systemd-journal", "/etc/passwd")); got != "systemd-journal" {
+		t.Errorf("expected the raw comm, got %q", got)
+	}
+}
+
+func TestEventHandler_ByteThreshold(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow"},
+		Threshold:          100,
+		ByteThreshold:      8192,
+	})
+	handler.proc = fakeProc(t, map[uint32]string{1234: "cat"})
+	fakeFd(t, handler.proc, 1234, 3, "/etc/shadow")
+	fakeFd(t, handler.proc, 1234, 4, "/etc/hostname")
+
+	// Reads of files that are not disallowed are not accumulated
+	if _, err := handler.processEvent(CreateMockReadEvent(1234, 1000, "cat", 4, 1<<20)); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if got := handler.GetBytesReadForPID(1234, "/etc/hostname"); got != 0 {
+		t.Errorf("expected no bytes counted for /etc/hostname, got %d", got)
+	}
+
+	// Reads accumulate until the threshold is exceeded
+	for i, expectBlocked := range []bool{false, false, true} {
+		result, err := handler.processEvent(CreateMockReadEvent(1234, 1000, "cat", 3, 4096))
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		if !result.Matched || !result.Counted {
+			t.Errorf("read %d: expected a counted match, got %+v", i, result)
+		}
+		if result.Blocked != expectBlocked {
+			t.Errorf("read %d: expected blocked=%v, got %v", i, expectBlocked, result.Blocked)
+		}
+	}
+
+	if got := handler.GetBytesReadForPID(1234, "/etc/shadow"); got != 3*4096 {
+		t.Errorf("expected %d bytes read, got %d", 3*4096, got)
+	}
+	if !provider.IsBlocked(1234) {
+		t.Error("expected PID 1234 to be blocked in the provider")
+	}
+	// Reads are not open violations
+	if handler.GetViolationCount() != 0 {
+		t.Errorf("expected no violations, got %d", handler.GetViolationCount())
+	}
+}
+
+func TestEventHandler_RenameRelativePath(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          10,
+		GlobOnly:           true,
+	})
+	handler.proc = fakeProc(t, map[uint32]string{1234: "mv"})
+	if err := os.Symlink("/etc", filepath.Join(handler.proc.root, "1234", "cwd")); err != nil {
+		t.Fatal(err)
+	}
+	fakeFd(t, handler.proc, 1234, 5, "/etc")
+
+	tests := []struct {
+		name     string
+		filename string
+		dirfd    uint32
+		counted  bool
+	}{
+		{"relative to the working directory", "shadow", uint32(0xffffff9c), true},
+		{"relative to a directory fd", "passwd", 5, true},
+		{"absolute", "/etc/group", 5, true},
+		{"unknown directory fd", "hosts", 9, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := CreateMockEvent(1234, 1000, "mv", tt.filename)
+			event.Type = EventRename
+			event.Fd = tt.dirfd
+			result, err := handler.processEvent(event)
+			if err != nil {
+				t.Fatalf("processEvent: %v", err)
+			}
+			if result.Counted != tt.counted {
+				t.Errorf("expected counted=%v, got %v", tt.counted, result.Counted)
+			}
+		})
+	}
+}
+
+func TestEventHandler_ByteThresholdFdReused(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	dir := t.TempDir()
+	secret, other := filepath.Join(dir, "secret"), filepath.Join(dir, "other")
+	for _, path := range []string{secret, other} {
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secretID, err := resolveInode(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID, err := resolveInode(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{secret},
+		Threshold:          100,
+		ByteThreshold:      8192,
+	})
+	handler.proc = fakeProc(t, map[uint32]string{1234: "cat"})
+	fakeFd(t, handler.proc, 1234, 3, secret)
+
+	// The kernel saw the read on another file, and fd 3 was reused since
+	read := CreateMockReadEvent(1234, 1000, "cat", 3, 1<<20)
+	read.Dev, read.Ino = otherID.Dev, otherID.Ino
+	result, err := handler.processEvent(read)
+	if err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if result.Matched || provider.IsBlocked(1234) {
+		t.Errorf("expected a read of another file not to count, got %+v", result)
+	}
+
+	read.Dev, read.Ino = secretID.Dev, secretID.Ino
+	if result, err = handler.processEvent(read); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if !result.Blocked {
+		t.Errorf("expected the read of %s to block, got %+v", secret, result)
+	}
+}
+
+func TestEventHandler_ByteThresholdDisabled(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow"},
+		Threshold:          1,
+	})
+	handler.proc = fakeProc(t, map[uint32]string{1234: "cat"})
+	fakeFd(t, handler.proc, 1234, 3, "/etc/shadow")
+
+	result, err := handler.processEvent(CreateMockReadEvent(1234, 1000, "cat", 3, 1<<20))
+	if err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if result.Matched || result.Blocked {
+		t.Errorf("expected reads to be ignored without ByteThreshold, got %+v", result)
+	}
+	// A read event has no filename, but must not count as malformed
+	if handler.GetMalformedEventCount() != 0 {
+		t.Errorf("expected no malformed events, got %d", handler.GetMalformedEventCount())
+	}
+}
+
+func TestEventHandler_TrustedParents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := NewMockEBPFProvider(ctx, nil)
+	defer provider.Close()
+
+	config := EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+		TrustedParentComms: []string{"sshd"},
+	}
+
+	handler := NewEventHandler(provider, config)
+	handler.proc = fakeProc(t, map[uint32]string{100: "sshd", 200: "bash", 1: "systemd"})
+
+	send := func(pid, ppid uint32) {
+		event := CreateMockEvent(pid, 1000, "app", "/etc/passwd")
+		event.Ppid = ppid
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	// Child of sshd is not counted
+	send(1000, 100)
+	if handler.GetViolationCountForPID(1000) != 0 {
+		t.Errorf("child of trusted parent should not be counted, got %d", handler.GetViolationCountForPID(1000))
+	}
+
+	// Child of an untrusted parent is counted and blocked
+	send(2000, 200)
+	if !handler.IsPIDBlocked(2000) {
+		t.Error("child of untrusted parent should be blocked")
+	}
+
+	// A parent that has exited (no /proc entry) is not trusted
+	send(3000, 999)
+	if !handler.IsPIDBlocked(3000) {
+		t.Error("child of exited parent should be blocked")
+	}
+
+	// The trust decision is cached per PID
+	os.RemoveAll(filepath.Join(handler.proc.root, "100"))
+	send(1000, 100)
+	if handler.GetViolationCountForPID(1000) != 0 {
+		t.Error("expected cached trusted parent to still apply")
+	}
+
+	// Reparenting to an untrusted parent invalidates the cache
+	send(1000, 1)
+	if !handler.IsPIDBlocked(1000) {
+		t.Error("expected reparented PID to be counted and blocked")
+	}
+}
+
+func TestEventHandler_TrustedParentsPIDReuse(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+		TrustedParentComms: []string{"sshd"},
+	})
+	handler.proc = fakeProc(t, map[uint32]string{100: "sshd", 200: "bash"})
+
+	send := func(pid, ppid uint32, start uint64) {
+		event := CreateMockEvent(pid, 1000, "app", "/etc/passwd")
+		event.Ppid = ppid
+		event.StartTime = start
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	send(1000, 100, 1)
+	if handler.GetViolationCountForPID(1000) != 0 {
+		t.Fatal("child of trusted parent should not be counted")
+	}
+
+	// PID 1000 is reused by another process, whose parent 100 is no longer
+	// sshd either
+	os.WriteFile(filepath.Join(handler.proc.root, "100", "comm"), []byte("bash\n"), 0644)
+	send(1000, 100, 2)
+	if !handler.IsPIDBlocked(1000) {
+		t.Error("expected the reused PID not to inherit the trusted verdict")
+	}
+
+	// The cache stays bounded however many processes come and go
+	for pid := uint32(0); pid < maxCachedParents+10; pid++ {
+		handler.hasTrustedParent(&Event{Pid: 10000 + pid, Ppid: 200})
+	}
+	if len(handler.parents) > maxCachedParents {
+		t.Errorf("parents cache holds %d entries, want at most %d", len(handler.parents), maxCachedParents)
+	}
+}
+
+func TestEventHandler_SelfMonitoring(t *testing.T) {
+	selfPID := uint32(os.Getpid())
+
+	tests := []struct {
+		name        string
+		monitorSelf bool
+		expected    uint32
+	}{
+		{name: "own PID excluded by default", monitorSelf: false, expected: 0},
+		// Only the non-routine open counts
+		{name: "monitor self skips routine opens", monitorSelf: true, expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Every open matches the broad patterns
+			events := []*Event{
+				CreateMockEvent(selfPID, 0, "ebpfence", "/sys/kernel/btf/vmlinux"),
+				CreateMockEvent(selfPID, 0, "ebpfence", "/proc/1234/comm"),
+				CreateMockEvent(selfPID, 0, "ebpfence", "/var/log/ebpfence/audit.jsonl"),
+				CreateMockEvent(selfPID, 0, "ebpfence", "/etc/passwd"),
+			}
+
+			provider := NewMockEBPFProvider(ctx, events)
+			defer provider.Close()
+
+			config := EventHandlerConfig{
+				DisallowedPatterns: []string{"/sys/*", "/proc/**", "/var/log/**", "/etc/*"},
+				Threshold:          1,
+				AuditLogPath:       "/var/log/ebpfence/audit.jsonl",
+				MonitorSelf:        tt.monitorSelf,
+			}
+
+			handler := NewEventHandler(provider, config)
+			handler.auditLog = newAuditLogger(&memAuditStore{}, 0, false)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			<-done
+
+			if got := handler.GetViolationCountForPID(selfPID); got != tt.expected {
+				t.Errorf("expected %d violations for own PID, got %d", tt.expected, got)
+			}
+			if !tt.monitorSelf && provider.IsBlocked(selfPID) {
+				t.Error("the fence must never block itself by default")
+			}
+		})
+	}
+}
+
+// countingInodeProvider counts BlockInode calls
+type countingInodeProvider struct {
+	*MockEBPFProvider
+	calls int
+}
+
+func (p *countingInodeProvider) BlockInode(dev, ino uint64) error {
+	p.calls++
+	return p.MockEBPFProvider.BlockInode(dev, ino)
+}
+
+func TestEventHandler_BlockFile(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("s3cr3t"), 0600); err != nil {
+		t.Fatalf("create file: %v", err)
+	}
+	hardlink := filepath.Join(dir, "hardlink")
+	if err := os.Link(secret, hardlink); err != nil {
+		t.Fatalf("create hardlink: %v", err)
+	}
+	id, err := resolveInode(secret)
+	if err != nil {
+		t.Fatalf("resolveInode: %v", err)
+	}
+
+	provider := &countingInodeProvider{MockEBPFProvider: NewMockEBPFProvider(context.Background(), nil)}
+	defer provider.Close()
+	handler := NewEventHandler(provider, EventHandlerConfig{Threshold: 1})
+
+	if err := handler.BlockFile(secret); err != nil {
+		t.Fatalf("BlockFile: %v", err)
+	}
+	if !handler.IsInodeBlocked(id) || !provider.IsInodeBlocked(id) {
+		t.Error("expected the file's inode to be blocked")
+	}
+
+	// Blocking another name of the same file is a no-op
+	if err := handler.BlockFile(hardlink); err != nil {
+		t.Fatalf("BlockFile(hardlink): %v", err)
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected 1 BlockInode call, got %d", provider.calls)
+	}
+
+	if err := handler.BlockFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestEventHandler_DedupByInode(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	other := filepath.Join(dir, "other")
+	for _, path := range []string{secret, other} {
+		if err := os.WriteFile(path, []byte("s3cr3t"), 0600); err != nil {
+			t.Fatalf("create file: %v", err)
+		}
+	}
+	symlink := filepath.Join(dir, "symlink")
+	if err := os.Symlink(secret, symlink); err != nil {
+		t.Fatalf("create symlink: %v", err)
+	}
+	hardlink := filepath.Join(dir, "hardlink")
+	if err := os.Link(secret, hardlink); err != nil {
+		t.Fatalf("create hardlink: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		dedup    bool
+		expected uint32
+	}{
+		{"disabled", false, 5},
+		// secret, its symlink and hardlink are one file; other is another
+		{"enabled", true, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewMockEBPFProvider(context.Background(), nil)
+			defer provider.Close()
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns: []string{dir + "/*"},
+				Threshold:          10,
+				DedupByInode:       tt.dedup,
+			})
+			// Paths are resolved as the process sees them, through its root
+			handler.proc = fakeProc(t, map[uint32]string{1234: "app", 5678: "app"})
+			for _, pid := range []string{"1234", "5678"} {
+				if err := os.Symlink("/", filepath.Join(handler.proc.root, pid, "root")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, path := range []string{secret, symlink, hardlink, secret, other} {
+				if _, err := handler.processEvent(CreateMockEvent(1234, 1000, "app", path)); err != nil {
+					t.Fatalf("processEvent(%s): %v", path, err)
+				}
+			}
+			// Each PID is deduplicated separately
+			if _, err := handler.processEvent(CreateMockEvent(5678, 1000, "app", symlink)); err != nil {
+				t.Fatalf("processEvent: %v", err)
+			}
+
+			if got := handler.GetViolationCountForPID(1234); got != tt.expected {
+				t.Errorf("expected %d violations, got %d", tt.expected, got)
+			}
+			if got := handler.GetViolationCountForPID(5678); got != 1 {
+				t.Errorf("expected 1 violation for the second PID, got %d", got)
+			}
+		})
+	}
+}
+
+func TestEventHandler_DedupByInodeProcessRoot(t *testing.T) {
+	// The process sees a different file at the same path, e.g. in its own
+	// mount namespace: both its files are counted, not the fence's
+	dir := t.TempDir()
+	hostFile := filepath.Join(dir, "secret")
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{hostFile, filepath.Join(root, dir, "secret"), filepath.Join(root, dir, "other")} {
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(hostFile, filepath.Join(dir, "other")); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{dir + "/*"},
+		Threshold:          10,
+		DedupByInode:       true,
+	})
+	handler.proc = fakeProc(t, map[uint32]string{1234: "app"})
+	if err := os.Symlink(root, filepath.Join(handler.proc.root, "1234", "root")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"secret", "other"} {
+		if _, err := handler.processEvent(CreateMockEvent(1234, 1000, "app", filepath.Join(dir, name))); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+	if got := handler.GetViolationCountForPID(1234); got != 2 {
+		t.Errorf("expected 2 violations, got %d", got)
+	}
+}
+
+func TestEventHandler_RunBlockedFiles(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	provider := NewMockEBPFProvider(ctx, nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		Threshold:    1,
+		BlockedFiles: []string{filepath.Join(t.TempDir(), "missing")},
+	})
+
+	// A file that cannot be blocked must stop the fence rather than be
+	// silently left unprotected
+	if err := handler.Run(ctx); err == nil {
+		t.Error("expected Run to fail for a missing blocked file")
+	}
+}
+
+func TestEventHandler_ReportOnlyPIDs(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Rules:              []Rule{{Pattern: "/root/.ssh/*", Immediate: true}},
+		Threshold:          2,
+		ReportOnlyPIDs:     []uint32{1000},
+	})
+
+	for _, pid := range []uint32{1000, 2000} {
+		for _, filename := range []string{"/etc/passwd", "/etc/shadow", "/etc/group", "/root/.ssh/id_rsa"} {
+			result, err := handler.processEvent(CreateMockEvent(pid, 1000, "app", filename))
+			if err != nil {
+				t.Fatalf("processEvent: %v", err)
+			}
+			if pid == 1000 && result.Blocked {
+				t.Errorf("report-only PID was blocked on %s", filename)
+			}
+		}
+	}
+
+	// Both PIDs accrue violations, past the threshold and immediate rules
+	if got := handler.GetViolationCountForPID(1000); got != 4 {
+		t.Errorf("expected 4 violations for the report-only PID, got %d", got)
+	}
+	if handler.IsPIDBlocked(1000) || provider.IsBlocked(1000) {
+		t.Error("report-only PID must never be blocked")
+	}
+	if !handler.IsPIDBlocked(2000) || !provider.IsBlocked(2000) {
+		t.Error("expected the enforced PID to be blocked")
+	}
+}
+
+func TestEventHandler_UnblockOnExit(t *testing.T) {
+	for _, unblock := range []bool{false, true} {
+		t.Run(fmt.Sprintf("unblock=%v", unblock), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			events := []*Event{
+				CreateMockEvent(1000, 1000, "app", "/etc/passwd"),
+				CreateMockEvent(2000, 1000, "app", "/etc/shadow"),
+			}
+			provider := NewMockEBPFProvider(ctx, events)
+			defer provider.Close()
+
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/*"},
+				Threshold:          1,
+				UnblockOnExit:      unblock,
+			})
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+
+			deadline := time.Now().Add(time.Second)
+			for len(handler.GetBlockedPIDs()) < 2 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			cancel()
+			<-done
+
+			for _, pid := range []uint32{1000, 2000} {
+				if provider.IsBlocked(pid) == unblock {
+					t.Errorf("PID %d: expected blocked=%v after shutdown", pid, !unblock)
+				}
+			}
+			// The report and state file are written after Run returns
+			if got := handler.GetBlockedPIDs(); len(got) != 2 {
+				t.Errorf("expected both blocks to stay recorded for the report, got %v", got)
+			}
+			if got := handler.GetViolationCount(); got != 2 {
+				t.Errorf("expected 2 violations to stay recorded, got %d", got)
+			}
+		})
+	}
+}
+
+// deadlineProvider is a provider whose ReadEvent, like the ring buffer's,
+// blocks until its read deadline when there are no events
+type deadlineProvider struct {
+	*MockEBPFProvider
+	deadline chan time.Time
+}
+
+func (p *deadlineProvider) SetReadDeadline(t time.Time) {
+	p.deadline <- t
+}
+
+func (p *deadlineProvider) ReadEvent() (*Event, error) {
+	time.Sleep(time.Until(<-p.deadline))
+	return nil, fmt.Errorf("reading from ring buffer: %w", os.ErrDeadlineExceeded)
+}
+
+func TestEventHandler_EventRateWindowExpiry(t *testing.T) {
+	tests := []struct {
+		name            string
+		spacing         time.Duration
+		expectDefensive bool
+	}{
+		// Three events within one window exceed the ceiling of two
+		{name: "events within a window", spacing: 300 * time.Millisecond, expectDefensive: true},
+		// The window expires before a third event lands in it
+		{name: "events spread across windows", spacing: 600 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var events []*Event
+			var delays []time.Duration
+			for i := 0; i < 4; i++ {
+				events = append(events, CreateMockEvent(1234, 1000, "app", "/tmp/file"))
+				delays = append(delays, tt.spacing)
+			}
+
+			clock := newFakeClock(time.Unix(1000, 0))
+			provider := NewMockEBPFProviderWithDelays(ctx, events, delays)
+			provider.wait = clock.Advance
+			defer provider.Close()
+
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/*"},
+				Threshold:          5,
+				MaxEventsPerSecond: 2,
+			})
+			handler.clock = clock
+
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+
+			deadline := time.Now().Add(2 * time.Second)
+			for handler.Stats().EventsRead < uint64(len(events)) && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			cancel()
+			<-done
+
+			if got := handler.Stats().EventsRead; got != uint64(len(events)) {
+				t.Fatalf("expected %d events read, got %d", len(events), got)
+			}
+			if handler.InDefensiveMode() != tt.expectDefensive {
+				t.Errorf("expected defensive mode %v", tt.expectDefensive)
+			}
+		})
+	}
+}
+
+func TestEventHandler_RunDeadline(t *testing.T) {
+	provider := &deadlineProvider{
+		MockEBPFProvider: NewMockEBPFProvider(context.Background(), nil),
+		deadline:         make(chan time.Time, 1),
+	}
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+	})
+	if _, err := handler.processEvent(CreateMockEvent(1234, 1000, "app", "/etc/passwd")); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected a clean return at the deadline, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return after its deadline")
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Errorf("expected Run to return near its 200ms deadline, took %v", elapsed)
+	}
+
+	summary := handler.Stats().String()
+	if !strings.Contains(summary, "events=1 violations=1 blocked=1") {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
+
+func TestEventHandler_Watchdog(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+		WatchdogTimeout:    time.Minute,
+	})
+	clock := newFakeClock(time.Unix(1000, 0))
+	handler.clock = clock
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	handler.markRead()
+
+	// Within the timeout the read loop is alive
+	clock.Advance(59 * time.Second)
+	handler.checkWatchdog()
+	if state := handler.Watchdog(); state.Stale || provider.Reopens() != 0 {
+		t.Fatalf("expected a live read loop, got %+v with %d reopens", state, provider.Reopens())
+	}
+
+	// Past it the reader is reopened
+	clock.Advance(time.Second)
+	handler.checkWatchdog()
+	if state := handler.Watchdog(); !state.Stale || state.Reopens != 1 || provider.Reopens() != 1 {
+		t.Fatalf("expected a stale read loop and one reopen, got %+v with %d reopens", state, provider.Reopens())
+	}
+
+	// The next attempt waits for another full timeout
+	clock.Advance(30 * time.Second)
+	handler.checkWatchdog()
+	if provider.Reopens() != 1 {
+		t.Errorf("expected no reopen within the timeout of the last one, got %d", provider.Reopens())
+	}
+
+	provider.FailReopen(errors.New("reopen failed"))
+	clock.Advance(30 * time.Second)
+	handler.checkWatchdog()
+	if state := handler.Watchdog(); state.Reopens != 1 || state.ReopenFailures != 1 {
+		t.Errorf("expected one failed reopen, got %+v", state)
+	}
+
+	// A read clears the stale state
+	handler.markRead()
+	if state := handler.Watchdog(); state.Stale || !state.LastRead.Equal(clock.Now()) {
+		t.Errorf("expected a live read loop after a read, got %+v", state)
+	}
+}
+
+func TestEventHandler_ReadErrors(t *testing.T) {
+	errUnknown := errors.New("unexpected failure")
+	errTransient := fmt.Errorf("reading from ring buffer: %w", syscall.EINTR)
+	errClosed := fmt.Errorf("ring buffer closed: %w", ringbuf.ErrClosed)
+
+	tests := []struct {
+		name          string
+		errs          []error
+		maxErrors     uint32
+		expectErr     bool
+		expectSleeps  int
+		expectBlocked bool // whether the event queued after the errors is processed
+	}{
+		{
+			name:         "transient errors back off",
+			errs:         []error{errTransient, errTransient, errClosed},
+			maxErrors:    2,
+			expectSleeps: 2,
+		},
+		{
+			name:      "closed ring buffer stops",
+			errs:      []error{errClosed},
+			maxErrors: 2,
+		},
+		{
+			name:      "repeated unknown errors give up",
+			errs:      []error{errUnknown, errUnknown, errClosed},
+			maxErrors: 2,
+			expectErr: true,
+		},
+		{
+			name:          "a transient error does not count towards giving up",
+			errs:          []error{errUnknown, errTransient},
+			maxErrors:     2,
+			expectSleeps:  1,
+			expectBlocked: true,
+		},
+		{
+			name:          "unknown errors are tolerated without a limit",
+			errs:          []error{errUnknown, errUnknown, errUnknown},
+			expectBlocked: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			events := []*Event{CreateMockEvent(1234, 1000, "app", "/etc/passwd")}
+			provider := NewMockEBPFProvider(ctx, events)
+			defer provider.Close()
+			provider.QueueReadErrors(tt.errs...)
+
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/*"},
+				Threshold:          1,
+				MaxReadErrors:      tt.maxErrors,
+			})
+			var sleeps int
+			handler.sleep = func(time.Duration) { sleeps++ }
+
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(100 * time.Millisecond):
+				// Still running: the errors were survived
+				cancel()
+				err = <-done
+			}
+
+			if tt.expectErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tt.expectErr, err)
+			}
+			if tt.expectErr && !errors.Is(err, errUnknown) {
+				t.Errorf("expected the last read error to be wrapped, got %v", err)
+			}
+			if sleeps != tt.expectSleeps {
+				t.Errorf("expected %d backoff sleeps, got %d", tt.expectSleeps, sleeps)
+			}
+			if handler.IsPIDBlocked(1234) != tt.expectBlocked {
+				t.Errorf("expected event processed=%v", tt.expectBlocked)
+			}
+		})
+	}
+}
+
+// failingBlockProvider is a mock whose blocks fail
+type failingBlockProvider struct {
+	*MockEBPFProvider
+}
+
+func (failingBlockProvider) BlockPID(pid uint32) error {
+	return errors.New("blocked_pids map is full")
+}
+
+func TestEventHandler_FailClosed(t *testing.T) {
+	errUnknown := errors.New("unexpected failure")
+	errTransient := fmt.Errorf("reading from ring buffer: %w", syscall.EINTR)
+	errClosed := fmt.Errorf("ring buffer closed: %w", ringbuf.ErrClosed)
+
+	tests := []struct {
+		name          string
+		errs          []error
+		failBlock     bool
+		failClosed    bool
+		expectErr     bool
+		expectBlocked bool // whether the event queued after the errors is processed
+	}{
+		{
+			name:          "fail open logs read errors and carries on",
+			errs:          []error{errUnknown},
+			expectBlocked: true,
+		},
+		{
+			name:       "fail closed returns the read error",
+			errs:       []error{errUnknown},
+			failClosed: true,
+			expectErr:  true,
+		},
+		{
+			name:       "fail closed when the ring buffer closes while running",
+			errs:       []error{errClosed},
+			failClosed: true,
+			expectErr:  true,
+		},
+		{
+			name:          "fail closed still retries transient errors",
+			errs:          []error{errTransient},
+			failClosed:    true,
+			expectBlocked: true,
+		},
+		{
+			name:      "fail open survives a failed block",
+			failBlock: true,
+		},
+		{
+			name:       "fail closed returns a failed block",
+			failBlock:  true,
+			failClosed: true,
+			expectErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			mock := NewMockEBPFProvider(ctx, []*Event{CreateMockEvent(1234, 1000, "app", "/etc/passwd")})
+			defer mock.Close()
+			mock.QueueReadErrors(tt.errs...)
+			var provider EBPFProvider = mock
+			if tt.failBlock {
+				provider = failingBlockProvider{mock}
+			}
+
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/*"},
+				Threshold:          1,
+				FailClosed:         tt.failClosed,
+			})
+			handler.sleep = func(time.Duration) {}
+
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+
+			var err error
+			select {
+			case err = <-done:
+			case <-time.After(100 * time.Millisecond):
+				// Still running: the errors were survived
+				cancel()
+				err = <-done
+				if errors.Is(err, context.Canceled) {
+					err = nil
+				}
+			}
+
+			if tt.expectErr != (err != nil) {
+				t.Errorf("expected error %v, got %v", tt.expectErr, err)
+			}
+			if tt.expectErr && len(tt.errs) > 0 && !errors.Is(err, tt.errs[0]) {
+				t.Errorf("expected the read error to be wrapped, got %v", err)
+			}
+			if mock.IsBlocked(1234) != tt.expectBlocked {
+				t.Errorf("expected event processed=%v", tt.expectBlocked)
+			}
+		})
+	}
+}
+
+func TestEventHandler_AccessedFiles(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          100,
+	})
+
+	for _, filename := range []string{"/etc/shadow", "/etc/passwd", "/tmp/safe", "/etc/shadow", "/etc/group", "/etc/passwd"} {
+		if _, err := handler.processEvent(CreateMockEvent(1234, 1000, "app", filename)); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+	if _, err := handler.processEvent(CreateMockEvent(5678, 1000, "app", "/etc/hosts")); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+
+	// Each file is listed once, sorted, and only matched files are listed
+	expected := []string{"/etc/group", "/etc/passwd", "/etc/shadow"}
+	files := handler.GetAccessedFiles(1234)
+	if strings.Join(files, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+	if files := handler.GetAccessedFiles(5678); len(files) != 1 || files[0] != "/etc/hosts" {
+		t.Errorf("expected only /etc/hosts for PID 5678, got %v", files)
+	}
+	if files := handler.GetAccessedFiles(9999); len(files) != 0 {
+		t.Errorf("expected no files for an unknown PID, got %v", files)
+	}
+}
+
+func TestEventHandler_AccessedFilesBounded(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1000,
+	})
+
+	for i := 0; i < maxAccessedFiles*2; i++ {
+		filename := fmt.Sprintf("/etc/file%03d", i)
+		if _, err := handler.processEvent(CreateMockEvent(1234, 1000, "app", filename)); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	files := handler.GetAccessedFiles(1234)
+	if len(files) != maxAccessedFiles {
+		t.Fatalf("expected %d files, got %d", maxAccessedFiles, len(files))
+	}
+	// The first files accessed are the ones kept
+	if files[len(files)-1] != fmt.Sprintf("/etc/file%03d", maxAccessedFiles-1) {
+		t.Errorf("unexpected last file %s", files[len(files)-1])
+	}
+}
+
+// failingUnblockProvider fails to unblock specific PIDs
+type failingUnblockProvider struct {
+	*MockEBPFProvider
+	failPIDs map[uint32]bool
+}
+
+func (p *failingUnblockProvider) UnblockPID(pid uint32) error {
+	if p.failPIDs[pid] {
+		return fmt.Errorf("map delete failed")
+	}
+	return p.MockEBPFProvider.UnblockPID(pid)
+}
+
+func TestEventHandler_RecordsViolationCounts(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          5,
+	})
+	for _, filename := range []string{"/etc/passwd", "/etc/shadow"} {
+		if _, err := handler.processEvent(CreateMockEvent(1000, 1000, "app", filename)); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+	if got := provider.ViolationCount(1000); got != 2 {
+		t.Errorf("expected the provider to hold 2 violations for PID 1000, got %d", got)
+	}
+
+	if err := handler.Reset(false); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if got := provider.ViolationCount(1000); got != 0 {
+		t.Errorf("expected Reset to clear the provider's count, got %d", got)
+	}
+}
+
+func TestEventHandler_Reset(t *testing.T) {
+	blockAll := func(t *testing.T, handler *EventHandler) {
+		t.Helper()
+		for _, pid := range []uint32{1000, 2000, 3000} {
+			if _, err := handler.processEvent(CreateMockEvent(pid, 1000, "app", "/etc/passwd")); err != nil {
+				t.Fatalf("processEvent: %v", err)
+			}
+		}
+		if len(handler.GetBlockedPIDs()) != 3 {
+			t.Fatalf("expected 3 blocked PIDs before reset, got %d", len(handler.GetBlockedPIDs()))
+		}
+	}
+
+	config := EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+	}
+
+	t.Run("reset and unblock", func(t *testing.T) {
+		provider := NewMockEBPFProvider(context.Background(), nil)
+		handler := NewEventHandler(provider, config)
+		blockAll(t, handler)
+		// Blocked by other means, e.g. the block command
+		if err := provider.BlockPID(4000); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := handler.Reset(true); err != nil {
+			t.Fatalf("Reset: %v", err)
+		}
+
+		if handler.GetViolationCount() != 0 || handler.IsBlocked() {
+			t.Error("expected handler state to be cleared")
+		}
+		for _, pid := range []uint32{1000, 2000, 3000, 4000} {
+			if provider.IsBlocked(pid) {
+				t.Errorf("PID %d should be unblocked in provider", pid)
+			}
+		}
+	})
+
+	t.Run("reset keeps kernel blocks", func(t *testing.T) {
+		provider := NewMockEBPFProvider(context.Background(), nil)
+		handler := NewEventHandler(provider, config)
+		blockAll(t, handler)
+
+		if err := handler.Reset(false); err != nil {
+			t.Fatalf("Reset: %v", err)
+		}
+
+		if handler.GetViolationCount() != 0 || handler.IsBlocked() {
+			t.Error("expected handler state to be cleared")
+		}
+		if !provider.IsBlocked(1000) {
+			t.Error("PID 1000 should still be blocked in provider")
+		}
+	})
+
+	t.Run("unblock failures are aggregated", func(t *testing.T) {
+		provider := &failingUnblockProvider{
+			MockEBPFProvider: NewMockEBPFProvider(context.Background(), nil),
+			failPIDs:         map[uint32]bool{2000: true, 3000: true},
+		}
+		handler := NewEventHandler(provider, config)
+		blockAll(t, handler)
+
+		err := handler.Reset(true)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		for _, pid := range []string{"2000", "3000"} {
+			if !strings.Contains(err.Error(), "unblock PID "+pid) {
+				t.Errorf("expected error to mention PID %s: %v", pid, err)
+			}
+		}
+
+		if handler.GetViolationCount() != 0 {
+			t.Error("expected violation counts to be cleared")
+		}
+		// PIDs still blocked in the kernel stay recorded
+		if handler.IsPIDBlocked(1000) || !handler.IsPIDBlocked(2000) || !handler.IsPIDBlocked(3000) {
+			t.Errorf("unexpected blocked PIDs after partial reset: %v", handler.GetBlockedPIDs())
+		}
+	})
+}
+
+func TestEventHandler_IgnoreDirectoryOpens(t *testing.T) {
+	createDirEvent := func(pid uint32, filename string) *Event {
+		event := CreateMockEvent(pid, 1000, "ls", filename)
+		event.Flags = syscall.O_RDONLY | syscall.O_DIRECTORY | syscall.O_CLOEXEC
+		return event
+	}
+
+	events := []*Event{
+		createDirEvent(1234, "/etc"),
+		createDirEvent(1234, "/etc/ssl"),
+		CreateMockEvent(1234, 1000, "ls", "/etc/passwd"),
+	}
+
+	tests := []struct {
+		name     string
+		ignore   bool
+		expected uint32
+	}{
+		{name: "directory opens ignored", ignore: true, expected: 1},
+		{name: "directory opens counted", ignore: false, expected: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			provider := NewMockEBPFProvider(ctx, events)
+			defer provider.Close()
+
+			config := EventHandlerConfig{
+				DisallowedPatterns:   []string{"/etc"},
+				Threshold:            10,
+				IgnoreDirectoryOpens: tt.ignore,
+			}
+
+			handler := NewEventHandler(provider, config)
+
+			done := make(chan error, 1)
+			go func() {
+				done <- handler.Run(ctx)
+			}()
+
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+			<-done
+
+			if got := handler.GetViolationCountForPID(1234); got != tt.expected {
+				t.Errorf("expected %d violations, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEventHandler_ProcessResult(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+
+	config := EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*", "secret"},
+		Rules:              []Rule{{Pattern: "id_rsa", Immediate: true}},
+		Threshold:          2,
+		TargetPID:          0,
+		ExcludePIDs:        []uint32{99},
+	}
+
+	handler := NewEventHandler(provider, config)
+
+	// Events are processed in order, so earlier ones affect later results
+	tests := []struct {
+		name     string
+		event    *Event
+		expected ProcessResult
+	}{
+		{
+			name:     "no match",
+			event:    CreateMockEvent(1000, 1000, "app", "/tmp/safe.txt"),
+			expected: ProcessResult{},
+		},
+		{
+			name:     "excluded PID",
+			event:    CreateMockEvent(99, 1000, "app", "/etc/passwd"),
+			expected: ProcessResult{},
+		},
+		{
+			name:     "first violation",
+			event:    CreateMockEvent(1000, 1000, "app", "/etc/passwd"),
+			expected: ProcessResult{Matched: true, Counted: true, MatchedPattern: "/etc/*", MatchKind: MatchGlob},
+		},
+		{
+			name:     "violation reaching threshold",
+			event:    CreateMockEvent(1000, 1000, "app", "/home/secret"),
+			expected: ProcessResult{Matched: true, Counted: true, Blocked: true, MatchedPattern: "secret", MatchKind: MatchSubstring},
+		},
+		{
+			name:     "violation after block",
+			event:    CreateMockEvent(1000, 1000, "app", "/etc/shadow"),
+			expected: ProcessResult{Matched: true, Counted: true, MatchedPattern: "/etc/*", MatchKind: MatchGlob},
+		},
+		{
+			name:     "immediate rule",
+			event:    CreateMockEvent(2000, 1000, "app", "/root/.ssh/id_rsa"),
+			expected: ProcessResult{Matched: true, Counted: true, Blocked: true, MatchedPattern: "id_rsa", MatchKind: MatchSubstring},
+		},
+	}
+
+	for _, tt := range tests {
+		result, err := handler.processEvent(tt.event)
+		if err != nil {
+			t.Fatalf("%s: processEvent: %v", tt.name, err)
+		}
+		if result != tt.expected {
+			t.Errorf("%s: got %+v, want %+v", tt.name, result, tt.expected)
+		}
+	}
+}
+
+func TestEventHandler_QuotePaths(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{"plain", "/etc/shadow", `"/etc/shadow"`},
+		{"newline", "/tmp/x\n[VIOLATION 1/1] PID 1", `"/tmp/x\n[VIOLATION 1/1] PID 1"`},
+		{"tab", "/tmp/a\tb", `"/tmp/a\tb"`},
+		{"escape sequence", "/tmp/\x1b[2Jx", `"/tmp/\x1b[2Jx"`},
+		{"multibyte", "/data/über/日本.txt", `"/data/über/日本.txt"`},
+	}
+
+	quoting := NewEventHandler(NewMockEBPFProvider(context.Background(), nil), EventHandlerConfig{QuotePaths: true})
+	plain := NewEventHandler(NewMockEBPFProvider(context.Background(), nil), EventHandlerConfig{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quoting.displayPath(tt.path); got != tt.expected {
+				t.Errorf("displayPath(%q) = %s, want %s", tt.path, got, tt.expected)
+			}
+			if got := plain.displayPath(tt.path); got != tt.path {
+				t.Errorf("without QuotePaths, displayPath(%q) = %q", tt.path, got)
+			}
+		})
+	}
+
+	// Truncation happens before quoting, so the quotes are always kept
+	quoting.config.MaxPathDisplay = 16
+	if got, want := quoting.displayPath("/var/lib/app/secrets/token"), `"/var/li.../token"`; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestTruncatePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		max      int
+		expected string
+	}{
+		{"disabled", "/var/lib/app/secrets/token", 0, "/var/lib/app/secrets/token"},
+		{"fits", "/etc/shadow", 11, "/etc/shadow"},
+		{"keeps leading dir and basename", "/var/lib/docker/overlay2/abc/merged/etc/shadow", 24, "/var/lib/docke.../shadow"},
+		{"short budget", "/var/lib/app/secrets/token", 16, "/var/li.../token"},
+		{"long basename", "/tmp/a-very-long-file-name-indeed.txt", 16, "/tmp/a-...ed.txt"},
+		{"no slash", "abcdefghijklmnopqrstuvwxyz", 10, "abcd...xyz"},
+		{"tiny max", "/etc/shadow", 3, "/et"},
+		{"multibyte", "/data/üüüüüüüü/f", 12, "/data/ü.../f"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncatePath(tt.path, tt.max)
+			if got != tt.expected {
+				t.Errorf("truncatePath(%q, %d) = %q, want %q", tt.path, tt.max, got, tt.expected)
+			}
+			if tt.max > 0 && utf8.RuneCountInString(got) > tt.max {
+				t.Errorf("truncatePath(%q, %d) is %d characters long", tt.path, tt.max, utf8.RuneCountInString(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// defaultMergeWindow is how long an eventMerger holds an event back waiting
+// for idle sources, which may still deliver an earlier one
+const defaultMergeWindow = time.Millisecond
+
+// eventSource reads the next event of one stream an eventMerger merges, e.g.
+// one ring buffer
+type eventSource func() (*Event, error)
+
+// eventMerger merges several event sources into one stream. Each source is
+// read one event ahead, and the earliest event read ahead is returned once
+// every source has one or once it was held for the merge window. Events of
+// one source keep their order; across sources they are ordered by Timestamp
+// unless a source lags by more than the window.
+type eventMerger struct {
+	window  time.Duration
+	results chan sourcedEvent
+	next    []chan struct{} // tells a source to read its next event
+	heads   []*Event        // event read ahead per source, nil while reading
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// sourcedEvent is what one source read
+type sourcedEvent struct {
+	source int
+	event  *Event
+	err    error
+}
+
+// newEventMerger starts reading from sources. Close stops the readers once
+// their sources return.
+func newEventMerger(sources []eventSource, window time.Duration) *eventMerger {
+	m := &eventMerger{
+		window:  window,
+		results: make(chan sourcedEvent),
+		next:    make([]chan struct{}, len(sources)),
+		heads:   make([]*Event, len(sources)),
+		done:    make(chan struct{}),
+	}
+	for i, source := range sources {
+		m.next[i] = make(chan struct{}, 1)
+		m.next[i] <- struct{}{}
+		go m.read(i, source)
+	}
+	return m
+}
+
+// read hands the events of one source to Next, one at a time
+func (m *eventMerger) read(i int, source eventSource) {
+	for {
+		select {
+		case <-m.next[i]:
+		case <-m.done:
+			return
+		}
+
+		event, err := source()
+		select {
+		case m.results <- sourcedEvent{source: i, event: event, err: err}:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// Next returns the next event of the merged stream, or the error a source
+// failed with. The failed source is read again on the following call. Next
+// must not be called concurrently.
+func (m *eventMerger) Next() (*Event, error) {
+	var expired <-chan time.Time
+	windowPassed := false
+
+	for {
+		earliest, pending := -1, 0
+		for i, head := range m.heads {
+			if head == nil {
+				continue
+			}
+			pending++
+			if earliest < 0 || head.Timestamp < m.heads[earliest].Timestamp {
+				earliest = i
+			}
+		}
+		if earliest >= 0 && (pending == len(m.heads) || windowPassed) {
+			return m.take(earliest), nil
+		}
+		if earliest >= 0 && expired == nil {
+			timer := time.NewTimer(m.window)
+			defer timer.Stop()
+			expired = timer.C
+		}
+
+		select {
+		case r := <-m.results:
+			if r.err != nil {
+				m.next[r.source] <- struct{}{}
+				return nil, r.err
+			}
+			m.heads[r.source] = r.event
+		case <-expired:
+			windowPassed = true
+		case <-m.done:
+			return nil, fmt.Errorf("ring buffer closed: %w", ringbuf.ErrClosed)
+		}
+	}
+}
+
+// take removes the event read ahead from source i and has it read the next
+func (m *eventMerger) take(i int) *Event {
+	event := m.heads[i]
+	m.heads[i] = nil
+	m.next[i] <- struct{}{}
+	return event
+}
+
+// Close makes Next fail with ringbuf.ErrClosed. Readers blocked in a source
+// exit once it returns, so the sources should be closed too.
+func (m *eventMerger) Close() {
+	m.closeOnce.Do(func() { close(m.done) })
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// sliceSource returns a source yielding events with the given timestamps,
+// then blocking until the merger is closed
+func sliceSource(done <-chan struct{}, timestamps ...uint64) eventSource {
+	return func() (*Event, error) {
+		if len(timestamps) == 0 {
+			<-done
+			return nil, ringbuf.ErrClosed
+		}
+		event := &Event{Timestamp: timestamps[0]}
+		timestamps = timestamps[1:]
+		return event, nil
+	}
+}
+
+// nextTimestamps reads n events from m and returns their timestamps
+func nextTimestamps(t *testing.T, m *eventMerger, n int) []uint64 {
+	t.Helper()
+	var timestamps []uint64
+	for i := 0; i < n; i++ {
+		event, err := m.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		timestamps = append(timestamps, event.Timestamp)
+	}
+	return timestamps
+}
+
+func TestEventMerger_OrdersByTimestamp(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	m := newEventMerger([]eventSource{
+		sliceSource(done, 1, 4, 5, 9),
+		sliceSource(done, 2, 3, 8),
+		sliceSource(done, 6, 7),
+	}, time.Hour)
+	defer m.Close()
+
+	// Once a source runs dry the window has to pass, so only read while
+	// every source still has events
+	got := nextTimestamps(t, m, 6)
+	if want := []uint64{1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected timestamps %v, got %v", want, got)
+	}
+}
+
+func TestEventMerger_IdleSourceWaitsForWindow(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	m := newEventMerger([]eventSource{
+		sliceSource(done, 3, 1),
+		sliceSource(done),
+	}, 10*time.Millisecond)
+	defer m.Close()
+
+	start := time.Now()
+	got := nextTimestamps(t, m, 2)
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond {
+		t.Errorf("expected events to be held for the window, got them after %v", elapsed)
+	}
+	// Events of one source keep their order, even if out of timestamp order
+	if want := []uint64{3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected timestamps %v, got %v", want, got)
+	}
+}
+
+func TestEventMerger_SourceError(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	failed := false
+	failing := func() (*Event, error) {
+		if !failed {
+			failed = true
+			return nil, errors.New("read failed")
+		}
+		return &Event{Timestamp: 2}, nil
+	}
+
+	m := newEventMerger([]eventSource{sliceSource(done, 1), failing}, time.Hour)
+	defer m.Close()
+
+	if _, err := m.Next(); err == nil || err.Error() != "read failed" {
+		t.Fatalf("expected the source's error, got %v", err)
+	}
+	// The failed source is read again
+	if got := nextTimestamps(t, m, 1); got[0] != 1 {
+		t.Errorf("expected timestamp 1, got %d", got[0])
+	}
+}
+
+func TestEventMerger_Close(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+
+	m := newEventMerger([]eventSource{sliceSource(done)}, time.Hour)
+	errs := make(chan error)
+	go func() {
+		_, err := m.Next()
+		errs <- err
+	}()
+
+	m.Close()
+	m.Close()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ringbuf.ErrClosed) {
+			t.Errorf("expected ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next did not return after Close")
+	}
+}
+
+// BenchmarkEventMerger compares reading one busy source with merging the same
+// events spread over several sources, as with WithEventShards
+func BenchmarkEventMerger(b *testing.B) {
+	for _, sources := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("sources=%d", sources), func(b *testing.B) {
+			done := make(chan struct{})
+			defer close(done)
+
+			var eventSources []eventSource
+			for i := 0; i < sources; i++ {
+				timestamp := uint64(i)
+				stride := uint64(sources)
+				eventSources = append(eventSources, func() (*Event, error) {
+					timestamp += stride
+					return &Event{Timestamp: timestamp}, nil
+				})
+			}
+			m := newEventMerger(eventSources, defaultMergeWindow)
+			defer m.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := m.Next(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -57,6 +57,7 @@ func ExampleEventHandler() {
 	// [VIOLATION 2/2] PID 1234 (myapp) opened disallowed file: /etc/shadow
 	//
 	// *** PID 1234 is now BLOCKED from opening any further files! ***
+	// Files accessed: /etc/passwd, /etc/shadow
 	//
 	// Total violations: 2
 	// PID 1234 violations: 2
@@ -114,8 +115,93 @@ func ExampleEventHandler_multipleProcesses() {
 	// [VIOLATION 2/2] PID 1000 (proc1) opened disallowed file: /etc/hosts
 	//
 	// *** PID 1000 is now BLOCKED from opening any further files! ***
+	// Files accessed: /etc/hosts, /etc/passwd
 	//
 	// Total violations: 3
 	// PID 1000 violations: 2, blocked: true
 	// PID 2000 violations: 1, blocked: false
 }
+
+func ExampleEventHandler_rename() {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          2,
+	})
+
+	// A file written somewhere allowed and then moved into place
+	handler.processEvent(CreateMockEvent(1234, 1000, "dropper", "/tmp/payload"))
+	handler.processEvent(CreateMockRenameEvent(1234, 1000, "dropper", "/etc/ld.so.preload"))
+
+	// Output:
+	// [VIOLATION 1/2] PID 1234 (dropper) renamed a file into disallowed path: /etc/ld.so.preload
+}
+
+func ExampleEventHandler_maxPathDisplay() {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow"},
+		Threshold:          2,
+		MaxPathDisplay:     32,
+	})
+
+	handler.processEvent(CreateMockEvent(1234, 1000, "cat", "/var/lib/docker/overlay2/3f9c2a/merged/etc/shadow"))
+
+	// Output:
+	// [VIOLATION 1/2] PID 1234 (cat) opened disallowed file: /var/lib/docker/overla.../shadow
+}
+
+func ExampleEventHandler_relativeTime() {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          2,
+		RelativeTime:       true,
+	})
+	clock := newFakeClock(time.Unix(1000, 0))
+	handler.clock = clock
+	handler.startedAt = clock.Now()
+
+	handler.processEvent(CreateMockEvent(1234, 1000, "cat", "/etc/passwd"))
+	clock.Advance(1200 * time.Millisecond)
+	handler.processEvent(CreateMockEvent(1234, 1000, "cat", "/etc/shadow"))
+
+	// Output:
+	// +0.0s [VIOLATION 1/2] PID 1234 (cat) opened disallowed file: /etc/passwd
+	// +1.2s [VIOLATION 2/2] PID 1234 (cat) opened disallowed file: /etc/shadow
+	//
+	// +1.2s *** PID 1234 is now BLOCKED from opening any further files! ***
+	// Files accessed: /etc/passwd, /etc/shadow
+}
+
+func ExampleEventHandler_stillBlocked() {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns:   []string{"/etc/*"},
+		Threshold:            1,
+		StillBlockedInterval: time.Minute,
+	})
+
+	handler.processEvent(CreateMockEvent(1234, 1000, "cat", "/etc/passwd"))
+	// Once blocked, its opens are denied by the kernel
+	handler.processEvent(CreateMockDeniedEvent(1234, 1000, "cat", "/etc/shadow"))
+	handler.processEvent(CreateMockDeniedEvent(1234, 1000, "cat", "/etc/group"))
+	handler.printStillBlocked()
+
+	// Output:
+	// [VIOLATION 1/1] PID 1234 (cat) opened disallowed file: /etc/passwd
+	//
+	// *** PID 1234 is now BLOCKED from opening any further files! ***
+	// Files accessed: /etc/passwd
+	//
+	// [ENFORCED] PID 1234 (cat) was denied /etc/shadow; the block is in effect
+	// [STILL BLOCKED] PID 1234 (cat) attempted 2 more opens
+}
@@ -2,6 +2,7 @@ module ebpfence
 
 go 1.25.5
 
-require github.com/cilium/ebpf v0.20.0
-
-require golang.org/x/sys v0.37.0 // indirect
+require (
+	github.com/cilium/ebpf v0.20.0
+	golang.org/x/sys v0.37.0
+)
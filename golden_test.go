@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata with the current output")
+
+// captureStdout returns what fn writes to standard output. The handler
+// prints alerts with fmt.Printf, so os.Stdout is swapped for a pipe while fn
+// runs; tests using it must not run in parallel.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("create pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+
+	done := make(chan string, 1)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	defer func() {
+		os.Stdout = stdout
+		w.Close()
+		r.Close()
+	}()
+
+	fn()
+	os.Stdout = stdout
+	w.Close()
+	return <-done
+}
+
+// assertGolden compares got with testdata/<name>.golden, or rewrites the
+// file with got when the tests run with -update
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatalf("update golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file: %v (run the tests with -update to create it)", err)
+	}
+	if got == string(want) {
+		return
+	}
+	gotLines, wantLines := strings.Split(got, "\n"), strings.Split(string(want), "\n")
+	for i := 0; i < len(gotLines) || i < len(wantLines); i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w {
+			t.Errorf("output differs from %s at line %d:\n got: %q\nwant: %q\n(run the tests with -update to accept the new output)", path, i+1, g, w)
+			return
+		}
+	}
+}
+
+// runGolden feeds events to a handler with config one at a time and compares
+// what it prints with testdata/<name>.golden
+func runGolden(t *testing.T, name string, config EventHandlerConfig, events []*Event) {
+	t.Helper()
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+	handler := NewEventHandler(provider, config)
+
+	got := captureStdout(t, func() {
+		for _, event := range events {
+			if _, err := handler.processEvent(event); err != nil {
+				t.Errorf("processEvent: %v", err)
+			}
+		}
+	})
+	assertGolden(t, name, got)
+}
+
+func TestGolden_WarnThreshold(t *testing.T) {
+	runGolden(t, "warn_threshold", EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          3,
+		WarnThreshold:      1,
+	}, []*Event{
+		CreateMockEvent(1234, 1000, "myapp", "/etc/passwd"),
+		CreateMockEvent(1234, 1000, "myapp", "/etc/shadow"),
+		CreateMockEvent(1234, 1000, "myapp", "/etc/group"),
+	})
+}
+
+func TestGolden_ReportOnly(t *testing.T) {
+	// A canary PID that is watched but never blocked, next to a PID that is
+	// enforced
+	runGolden(t, "report_only", EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          2,
+		ReportOnlyPIDs:     []uint32{1234},
+	}, []*Event{
+		CreateMockEvent(1234, 1000, "canary", "/etc/passwd"),
+		CreateMockEvent(1234, 1000, "canary", "/etc/shadow"),
+		CreateMockEvent(1234, 1000, "canary", "/etc/group"),
+		CreateMockEvent(5678, 1000, "myapp", "/etc/passwd"),
+		CreateMockEvent(5678, 1000, "myapp", "/etc/shadow"),
+	})
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// FileID identifies a file by device and inode number, so it is the same
+// whichever hardlink or name it is reached through
+type FileID struct {
+	Dev uint64
+	Ino uint64
+}
+
+// resolveInode returns the FileID of the file at path, following symlinks
+func resolveInode(path string) (FileID, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return FileID{}, fmt.Errorf("stat %s: %w", path, err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return FileID{}, fmt.Errorf("stat %s: unexpected stat type %T", path, info.Sys())
+	}
+	return FileID{Dev: uint64(stat.Dev), Ino: stat.Ino}, nil
+}
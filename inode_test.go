@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveInode(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("s3cr3t"), 0600); err != nil {
+		t.Fatalf("create file: %v", err)
+	}
+	other := filepath.Join(dir, "other")
+	if err := os.WriteFile(other, nil, 0600); err != nil {
+		t.Fatalf("create file: %v", err)
+	}
+
+	id, err := resolveInode(secret)
+	if err != nil {
+		t.Fatalf("resolveInode: %v", err)
+	}
+	if id.Ino == 0 {
+		t.Error("expected a non-zero inode number")
+	}
+
+	// Hardlinks and symlinks resolve to the same file
+	hardlink := filepath.Join(dir, "hardlink")
+	if err := os.Link(secret, hardlink); err != nil {
+		t.Fatalf("create hardlink: %v", err)
+	}
+	symlink := filepath.Join(dir, "symlink")
+	if err := os.Symlink(secret, symlink); err != nil {
+		t.Fatalf("create symlink: %v", err)
+	}
+	for _, path := range []string{hardlink, symlink} {
+		got, err := resolveInode(path)
+		if err != nil {
+			t.Fatalf("resolveInode(%s): %v", path, err)
+		}
+		if got != id {
+			t.Errorf("resolveInode(%s) = %+v, want %+v", path, got, id)
+		}
+	}
+
+	// A rename keeps the inode
+	renamed := filepath.Join(dir, "renamed")
+	if err := os.Rename(secret, renamed); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if got, err := resolveInode(renamed); err != nil || got != id {
+		t.Errorf("resolveInode after rename = %+v, %v, want %+v", got, err, id)
+	}
+
+	if got, err := resolveInode(other); err != nil || got == id {
+		t.Errorf("expected a different file to have a different id, got %+v, %v", got, err)
+	}
+
+	if _, err := resolveInode(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -4,10 +4,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 // checkIntegrationTestRequirements checks if we can run integration tests
@@ -174,6 +178,129 @@ func TestIntegration_BlockingFunctionality(t *testing.T) {
 	}
 }
 
+// TestIntegration_EnforceRead tests that a blocked PID can open files but not
+// read them when enforcing at read
+func TestIntegration_EnforceRead(t *testing.T) {
+	checkIntegrationTestRequirements(t)
+
+	provider, err := NewRealEBPFProvider()
+	if err != nil {
+		t.Fatalf("Failed to create eBPF provider: %v", err)
+	}
+	defer provider.Close()
+
+	if err := provider.SetEnforcementPoint(EnforceRead); err != nil {
+		t.Skipf("file_permission LSM hook not available: %v", err)
+	}
+
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("test data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	currentPID := uint32(os.Getpid())
+	if err := provider.BlockPID(currentPID); err != nil {
+		t.Fatalf("Failed to block PID: %v", err)
+	}
+	defer provider.UnblockPID(currentPID)
+
+	f, err := os.Open(testFile)
+	if err != nil {
+		t.Fatalf("Open should succeed when enforcing at read, got %v", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 16)
+	_, err = f.Read(buf)
+	if err == nil {
+		t.Skip("Read was not blocked - LSM BPF may not be active")
+	}
+	if !errors.Is(err, unix.EACCES) {
+		t.Errorf("Expected EACCES from read, got %v", err)
+	}
+}
+
+// TestIntegration_EnforceExistingFDs tests that a file opened before its PID
+// was blocked can no longer be read, while new opens are denied
+func TestIntegration_EnforceExistingFDs(t *testing.T) {
+	checkIntegrationTestRequirements(t)
+
+	provider, err := NewRealEBPFProvider()
+	if err != nil {
+		t.Fatalf("Failed to create eBPF provider: %v", err)
+	}
+	defer provider.Close()
+
+	if err := provider.SetEnforcementPoint(EnforceOpenAndRead); err != nil {
+		t.Skipf("file_permission LSM hook not available: %v", err)
+	}
+
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("test data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	f, err := os.Open(testFile)
+	if err != nil {
+		t.Fatalf("Failed to open test file: %v", err)
+	}
+	defer f.Close()
+
+	currentPID := uint32(os.Getpid())
+	if err := provider.BlockPID(currentPID); err != nil {
+		t.Fatalf("Failed to block PID: %v", err)
+	}
+	defer provider.UnblockPID(currentPID)
+
+	buf := make([]byte, 16)
+	_, err = f.Read(buf)
+	if err == nil {
+		t.Skip("Read was not blocked - LSM BPF may not be active")
+	}
+	if !errors.Is(err, unix.EACCES) {
+		t.Errorf("Expected EACCES from read, got %v", err)
+	}
+
+	if _, err := os.Open(testFile); !os.IsPermission(err) {
+		t.Errorf("Expected a permission error from a new open, got %v", err)
+	}
+}
+
+// TestIntegration_EnforceOnly tests that an enforce-only provider still
+// blocks PIDs while reporting no events
+func TestIntegration_EnforceOnly(t *testing.T) {
+	checkIntegrationTestRequirements(t)
+
+	provider, err := NewRealEBPFProvider(WithEnforceOnly())
+	if err != nil {
+		t.Fatalf("Failed to create eBPF provider: %v", err)
+	}
+	defer provider.Close()
+
+	if _, err := provider.ReadEvent(); !errors.Is(err, ErrEventsDisabled) {
+		t.Errorf("Expected ErrEventsDisabled from ReadEvent, got %v", err)
+	}
+
+	testFile := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(testFile, []byte("test data"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	currentPID := uint32(os.Getpid())
+	if err := provider.BlockPID(currentPID); err != nil {
+		t.Fatalf("Failed to block PID: %v", err)
+	}
+	defer provider.UnblockPID(currentPID)
+
+	_, err = os.ReadFile(testFile)
+	if err == nil {
+		t.Skip("Open was not blocked - LSM BPF may not be active")
+	}
+	if !os.IsPermission(err) {
+		t.Errorf("Expected a permission error, got %v", err)
+	}
+}
+
 // TestIntegration_EndToEnd tests the complete event handler flow
 func TestIntegration_EndToEnd(t *testing.T) {
 	checkIntegrationTestRequirements(t)
@@ -205,7 +332,8 @@ func TestIntegration_EndToEnd(t *testing.T) {
 	config := EventHandlerConfig{
 		DisallowedPatterns: []string{secretDir + "/*"},
 		Threshold:          2,
-		TargetPID:          0, // Monitor all PIDs
+		TargetPID:          0,    // Monitor all PIDs
+		MonitorSelf:        true, // The test process is the one opening the files
 	}
 
 	handler := NewEventHandler(provider, config)
@@ -268,7 +396,142 @@ func TestIntegration_EndToEnd(t *testing.T) {
 	t.Log("Integration test completed successfully")
 }
 
+// TestIntegration_Openat2Flags tests that openat2 events carry the open_how flags
+func TestIntegration_Openat2Flags(t *testing.T) {
+	checkIntegrationTestRequirements(t)
+
+	provider, err := NewRealEBPFProvider()
+	if err != nil {
+		t.Fatalf("Failed to create eBPF provider: %v", err)
+	}
+	defer provider.Close()
+
+	tmpFile := filepath.Join(t.TempDir(), "openat2.txt")
+	if err := os.WriteFile(tmpFile, []byte("test"), 0644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	eventChan := make(chan *Event, 10)
+	go func() {
+		for ctx.Err() == nil {
+			event, err := provider.ReadEvent()
+			if err != nil {
+				return
+			}
+			eventChan <- event
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	how := unix.OpenHow{Flags: unix.O_RDONLY | unix.O_CLOEXEC, Resolve: unix.RESOLVE_NO_SYMLINKS}
+	fd, err := unix.Openat2(unix.AT_FDCWD, tmpFile, &how)
+	if err == unix.ENOSYS {
+		t.Skip("openat2 not supported by this kernel")
+	}
+	if err != nil {
+		t.Fatalf("openat2 failed: %v", err)
+	}
+	unix.Close(fd)
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case event := <-eventChan:
+			if nullTerminatedString(event.Filename[:]) != tmpFile {
+				continue
+			}
+			if event.Flags&unix.O_CLOEXEC == 0 {
+				t.Errorf("expected O_CLOEXEC in flags, got %#x", event.Flags)
+			}
+			if event.Resolve != unix.RESOLVE_NO_SYMLINKS {
+				t.Errorf("expected resolve %#x, got %#x", unix.RESOLVE_NO_SYMLINKS, event.Resolve)
+			}
+			return
+		case <-timeout:
+			t.Fatal("Timeout waiting for openat2 event")
+		}
+	}
+}
+
 // nullTerminatedString converts a null-terminated byte array to a string
+// TestIntegration_AllowlistStartupOpens runs real binaries, dynamically
+// linked C programs and a Go one, and checks that the files they open just to
+// start are all allowed by the base allowlist
+func TestIntegration_AllowlistStartupOpens(t *testing.T) {
+	checkIntegrationTestRequirements(t)
+
+	provider, err := NewRealEBPFProvider()
+	if err != nil {
+		t.Fatalf("Failed to create eBPF provider: %v", err)
+	}
+	defer provider.Close()
+
+	events := make(chan *Event, 4096)
+	go func() {
+		for {
+			event, err := provider.ReadEvent()
+			if err != nil {
+				close(events)
+				return
+			}
+			events <- event
+		}
+	}()
+
+	// The test binary itself, running no tests, stands in for a Go program
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := [][]string{
+		{"/bin/true"},
+		{"/bin/sh", "-c", ":"},
+		{"/usr/bin/id"},
+		{"/bin/date"},
+		{self, "-test.run=^$"},
+	}
+
+	allowlist := newAllowlist(nil, false)
+	for _, args := range commands {
+		if _, err := os.Stat(args[0]); err != nil {
+			t.Logf("Skipping %s: %v", args[0], err)
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		if err := cmd.Start(); err != nil {
+			t.Fatalf("start %s: %v", args[0], err)
+		}
+		pid := uint32(cmd.Process.Pid)
+		if err := cmd.Wait(); err != nil {
+			t.Fatalf("run %s: %v", args[0], err)
+		}
+
+		deadline := time.After(500 * time.Millisecond)
+	drain:
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					break drain
+				}
+				if event.Pid != pid || event.Type != EventOpen || event.IsDirectoryOpen() {
+					continue
+				}
+				filename := cleanFilename(nullTerminatedString(event.Filename[:]))
+				if !allowlist.allows(filename) {
+					t.Errorf("%s opened %s at startup, which the base allowlist does not allow", args[0], filename)
+				}
+			case <-deadline:
+				break drain
+			}
+		}
+	}
+}
+
 func nullTerminatedString(b []byte) string {
 	for i, c := range b {
 		if c == 0 {
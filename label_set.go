@@ -0,0 +1,153 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	otherLabel         = "other" // the label values outside a labelSet's top K share
+	defaultMetricsTopK = 10
+	maxLabelValues     = 4096 // values a labelSet tracks at once
+)
+
+// labelSet counts violations per label value, e.g. per comm, and reports the
+// K most frequent values with the rest summed under otherLabel, so a host
+// spawning many unique commands cannot blow up the cardinality of exported
+// labels. It tracks at most maxLabelValues values with the Space-Saving
+// algorithm: once full, a new value takes over the least counted one and
+// its count, so a frequent value showing up late still makes the top K,
+// its count overestimated by at most that of the value it replaced.
+type labelSet struct {
+	k      int
+	index  map[string]*labelCount
+	counts labelHeap // least counted first
+}
+
+// labelCount is the count of one value of a labelSet
+type labelCount struct {
+	value string
+	count uint64
+	pos   int // position in the labelHeap
+}
+
+// labelHeap is a min-heap of label counts, ties putting the higher value
+// first, so the value top would rank last is replaced first
+type labelHeap []*labelCount
+
+func (h labelHeap) Len() int { return len(h) }
+
+func (h labelHeap) Less(i, j int) bool {
+	if h[i].count != h[j].count {
+		return h[i].count < h[j].count
+	}
+	return h[i].value > h[j].value
+}
+
+func (h labelHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos, h[j].pos = i, j
+}
+
+func (h *labelHeap) Push(x any) {
+	c := x.(*labelCount)
+	c.pos = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *labelHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// newLabelSet returns a labelSet reporting k values, defaultMetricsTopK if
+// k is 0 or less
+func newLabelSet(k int) *labelSet {
+	if k <= 0 {
+		k = defaultMetricsTopK
+	}
+	return &labelSet{k: k, index: make(map[string]*labelCount)}
+}
+
+// add counts one violation for value
+func (s *labelSet) add(value string) {
+	if c, ok := s.index[value]; ok {
+		c.count++
+		heap.Fix(&s.counts, c.pos)
+		return
+	}
+	if len(s.counts) < maxLabelValues {
+		c := &labelCount{value: value, count: 1}
+		s.index[value] = c
+		heap.Push(&s.counts, c)
+		return
+	}
+
+	least := s.counts[0]
+	delete(s.index, least.value)
+	least.value = value
+	least.count++
+	s.index[value] = least
+	heap.Fix(&s.counts, 0)
+}
+
+// top returns the counts of the k most frequent values, ties going to the
+// lower value, and the sum of all others under otherLabel if there are any
+func (s *labelSet) top() map[string]uint64 {
+	counts := make(map[string]uint64, len(s.counts))
+	values := make([]string, 0, len(s.counts))
+	for _, c := range s.counts {
+		counts[c.value] = c.count
+		values = append(values, c.value)
+	}
+	sortLabels(values, counts)
+
+	top := make(map[string]uint64, s.k+1)
+	for i, value := range values {
+		if i < s.k && value != otherLabel {
+			top[value] = counts[value]
+		} else {
+			top[otherLabel] += counts[value]
+		}
+	}
+	return top
+}
+
+// sortLabels sorts values by descending count, then by value
+func sortLabels(values []string, counts map[string]uint64) {
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+}
+
+// labelEscaper escapes the separators of formatLabelCounts in values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `:`, `\:`)
+
+// formatLabelCounts formats counts as "cat:5,ls:2,other:1", by descending
+// count with otherLabel last. A backslash, comma or colon in a value is
+// escaped with a backslash.
+func formatLabelCounts(counts map[string]uint64) string {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		if value != otherLabel {
+			values = append(values, value)
+		}
+	}
+	sortLabels(values, counts)
+	if _, ok := counts[otherLabel]; ok {
+		values = append(values, otherLabel)
+	}
+
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = fmt.Sprintf("%s:%d", labelEscaper.Replace(value), counts[value])
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestLabelSet_Top(t *testing.T) {
+	tests := []struct {
+		name     string
+		k        int
+		adds     []string
+		expected map[string]uint64
+	}{
+		{
+			name:     "empty",
+			k:        2,
+			expected: map[string]uint64{},
+		},
+		{
+			name:     "fewer values than k",
+			k:        3,
+			adds:     []string{"cat", "cat", "ls"},
+			expected: map[string]uint64{"cat": 2, "ls": 1},
+		},
+		{
+			name:     "rest bucketed into other",
+			k:        2,
+			adds:     []string{"cat", "cat", "cat", "ls", "ls", "vim", "sh"},
+			expected: map[string]uint64{"cat": 3, "ls": 2, "other": 2},
+		},
+		{
+			name:     "ties go to the lower value",
+			k:        1,
+			adds:     []string{"zsh", "bash"},
+			expected: map[string]uint64{"bash": 1, "other": 1},
+		},
+		{
+			name:     "a value named other is merged",
+			k:        2,
+			adds:     []string{"other", "other", "cat", "ls"},
+			expected: map[string]uint64{"cat": 1, "other": 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := newLabelSet(tt.k)
+			for _, value := range tt.adds {
+				set.add(value)
+			}
+			if got := set.top(); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("top() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLabelSet_Bounded(t *testing.T) {
+	set := newLabelSet(0)
+	for i := 0; i < maxLabelValues+100; i++ {
+		set.add("comm-" + strconv.Itoa(i))
+	}
+	// A frequent value showing up once the set is full still makes the top
+	for i := 0; i < 50; i++ {
+		set.add("late")
+	}
+
+	if len(set.counts) != maxLabelValues || len(set.index) != maxLabelValues {
+		t.Errorf("expected %d values tracked, got %d", maxLabelValues, len(set.counts))
+	}
+	top := set.top()
+	if len(top) != defaultMetricsTopK+1 {
+		t.Errorf("expected %d labels, got %d: %v", defaultMetricsTopK+1, len(top), top)
+	}
+	if top["late"] < 50 {
+		t.Errorf("expected late to be counted at least 50 times, got %v", top)
+	}
+	var total uint64
+	for _, count := range top {
+		total += count
+	}
+	if total != maxLabelValues+100+50 {
+		t.Errorf("expected the counts to add up to %d, got %d", maxLabelValues+100+50, total)
+	}
+}
+
+func TestFormatLabelCounts(t *testing.T) {
+	got := formatLabelCounts(map[string]uint64{"other": 9, "ls": 2, "cat": 5})
+	if expected := "cat:5,ls:2,other:9"; got != expected {
+		t.Errorf("got %q, want %q", got, expected)
+	}
+	// Separators in values are escaped
+	got = formatLabelCounts(map[string]uint64{"a,b:c": 2, `x\y`: 1})
+	if expected := `a\,b\:c:2,x\\y:1`; got != expected {
+		t.Errorf("got %q, want %q", got, expected)
+	}
+	if got := formatLabelCounts(map[string]uint64{}); got != "" {
+		t.Errorf("expected no labels, got %q", got)
+	}
+}
+
+func TestEventHandler_ViolationsByLabel(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          100,
+		MetricsTopK:        1,
+	})
+	for _, event := range []*Event{
+		CreateMockEvent(1000, 1000, "cat", "/etc/passwd"),
+		CreateMockEvent(1000, 1000, "cat", "/etc/hosts"),
+		CreateMockEvent(2000, 0, "ls", "/etc/passwd"),
+		CreateMockEvent(3000, 1000, "vim", "/tmp/file"),
+	} {
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	stats := handler.Stats()
+	if expected := map[string]uint64{"cat": 2, "other": 1}; !reflect.DeepEqual(stats.ViolationsByComm, expected) {
+		t.Errorf("ViolationsByComm = %v, want %v", stats.ViolationsByComm, expected)
+	}
+	if expected := map[string]uint64{"1000": 2, "other": 1}; !reflect.DeepEqual(stats.ViolationsByUID, expected) {
+		t.Errorf("ViolationsByUID = %v, want %v", stats.ViolationsByUID, expected)
+	}
+
+	if err := handler.Reset(false); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if stats := handler.Stats(); len(stats.ViolationsByComm) != 0 || len(stats.ViolationsByUID) != 0 {
+		t.Errorf("expected Reset to clear the label counts, got %v and %v", stats.ViolationsByComm, stats.ViolationsByUID)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// latencySamples is how many recent latencies percentiles are computed over
+const latencySamples = 1024
+
+// latencySummary keeps the most recent event processing latencies
+type latencySummary struct {
+	samples []time.Duration
+	next    int // index overwritten next once samples is full
+}
+
+// add records a latency, evicting the oldest once the window is full
+func (s *latencySummary) add(d time.Duration) {
+	if len(s.samples) < latencySamples {
+		s.samples = append(s.samples, d)
+		return
+	}
+	s.samples[s.next] = d
+	s.next = (s.next + 1) % latencySamples
+}
+
+// percentile returns the nearest-rank p-th percentile (0-100) of the recorded
+// latencies, or 0 if none were recorded
+func (s *latencySummary) percentile(p float64) time.Duration {
+	if len(s.samples) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(s.samples)
+	slices.Sort(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+// bootTime returns the wall clock time the system booted, used to convert
+// CLOCK_BOOTTIME event timestamps to wall clock time
+func bootTime() time.Time {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_BOOTTIME, &ts); err != nil {
+		return time.Time{}
+	}
+	return time.Now().Add(-time.Duration(ts.Nano()))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencySummary_Percentile(t *testing.T) {
+	var s latencySummary
+	if got := s.percentile(50); got != 0 {
+		t.Errorf("expected 0 with no samples, got %v", got)
+	}
+
+	for i := 100; i >= 1; i-- {
+		s.add(time.Duration(i) * time.Millisecond)
+	}
+	if got := s.percentile(50); got != 50*time.Millisecond {
+		t.Errorf("expected p50 of 50ms, got %v", got)
+	}
+	if got := s.percentile(99); got != 99*time.Millisecond {
+		t.Errorf("expected p99 of 99ms, got %v", got)
+	}
+	if got := s.percentile(0); got != time.Millisecond {
+		t.Errorf("expected p0 to be the minimum, got %v", got)
+	}
+}
+
+func TestLatencySummary_Window(t *testing.T) {
+	var s latencySummary
+	for i := 0; i < latencySamples; i++ {
+		s.add(time.Second)
+	}
+	// Newer samples evict the oldest ones
+	for i := 0; i < latencySamples; i++ {
+		s.add(time.Millisecond)
+	}
+	if len(s.samples) != latencySamples {
+		t.Errorf("expected %d samples, got %d", latencySamples, len(s.samples))
+	}
+	if got := s.percentile(100); got != time.Millisecond {
+		t.Errorf("expected old samples to be evicted, got max %v", got)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"sort"
+)
+
+// learnGlobMin is how many files a process must access in one directory
+// before the whole directory is suggested rather than each file
+const learnGlobMin = 3
+
+// learner aggregates the disallowed files each command accessed in learning
+// mode, to suggest the patterns those commands legitimately need
+type learner struct {
+	accesses map[string]map[string]uint64 // comm -> file -> opens
+}
+
+func newLearner() *learner {
+	return &learner{accesses: make(map[string]map[string]uint64)}
+}
+
+// record notes that comm opened filename
+func (l *learner) record(comm, filename string) {
+	files := l.accesses[comm]
+	if files == nil {
+		files = make(map[string]uint64)
+		l.accesses[comm] = files
+	}
+	files[filename]++
+}
+
+// LearnReport is the outcome of learning mode: the files each command
+// accessed and the allowed patterns suggested to cover them
+type LearnReport struct {
+	Processes []LearnedProcess `json:"processes"`
+}
+
+// LearnedProcess is what one command accessed while learning
+type LearnedProcess struct {
+	Comm              string        `json:"comm"`
+	Files             []LearnedFile `json:"files"`
+	SuggestedPatterns []string      `json:"suggested_patterns"`
+}
+
+// LearnedFile is a disallowed file and how often it was opened
+type LearnedFile struct {
+	Path  string `json:"path"`
+	Opens uint64 `json:"opens"`
+}
+
+// report builds the learning report, sorted by comm and path
+func (l *learner) report() LearnReport {
+	report := LearnReport{Processes: []LearnedProcess{}}
+	for comm, files := range l.accesses {
+		process := LearnedProcess{Comm: comm}
+		paths := make([]string, 0, len(files))
+		for path, opens := range files {
+			process.Files = append(process.Files, LearnedFile{Path: path, Opens: opens})
+			paths = append(paths, path)
+		}
+		sort.Slice(process.Files, func(i, j int) bool { return process.Files[i].Path < process.Files[j].Path })
+		process.SuggestedPatterns = suggestPatterns(paths)
+		report.Processes = append(report.Processes, process)
+	}
+	sort.Slice(report.Processes, func(i, j int) bool { return report.Processes[i].Comm < report.Processes[j].Comm })
+	return report
+}
+
+// suggestPatterns covers paths with as few patterns as possible without
+// being much broader than what was observed: a directory glob where at least
+// learnGlobMin files in it were accessed, the exact paths otherwise
+func suggestPatterns(paths []string) []string {
+	byDir := make(map[string][]string)
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		byDir[dir] = append(byDir[dir], path)
+	}
+
+	var patterns []string
+	for dir, files := range byDir {
+		if len(files) >= learnGlobMin {
+			patterns = append(patterns, filepath.Join(dir, "*"))
+			continue
+		}
+		patterns = append(patterns, files...)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// WriteJSON writes the report as indented JSON
+func (r LearnReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
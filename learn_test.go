@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSuggestPatterns(t *testing.T) {
+	paths := []string{
+		"/etc/nginx/nginx.conf",
+		"/etc/nginx/mime.types",
+		"/etc/nginx/fastcgi_params",
+		"/etc/passwd",
+		"/etc/ssl/certs/ca.pem",
+	}
+
+	// Three files in /etc/nginx collapse into a glob; the rest stay exact
+	expected := []string{"/etc/nginx/*", "/etc/passwd", "/etc/ssl/certs/ca.pem"}
+	if got := suggestPatterns(paths); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestEventHandler_Learn(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*", "/var/lib/app/*"},
+		Threshold:          1,
+		Learn:              true,
+	})
+
+	accesses := []struct {
+		pid      uint32
+		comm     string
+		filename string
+	}{
+		{1000, "nginx", "/etc/nginx.conf"},
+		{1000, "nginx", "/etc/mime.types"},
+		{1001, "nginx", "/etc/nginx.conf"},
+		{1001, "nginx", "/etc/hosts"},
+		{2000, "app", "/var/lib/app/state.db"},
+		{2000, "app", "/tmp/scratch"},
+	}
+	for _, a := range accesses {
+		result, err := handler.processEvent(CreateMockEvent(a.pid, 1000, a.comm, a.filename))
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		if result.Counted || result.Blocked {
+			t.Errorf("%s: expected nothing counted or blocked while learning, got %+v", a.filename, result)
+		}
+	}
+	if provider.IsBlocked(1000) || provider.IsBlocked(2000) {
+		t.Error("expected nothing blocked while learning")
+	}
+
+	expected := LearnReport{Processes: []LearnedProcess{
+		{
+			Comm:              "app",
+			Files:             []LearnedFile{{"/var/lib/app/state.db", 1}},
+			SuggestedPatterns: []string{"/var/lib/app/state.db"},
+		},
+		{
+			Comm: "nginx",
+			Files: []LearnedFile{
+				{"/etc/hosts", 1},
+				{"/etc/mime.types", 1},
+				{"/etc/nginx.conf", 2},
+			},
+			SuggestedPatterns: []string{"/etc/*"},
+		},
+	}}
+	report := handler.LearnReport()
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("unexpected report:\n%+v\nwant:\n%+v", report, expected)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var decoded LearnReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("decoding report: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("report did not round-trip through JSON: %+v", decoded)
+	}
+}
@@ -2,36 +2,275 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 //go:generate go run github.com/cilium/ebpf/cmd/bpf2go -cc clang -cflags "-O2 -g -target bpf" Bpf ./bpf/deny_new_reads.bpf.c -- -I.
 
 func main() {
-	disallowedFiles := flag.String("disallowed", "", "Comma-separated list of disallowed file patterns (e.g., '/etc/passwd,/etc/shadow')")
-	threshold := flag.Uint("threshold", 2, "Number of disallowed files before blocking (default: 2)")
-	pid := flag.Uint("pid", 0, "PID to block (default: 0, which blocks all processes)")
-	flag.Parse()
+	if err := dispatch(os.Args[1:]); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// runCommand monitors file opens and blocks offending processes. It is the
+// default command.
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	policy := registerPolicyFlags(flags)
+	record := flags.String("record", "", "Write every event read to this file, for the verify command to replay against another policy")
+	noEBPF := flags.Bool("no-ebpf", false, "Do not load eBPF; poll /proc for open files instead, reporting violations without blocking anything (for demos and sandboxes such as gVisor)")
+	ebpfFallback := flags.Bool("ebpf-fallback", false, "If eBPF cannot be loaded, fall back to polling /proc as with -no-ebpf instead of exiting")
+	enforceOnly := flags.Bool("enforce-only", false, "Only deny the blocked PIDs, e.g. pushed into the pinned map (see -pin-path), without collecting events; no patterns are needed")
+	enforce := flags.String("enforce", EnforceOpen, "Where blocked processes are denied: 'open' (opens fail) or 'read' (files can be opened, e.g. to stat them, but reads fail)")
+	action := flags.String("action", ActionDeny, "What blocking a process does: 'deny' (its opens are denied) or 'quarantine' (it is moved into -quarantine-cgroup, e.g. a frozen cgroup)")
+	quarantineCgroup := flags.String("quarantine-cgroup", "", "With -action quarantine, the cgroup directory blocked processes are moved into, e.g. /sys/fs/cgroup/quarantine")
+	releaseCgroup := flags.String("release-cgroup", "", "With -action quarantine, the cgroup directory quarantined processes are moved back into when unblocked, e.g. /sys/fs/cgroup (default: they stay quarantined)")
+	enforceExistingFDs := flags.Bool("enforce-existing-fds", false, "With -enforce open, also fail reads through files a process opened before it was blocked; hooks every read")
+	warnThreshold := flags.Uint("warn-threshold", 0, "Number of disallowed files that triggers a one-time warning before blocking (default: 0, disabled)")
+	pid := flags.Uint("pid", 0, "PID to block (default: 0, which blocks all processes)")
+	uids := flags.String("uid", "", "Comma-separated list of UIDs to monitor (default: all users)")
+	comms := flags.String("comm", "", "Comma-separated list of process names to monitor (default: all processes)")
+	containers := flags.String("container", "", "Comma-separated list of containers to monitor, by ID prefix or mnt:<inode> (default: all processes)")
+	tids := flags.String("tid", "", "Comma-separated list of thread IDs to monitor; blocks still apply to the whole process (default: all threads)")
+	filterMode := flags.String("filter-mode", FilterAll, "How -pid, -uid, -comm, -container and -tid combine: 'all' (match every one set) or 'any' (match at least one)")
+	auditLogPath := flags.String("audit-log", "", "Path of a JSON Lines audit log of violations and blocks (reopened on SIGHUP)")
+	auditMaxBytes := flags.Int64("audit-max-bytes", 0, "Rotate the audit log once it exceeds this many bytes (default: 0, no rotation)")
+	auditSync := flags.Bool("audit-sync", false, "Sync the audit log to disk after every record")
+	pidMin := flags.Uint("pid-min", 0, "Lowest PID to monitor (default: 0, no lower bound)")
+	pidMax := flags.Uint("pid-max", 0, "Highest PID to monitor (default: 0, no upper bound)")
+	pidExclude := flags.String("pid-exclude", "", "Comma-separated list of PIDs never to monitor")
+	pidReportOnly := flags.String("pid-report-only", "", "Comma-separated list of PIDs whose violations are logged but never blocked (canaries)")
+	allowComms := flags.String("allow-comms", "", "Comma-separated list of process names never monitored, dropped in the kernel to relieve the ring buffer (e.g., 'systemd-journal,chronyd')")
+	trustedParents := flags.String("trusted-parents", "", "Comma-separated list of parent process names whose children are never fenced (e.g., 'sshd')")
+	monitorSelf := flags.Bool("monitor-self", false, "Count violations by ebpfence's own process, except its routine opens (default: false, own PID is excluded)")
+	dedupByInode := flags.Bool("dedup-by-inode", false, "Count each file once per process, whichever path, symlink or hardlink it is opened through")
+	pidNsOf := flags.Uint("pid-ns-of", 0, "Interpret -pid inside the PID namespace of this host PID, e.g. a container's init (default: 0, host PIDs)")
+	blocklistFile := flags.String("blocklist-file", "", "File of PIDs and command names, one per line, to block immediately; watched for additions")
+	blocklistInterval := flags.Duration("blocklist-interval", defaultBlocklistInterval, "How often -blocklist-file is checked for changes")
+	otlpEndpoint := flags.String("otlp-endpoint", "", "OTLP/HTTP collector to export violations and blocks to as log records (e.g., 'http://localhost:4318')")
+	learn := flags.Duration("learn", 0, "Learn for this long, e.g. 1h, blocking nothing, then write the files each command accessed and suggested allowed patterns as JSON (default: 0, enforce)")
+	learnOutput := flags.String("learn-output", "", "Write the -learn report to this file (default: stdout)")
+	stateFile := flags.String("state-file", "", "Restore violation counts and blocked PIDs from this file on start, if it exists, and save them to it on exit")
+	duration := flags.Duration("duration", 0, "Stop and print a summary after running this long, e.g. 1h (default: 0, run until interrupted)")
+	logFormat := flags.String("log-format", LogFormatText, "Format of the report printed on exit: 'text' or 'json'")
+	metricsTopK := flags.Int("metrics-top-k", defaultMetricsTopK, "Commands and UIDs the stats summary shows violations of by name, counting the rest as \"other\"")
+	statsInterval := flags.Duration("stats-interval", 0, "Log a stats summary at this interval, e.g. 1m (default: 0, disabled)")
+	stillBlockedInterval := flags.Duration("still-blocked-interval", 0, "Print how many more matching opens each blocked PID attempted at this interval, e.g. 1m (default: 0, disabled)")
+	maxEventsPerSec := flags.Uint("max-events-per-sec", 0, "Event rate that switches to defensive mode, blocking on the first violation (default: 0, disabled)")
+	blockFiles := flags.String("block-files", "", "Comma-separated list of files no process may open, blocked by inode so hardlinks and renames are covered")
+	trackPIDReuse := flags.Bool("track-pid-reuse", false, "Forget a PID's violations and lift its block once the PID belongs to a new process, told apart by its start time in /proc/<pid>/stat")
+	relativeTime := flags.Bool("relative-time", false, "Prefix violation and block lines with the time since start, e.g. '+1.2s' (default: false)")
+	quotePaths := flags.Bool("quote-paths", false, "Quote file paths in text output, escaping newlines and other control characters (default: false)")
+	maxPathDisplay := flags.Int("max-path-display", 0, "Shorten file paths printed to the console to this many characters, eliding the middle (default: 0, full paths); audit logs keep full paths")
+	fullComm := flags.Bool("full-comm", false, "Resolve process names the kernel truncated to 15 characters from /proc/<pid>/cmdline")
+	showContainer := flags.Bool("show-container", false, "Show the container of violating processes, from their cgroup or mount namespace, and add it to audit records")
+	unblockOnExit := flags.Bool("unblock-on-exit", false, "Unblock every blocked PID when exiting, including those blocked by the block and panic commands; with the eBPF provider blocks end on exit anyway, as the LSM program is detached (default: false)")
+	watchdogTimeout := flags.Duration("watchdog-timeout", 0, "Reopen the ring buffer reader if no event is read for this long, e.g. 1m (default: 0, disabled)")
+	failClosed := flags.Bool("fail-closed", false, "Exit with an error on the first ring buffer read or block failure instead of logging it and carrying on; exiting lifts every block")
+	maxReadErrors := flags.Uint("max-read-errors", 100, "Consecutive unexpected ring buffer read errors before exiting so a supervisor can restart (0: never exit)")
+	dumpMaps := flags.Bool("dump-maps", false, "Print the contents of the BPF maps a running instance pinned under -pin-path (default: '"+defaultPinPath+"') and exit")
+	ringbufBytes := flags.Uint("ringbuf-bytes", 0, "Size of the ring buffer events are sent through, a power of two multiple of the page size (default: 0, 256 KB)")
+	maxBlocks := flags.Uint("max-blocks-before-escalate", 0, "Escalate when more than this many processes are blocked within -escalation-window (default: 0, disabled)")
+	escalationWindow := flags.Duration("escalation-window", time.Minute, "Window -max-blocks-before-escalate is counted over, e.g. 5m")
+	exitOnEscalate := flags.Bool("exit-on-escalate", false, "Exit with an error on escalation (default: false)")
+	verifyBlocks := flags.Duration("verify-blocks", 0, "Look each blocked PID up in the blocked_pids map before reporting the block, waiting up to this long for it to show up, e.g. 50ms (default: 0, do not verify)")
+	eventBatch := flags.Int("event-batch", 0, "Read and process up to this many waiting events at once, saving per-event overhead at high rates (default: 0, one at a time)")
+	sampleRate := flags.Uint("sample-rate", 0, "Process only every Nth event to cap overhead on very busy hosts; violations are undercounted, so use it for observability only (default: 0, every event)")
+	eventShards := flags.Uint("event-shards", 0, "Spread events over this many ring buffers by CPU, each with its own reader, to scale on machines with many CPUs (default: 0, one ring buffer)")
+	readBuffer := flags.Int("read-buffer", 0, "Read events ahead of processing into a buffer of this many events, so slow processing does not hold up draining the ring buffer (default: 0, read events as they are processed)")
+	blockBatch := flags.Duration("block-batch-interval", 0, "Coalesce blocked_pids map updates made within this interval into batches, e.g. 5ms, for when many PIDs are blocked at once; blocks take effect up to this much later (default: 0, write each block at once)")
+	bpfObject := flags.String("bpf-object", "", "Load the BPF programs from this prebuilt object file instead of the ones built into the binary")
+	pinPath := flags.String("pin-path", "", "Pin the BPF maps under this bpffs directory so the block, unblock and status commands can reach them (e.g., '"+defaultPinPath+"'), empty to not pin")
+	if err := applyEnv(flags); err != nil {
+		return err
+	}
+	flags.Parse(args)
+
+	if *dumpMaps {
+		dir := *pinPath
+		if dir == "" {
+			dir = defaultPinPath
+		}
+		if err := dumpPinnedMaps(os.Stdout, dir); err != nil {
+			return fmt.Errorf("failed to dump maps: %w", err)
+		}
+		return nil
+	}
+
+	if err := policy.parse(); err != nil {
+		return err
+	}
 
-	if *disallowedFiles == "" {
-		log.Fatalf("Please specify disallowed files with -disallowed flag")
+	excludePIDs, err := parseIDList(*pidExclude)
+	if err != nil {
+		return fmt.Errorf("invalid -pid-exclude: %w", err)
+	}
+
+	targetUIDs, err := parseIDList(*uids)
+	if err != nil {
+		return fmt.Errorf("invalid -uid: %w", err)
+	}
+
+	targetTIDs, err := parseIDList(*tids)
+	if err != nil {
+		return fmt.Errorf("invalid -tid: %w", err)
+	}
+
+	var targetComms []string
+	if *comms != "" {
+		targetComms = splitPatterns(*comms)
+	}
+
+	var targetContainers []string
+	if *containers != "" {
+		targetContainers = splitPatterns(*containers)
+	}
+
+	if *logFormat != LogFormatText && *logFormat != LogFormatJSON {
+		return fmt.Errorf("invalid -log-format %q: must be %q or %q", *logFormat, LogFormatText, LogFormatJSON)
+	}
+
+	if *filterMode != FilterAll && *filterMode != FilterAny {
+		return fmt.Errorf("invalid -filter-mode %q: must be %q or %q", *filterMode, FilterAll, FilterAny)
+	}
+
+	if *enforce != EnforceOpen && *enforce != EnforceRead {
+		return fmt.Errorf("invalid -enforce %q: must be %q or %q", *enforce, EnforceOpen, EnforceRead)
+	}
+	if *action != ActionDeny && *action != ActionQuarantine {
+		return fmt.Errorf("invalid -action %q: must be %q or %q", *action, ActionDeny, ActionQuarantine)
+	}
+	if (*action == ActionQuarantine) != (*quarantineCgroup != "") {
+		return fmt.Errorf("-action quarantine and -quarantine-cgroup must be given together")
+	}
+	if *releaseCgroup != "" && *action != ActionQuarantine {
+		return fmt.Errorf("-release-cgroup requires -action quarantine")
+	}
+
+	reportOnlyPIDs, err := parseIDList(*pidReportOnly)
+	if err != nil {
+		return fmt.Errorf("invalid -pid-report-only: %w", err)
+	}
+
+	var allowedComms []string
+	if *allowComms != "" {
+		allowedComms = splitPatterns(*allowComms)
+	}
+
+	var trustedComms []string
+	if *trustedParents != "" {
+		trustedComms = splitPatterns(*trustedParents)
+	}
+
+	var blockedFiles []string
+	if *blockFiles != "" {
+		blockedFiles = splitPatterns(*blockFiles)
+	}
+
+	// Only now that every source was merged, is there anything to enforce?
+	// Without events, the PIDs to block come from elsewhere.
+	if *bpfObject != "" && *noEBPF {
+		return fmt.Errorf("-bpf-object cannot be combined with -no-ebpf, which loads no BPF programs")
+	}
+	if *enforceOnly && (*noEBPF || *ebpfFallback) {
+		return fmt.Errorf("-enforce-only needs eBPF, so it cannot be combined with -no-ebpf or -ebpf-fallback")
+	}
+	if *enforceOnly {
+		if *learn > 0 || policy.parsed.ByteThreshold > 0 || *watchdogTimeout > 0 {
+			return fmt.Errorf("-enforce-only collects no events, so it cannot be combined with -learn, -byte-threshold or -watchdog-timeout")
+		}
+	} else if policy.parsed.PolicyMode == PolicyAllowlist {
+		if err := requireAllowlistTarget(uint32(*pid), targetUIDs, targetComms, targetContainers); err != nil {
+			return err
+		}
+	} else if len(policy.parsed.Policies) == 0 {
+		if err := requireFileRules(policy.parsed.DisallowedPatterns, policy.parsed.Rules, blockedFiles); err != nil {
+			return err
+		}
+	}
+
+	if *pidMin > math.MaxUint32 || *pidMax > math.MaxUint32 {
+		return fmt.Errorf("invalid -pid-min or -pid-max: PIDs are at most %d", uint32(math.MaxUint32))
+	}
+	if *pidMax > 0 && *pidMin > *pidMax {
+		return fmt.Errorf("invalid -pid-min %d: greater than -pid-max %d, so no PID would be monitored", *pidMin, *pidMax)
+	}
+	if *ringbufBytes > math.MaxUint32 {
+		return fmt.Errorf("invalid -ringbuf-bytes: %d is too large", *ringbufBytes)
+	}
+	if *blocklistFile != "" && *blocklistInterval <= 0 {
+		return fmt.Errorf("invalid -blocklist-interval %v: must be positive", *blocklistInterval)
+	}
+	if *maxBlocks > math.MaxUint32-1 {
+		return fmt.Errorf("invalid -max-blocks-before-escalate: %d is too large", *maxBlocks)
+	}
+	if *maxBlocks > 0 && *escalationWindow <= 0 {
+		return fmt.Errorf("invalid -escalation-window %v: must be positive", *escalationWindow)
+	}
+	if *exitOnEscalate && *maxBlocks == 0 {
+		return fmt.Errorf("-exit-on-escalate needs -max-blocks-before-escalate")
+	}
+	if *metricsTopK < 1 {
+		return fmt.Errorf("invalid -metrics-top-k %d: must be at least 1", *metricsTopK)
+	}
+	if *verifyBlocks < 0 {
+		return fmt.Errorf("invalid -verify-blocks %v: must not be negative", *verifyBlocks)
+	}
+	if *verifyBlocks > 0 && *blockBatch >= *verifyBlocks {
+		return fmt.Errorf("-verify-blocks %v must be longer than -block-batch-interval %v, or batched blocks never show up in time", *verifyBlocks, *blockBatch)
+	}
+	if *eventBatch < 0 {
+		return fmt.Errorf("invalid -event-batch %d: must not be negative", *eventBatch)
+	}
+	if *sampleRate > math.MaxUint32 {
+		return fmt.Errorf("invalid -sample-rate: %d is too large", *sampleRate)
+	}
+	if *eventShards > maxEventShards {
+		return fmt.Errorf("invalid -event-shards %d: must be at most %d", *eventShards, maxEventShards)
+	}
+	if *eventShards > 1 && *watchdogTimeout > 0 {
+		return fmt.Errorf("-watchdog-timeout cannot reopen sharded ring buffers, so it cannot be combined with -event-shards")
+	}
+	// A filter dropping events in the kernel can silence a healthy ring
+	// buffer for as long as the filtered processes stay idle
+	if *watchdogTimeout > 0 && (*uids != "" || *allowComms != "" || *sampleRate > 1) {
+		return fmt.Errorf("-watchdog-timeout takes a silent ring buffer for a stuck reader, so it cannot be combined with -uid, -allow-comms or -sample-rate, which drop events in the kernel")
 	}
 
-	// Parse disallowed file patterns
-	patterns := strings.Split(*disallowedFiles, ",")
-	for i := range patterns {
-		patterns[i] = strings.TrimSpace(patterns[i])
+	// Resolve the PID namespace -pid is given in
+	var pidNamespace uint32
+	if *pidNsOf != 0 {
+		ns, err := hostProc.pidNamespace(uint32(*pidNsOf))
+		if err != nil {
+			return fmt.Errorf("failed to resolve PID namespace of PID %d: %w", *pidNsOf, err)
+		}
+		pidNamespace = ns
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Stop after -duration, or on a signal, whichever comes first
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	if *learn > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *learn)
+		defer cancel()
+	}
+
 	// Handle Ctrl+C
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
@@ -41,24 +280,304 @@ func main() {
 	}()
 
 	// Create the eBPF provider
-	provider, err := NewRealEBPFProvider()
+	providerOpts := []ProviderOption{WithPinPath(*pinPath), WithRingbufBytes(uint32(*ringbufBytes)), WithEventShards(int(*eventShards)), WithBPFObject(*bpfObject), WithBlockBatching(*blockBatch), WithReadBuffer(*readBuffer)}
+	if *enforceOnly {
+		providerOpts = append(providerOpts, WithEnforceOnly())
+	}
+	provider, err := openProvider(ctx, providerOpts, *noEBPF, *ebpfFallback)
 	if err != nil {
-		log.Fatalf("failed to create eBPF provider: %v", err)
+		return err
 	}
 	defer provider.Close()
 
+	// Read volume is only tracked on request, as it traces every read
+	if policy.parsed.ByteThreshold > 0 {
+		realProvider, ok := provider.(*RealEBPFProvider)
+		if !ok {
+			return fmt.Errorf("-byte-threshold needs eBPF to track reads")
+		}
+		if err := realProvider.SetByteThreshold(policy.parsed.ByteThreshold); err != nil {
+			return fmt.Errorf("failed to enable read tracking: %w", err)
+		}
+		if err := realProvider.EnableReadTracking(); err != nil {
+			return fmt.Errorf("failed to enable read tracking: %w", err)
+		}
+	}
+
 	// Create the event handler with configuration
 	config := EventHandlerConfig{
-		DisallowedPatterns: patterns,
-		Threshold:          uint32(*threshold),
-		TargetPID:          uint32(*pid),
+		WarnThreshold:        uint32(*warnThreshold),
+		TargetPID:            uint32(*pid),
+		TargetUIDs:           targetUIDs,
+		TargetComms:          targetComms,
+		TargetContainers:     targetContainers,
+		TargetTIDs:           targetTIDs,
+		FilterMode:           *filterMode,
+		PIDNamespace:         pidNamespace,
+		PIDMin:               uint32(*pidMin),
+		PIDMax:               uint32(*pidMax),
+		ExcludePIDs:          excludePIDs,
+		ReportOnlyPIDs:       reportOnlyPIDs,
+		TrustedParentComms:   trustedComms,
+		AllowedComms:         allowedComms,
+		SampleRate:           uint32(*sampleRate),
+		EventBatchSize:       *eventBatch,
+		VerifyBlocks:         *verifyBlocks > 0,
+		BlockVerifyGrace:     *verifyBlocks,
+		MonitorSelf:          *monitorSelf,
+		MaxEventsPerSecond:   uint32(*maxEventsPerSec),
+		AuditLogPath:         *auditLogPath,
+		RecordPath:           *record,
+		AuditMaxBytes:        *auditMaxBytes,
+		AuditSync:            *auditSync,
+		StatsInterval:        *statsInterval,
+		MetricsTopK:          *metricsTopK,
+		StillBlockedInterval: *stillBlockedInterval,
+		OTLPEndpoint:         *otlpEndpoint,
+		BlocklistFile:        *blocklistFile,
+		BlocklistInterval:    *blocklistInterval,
+		BlockedFiles:         blockedFiles,
+		ResolveFullComm:      *fullComm,
+		ResolveContainer:     *showContainer,
+		TrackPIDReuse:        *trackPIDReuse,
+		UnblockOnExit:        *unblockOnExit,
+		MaxReadErrors:        uint32(*maxReadErrors),
+		FailClosed:           *failClosed,
+		WatchdogTimeout:      *watchdogTimeout,
+		Learn:                *learn > 0,
+		EnforcementPoint:     *enforce,
+		EnforceExistingFDs:   *enforceExistingFDs,
+		EnforcementAction:    *action,
+		QuarantineCgroup:     *quarantineCgroup,
+		ReleaseCgroup:        *releaseCgroup,
+		MaxPathDisplay:       *maxPathDisplay,
+		QuotePaths:           *quotePaths,
+		RelativeTime:         *relativeTime,
+		DedupByInode:         *dedupByInode,
+		LogFormat:            *logFormat,
+
+		MaxBlocksBeforeEscalate: uint32(*maxBlocks),
+		EscalationWindow:        *escalationWindow,
+		ExitOnEscalate:          *exitOnEscalate,
 	}
+	policy.apply(&config)
 	handler := NewEventHandler(provider, config)
+	if *stateFile != "" {
+		if err := loadStateFile(handler, *stateFile); err != nil {
+			return fmt.Errorf("failed to load state: %w", err)
+		}
+	}
+
+	// Reopen the audit log on SIGHUP for logrotate compatibility
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go reopenOnSignal(ctx, hup, handler.ReopenAuditLog)
 
 	// Run the event handler
-	if err := handler.Run(ctx); err != nil && err != context.Canceled {
-		log.Fatalf("event handler error: %v", err)
+	if err := handler.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("event handler error: %w", err)
+	}
+
+	fmt.Println()
+	if err := handler.Report(os.Stdout); err != nil {
+		log.Printf("writing shutdown report: %v", err)
+	}
+	if *stateFile != "" {
+		if err := saveStateFile(handler, *stateFile); err != nil {
+			return fmt.Errorf("failed to save state: %w", err)
+		}
+	}
+	if *learn > 0 {
+		if err := writeLearnReport(handler.LearnReport(), *learnOutput); err != nil {
+			return fmt.Errorf("failed to write learning report: %w", err)
+		}
 	}
 
 	fmt.Println("\nExiting...")
+	return nil
+}
+
+// openProvider creates the eBPF provider. With noEBPF, or with fallback when
+// the BPF programs cannot be loaded, it polls /proc instead, which finds
+// violations but cannot block anything.
+func openProvider(ctx context.Context, opts []ProviderOption, noEBPF, fallback bool) (EBPFProvider, error) {
+	if !noEBPF {
+		provider, err := NewRealEBPFProvider(opts...)
+		if err == nil {
+			return provider, nil
+		}
+		if !fallback {
+			return nil, fmt.Errorf("failed to create eBPF provider: %w", err)
+		}
+		log.Printf("eBPF is unavailable: %v", err)
+	}
+
+	log.Printf("WARNING: running without eBPF, polling /proc for open files every %v. "+
+		"ENFORCEMENT IS DISABLED: violations are reported but nothing is blocked, and short-lived opens are missed", procPollInterval)
+	return NewProcEBPFProvider(ctx, procPollInterval), nil
+}
+
+// writeLearnReport writes the learning report to path, or stdout if empty
+func writeLearnReport(report LearnReport, path string) error {
+	if path == "" {
+		return report.WriteJSON(os.Stdout)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// envPrefix prefixes the environment variables that set flags
+const envPrefix = "EBPFENCE_"
+
+// envVarName returns the environment variable that sets a flag, e.g.
+// EBPFENCE_MAX_EVENTS_PER_SEC for -max-events-per-sec
+func envVarName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnv sets each flag from its environment variable, if present. It must
+// run before the flags are parsed so that flags given on the command line
+// take precedence over the environment.
+func applyEnv(flags *flag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		name := envVarName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid %s: %w", name, setErr)
+		}
+	})
+	return err
+}
+
+// requireFileRules checks that at least one disallowed pattern, immediate
+// pattern or blocked file was given, from flags or the environment
+func requireFileRules(patterns []string, rules []Rule, blockedFiles []string) error {
+	if len(patterns) == 0 && len(rules) == 0 && len(blockedFiles) == 0 {
+		return fmt.Errorf("no files to protect: specify patterns with -disallowed or -immediate, " +
+			"or files with -block-files (or the EBPFENCE_DISALLOWED, EBPFENCE_IMMEDIATE or EBPFENCE_BLOCK_FILES environment variables)")
+	}
+	return nil
+}
+
+// requireAllowlistTarget checks that allowlist mode is limited to a PID, UID,
+// command or container: every other open of every process on the system
+// would be a violation
+func requireAllowlistTarget(pid uint32, uids []uint32, comms, containers []string) error {
+	if pid == 0 && len(uids) == 0 && len(comms) == 0 && len(containers) == 0 {
+		return fmt.Errorf("allowlist mode needs a target: specify the processes to confine with -pid, -uid, -comm or -container")
+	}
+	return nil
+}
+
+// parseIDList parses a comma-separated list of PIDs or UIDs
+func parseIDList(list string) ([]uint32, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	var ids []uint32
+	for _, field := range strings.Split(list, ",") {
+		id, err := strconv.ParseUint(strings.TrimSpace(field), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ID %q: %w", field, err)
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
+
+// validateThreshold rejects a -threshold that cannot be passed on as a
+// violation count. 0 would block on the first violation, the same as 1, so it
+// is refused rather than given a surprising meaning.
+func validateThreshold(threshold uint) error {
+	if threshold == 0 {
+		return fmt.Errorf("invalid -threshold 0: must be at least 1; to monitor without blocking use -learn or -pid-report-only")
+	}
+	if threshold > math.MaxUint32 {
+		return fmt.Errorf("invalid -threshold %d: must be at most %d", threshold, uint32(math.MaxUint32))
+	}
+	return nil
+}
+
+// parseCommThresholds parses a comma-separated list of command:threshold pairs
+func parseCommThresholds(list string) (map[string]uint32, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	thresholds := make(map[string]uint32)
+	for _, field := range strings.Split(list, ",") {
+		comm, thresholdField, ok := strings.Cut(strings.TrimSpace(field), ":")
+		if !ok || comm == "" {
+			return nil, fmt.Errorf("invalid pair %q: want command:threshold", field)
+		}
+		threshold, err := strconv.ParseUint(thresholdField, 10, 32)
+		if err != nil || threshold == 0 {
+			return nil, fmt.Errorf("invalid threshold %q for %s", thresholdField, comm)
+		}
+		thresholds[comm] = uint32(threshold)
+	}
+	return thresholds, nil
+}
+
+// parsePIDThresholds parses a comma-separated list of PID:threshold pairs
+func parsePIDThresholds(list string) (map[uint32]uint32, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+
+	thresholds := make(map[uint32]uint32)
+	for _, field := range strings.Split(list, ",") {
+		pidField, thresholdField, ok := strings.Cut(strings.TrimSpace(field), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid pair %q: want PID:threshold", field)
+		}
+		pid, err := strconv.ParseUint(pidField, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PID %q: %w", pidField, err)
+		}
+		threshold, err := strconv.ParseUint(thresholdField, 10, 32)
+		if err != nil || threshold == 0 {
+			return nil, fmt.Errorf("invalid threshold %q for PID %d", thresholdField, pid)
+		}
+		thresholds[uint32(pid)] = uint32(threshold)
+	}
+	return thresholds, nil
+}
+
+// splitPatterns parses a comma-separated pattern list. Commas inside brace
+// groups such as /etc/{passwd,shadow} do not separate patterns.
+func splitPatterns(list string) []string {
+	var patterns []string
+	depth, start := 0, 0
+	for i := 0; i < len(list); i++ {
+		switch list[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				patterns = append(patterns, strings.TrimSpace(list[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(patterns, strings.TrimSpace(list[start:]))
 }
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIDList(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  []uint32
+		expectErr bool
+	}{
+		{input: "", expected: nil},
+		{input: "1", expected: []uint32{1}},
+		{input: "1, 2,3", expected: []uint32{1, 2, 3}},
+		{input: "1,abc", expectErr: true},
+		{input: "-5", expectErr: true},
+		{input: "4294967296", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		pids, err := parseIDList(tt.input)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("parseIDList(%q): expected an error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseIDList(%q): unexpected error: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(pids, tt.expected) {
+			t.Errorf("parseIDList(%q) = %v, want %v", tt.input, pids, tt.expected)
+		}
+	}
+}
+
+func TestEnvVarName(t *testing.T) {
+	tests := map[string]string{
+		"disallowed":         "EBPFENCE_DISALLOWED",
+		"pid":                "EBPFENCE_PID",
+		"max-events-per-sec": "EBPFENCE_MAX_EVENTS_PER_SEC",
+	}
+	for flagName, expected := range tests {
+		if got := envVarName(flagName); got != expected {
+			t.Errorf("envVarName(%q) = %q, want %q", flagName, got, expected)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	newFlags := func() (*flag.FlagSet, *string, *uint, *bool) {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		disallowed := flags.String("disallowed", "", "")
+		threshold := flags.Uint("threshold", 2, "")
+		ignoreDirs := flags.Bool("ignore-dir-opens", false, "")
+		return flags, disallowed, threshold, ignoreDirs
+	}
+
+	// Defaults apply without environment or flags
+	flags, disallowed, threshold, ignoreDirs := newFlags()
+	if err := applyEnv(flags); err != nil {
+		t.Fatalf("applyEnv: %v", err)
+	}
+	flags.Parse(nil)
+	if *disallowed != "" || *threshold != 2 || *ignoreDirs {
+		t.Errorf("expected defaults, got %q %d %v", *disallowed, *threshold, *ignoreDirs)
+	}
+
+	// The environment overrides defaults
+	t.Setenv("EBPFENCE_DISALLOWED", "/etc/shadow")
+	t.Setenv("EBPFENCE_THRESHOLD", "5")
+	t.Setenv("EBPFENCE_IGNORE_DIR_OPENS", "true")
+	flags, disallowed, threshold, ignoreDirs = newFlags()
+	if err := applyEnv(flags); err != nil {
+		t.Fatalf("applyEnv: %v", err)
+	}
+	flags.Parse(nil)
+	if *disallowed != "/etc/shadow" || *threshold != 5 || !*ignoreDirs {
+		t.Errorf("expected values from the environment, got %q %d %v", *disallowed, *threshold, *ignoreDirs)
+	}
+
+	// Flags override the environment
+	flags, disallowed, threshold, ignoreDirs = newFlags()
+	if err := applyEnv(flags); err != nil {
+		t.Fatalf("applyEnv: %v", err)
+	}
+	flags.Parse([]string{"-threshold", "3", "-ignore-dir-opens=false"})
+	if *disallowed != "/etc/shadow" || *threshold != 3 || *ignoreDirs {
+		t.Errorf("expected flags to win over the environment, got %q %d %v", *disallowed, *threshold, *ignoreDirs)
+	}
+
+	// Invalid values name the variable
+	t.Setenv("EBPFENCE_THRESHOLD", "many")
+	flags, _, _, _ = newFlags()
+	if err := applyEnv(flags); err == nil || !strings.Contains(err.Error(), "EBPFENCE_THRESHOLD") {
+		t.Errorf("expected an error naming EBPFENCE_THRESHOLD, got %v", err)
+	}
+}
+
+func TestParsePIDThresholds(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  map[uint32]uint32
+		expectErr bool
+	}{
+		{input: "", expected: nil},
+		{input: "1234:1", expected: map[uint32]uint32{1234: 1}},
+		{input: "1234:1, 5678:3", expected: map[uint32]uint32{1234: 1, 5678: 3}},
+		{input: "1234", expectErr: true},
+		{input: "abc:1", expectErr: true},
+		{input: "1234:0", expectErr: true},
+		{input: "1234:-1", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		thresholds, err := parsePIDThresholds(tt.input)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("parsePIDThresholds(%q): expected an error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePIDThresholds(%q): unexpected error: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(thresholds, tt.expected) {
+			t.Errorf("parsePIDThresholds(%q) = %v, want %v", tt.input, thresholds, tt.expected)
+		}
+	}
+}
+
+func TestParseCommThresholds(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  map[string]uint32
+		expectErr bool
+	}{
+		{input: "", expected: nil},
+		{input: "curl:1", expected: map[string]uint32{"curl": 1}},
+		{input: "postgres:10, curl:1", expected: map[string]uint32{"postgres": 10, "curl": 1}},
+		{input: "curl", expectErr: true},
+		{input: ":1", expectErr: true},
+		{input: "curl:0", expectErr: true},
+		{input: "curl:many", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		thresholds, err := parseCommThresholds(tt.input)
+		if tt.expectErr {
+			if err == nil {
+				t.Errorf("parseCommThresholds(%q): expected an error", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCommThresholds(%q): unexpected error: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(thresholds, tt.expected) {
+			t.Errorf("parseCommThresholds(%q) = %v, want %v", tt.input, thresholds, tt.expected)
+		}
+	}
+}
+
+func TestRequireFileRules(t *testing.T) {
+	if err := requireFileRules(nil, nil, nil); err == nil || !strings.Contains(err.Error(), "EBPFENCE_DISALLOWED") {
+		t.Errorf("expected an error listing every way to give patterns, got %v", err)
+	}
+
+	// Any one source is enough
+	if err := requireFileRules([]string{"/etc/*"}, nil, nil); err != nil {
+		t.Errorf("disallowed patterns only: %v", err)
+	}
+	if err := requireFileRules(nil, []Rule{{Pattern: "/etc/shadow", Immediate: true}}, nil); err != nil {
+		t.Errorf("immediate patterns only: %v", err)
+	}
+	if err := requireFileRules(nil, nil, []string{"/etc/shadow"}); err != nil {
+		t.Errorf("blocked files only: %v", err)
+	}
+}
+
+func TestApplyEnv_DisallowedOnlyFromEnvironment(t *testing.T) {
+	t.Setenv("EBPFENCE_DISALLOWED", "/etc/passwd,/etc/shadow")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	disallowed := flags.String("disallowed", "", "")
+	if err := applyEnv(flags); err != nil {
+		t.Fatalf("applyEnv: %v", err)
+	}
+	flags.Parse(nil)
+
+	patterns := splitPatterns(*disallowed)
+	if err := requireFileRules(patterns, nil, nil); err != nil {
+		t.Errorf("expected patterns from the environment to be accepted, got %v", err)
+	}
+	if !reflect.DeepEqual(patterns, []string{"/etc/passwd", "/etc/shadow"}) {
+		t.Errorf("unexpected patterns %v", patterns)
+	}
+}
+
+func TestSplitPatterns(t *testing.T) {
+	tests := []struct {
+		list     string
+		expected []string
+	}{
+		{"/etc/passwd, /etc/shadow", []string{"/etc/passwd", "/etc/shadow"}},
+		{"/etc/{passwd,shadow},/root/*", []string{"/etc/{passwd,shadow}", "/root/*"}},
+		{"/a/{b,{c,d}},e", []string{"/a/{b,{c,d}}", "e"}},
+		// An unclosed brace keeps the rest of the list together
+		{"/etc/{passwd,shadow", []string{"/etc/{passwd,shadow"}},
+		{`/etc/\{a,b`, []string{`/etc/\{a`, "b"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitPatterns(tt.list); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("splitPatterns(%q) = %q, want %q", tt.list, got, tt.expected)
+		}
+	}
+}
+
+func TestOpenProvider_NoEBPF(t *testing.T) {
+	provider, err := openProvider(context.Background(), nil, true, false)
+	if err != nil {
+		t.Fatalf("openProvider: %v", err)
+	}
+	defer provider.Close()
+
+	if _, ok := provider.(*ProcEBPFProvider); !ok {
+		t.Errorf("expected the /proc polling provider, got %T", provider)
+	}
+}
+
+func TestValidateThreshold(t *testing.T) {
+	for _, threshold := range []uint{1, 2, math.MaxUint32} {
+		if err := validateThreshold(threshold); err != nil {
+			t.Errorf("validateThreshold(%d): unexpected error: %v", threshold, err)
+		}
+	}
+
+	err := validateThreshold(0)
+	if err == nil {
+		t.Fatal("validateThreshold(0): expected an error")
+	}
+	if !strings.Contains(err.Error(), "-threshold 0") || !strings.Contains(err.Error(), "-pid-report-only") {
+		t.Errorf("validateThreshold(0) error %q should name the flag and the monitor-only alternatives", err)
+	}
+
+	if err := validateThreshold(math.MaxUint32 + 1); err == nil {
+		t.Error("validateThreshold(MaxUint32+1): expected an error")
+	}
+}
+
+func TestRequireAllowlistTarget(t *testing.T) {
+	if err := requireAllowlistTarget(0, nil, nil, nil); err == nil || !strings.Contains(err.Error(), "-comm") {
+		t.Errorf("expected an error naming the target flags, got %v", err)
+	}
+	if err := requireAllowlistTarget(1234, nil, nil, nil); err != nil {
+		t.Errorf("PID target: unexpected error: %v", err)
+	}
+	if err := requireAllowlistTarget(0, []uint32{1000}, nil, nil); err != nil {
+		t.Errorf("UID target: unexpected error: %v", err)
+	}
+	if err := requireAllowlistTarget(0, nil, []string{"nginx"}, nil); err != nil {
+		t.Errorf("comm target: unexpected error: %v", err)
+	}
+	if err := requireAllowlistTarget(0, nil, nil, []string{"3f4e"}); err != nil {
+		t.Errorf("container target: unexpected error: %v", err)
+	}
+}
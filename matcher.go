@@ -0,0 +1,428 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Matcher decides whether a filename is disallowed
+type Matcher interface {
+	Matches(filename string) bool
+}
+
+// PatternReporter is implemented by matchers that can report which pattern
+// matched a filename and how. The handler uses it to keep per-pattern hit
+// counters and to explain violations in audit records.
+type PatternReporter interface {
+	MatchRule(filename string) (matched bool, pattern, kind string)
+}
+
+// Match kinds reported by PatternReporter
+const (
+	MatchExact     = "exact"     // the pattern is the filename
+	MatchGlob      = "glob"      // the pattern matched as a filepath.Match glob
+	MatchSubstring = "substring" // the pattern occurs in the filename
+	MatchDirectory = "directory" // the pattern ends in / and the file is directly in its directory
+	MatchRecursive = "recursive" // the pattern ends in /** and the file is anywhere beneath its directory
+)
+
+// PatternMatcher is the default Matcher, supporting glob and substring patterns
+type PatternMatcher struct {
+	patterns []string
+	folded   []string // lowercased patterns when matching case-insensitively, nil otherwise
+	globOnly bool     // never match patterns as substrings
+}
+
+// NewPatternMatcher creates a matcher for the given glob/substring patterns
+func NewPatternMatcher(patterns []string) *PatternMatcher {
+	return &PatternMatcher{patterns: patterns}
+}
+
+// NewCaseInsensitivePatternMatcher creates a matcher for the given
+// glob/substring patterns that ignores case, so /ETC/PASSWD matches /etc/*.
+// Both the patterns and filenames are lowercased, which leaves glob
+// metacharacters alone and keeps character ranges consistent.
+func NewCaseInsensitivePatternMatcher(patterns []string) *PatternMatcher {
+	folded := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		folded[i] = strings.ToLower(pattern)
+	}
+	return &PatternMatcher{patterns: patterns, folded: folded}
+}
+
+// NewGlobPatternMatcher creates a matcher for the given patterns that only
+// matches them exactly or as globs, never as substrings. A * does not cross
+// a /, so /home/*/.ssh/id_rsa matches /home/alice/.ssh/id_rsa but not
+// /home/alice/projects/.ssh/id_rsa.
+func NewGlobPatternMatcher(patterns []string) *PatternMatcher {
+	return &PatternMatcher{patterns: patterns, globOnly: true}
+}
+
+// newPatternMatcher creates the PatternMatcher for patterns matched as
+// CaseInsensitive and GlobOnly configure
+func newPatternMatcher(patterns []string, caseInsensitive, globOnly bool) *PatternMatcher {
+	m := NewPatternMatcher(patterns)
+	if caseInsensitive {
+		m = NewCaseInsensitivePatternMatcher(patterns)
+	}
+	m.globOnly = globOnly
+	return m
+}
+
+// Matches reports whether the filename matches any pattern
+func (m *PatternMatcher) Matches(filename string) bool {
+	matched, _, _ := m.MatchRule(filename)
+	return matched
+}
+
+// MatchRule returns the first pattern, in configured order, that matches the
+// filename and how it matched. The pattern is reported as configured, even
+// when matching ignores case.
+func (m *PatternMatcher) MatchRule(filename string) (bool, string, string) {
+	patterns := m.patterns
+	if m.folded != nil {
+		filename, patterns = strings.ToLower(filename), m.folded
+	}
+
+	index, kind := matchRuleIndex(filename, patterns, m.globOnly)
+	if index < 0 {
+		return false, "", ""
+	}
+	return true, m.patterns[index], kind
+}
+
+// AhoCorasickMatcher matches filenames against many substring patterns in a
+// single pass. Unlike PatternMatcher it does not interpret glob characters,
+// which makes it suited to large literal pattern sets.
+type AhoCorasickMatcher struct {
+	patterns []string
+	nodes    []acNode
+}
+
+// acNode is a single state of the Aho-Corasick automaton
+type acNode struct {
+	next    map[byte]int
+	fail    int
+	pattern int // index of a pattern ending at this state or its fail chain, -1 if none
+}
+
+// NewAhoCorasickMatcher builds the automaton for the given substring patterns
+func NewAhoCorasickMatcher(patterns []string) *AhoCorasickMatcher {
+	m := &AhoCorasickMatcher{
+		patterns: patterns,
+		nodes:    []acNode{{next: make(map[byte]int), pattern: -1}},
+	}
+
+	// Build the trie
+	for index, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		state := 0
+		for i := 0; i < len(pattern); i++ {
+			c := pattern[i]
+			next, ok := m.nodes[state].next[c]
+			if !ok {
+				m.nodes = append(m.nodes, acNode{next: make(map[byte]int), pattern: -1})
+				next = len(m.nodes) - 1
+				m.nodes[state].next[c] = next
+			}
+			state = next
+		}
+		// Keep the earliest configured pattern when duplicates end here
+		if m.nodes[state].pattern < 0 {
+			m.nodes[state].pattern = index
+		}
+	}
+
+	// Compute failure links breadth-first
+	queue := make([]int, 0, len(m.nodes))
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for c, child := range m.nodes[state].next {
+			fail := m.nodes[state].fail
+			for fail != 0 {
+				if _, ok := m.nodes[fail].next[c]; ok {
+					break
+				}
+				fail = m.nodes[fail].fail
+			}
+			if next, ok := m.nodes[fail].next[c]; ok && next != child {
+				m.nodes[child].fail = next
+			}
+			if m.nodes[child].pattern < 0 {
+				m.nodes[child].pattern = m.nodes[m.nodes[child].fail].pattern
+			}
+			queue = append(queue, child)
+		}
+	}
+
+	return m
+}
+
+// Matches reports whether the filename contains any of the patterns
+func (m *AhoCorasickMatcher) Matches(filename string) bool {
+	matched, _, _ := m.MatchRule(filename)
+	return matched
+}
+
+// MatchRule returns the pattern whose occurrence ends earliest in the
+// filename. When several patterns end at the same position the longest wins.
+// Patterns only ever match as substrings.
+func (m *AhoCorasickMatcher) MatchRule(filename string) (bool, string, string) {
+	state := 0
+	for i := 0; i < len(filename); i++ {
+		c := filename[i]
+		for state != 0 {
+			if _, ok := m.nodes[state].next[c]; ok {
+				break
+			}
+			state = m.nodes[state].fail
+		}
+		if next, ok := m.nodes[state].next[c]; ok {
+			state = next
+		}
+		if index := m.nodes[state].pattern; index >= 0 {
+			return true, m.patterns[index], MatchSubstring
+		}
+	}
+	return false, "", ""
+}
+
+// maxBraceExpansions caps how many patterns one pattern may expand to
+const maxBraceExpansions = 256
+
+// expandBraces expands shell-style brace groups, so /etc/{passwd,shadow}
+// becomes /etc/passwd and /etc/shadow. Groups may be nested or follow each
+// other, and alternatives may be empty. Braces without a comma, unbalanced
+// braces and braces escaped with a backslash are kept literally. A pattern
+// that would expand to more than maxBraceExpansions patterns is not expanded.
+func expandBraces(pattern string) []string {
+	expanded := []string{pattern}
+	for i := 0; i < len(expanded); {
+		p := expanded[i]
+		open, close, commas, ok := braceGroup(p)
+		if !ok {
+			i++
+			continue
+		}
+
+		alternatives := make([]string, 0, len(commas)+1)
+		start := open + 1
+		for _, comma := range append(commas, close) {
+			alternatives = append(alternatives, p[:open]+p[start:comma]+p[close+1:])
+			start = comma + 1
+		}
+		// Expand the alternatives in place, before the patterns that follow
+		expanded = append(expanded[:i], append(alternatives, expanded[i+1:]...)...)
+		if len(expanded) > maxBraceExpansions {
+			return []string{pattern}
+		}
+	}
+	return expanded
+}
+
+// braceGroup finds the first brace group in pattern with a comma at its top
+// level, returning the positions of its braces and of those commas
+func braceGroup(pattern string) (open, close int, commas []int, ok bool) {
+	for open = 0; open < len(pattern); open++ {
+		if pattern[open] == '\\' {
+			open++
+			continue
+		}
+		if pattern[open] != '{' {
+			continue
+		}
+
+		depth := 0
+		commas = nil
+	scan:
+		for i := open; i < len(pattern); i++ {
+			switch pattern[i] {
+			case '\\':
+				i++
+			case '{':
+				depth++
+			case ',':
+				if depth == 1 {
+					commas = append(commas, i)
+				}
+			case '}':
+				depth--
+				if depth > 0 {
+					continue
+				}
+				if len(commas) > 0 {
+					return open, i, commas, true
+				}
+				break scan
+			}
+		}
+	}
+	return 0, 0, nil, false
+}
+
+// expandPatterns applies expandBraces to each pattern, keeping their order,
+// and cleans the results
+func expandPatterns(patterns []string) []string {
+	if patterns == nil {
+		return nil
+	}
+	expanded := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		for _, p := range expandBraces(pattern) {
+			expanded = append(expanded, cleanPattern(p))
+		}
+	}
+	return expanded
+}
+
+// cleanPattern applies filepath.Clean to an absolute pattern, so
+// /etc//passwd and /etc/./passwd match the /etc/passwd the kernel reports.
+// A trailing / or /** is kept, and glob characters are left alone. Relative
+// patterns, which match as substrings, are kept as written.
+func cleanPattern(pattern string) string {
+	if !strings.HasPrefix(pattern, "/") {
+		return pattern
+	}
+	for _, suffix := range []string{"/**", "/"} {
+		if dir, ok := strings.CutSuffix(pattern, suffix); ok && dir != "" {
+			return filepath.Clean(dir) + suffix
+		}
+	}
+	return filepath.Clean(pattern)
+}
+
+// cleanFilename applies filepath.Clean to an absolute filename for matching.
+// Relative filenames are kept, as relative patterns are.
+func cleanFilename(filename string) string {
+	if !strings.HasPrefix(filename, "/") {
+		return filename
+	}
+	return filepath.Clean(filename)
+}
+
+// validatePattern reports why a pattern can never match as intended, e.g. a
+// glob with an unclosed [, which filepath.Match would otherwise fail on
+// silently at every open
+func validatePattern(pattern string) error {
+	if pattern == "" {
+		return errors.New("empty pattern matches every file")
+	}
+	glob := pattern
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		glob = dir
+	} else if dir, ok := strings.CutSuffix(pattern, "/"); ok && dir != "" {
+		glob = dir
+	}
+	return globSyntax(glob)
+}
+
+// globSyntax returns filepath.ErrBadPattern if filepath.Match would reject
+// glob. Match reports a syntax error only once it reaches it, so matching
+// against one name can never prove a glob valid.
+func globSyntax(glob string) error {
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '\\':
+			i++
+			if i == len(glob) {
+				return filepath.ErrBadPattern
+			}
+		case '[':
+			i++
+			if i < len(glob) && glob[i] == '^' {
+				i++
+			}
+			for ranges := 0; i >= len(glob) || glob[i] != ']' || ranges == 0; ranges++ {
+				var err error
+				if i, err = classChar(glob, i); err != nil {
+					return err
+				}
+				if i < len(glob) && glob[i] == '-' {
+					if i, err = classChar(glob, i+1); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// classChar checks the possibly escaped character at glob[i] inside a
+// character class, returning the position after it
+func classChar(glob string, i int) (int, error) {
+	if i >= len(glob) || glob[i] == '-' || glob[i] == ']' {
+		return 0, filepath.ErrBadPattern
+	}
+	if glob[i] == '\\' {
+		i++
+		if i == len(glob) {
+			return 0, filepath.ErrBadPattern
+		}
+	}
+	_, size := utf8.DecodeRuneInString(glob[i:])
+	return i + size, nil
+}
+
+// ValidatePatterns checks every file pattern of the config, returning one
+// error listing each invalid pattern and why
+func (c EventHandlerConfig) ValidatePatterns() error {
+	var problems []string
+	c.eachPattern(func(source, pattern string) {
+		if err := validatePattern(pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("%s pattern %q: %v", source, pattern, err))
+		}
+	})
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid patterns: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// DirectoryPatternWarnings describes each pattern ending in a bare /, which
+// matches only the files directly in its directory. Such patterns used to
+// match at any depth, so a config written for that would now miss files.
+func (c EventHandlerConfig) DirectoryPatternWarnings() []string {
+	var warnings []string
+	c.eachPattern(func(source, pattern string) {
+		if dir, ok := strings.CutSuffix(pattern, "/"); ok && dir != "" {
+			warnings = append(warnings, fmt.Sprintf(
+				"%s pattern %q matches only files directly in %s; use %q to match at any depth",
+				source, pattern, dir, dir+"/**"))
+		}
+	})
+	return warnings
+}
+
+// eachPattern calls fn with every file pattern of the config and where it
+// comes from
+func (c EventHandlerConfig) eachPattern(fn func(source, pattern string)) {
+	for _, pattern := range c.DisallowedPatterns {
+		fn("disallowed", pattern)
+	}
+	for _, pattern := range c.AllowedPatterns {
+		fn("allowed", pattern)
+	}
+	for _, rule := range c.Rules {
+		fn("rule", rule.Pattern)
+	}
+	for i, policy := range c.Policies {
+		name := policy.Name
+		if name == "" {
+			name = fmt.Sprintf("policy-%d", i+1)
+		}
+		for _, pattern := range policy.Patterns {
+			fn("policy "+name, pattern)
+		}
+	}
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPatternMatcher(t *testing.T) {
+	m := NewPatternMatcher([]string{"/etc/*", "secret"})
+
+	tests := []struct {
+		filename string
+		expected bool
+	}{
+		{"/etc/passwd", true},
+		{"/home/user/secret/key", true},
+		{"/tmp/file.txt", false},
+	}
+
+	for _, tt := range tests {
+		if got := m.Matches(tt.filename); got != tt.expected {
+			t.Errorf("Matches(%q) = %v, want %v", tt.filename, got, tt.expected)
+		}
+	}
+}
+
+func TestGlobPatternMatcher(t *testing.T) {
+	patterns := []string{"/home/*/.ssh/id_rsa", "/home/*/.bash_history", "secret"}
+	m := NewGlobPatternMatcher(patterns)
+
+	tests := []struct {
+		filename string
+		matched  bool
+		pattern  string
+		kind     string
+	}{
+		{"/home/alice/.ssh/id_rsa", true, "/home/*/.ssh/id_rsa", MatchGlob},
+		// * does not cross a /
+		{"/home/alice/projects/.ssh/id_rsa", false, "", ""},
+		{"/home/.ssh/id_rsa", false, "", ""},
+		{"/home/bob/.bash_history", true, "/home/*/.bash_history", MatchGlob},
+		{"/home/bob/old/.bash_history", false, "", ""},
+		// Patterns never match as substrings
+		{"/home/alice/secret", false, "", ""},
+		{"secret", true, "secret", MatchExact},
+		{"/mnt/home/*/.ssh/id_rsa", false, "", ""},
+	}
+
+	for _, tt := range tests {
+		matched, pattern, kind := m.MatchRule(tt.filename)
+		if matched != tt.matched || pattern != tt.pattern || kind != tt.kind {
+			t.Errorf("MatchRule(%q) = (%v, %q, %q), want (%v, %q, %q)",
+				tt.filename, matched, pattern, kind, tt.matched, tt.pattern, tt.kind)
+		}
+	}
+
+	// The default matcher still falls back to substrings
+	if matched, _, kind := NewPatternMatcher(patterns).MatchRule("/mnt/home/*/.ssh/id_rsa"); !matched || kind != MatchSubstring {
+		t.Errorf("expected the default matcher to match as a substring, got %v, %q", matched, kind)
+	}
+}
+
+func TestEventHandler_GlobOnly(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/home/*/.ssh/id_rsa", "shadow"},
+		Rules:              []Rule{{Pattern: "/home/*/.aws/credentials", Immediate: true}},
+		Threshold:          10,
+		GlobOnly:           true,
+	})
+
+	for _, tt := range []struct {
+		filename string
+		counted  bool
+	}{
+		{"/home/alice/.ssh/id_rsa", true},
+		{"/home/alice/projects/.ssh/id_rsa", false},
+		{"/etc/shadow", false},
+		{"/home/alice/.aws/credentials", true},
+		{"/home/alice/work/.aws/credentials", false},
+	} {
+		result, err := handler.processEvent(CreateMockEvent(1234, 1000, "cat", tt.filename))
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		if result.Counted != tt.counted {
+			t.Errorf("%s: counted = %v, want %v", tt.filename, result.Counted, tt.counted)
+		}
+	}
+}
+
+func TestCaseInsensitivePatternMatcher(t *testing.T) {
+	m := NewCaseInsensitivePatternMatcher([]string{"/ETC/*.Conf", "/etc/[a-c]*", "Id_RSA"})
+
+	tests := []struct {
+		filename string
+		matched  bool
+		pattern  string
+		kind     string
+	}{
+		{"/etc/Resolv.CONF", true, "/ETC/*.Conf", MatchGlob},
+		// Character ranges are folded along with the filename
+		{"/Etc/Bashrc", true, "/etc/[a-c]*", MatchGlob},
+		{"/etc/Zshrc", false, "", ""},
+		{"/home/alice/.ssh/id_rsa", true, "Id_RSA", MatchSubstring},
+		{"/tmp/file.txt", false, "", ""},
+	}
+
+	for _, tt := range tests {
+		matched, pattern, kind := m.MatchRule(tt.filename)
+		if matched != tt.matched || pattern != tt.pattern || kind != tt.kind {
+			t.Errorf("MatchRule(%q) = (%v, %q, %q), want (%v, %q, %q)",
+				tt.filename, matched, pattern, kind, tt.matched, tt.pattern, tt.kind)
+		}
+	}
+
+	// The case-sensitive matcher is unchanged
+	if NewPatternMatcher([]string{"/ETC/*.Conf"}).Matches("/etc/resolv.conf") {
+		t.Error("expected the default matcher to be case-sensitive")
+	}
+}
+
+func TestAhoCorasickMatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		filename string
+		expected bool
+	}{
+		{"single substring", []string{"secret"}, "/path/to/secret/file.txt", true},
+		{"exact path", []string{"/etc/passwd"}, "/etc/passwd", true},
+		{"no match", []string{"/etc/passwd"}, "/etc/hosts", false},
+		{"overlapping prefixes", []string{"abcd", "bce"}, "xabcex", true},
+		{"match via failure link", []string{"he", "she", "his", "hers"}, "ushers", true},
+		{"partial pattern", []string{"shadow", "dow"}, "/etc/shado", false},
+		{"suffix of longer pattern", []string{"shadow", "dow"}, "/etc/windows", true},
+		{"empty pattern ignored", []string{""}, "/etc/passwd", false},
+		{"no patterns", nil, "/etc/passwd", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewAhoCorasickMatcher(tt.patterns)
+			if got := m.Matches(tt.filename); got != tt.expected {
+				t.Errorf("Matches(%q) with %v = %v, want %v", tt.filename, tt.patterns, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAhoCorasickMatcher_AgreesWithSubstring(t *testing.T) {
+	patterns := []string{"passwd", "shadow", ".ssh/id_", "secret", "id_rsa"}
+	m := NewAhoCorasickMatcher(patterns)
+
+	filenames := []string{
+		"/etc/passwd",
+		"/etc/gshadow",
+		"/home/user/.ssh/id_ed25519",
+		"/home/user/.ssh/known_hosts",
+		"/var/lib/secrets/db",
+		"/tmp/id_rs",
+	}
+
+	for _, filename := range filenames {
+		// Patterns without glob characters only match via substring
+		want := matchesPattern(filename, patterns)
+		if got := m.Matches(filename); got != want {
+			t.Errorf("Matches(%q) = %v, substring matching says %v", filename, got, want)
+		}
+	}
+}
+
+func TestEventHandler_CustomMatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{
+		CreateMockEvent(1234, 1000, "app", "/etc/passwd"),
+		CreateMockEvent(1234, 1000, "app", "/tmp/safe.txt"),
+		CreateMockEvent(1234, 1000, "app", "/etc/shadow"),
+	}
+
+	provider := NewMockEBPFProvider(ctx, events)
+	defer provider.Close()
+
+	config := EventHandlerConfig{
+		Threshold: 2,
+	}
+
+	handler := NewEventHandlerWithMatcher(provider, config, NewAhoCorasickMatcher([]string{"passwd", "shadow"}))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	if handler.GetViolationCount() != 2 {
+		t.Errorf("expected 2 violations, got %d", handler.GetViolationCount())
+	}
+
+	if !provider.IsBlocked(1234) {
+		t.Error("expected PID 1234 to be blocked in provider")
+	}
+}
+
+// benchmarkPatterns generates a large set of literal patterns
+func benchmarkPatterns(n int) []string {
+	patterns := make([]string, n)
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("/srv/data/tenant-%d/secret.key", i)
+	}
+	return patterns
+}
+
+func BenchmarkPatternMatcher(b *testing.B) {
+	m := NewPatternMatcher(benchmarkPatterns(1000))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Matches("/usr/lib/x86_64-linux-gnu/libc.so.6")
+	}
+}
+
+func BenchmarkAhoCorasickMatcher(b *testing.B) {
+	m := NewAhoCorasickMatcher(benchmarkPatterns(1000))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Matches("/usr/lib/x86_64-linux-gnu/libc.so.6")
+	}
+}
+
+func TestMatchRule_FirstMatchWins(t *testing.T) {
+	m := NewPatternMatcher([]string{"secret", "/etc/*", "passwd"})
+
+	tests := []struct {
+		filename string
+		pattern  string
+		matched  bool
+	}{
+		{"/etc/passwd", "/etc/*", true},
+		{"/etc/secret", "secret", true},
+		{"/backup/passwd", "passwd", true},
+		{"/tmp/file", "", false},
+	}
+
+	for _, tt := range tests {
+		matched, pattern, _ := m.MatchRule(tt.filename)
+		if pattern != tt.pattern || matched != tt.matched {
+			t.Errorf("MatchRule(%q) = (%v, %q), want (%v, %q)",
+				tt.filename, matched, pattern, tt.matched, tt.pattern)
+		}
+	}
+}
+
+func TestMatchRule_Kind(t *testing.T) {
+	m := NewPatternMatcher([]string{"/etc/shadow", "/etc/*.conf", "id_rsa"})
+
+	tests := []struct {
+		filename string
+		pattern  string
+		kind     string
+	}{
+		{"/etc/shadow", "/etc/shadow", MatchExact},
+		{"/etc/resolv.conf", "/etc/*.conf", MatchGlob},
+		{"/home/alice/.ssh/id_rsa", "id_rsa", MatchSubstring},
+		// A literal pattern that is only part of the filename is a substring
+		{"/etc/shadow-", "/etc/shadow", MatchSubstring},
+	}
+
+	for _, tt := range tests {
+		matched, pattern, kind := m.MatchRule(tt.filename)
+		if !matched || pattern != tt.pattern || kind != tt.kind {
+			t.Errorf("MatchRule(%q) = (%v, %q, %q), want (true, %q, %q)",
+				tt.filename, matched, pattern, kind, tt.pattern, tt.kind)
+		}
+	}
+
+	if matched, pattern, kind := m.MatchRule("/tmp/file"); matched || pattern != "" || kind != "" {
+		t.Errorf("expected no match, got (%v, %q, %q)", matched, pattern, kind)
+	}
+}
+
+func TestMatchRule_DirectoryPatterns(t *testing.T) {
+	m := NewPatternMatcher([]string{"/root/", "/var/log/**", "/home/*/.ssh/"})
+
+	tests := []struct {
+		filename string
+		matched  bool
+		pattern  string
+		kind     string
+	}{
+		// A trailing slash matches direct children only
+		{"/root/x", true, "/root/", MatchDirectory},
+		{"/root/a/b", false, "", ""},
+		{"/root", false, "", ""},
+		{"/rooted/x", false, "", ""},
+		// A trailing /** matches at any depth
+		{"/var/log/syslog", true, "/var/log/**", MatchRecursive},
+		{"/var/log/nginx/access.log", true, "/var/log/**", MatchRecursive},
+		{"/var/logs/syslog", false, "", ""},
+		{"/var/log", false, "", ""},
+		// The directory may be a glob
+		{"/home/alice/.ssh/id_rsa", true, "/home/*/.ssh/", MatchDirectory},
+		{"/home/alice/.ssh/keys/id_rsa", false, "", ""},
+	}
+
+	for _, tt := range tests {
+		matched, pattern, kind := m.MatchRule(tt.filename)
+		if matched != tt.matched || pattern != tt.pattern || kind != tt.kind {
+			t.Errorf("MatchRule(%q) = (%v, %q, %q), want (%v, %q, %q)",
+				tt.filename, matched, pattern, kind, tt.matched, tt.pattern, tt.kind)
+		}
+	}
+
+	// Folding applies to the directory too
+	if !NewCaseInsensitivePatternMatcher([]string{"/Root/"}).Matches("/ROOT/x") {
+		t.Error("expected a case-insensitive directory pattern to match")
+	}
+}
+
+func TestAhoCorasickMatcher_MatchRule(t *testing.T) {
+	m := NewAhoCorasickMatcher([]string{"shadow", "dow", "/etc/", "passwd"})
+
+	tests := []struct {
+		filename string
+		pattern  string
+	}{
+		// "/etc/" ends before "shadow" or "dow"
+		{"/etc/shadow", "/etc/"},
+		// "shadow" and "dow" end together; the longer one is reported
+		{"/backup/shadow", "shadow"},
+		{"/home/windows", "dow"},
+		{"/backup/passwd", "passwd"},
+	}
+
+	for _, tt := range tests {
+		matched, pattern, kind := m.MatchRule(tt.filename)
+		if !matched || pattern != tt.pattern || kind != MatchSubstring {
+			t.Errorf("MatchRule(%q) = (%v, %q, %q), want (true, %q, %q)",
+				tt.filename, matched, pattern, kind, tt.pattern, MatchSubstring)
+		}
+	}
+}
+
+func TestExpandBraces(t *testing.T) {
+	tests := []struct {
+		name     string
+		pattern  string
+		expected []string
+	}{
+		{"no braces", "/etc/passwd", []string{"/etc/passwd"}},
+		{"simple", "/etc/{passwd,shadow,group}", []string{"/etc/passwd", "/etc/shadow", "/etc/group"}},
+		{"multiple groups", "/{etc,opt}/{a,b}", []string{"/etc/a", "/etc/b", "/opt/a", "/opt/b"}},
+		{"nested", "/etc/{ssh/{ssh_host_rsa_key,ssh_host_ed25519_key},shadow}",
+			[]string{"/etc/ssh/ssh_host_rsa_key", "/etc/ssh/ssh_host_ed25519_key", "/etc/shadow"}},
+		{"empty alternative", "/etc/shadow{,-}", []string{"/etc/shadow", "/etc/shadow-"}},
+		{"with globs", "/home/*/.ssh/id_{rsa,ed25519}", []string{"/home/*/.ssh/id_rsa", "/home/*/.ssh/id_ed25519"}},
+		// Malformed or comma-less groups are literal, as in the shell
+		{"no comma", "/etc/{passwd}", []string{"/etc/{passwd}"}},
+		{"unclosed", "/etc/{passwd,shadow", []string{"/etc/{passwd,shadow"}},
+		{"unopened", "/etc/passwd,shadow}", []string{"/etc/passwd,shadow}"}},
+		{"unclosed before a group", "/{a,{b,c}", []string{"/{a,b", "/{a,c"}},
+		{"escaped", `/etc/\{a,b}`, []string{`/etc/\{a,b}`}},
+		{"empty", "", []string{""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expandBraces(tt.pattern); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expandBraces(%q) = %q, want %q", tt.pattern, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestExpandBraces_Limit(t *testing.T) {
+	// 4^5 = 1024 patterns is over the limit, so the pattern stays as it is
+	pattern := strings.Repeat("{a,b,c,d}", 5)
+	if got := expandBraces(pattern); !reflect.DeepEqual(got, []string{pattern}) {
+		t.Errorf("expected %q not to be expanded, got %d patterns", pattern, len(got))
+	}
+
+	// 4^4 = 256 is allowed
+	if got := expandBraces(strings.Repeat("{a,b,c,d}", 4)); len(got) != 256 {
+		t.Errorf("expected 256 patterns, got %d", len(got))
+	}
+}
+
+func TestEventHandler_BracePatterns(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/{passwd,shadow}"},
+		Rules:              []Rule{{Pattern: "/root/.ssh/id_{rsa,ed25519}", Immediate: true}},
+		Threshold:          3,
+	})
+
+	for _, tt := range []struct {
+		filename string
+		matched  bool
+		blocked  bool
+	}{
+		{"/etc/shadow", true, false},
+		{"/etc/group", false, false},
+		{"/etc/{passwd,shadow}", false, false},
+		{"/root/.ssh/id_ed25519", true, true},
+	} {
+		result, err := handler.processEvent(CreateMockEvent(1234, 1000, "app", tt.filename))
+		if err != nil {
+			t.Fatalf("processEvent(%q): %v", tt.filename, err)
+		}
+		if result.Matched != tt.matched || result.Blocked != tt.blocked {
+			t.Errorf("%s: matched=%v blocked=%v, want matched=%v blocked=%v",
+				tt.filename, result.Matched, result.Blocked, tt.matched, tt.blocked)
+		}
+	}
+}
+
+func TestCleanPattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{"/etc/passwd", "/etc/passwd"},
+		{"/etc//passwd", "/etc/passwd"},
+		{"/etc/./passwd", "/etc/passwd"},
+		{"/etc/ssh/../shadow", "/etc/shadow"},
+		{"/home/*//.ssh/id_[rd]sa", "/home/*/.ssh/id_[rd]sa"},
+		// Directory and recursive suffixes keep their meaning
+		{"/etc//ssh/", "/etc/ssh/"},
+		{"/etc/./ssh/**", "/etc/ssh/**"},
+		{"/**", "/**"},
+		// Relative patterns match as substrings and are kept as written
+		{"./config", "./config"},
+		{"a//b", "a//b"},
+	}
+
+	for _, tt := range tests {
+		if got := cleanPattern(tt.pattern); got != tt.expected {
+			t.Errorf("cleanPattern(%q) = %q, want %q", tt.pattern, got, tt.expected)
+		}
+	}
+}
+
+func TestEventHandler_CleanPatterns(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc//passwd", "/root/./.ssh/"},
+		Rules:              []Rule{{Pattern: "/etc/ssh/../shadow", Immediate: true}},
+		Threshold:          10,
+	})
+
+	for _, tt := range []struct {
+		filename string
+		pattern  string
+	}{
+		{"/etc/passwd", "/etc/passwd"},
+		{"/root/.ssh/id_rsa", "/root/.ssh/"},
+		{"/etc/shadow", "/etc/shadow"},
+		// Filenames are cleaned too
+		{"/etc/./passwd", "/etc/passwd"},
+		{"//root//.ssh/id_rsa", "/root/.ssh/"},
+		{"/tmp/../etc/shadow", "/etc/shadow"},
+	} {
+		result, err := handler.processEvent(CreateMockEvent(1234, 1000, "app", tt.filename))
+		if err != nil {
+			t.Fatalf("processEvent(%q): %v", tt.filename, err)
+		}
+		if !result.Matched || result.MatchedPattern != tt.pattern {
+			t.Errorf("%s: matched=%v pattern=%q, want %q", tt.filename, result.Matched, result.MatchedPattern, tt.pattern)
+		}
+	}
+	if hits := handler.PatternHits(); hits["/etc/passwd"] != 2 {
+		t.Errorf("expected both spellings to credit /etc/passwd, got %v", hits)
+	}
+	// The accessed files are kept as the process opened them
+	if got := handler.sortedAccessedFiles(1234); len(got) != 6 || got[0] != "//root//.ssh/id_rsa" {
+		t.Errorf("accessed files = %v", got)
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	valid := EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow", "/home/*/.ssh/", "/var/log/**", "/etc/[ab]*", "/etc/*[^a-c]", "/etc/*\\*", "/etc/*[\\]]x"},
+		AllowedPatterns:    []string{"/etc/hosts"},
+		Rules:              []Rule{{Pattern: "/root/**", Immediate: true}},
+		Policies:           []Policy{{Patterns: []string{"/srv/*"}}},
+	}
+	if err := valid.ValidatePatterns(); err != nil {
+		t.Errorf("ValidatePatterns() = %v, want nil", err)
+	}
+
+	invalid := EventHandlerConfig{
+		// Errors after a * included, which matching stops short of
+		DisallowedPatterns: []string{"/etc/shadow", "/etc/[", "", "/etc/sha*[dow", "/etc/*[", "/etc/*\\", "/etc/*[]a]", "/etc/*[a-]"},
+		AllowedPatterns:    []string{"/tmp/\\"},
+		Rules:              []Rule{{Pattern: "/root/[/"}},
+		Policies:           []Policy{{Name: "web", Patterns: []string{"/srv/[a-/**"}}, {Patterns: []string{"/opt/["}}},
+	}
+	err := invalid.ValidatePatterns()
+	if err == nil {
+		t.Fatal("ValidatePatterns() = nil, want an error")
+	}
+	for _, want := range []string{
+		`disallowed pattern "/etc/[": syntax error in pattern`,
+		`disallowed pattern "": empty pattern matches every file`,
+		`allowed pattern "/tmp/\\": syntax error in pattern`,
+		`rule pattern "/root/[/": syntax error in pattern`,
+		`policy web pattern "/srv/[a-/**": syntax error in pattern`,
+		`policy policy-2 pattern "/opt/[": syntax error in pattern`,
+		`disallowed pattern "/etc/sha*[dow": syntax error in pattern`,
+		`disallowed pattern "/etc/*[": syntax error in pattern`,
+		`disallowed pattern "/etc/*\\": syntax error in pattern`,
+		`disallowed pattern "/etc/*[]a]": syntax error in pattern`,
+		`disallowed pattern "/etc/*[a-]": syntax error in pattern`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("ValidatePatterns() = %q, missing %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), `"/etc/shadow"`) {
+		t.Errorf("ValidatePatterns() = %q, reports a valid pattern", err)
+	}
+}
+
+func TestDirectoryPatternWarnings(t *testing.T) {
+	config := EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow", "/root/", "/var/log/**"},
+		Rules:              []Rule{{Pattern: "/home/*/.ssh/"}},
+		Policies:           []Policy{{Name: "web", Patterns: []string{"/srv/"}}},
+	}
+	want := []string{
+		`disallowed pattern "/root/" matches only files directly in /root; use "/root/**" to match at any depth`,
+		`rule pattern "/home/*/.ssh/" matches only files directly in /home/*/.ssh; use "/home/*/.ssh/**" to match at any depth`,
+		`policy web pattern "/srv/" matches only files directly in /srv; use "/srv/**" to match at any depth`,
+	}
+	if got := config.DirectoryPatternWarnings(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DirectoryPatternWarnings() = %q, want %q", got, want)
+	}
+}
+
+func TestEventHandler_InvalidPatterns(t *testing.T) {
+	mock := NewMockEBPFProvider(context.Background(), nil)
+	handler := NewEventHandler(mock, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/["},
+		Threshold:          1,
+	})
+
+	err := handler.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `disallowed pattern "/etc/["`) {
+		t.Errorf("Run() = %v, want the invalid pattern reported", err)
+	}
+}
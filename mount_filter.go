@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// cleanMounts cleans the mount points of MountFilter, so /data/ and /data
+// are the same mount
+func cleanMounts(mounts []string) []string {
+	cleaned := make([]string, 0, len(mounts))
+	for _, mount := range mounts {
+		cleaned = append(cleaned, filepath.Clean(mount))
+	}
+	return cleaned
+}
+
+// underMount reports whether an absolute, cleaned filename is at or beneath
+// any of the mount points, comparing whole path components: /data matches
+// /data/x but not /database/x.
+func underMount(filename string, mounts []string) bool {
+	for _, mount := range mounts {
+		if mount == "/" || filename == mount || strings.HasPrefix(filename, mount+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// onWatchedMount reports whether an event's filename is under MountFilter,
+// if set. A relative filename is relative to the process's working
+// directory or to a directory fd the event does not carry, so it cannot be
+// placed and is always monitored.
+//
+// The filename of an open is the path the process passed, not the file it
+// resolves to: an open through a symlink, or through /proc/self/root or
+// another /proc/<pid>/root, of a file under a watched mount is skipped if
+// the path itself is not under it. MountFilter only cuts noise and must not
+// be relied on to keep files protected. Reads are placed by the fd's path,
+// which is resolved.
+func (h *EventHandler) onWatchedMount(filename string) bool {
+	if len(h.watchedMounts) == 0 || !strings.HasPrefix(filename, "/") {
+		return true
+	}
+	return underMount(cleanFilename(filename), h.watchedMounts)
+}
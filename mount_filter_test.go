@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestUnderMount(t *testing.T) {
+	mounts := cleanMounts([]string{"/data/", "/srv/www"})
+	if !reflect.DeepEqual(mounts, []string{"/data", "/srv/www"}) {
+		t.Fatalf("cleanMounts = %q", mounts)
+	}
+
+	tests := []struct {
+		filename string
+		expected bool
+	}{
+		{"/data", true},
+		{"/data/db/users.sqlite", true},
+		{"/srv/www/index.html", true},
+		// Only whole path components match
+		{"/database/users.sqlite", false},
+		{"/srv/www2/index.html", false},
+		{"/srv/index.html", false},
+		{"/etc/passwd", false},
+	}
+	for _, tt := range tests {
+		if got := underMount(tt.filename, mounts); got != tt.expected {
+			t.Errorf("underMount(%q) = %v, want %v", tt.filename, got, tt.expected)
+		}
+	}
+
+	if !underMount("/etc/passwd", []string{"/"}) {
+		t.Error("expected / to cover every file")
+	}
+}
+
+func TestEventHandler_MountFilter(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"secret"},
+		Threshold:          10,
+		MountFilter:        []string{"/data"},
+	})
+
+	for _, tt := range []struct {
+		filename string
+		matched  bool
+	}{
+		{"/data/secret.txt", true},
+		{"/data/../data/secret.txt", true},
+		{"/home/alice/secret.txt", false},
+		{"/data/../home/secret.txt", false},
+		{"/database/secret.txt", false},
+		// Relative filenames cannot be placed, so they are monitored
+		{"secret.txt", true},
+	} {
+		result, err := handler.processEvent(CreateMockEvent(1234, 1000, "app", tt.filename))
+		if err != nil {
+			t.Fatalf("processEvent(%q): %v", tt.filename, err)
+		}
+		if result.Matched != tt.matched {
+			t.Errorf("%s: matched=%v, want %v", tt.filename, result.Matched, tt.matched)
+		}
+	}
+	if got := handler.Stats().EventsProcessed; got != 3 {
+		t.Errorf("expected events outside /data not to be processed, got %d processed", got)
+	}
+}
+
+func TestEventHandler_MountFilterReads(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"secret"},
+		Threshold:          10,
+		ByteThreshold:      1,
+		MountFilter:        []string{"/data"},
+	})
+	handler.proc = fakeProc(t, map[uint32]string{1234: "cat"})
+	fakeFd(t, handler.proc, 1234, 3, "/data/secret.txt")
+	fakeFd(t, handler.proc, 1234, 4, "/home/alice/secret.txt")
+
+	for fd, counted := range map[uint32]bool{3: true, 4: false} {
+		result, err := handler.processEvent(CreateMockReadEvent(1234, 1000, "cat", fd, 4096))
+		if err != nil {
+			t.Fatalf("processEvent(fd %d): %v", fd, err)
+		}
+		if result.Counted != counted {
+			t.Errorf("fd %d: counted=%v, want %v", fd, result.Counted, counted)
+		}
+	}
+	if got := handler.GetBytesReadForPID(1234, "/home/alice/secret.txt"); got != 0 {
+		t.Errorf("expected no bytes counted outside /data, got %d", got)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// openFlagBits maps the names of open(2) flags IgnoreFlags accepts to their
+// bits
+var openFlagBits = map[string]int32{
+	"O_APPEND":    unix.O_APPEND,
+	"O_CREAT":     unix.O_CREAT,
+	"O_DIRECTORY": unix.O_DIRECTORY,
+	"O_EXCL":      unix.O_EXCL,
+	"O_NOATIME":   unix.O_NOATIME,
+	"O_NOFOLLOW":  unix.O_NOFOLLOW,
+	"O_PATH":      unix.O_PATH,
+	"O_SYNC":      unix.O_SYNC,
+	"O_TRUNC":     unix.O_TRUNC,
+}
+
+// routineOpenFlags are open(2) flags IgnoreFlags rejects: they say nothing
+// about what an open is for, and libraries set them on ordinary reads, e.g.
+// O_CLOEXEC on nearly every open, so ignoring them would let any process read
+// disallowed files uncounted
+var routineOpenFlags = map[string]bool{
+	"O_CLOEXEC":  true,
+	"O_NOCTTY":   true,
+	"O_NONBLOCK": true,
+}
+
+// openAccessModes maps the names of open(2) access modes to their values.
+// O_RDONLY is 0, so access modes are compared rather than masked.
+var openAccessModes = map[string]int32{
+	"O_RDONLY": unix.O_RDONLY,
+	"O_WRONLY": unix.O_WRONLY,
+	"O_RDWR":   unix.O_RDWR,
+}
+
+// openFlagFilter matches opens carrying any of a set of flags or opened with
+// one of a set of access modes
+type openFlagFilter struct {
+	mask  int32
+	modes map[int32]bool
+}
+
+// parseOpenFlags parses flag and access mode names, e.g. O_PATH or
+// O_WRONLY, case-insensitively and with or without the O_ prefix
+func parseOpenFlags(names []string) (openFlagFilter, error) {
+	filter := openFlagFilter{modes: make(map[int32]bool)}
+	for _, name := range names {
+		key := strings.ToUpper(strings.TrimSpace(name))
+		if !strings.HasPrefix(key, "O_") {
+			key = "O_" + key
+		}
+		if routineOpenFlags[key] {
+			return openFlagFilter{}, fmt.Errorf("open flag %q is set on ordinary reads, ignoring it would leave files unprotected; expected one of: %s", name, strings.Join(openFlagNames(), ", "))
+		}
+		if bit, ok := openFlagBits[key]; ok {
+			filter.mask |= bit
+		} else if mode, ok := openAccessModes[key]; ok {
+			filter.modes[mode] = true
+		} else {
+			return openFlagFilter{}, fmt.Errorf("unknown open flag %q, expected one of: %s", name, strings.Join(openFlagNames(), ", "))
+		}
+	}
+	return filter, nil
+}
+
+// matches reports whether open flags carry one of the filter's flags or
+// its access mode is one of the filter's
+func (f openFlagFilter) matches(flags int32) bool {
+	return flags&f.mask != 0 || f.modes[flags&unix.O_ACCMODE]
+}
+
+// empty reports whether the filter matches no open
+func (f openFlagFilter) empty() bool {
+	return f.mask == 0 && len(f.modes) == 0
+}
+
+// openFlagNames returns the names parseOpenFlags accepts, sorted
+func openFlagNames() []string {
+	names := make([]string, 0, len(openFlagBits)+len(openAccessModes))
+	for name := range openFlagBits {
+		names = append(names, name)
+	}
+	for name := range openAccessModes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
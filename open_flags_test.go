@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseOpenFlags(t *testing.T) {
+	tests := []struct {
+		name      string
+		names     []string
+		flags     int32
+		expected  bool
+		expectErr bool
+	}{
+		{name: "none", flags: unix.O_PATH, expected: false},
+		{name: "O_PATH open", names: []string{"O_PATH"}, flags: unix.O_PATH | unix.O_CLOEXEC, expected: true},
+		{name: "plain read", names: []string{"O_PATH"}, flags: unix.O_RDONLY | unix.O_CLOEXEC, expected: false},
+		{name: "without prefix, any case", names: []string{"path"}, flags: unix.O_PATH, expected: true},
+		{name: "access mode", names: []string{"O_WRONLY"}, flags: unix.O_WRONLY | unix.O_CREAT, expected: true},
+		{name: "other access mode", names: []string{"O_WRONLY"}, flags: unix.O_RDWR, expected: false},
+		{name: "O_RDONLY is compared, not masked", names: []string{"O_RDONLY"}, flags: unix.O_RDWR, expected: false},
+		{name: "unknown", names: []string{"O_FROB"}, expectErr: true},
+		{name: "O_CLOEXEC is on ordinary reads", names: []string{"O_CLOEXEC"}, expectErr: true},
+		{name: "O_NONBLOCK is on ordinary reads", names: []string{"nonblock"}, expectErr: true},
+		{name: "O_NOCTTY is on ordinary reads", names: []string{"O_NOCTTY"}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := parseOpenFlags(tt.names)
+			if tt.expectErr {
+				if err == nil || !strings.Contains(err.Error(), "O_PATH") {
+					t.Errorf("expected an error listing the known flags, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := filter.matches(tt.flags); got != tt.expected {
+				t.Errorf("matches(%#o) = %v, want %v", tt.flags, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEventHandler_IgnoreFlags(t *testing.T) {
+	pathOpen := CreateMockEvent(1234, 1000, "find", "/etc/passwd")
+	pathOpen.Flags = unix.O_PATH | unix.O_CLOEXEC
+	write := CreateMockEvent(1234, 1000, "find", "/etc/hosts")
+	write.Flags = unix.O_WRONLY | unix.O_TRUNC
+	read := CreateMockEvent(1234, 1000, "find", "/etc/group")
+	read.Flags = unix.O_RDONLY
+	rename := CreateMockEvent(1234, 1000, "find", "/etc/shadow")
+	rename.Type = EventRename
+
+	tests := []struct {
+		name     string
+		ignore   []string
+		expected uint32
+	}{
+		{name: "all counted", expected: 4},
+		{name: "O_PATH opens skipped", ignore: []string{"O_PATH"}, expected: 3},
+		{name: "only reads counted", ignore: []string{"O_PATH", "O_WRONLY", "O_RDWR"}, expected: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewMockEBPFProvider(context.Background(), nil)
+			defer provider.Close()
+
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/*"},
+				Threshold:          10,
+				IgnoreFlags:        tt.ignore,
+			})
+			for _, event := range []*Event{pathOpen, write, read, rename} {
+				if _, err := handler.processEvent(event); err != nil {
+					t.Fatalf("processEvent: %v", err)
+				}
+			}
+			if got := handler.GetViolationCountForPID(1234); got != tt.expected {
+				t.Errorf("expected %d violations, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEventHandler_InvalidIgnoreFlags(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/passwd"},
+		IgnoreFlags:        []string{"O_FROB"},
+	})
+	if err := handler.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "O_FROB") {
+		t.Errorf("expected Run to fail on the unknown flag, got %v", err)
+	}
+}
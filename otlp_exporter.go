@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	otlpBatchSize     = 100
+	otlpFlushInterval = time.Second
+	otlpQueueSize     = 4096
+)
+
+// OTLPExporter ships audit records to an OpenTelemetry collector as OTLP log
+// records. It speaks OTLP/HTTP with JSON encoding so no OpenTelemetry SDK
+// dependency is needed. Records are queued and sent in batches so a slow
+// collector never stalls event processing.
+type OTLPExporter struct {
+	url           string
+	client        *http.Client
+	queue         chan AuditRecord
+	batchSize     int
+	flushInterval time.Duration
+	dropped       atomic.Uint64
+}
+
+// NewOTLPExporter creates an exporter for the collector at endpoint, e.g.
+// http://localhost:4318. Records are only sent once Run is started.
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+	return &OTLPExporter{
+		url:           strings.TrimRight(endpoint, "/") + "/v1/logs",
+		client:        &http.Client{Timeout: 10 * time.Second},
+		queue:         make(chan AuditRecord, otlpQueueSize),
+		batchSize:     otlpBatchSize,
+		flushInterval: otlpFlushInterval,
+	}
+}
+
+// Export queues a record without blocking. Records are dropped if the queue is full.
+func (e *OTLPExporter) Export(record AuditRecord) {
+	select {
+	case e.queue <- record:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of records dropped because the queue was full
+func (e *OTLPExporter) Dropped() uint64 {
+	return e.dropped.Load()
+}
+
+// Run sends queued records in batches until the context is cancelled, then
+// flushes whatever is still queued
+func (e *OTLPExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]AuditRecord, 0, e.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			log.Printf("exporting to OTLP collector: %v", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case record := <-e.queue:
+			batch = append(batch, record)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case record := <-e.queue:
+					batch = append(batch, record)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts a batch of records to the collector
+func (e *OTLPExporter) send(records []AuditRecord) error {
+	body, err := json.Marshal(otlpLogsRequest(records))
+	if err != nil {
+		return fmt.Errorf("encode OTLP request: %w", err)
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("post OTLP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP/JSON request structure, a subset of ExportLogsServiceRequest
+type (
+	otlpRequest struct {
+		ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+	}
+	otlpResourceLogs struct {
+		Resource  otlpResource    `json:"resource"`
+		ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeLogs struct {
+		Scope      otlpScope       `json:"scope"`
+		LogRecords []otlpLogRecord `json:"logRecords"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpLogRecord struct {
+		TimeUnixNano string          `json:"timeUnixNano"`
+		SeverityText string          `json:"severityText"`
+		Body         otlpValue       `json:"body"`
+		Attributes   []otlpAttribute `json:"attributes"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	// otlpValue is an AnyValue; int64 values are strings in the JSON mapping
+	otlpValue struct {
+		StringValue *string `json:"stringValue,omitempty"`
+		IntValue    *string `json:"intValue,omitempty"`
+	}
+)
+
+func otlpString(key, value string) otlpAttribute {
+	return otlpAttribute{Key: key, Value: otlpValue{StringValue: &value}}
+}
+
+func otlpInt(key string, value uint64) otlpAttribute {
+	s := strconv.FormatUint(value, 10)
+	return otlpAttribute{Key: key, Value: otlpValue{IntValue: &s}}
+}
+
+// otlpLogsRequest converts audit records into an OTLP logs request
+func otlpLogsRequest(records []AuditRecord) otlpRequest {
+	logRecords := make([]otlpLogRecord, 0, len(records))
+	for _, record := range records {
+		body := record.Type
+		attributes := []otlpAttribute{
+			otlpString("event.type", record.Type),
+			otlpInt("pid", uint64(record.PID)),
+			otlpInt("uid", uint64(record.UID)),
+			otlpString("comm", record.Comm),
+			otlpString("filename", record.Filename),
+			otlpInt("count", uint64(record.Count)),
+			otlpInt("threshold", uint64(record.Threshold)),
+		}
+		if record.Pattern != "" {
+			attributes = append(attributes,
+				otlpString("pattern", record.Pattern),
+				otlpString("match.kind", record.MatchKind))
+		}
+		if record.TID != 0 {
+			attributes = append(attributes, otlpInt("thread.id", uint64(record.TID)))
+		}
+		if record.Container != "" {
+			attributes = append(attributes, otlpString("container.id", record.Container))
+		}
+		logRecords = append(logRecords, otlpLogRecord{
+			TimeUnixNano: strconv.FormatInt(record.Time.UnixNano(), 10),
+			SeverityText: "WARN",
+			Body:         otlpValue{StringValue: &body},
+			Attributes:   attributes,
+		})
+	}
+
+	return otlpRequest{
+		ResourceLogs: []otlpResourceLogs{{
+			Resource: otlpResource{Attributes: []otlpAttribute{otlpString("service.name", "ebpfence")}},
+			ScopeLogs: []otlpScopeLogs{{
+				Scope:      otlpScope{Name: "ebpfence"},
+				LogRecords: logRecords,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCollector is an OTLP/HTTP receiver that records the log records it gets
+type fakeCollector struct {
+	mu       sync.Mutex
+	requests int
+	records  []map[string]string // attribute key -> value, per log record
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	for _, rl := range req.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			for _, lr := range sl.LogRecords {
+				attrs := make(map[string]string)
+				for _, attr := range lr.Attributes {
+					switch {
+					case attr.Value.StringValue != nil:
+						attrs[attr.Key] = *attr.Value.StringValue
+					case attr.Value.IntValue != nil:
+						attrs[attr.Key] = *attr.Value.IntValue
+					}
+				}
+				c.records = append(c.records, attrs)
+			}
+		}
+	}
+}
+
+func (c *fakeCollector) snapshot() (int, []map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests, append([]map[string]string(nil), c.records...)
+}
+
+func TestOTLPExporter_Batching(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	exporter := NewOTLPExporter(server.URL + "/")
+	exporter.batchSize = 2
+	exporter.flushInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exporter.Run(ctx)
+		close(done)
+	}()
+
+	for i := 0; i < 3; i++ {
+		exporter.Export(AuditRecord{Type: "violation", PID: uint32(1000 + i)})
+	}
+
+	// The first two records fill a batch and are sent right away
+	deadline := time.Now().Add(time.Second)
+	for {
+		if requests, _ := collector.snapshot(); requests == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the first batch")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The third is flushed on shutdown
+	cancel()
+	<-done
+
+	requests, records := collector.snapshot()
+	if requests != 2 {
+		t.Errorf("expected 2 requests, got %d", requests)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	for i, record := range records {
+		if want := []string{"1000", "1001", "1002"}[i]; record["pid"] != want {
+			t.Errorf("record %d: expected pid %s, got %s", i, want, record["pid"])
+		}
+	}
+}
+
+func TestOTLPExporter_DropsWhenQueueFull(t *testing.T) {
+	exporter := NewOTLPExporter("http://127.0.0.1:0")
+	exporter.queue = make(chan AuditRecord, 1)
+
+	exporter.Export(AuditRecord{})
+	exporter.Export(AuditRecord{})
+
+	if exporter.Dropped() != 1 {
+		t.Errorf("expected 1 dropped record, got %d", exporter.Dropped())
+	}
+}
+
+func TestEventHandler_OTLPExport(t *testing.T) {
+	collector := &fakeCollector{}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{
+		CreateMockEvent(1234, 1000, "app", "/etc/passwd"),
+		CreateMockEvent(1234, 1000, "app", "/tmp/safe.txt"),
+	}
+
+	provider := NewMockEBPFProvider(ctx, events)
+	defer provider.Close()
+
+	config := EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+		OTLPEndpoint:       server.URL,
+	}
+
+	handler := NewEventHandler(provider, config)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	<-done
+
+	_, records := collector.snapshot()
+	if len(records) != 2 {
+		t.Fatalf("expected 2 exported records, got %d", len(records))
+	}
+
+	for i, recordType := range []string{"violation", "block"} {
+		record := records[i]
+		if record["event.type"] != recordType {
+			t.Errorf("record %d: expected type %s, got %s", i, recordType, record["event.type"])
+		}
+		if record["pid"] != "1234" || record["comm"] != "app" || record["filename"] != "/etc/passwd" {
+			t.Errorf("record %d: unexpected attributes %v", i, record)
+		}
+	}
+}
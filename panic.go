@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+// BlockAllForUID blocks every running process whose real or effective UID is
+// uid, e.g. to freeze a compromised account during an incident. Processes
+// started afterwards are not blocked, and the fence itself never is.
+func (h *EventHandler) BlockAllForUID(uid uint32) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	blocked, err := blockUID(h.proc, uid, h.selfPID, func(pid uint32) (bool, error) {
+		if h.isBlocked(pid) {
+			return false, nil
+		}
+		comm, _ := h.proc.comm(pid)
+		h.blockedPIDs[pid] = blockRecord{comm: comm}
+		if err := h.blockPID(pid, BlockReasonPanic, ""); err != nil {
+			return false, err
+		}
+		h.printBlocked(pid)
+		h.audit("block", &Event{Pid: pid, Uid: uid}, comm, "", 0, 0, ProcessResult{Blocked: true})
+		return true, nil
+	})
+	fmt.Printf("%s[PANIC] Blocked %d processes of UID %d\n", h.relativeTime(), blocked, uid)
+	if err != nil {
+		return fmt.Errorf("blocking processes of UID %d: %w", uid, err)
+	}
+	return nil
+}
+
+// blockUID calls block for every running process of uid in proc but self,
+// returning how many it blocked. block returns false for a PID it skipped,
+// e.g. one blocked already; its errors are collected and the rest still
+// blocked.
+func blockUID(proc procFS, uid, self uint32, block func(pid uint32) (bool, error)) (int, error) {
+	pids, err := proc.pidsOfUID(uid)
+	if err != nil {
+		return 0, err
+	}
+
+	var blocked int
+	var errs []error
+	for _, pid := range pids {
+		if pid == self {
+			continue
+		}
+		ok, err := block(pid)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to block PID %d: %w", pid, err))
+			continue
+		}
+		if ok {
+			blocked++
+		}
+	}
+	return blocked, errors.Join(errs...)
+}
+
+// parsePanicCommand parses the arguments of the panic command: an optional
+// -pin-path followed by the UID. Root is refused, as blocking every root
+// process would freeze the host.
+func parsePanicCommand(args []string) (uint32, string, error) {
+	flags := flag.NewFlagSet("panic", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	pinPath := flags.String("pin-path", defaultPinPath, "bpffs directory the running instance pinned its maps under")
+	if err := applyEnv(flags); err != nil {
+		return 0, "", fmt.Errorf("panic: %w", err)
+	}
+	if err := flags.Parse(args); err != nil {
+		return 0, "", fmt.Errorf("panic: %w", err)
+	}
+
+	if flags.NArg() != 1 {
+		return 0, "", fmt.Errorf("usage: ebpfence panic [-pin-path dir] <uid>")
+	}
+	uid, err := strconv.ParseUint(flags.Arg(0), 10, 32)
+	if err != nil {
+		return 0, "", fmt.Errorf("panic: invalid UID %q", flags.Arg(0))
+	}
+	if uid == 0 {
+		return 0, "", fmt.Errorf("panic: refusing to block every process of root")
+	}
+	return uint32(uid), *pinPath, nil
+}
+
+// panicCommand blocks every running process of a UID in a running instance
+func panicCommand(args []string) error {
+	uid, pinPath, err := parsePanicCommand(args)
+	if err != nil {
+		return err
+	}
+
+	blocked, err := loadPinnedMap(pinPath, "blocked_pids")
+	if err != nil {
+		return err
+	}
+	defer blocked.Close()
+
+	info := BlockInfo{Reason: BlockReasonPanic, Rule: NoRule, BlockedAt: uint64(time.Now().UnixNano())}
+	_, err = blockUID(hostProc, uid, uint32(os.Getpid()), func(pid uint32) (bool, error) {
+		if err := blocked.Update(pid, info, ebpf.UpdateAny); err != nil {
+			return false, err
+		}
+		fmt.Printf("PID %d is now BLOCKED\n", pid)
+		return true, nil
+	})
+	if err != nil {
+		return fmt.Errorf("panic: %w", err)
+	}
+	return nil
+}
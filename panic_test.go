@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// fakeStatus gives a fake process a status file with the given real and
+// effective UIDs
+func fakeStatus(t *testing.T, proc procFS, pid, real, effective uint32) {
+	t.Helper()
+	status := "Name:\tx\nUid:\t" + strconv.FormatUint(uint64(real), 10) + "\t" +
+		strconv.FormatUint(uint64(effective), 10) + "\t0\t0\nGid:\t0\t0\t0\t0\n"
+	path := filepath.Join(proc.root, strconv.FormatUint(uint64(pid), 10), "status")
+	if err := os.WriteFile(path, []byte(status), 0644); err != nil {
+		t.Fatalf("create fake status: %v", err)
+	}
+}
+
+func TestProcFS_PIDsOfUID(t *testing.T) {
+	proc := fakeProc(t, map[uint32]string{100: "bash", 101: "sudo", 102: "sshd", 103: "zombie"})
+	fakeStatus(t, proc, 100, 1000, 1000)
+	fakeStatus(t, proc, 101, 1000, 0)
+	fakeStatus(t, proc, 102, 0, 0)
+
+	pids, err := proc.pidsOfUID(1000)
+	if err != nil {
+		t.Fatalf("pidsOfUID: %v", err)
+	}
+	if !reflect.DeepEqual(pids, []uint32{100, 101}) {
+		t.Errorf("pidsOfUID(1000) = %v, want [100 101]", pids)
+	}
+}
+
+func TestEventHandler_BlockAllForUID(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{DisallowedPatterns: []string{"/etc/shadow"}})
+	proc := fakeProc(t, map[uint32]string{100: "bash", 101: "miner", 102: "sshd", handler.selfPID: "ebpfence"})
+	fakeStatus(t, proc, 100, 1000, 1000)
+	fakeStatus(t, proc, 101, 1000, 1000)
+	fakeStatus(t, proc, 102, 0, 0)
+	fakeStatus(t, proc, handler.selfPID, 1000, 1000)
+	handler.proc = proc
+
+	if err := handler.BlockAllForUID(1000); err != nil {
+		t.Fatalf("BlockAllForUID: %v", err)
+	}
+	for _, pid := range []uint32{100, 101} {
+		if !provider.IsBlocked(pid) {
+			t.Errorf("expected PID %d of UID 1000 to be blocked", pid)
+		}
+	}
+	if provider.IsBlocked(102) {
+		t.Error("expected a process of another UID not to be blocked")
+	}
+	if provider.IsBlocked(handler.selfPID) {
+		t.Error("expected the fence never to block itself")
+	}
+	if summary := handler.GetBlockedSummary(); !reflect.DeepEqual(summary["miner"], []uint32{101}) {
+		t.Errorf("expected PID 101 to be recorded as miner, got %v", summary)
+	}
+}
+
+func TestBlockUID(t *testing.T) {
+	proc := fakeProc(t, map[uint32]string{100: "bash", 101: "miner", 102: "vim", 103: "sshd", 200: "ebpfence"})
+	for _, pid := range []uint32{100, 101, 102, 200} {
+		fakeStatus(t, proc, pid, 1000, 1000)
+	}
+	fakeStatus(t, proc, 103, 0, 0)
+
+	// PID 100 is blocked already and blocking PID 102 fails
+	var called []uint32
+	blocked, err := blockUID(proc, 1000, 200, func(pid uint32) (bool, error) {
+		called = append(called, pid)
+		switch pid {
+		case 100:
+			return false, nil
+		case 102:
+			return false, errors.New("map full")
+		}
+		return true, nil
+	})
+	if !reflect.DeepEqual(called, []uint32{100, 101, 102}) {
+		t.Errorf("block called for %v, want [100 101 102]", called)
+	}
+	if blocked != 1 {
+		t.Errorf("blocked %d processes, want 1", blocked)
+	}
+	if err == nil || err.Error() != "failed to block PID 102: map full" {
+		t.Errorf("expected the failure of PID 102, got %v", err)
+	}
+
+	if _, err := blockUID(procFS{root: filepath.Join(t.TempDir(), "missing")}, 1000, 0, nil); err == nil {
+		t.Error("expected an error for an unreadable proc")
+	}
+}
+
+func TestParsePanicCommand(t *testing.T) {
+	if uid, pinPath, err := parsePanicCommand([]string{"-pin-path", "/tmp/pins", "1000"}); err != nil || uid != 1000 || pinPath != "/tmp/pins" {
+		t.Errorf("got (%d, %q, %v), want (1000, /tmp/pins, nil)", uid, pinPath, err)
+	}
+	for _, args := range [][]string{nil, {"0"}, {"abc"}, {"1", "2"}, {"4294967296"}} {
+		if _, _, err := parsePanicCommand(args); err == nil {
+			t.Errorf("parsePanicCommand(%q): expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import "fmt"
+
+// checkPIDReuse compares the start time of the process behind pid with the
+// one its state was tracked for. If they differ the PID was reused: the state
+// of the old process is forgotten and, if it was blocked, the block is lifted
+// so the new process is not denied for what the old one did. It reports
+// whether a block was lifted. A process that already exited cannot be
+// checked and keeps the state.
+func (h *EventHandler) checkPIDReuse(pid uint32) (bool, error) {
+	start, err := h.proc.startTime(pid)
+	if err != nil {
+		return false, nil
+	}
+	tracked, ok := h.pidStarts[pid]
+	h.pidStarts[pid] = start
+	if !ok || tracked == start {
+		return false, nil
+	}
+
+	_, blocked := h.blockedPIDs[pid]
+	h.forgetPID(pid)
+	h.pidStarts[pid] = start
+	if !blocked {
+		return false, nil
+	}
+	fmt.Printf("%sPID %d was reused by a new process, lifting the block of the old one\n", h.relativeTime(), pid)
+	if err := h.provider.UnblockPID(pid); err != nil {
+		return true, fmt.Errorf("failed to unblock reused PID %d: %w", pid, err)
+	}
+	if err := h.release(pid); err != nil {
+		return true, fmt.Errorf("failed to unblock reused PID %d: %w", pid, err)
+	}
+	return true, nil
+}
+
+// trackStart records the start time of the process behind pid, once, so its
+// state is known to be for that process. A process that already exited is
+// left untracked.
+func (h *EventHandler) trackStart(pid uint32) {
+	if _, ok := h.pidStarts[pid]; ok {
+		return
+	}
+	if start, err := h.proc.startTime(pid); err == nil {
+		h.pidStarts[pid] = start
+	}
+}
+
+// forgetPID drops everything tracked for pid
+func (h *EventHandler) forgetPID(pid uint32) {
+	delete(h.violationCounts, pid)
+	h.recordViolationCount(pid)
+	delete(h.accessedFiles, pid)
+	delete(h.openCounts, pid)
+	delete(h.countedInodes, pid)
+	delete(h.openTimes, pid)
+	delete(h.blockedPIDs, pid)
+	delete(h.blockedAttempts, pid)
+	delete(h.confirmedBlocks, pid)
+	delete(h.warnedPIDs, pid)
+	delete(h.bytesRead, pid)
+	delete(h.fullComms, pid)
+	delete(h.containers, pid)
+	delete(h.parents, pid)
+	delete(h.pidStarts, pid)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// fakeStat gives a fake process a stat file with the given comm and start
+// time
+func fakeStat(t *testing.T, proc procFS, pid uint32, comm string, start uint64) {
+	t.Helper()
+	stat := fmt.Sprintf("%d (%s) S 1 %d %d 0 -1 4194560 100 0 0 0 1 2 0 0 20 0 1 0 %d 8192000 200 18446744073709551615\n",
+		pid, comm, pid, pid, start)
+	path := filepath.Join(proc.root, strconv.FormatUint(uint64(pid), 10), "stat")
+	if err := os.WriteFile(path, []byte(stat), 0644); err != nil {
+		t.Fatalf("create fake stat: %v", err)
+	}
+}
+
+func TestProcFS_StartTime(t *testing.T) {
+	proc := fakeProc(t, map[uint32]string{100: "cat", 101: "odd", 102: "short"})
+	fakeStat(t, proc, 100, "cat", 123456)
+	// The comm may contain spaces and parentheses
+	fakeStat(t, proc, 101, "a) (b c", 42)
+	path := filepath.Join(proc.root, "102", "stat")
+	if err := os.WriteFile(path, []byte("102 (short) S 1 102\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := proc.startTime(100); err != nil || got != 123456 {
+		t.Errorf("startTime(100) = %d, %v, want 123456", got, err)
+	}
+	if got, err := proc.startTime(101); err != nil || got != 42 {
+		t.Errorf("startTime(101) = %d, %v, want 42", got, err)
+	}
+	if _, err := proc.startTime(102); err == nil {
+		t.Error("expected an error for a truncated stat")
+	}
+	if _, err := proc.startTime(103); err == nil {
+		t.Error("expected an error for a missing process")
+	}
+}
+
+func TestEventHandler_TrackPIDReuse(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow"},
+		Threshold:          2,
+		TrackPIDReuse:      true,
+	})
+	handler.proc = fakeProc(t, map[uint32]string{100: "cat", 200: "less"})
+	fakeStat(t, handler.proc, 100, "cat", 1000)
+	fakeStat(t, handler.proc, 200, "less", 2000)
+
+	for i := 0; i < 2; i++ {
+		if _, err := handler.processEvent(CreateMockEvent(100, 1000, "cat", "/etc/shadow")); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+	if _, err := handler.processEvent(CreateMockEvent(200, 1000, "less", "/etc/shadow")); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if !provider.IsBlocked(100) {
+		t.Fatal("expected PID 100 to be blocked")
+	}
+
+	// Both PIDs wrap around to new processes
+	fakeStat(t, handler.proc, 100, "sh", 5000)
+	fakeStat(t, handler.proc, 200, "less", 6000)
+
+	result, err := handler.processEvent(CreateMockEvent(100, 1000, "sh", "/etc/shadow"))
+	if err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if result.Blocked {
+		t.Error("expected the new process not to be blocked at its first violation")
+	}
+	if provider.IsBlocked(100) {
+		t.Error("expected the block of the old process to be lifted")
+	}
+	if got := handler.GetViolationCountForPID(100); got != 1 {
+		t.Errorf("PID 100 violations = %d, want 1", got)
+	}
+
+	if _, err := handler.processEvent(CreateMockEvent(200, 1000, "less", "/etc/shadow")); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if provider.IsBlocked(200) {
+		t.Error("expected the new PID 200 not to inherit the old one's violation")
+	}
+	if got := handler.GetViolationCountForPID(200); got != 1 {
+		t.Errorf("PID 200 violations = %d, want 1", got)
+	}
+}
+
+func TestEventHandler_TrackPIDReuseSameProcess(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow"},
+		Threshold:          3,
+		TrackPIDReuse:      true,
+	})
+	// PID 100 keeps its start time, PID 200 has no stat, e.g. it exited
+	handler.proc = fakeProc(t, map[uint32]string{100: "cat", 200: "less"})
+	fakeStat(t, handler.proc, 100, "cat", 1000)
+
+	for i := 0; i < 3; i++ {
+		for _, pid := range []uint32{100, 200} {
+			if _, err := handler.processEvent(CreateMockEvent(pid, 1000, "cat", "/etc/shadow")); err != nil {
+				t.Fatalf("processEvent: %v", err)
+			}
+		}
+	}
+	for _, pid := range []uint32{100, 200} {
+		if !provider.IsBlocked(pid) {
+			t.Errorf("expected PID %d to be blocked at the threshold", pid)
+		}
+	}
+}
+
+func TestEventHandler_LoadStateStartTime(t *testing.T) {
+	comms := map[uint32]string{100: "cat", 200: "cat"}
+	handler, _ := stateHandler(t, comms)
+	fakeStat(t, handler.proc, 100, "cat", 1000)
+	fakeStat(t, handler.proc, 200, "cat", 2000)
+	for i := 0; i < 2; i++ {
+		for _, pid := range []uint32{100, 200} {
+			if _, err := handler.processEvent(CreateMockEvent(pid, 1000, "cat", "/etc/shadow")); err != nil {
+				t.Fatalf("processEvent: %v", err)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := handler.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	// PID 200 was reused by another cat while the instance was down
+	restored, provider := stateHandler(t, comms)
+	fakeStat(t, restored.proc, 100, "cat", 1000)
+	fakeStat(t, restored.proc, 200, "cat", 9000)
+	if err := provider.BlockPID(200); err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.LoadState(&buf); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	if !provider.IsBlocked(100) {
+		t.Error("expected PID 100 to be blocked again")
+	}
+	if provider.IsBlocked(200) {
+		t.Error("expected the reused PID 200 to be unblocked")
+	}
+	if got := restored.GetViolationCountForPID(200); got != 0 {
+		t.Errorf("reused PID 200 violations = %d, want 0", got)
+	}
+}
+
+func TestEventHandler_TrackPIDReuseDenied(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow"},
+		Threshold:          1,
+		TrackPIDReuse:      true,
+	})
+	handler.proc = fakeProc(t, map[uint32]string{100: "cat"})
+	fakeStat(t, handler.proc, 100, "cat", 1000)
+
+	if _, err := handler.processEvent(CreateMockEvent(100, 1000, "cat", "/etc/shadow")); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if !provider.IsBlocked(100) {
+		t.Fatal("expected PID 100 to be blocked")
+	}
+
+	// The new process on the reused PID is still in the kernel's blocked
+	// list, so all it produces are denials
+	fakeStat(t, handler.proc, 100, "sh", 5000)
+	if _, err := handler.processEvent(CreateMockDeniedEvent(100, 1000, "sh", "/etc/hostname")); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	if provider.IsBlocked(100) {
+		t.Error("expected the stale block to be lifted on a denial")
+	}
+	if handler.IsPIDBlocked(100) {
+		t.Error("expected the handler to forget the stale block")
+	}
+	if got := handler.GetConfirmedBlocks(100); got != 0 {
+		t.Errorf("expected the old block's denial not to be recorded, got %d", got)
+	}
+}
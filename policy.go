@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Policy applies its own patterns and threshold to the processes it selects.
+// Policies are tried in order and an event goes to the first one selecting
+// its process; events no policy selects use the handler's top-level
+// DisallowedPatterns and Threshold.
+type Policy struct {
+	Name      string   `json:"name"` // identifies the policy in output and stats
+	Patterns  []string `json:"patterns"`
+	Threshold uint32   `json:"threshold"`
+	PIDs      []uint32 `json:"pids"` // select these host PIDs, empty for any
+	UIDs      []uint32 `json:"uids"` // select these UIDs, empty for any
+}
+
+// PolicyStats counts the violations and blocks attributed to a policy
+type PolicyStats struct {
+	Violations uint64 `json:"violations"`
+	Blocks     uint64 `json:"blocks"`
+}
+
+// loadPolicies reads the policies in a JSON file, an array tried in order,
+// e.g.
+//
+//	[{"name": "web", "patterns": ["/etc/shadow"], "threshold": 1, "uids": [33]}]
+func loadPolicies(path string) ([]Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read policy file: %w", err)
+	}
+
+	var policies []Policy
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&policies); err != nil {
+		return nil, fmt.Errorf("parse policy file %s: %w", path, err)
+	}
+	for i, policy := range policies {
+		if len(policy.Patterns) == 0 {
+			return nil, fmt.Errorf("policy %d in %s has no patterns", i+1, path)
+		}
+		if policy.Threshold == 0 {
+			return nil, fmt.Errorf("policy %d in %s: threshold must be at least 1", i+1, path)
+		}
+	}
+	return policies, nil
+}
+
+// formatPolicyStats formats per-policy stats for the stats line, sorted by
+// name, e.g. "batch:0/0,default:3/1,web:5/2" for violations/blocks
+func formatPolicyStats(stats map[string]PolicyStats) string {
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s:%d/%d", name, stats[name].Violations, stats[name].Blocks)
+	}
+	return strings.Join(parts, ",")
+}
+
+// defaultPolicyName identifies the top-level patterns and threshold in stats
+const defaultPolicyName = "default"
+
+// activePolicy is a Policy prepared for matching, with its counters
+type activePolicy struct {
+	name      string
+	matcher   Matcher
+	threshold uint32
+	pids      map[uint32]bool
+	uids      map[uint32]bool
+	stats     PolicyStats
+}
+
+// newActivePolicy prepares the i-th configured policy
+func newActivePolicy(i int, p Policy, caseInsensitive, globOnly bool) *activePolicy {
+	name := p.Name
+	if name == "" {
+		name = fmt.Sprintf("policy-%d", i+1)
+	}
+
+	policy := &activePolicy{
+		name:      name,
+		matcher:   newPatternMatcher(p.Patterns, caseInsensitive, globOnly),
+		threshold: p.Threshold,
+		pids:      make(map[uint32]bool),
+		uids:      make(map[uint32]bool),
+	}
+	for _, pid := range p.PIDs {
+		policy.pids[pid] = true
+	}
+	for _, uid := range p.UIDs {
+		policy.uids[uid] = true
+	}
+	return policy
+}
+
+// selects reports whether the policy applies to the process behind an
+// event. When both PIDs and UIDs are set, both must match.
+func (p *activePolicy) selects(event *Event) bool {
+	if len(p.pids) > 0 && !p.pids[event.Pid] {
+		return false
+	}
+	if len(p.uids) > 0 && !p.uids[event.Uid] {
+		return false
+	}
+	return true
+}
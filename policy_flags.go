@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"time"
+)
+
+// policyFlags are the flags deciding what a violation is and when a process
+// is blocked, shared by run and verify so a recording is replayed against
+// the policy run would enforce
+type policyFlags struct {
+	disallowed          *string
+	immediate           *string
+	policyMode          *string
+	allowed             *string
+	policyFile          *string
+	threshold           *uint
+	pidThresholds       *string
+	commThresholds      *string
+	graceOpens          *uint
+	successfulOpensOnly *bool
+	ignoreDirs          *bool
+	ignoreFlags         *string
+	mounts              *string
+	ignoreCase          *bool
+	globOnly            *bool
+	byteThreshold       *uint64
+	rapidOpenThreshold  *uint
+	rapidOpenWindow     *time.Duration
+
+	parsed EventHandlerConfig // the policy fields, set by parse
+}
+
+// registerPolicyFlags defines the policy flags on flags
+func registerPolicyFlags(flags *flag.FlagSet) *policyFlags {
+	return &policyFlags{
+		disallowed:          flags.String("disallowed", "", "Comma-separated list of disallowed file patterns (e.g., '/etc/passwd,/etc/shadow')"),
+		immediate:           flags.String("immediate", "", "Comma-separated list of file patterns that block on the first match (e.g., '/etc/shadow')"),
+		policyMode:          flags.String("policy-mode", PolicyDenylist, "How files are judged: 'denylist' makes opens of -disallowed files violations, 'allowlist' makes opens of anything but -allowed files violations"),
+		allowed:             flags.String("allowed", "", "Comma-separated list of files, as exact paths or globs, monitored processes may open in allowlist mode (e.g., '/etc/myapp/*,/var/lib/myapp/*')"),
+		policyFile:          flags.String("policy-file", "", "JSON file of policies for groups of processes, each with its own patterns, threshold and PID/UID selector, tried in order before -disallowed and -threshold (e.g., '[{\"name\": \"web\", \"patterns\": [\"/etc/shadow\"], \"threshold\": 1, \"uids\": [33]}]')"),
+		threshold:           flags.Uint("threshold", 2, "Number of disallowed files before blocking (default: 2)"),
+		pidThresholds:       flags.String("pid-thresholds", "", "Comma-separated PID:threshold pairs overriding -threshold for those PIDs (e.g., '1234:1')"),
+		commThresholds:      flags.String("comm-thresholds", "", "Comma-separated command:threshold pairs overriding -threshold for processes running those commands (e.g., 'postgres:10,curl:1')"),
+		graceOpens:          flags.Uint("grace-opens", 0, "Number of opens by a process, of any file, before its violations count (default: 0, count from the first)"),
+		successfulOpensOnly: flags.Bool("successful-opens-only", false, "Count only opens that returned a file descriptor, not those that failed, e.g. of missing files (default: false, every open attempt)"),
+		ignoreDirs:          flags.Bool("ignore-dir-opens", false, "Do not count opens of directories (e.g., opendir) as violations"),
+		ignoreFlags:         flags.String("ignore-flags", "", "Comma-separated list of open(2) flags whose opens are not counted as violations, e.g. 'O_PATH', or access modes, e.g. 'O_WRONLY' to count only reads; O_CLOEXEC, O_NONBLOCK and O_NOCTTY are rejected since ordinary reads carry them"),
+		mounts:              flags.String("mounts", "", "Comma-separated list of mount points, e.g. '/data', outside which opens are not monitored; opens by relative path are always monitored. Paths are not resolved, so opens through symlinks or /proc/self/root can slip past it"),
+		ignoreCase:          flags.Bool("ignore-case", false, "Match file patterns ignoring case"),
+		globOnly:            flags.Bool("glob-only", false, "Match file patterns only exactly or as globs, never as substrings, so '/home/*/.ssh/id_rsa' matches one directory level"),
+		byteThreshold:       flags.Uint64("byte-threshold", 0, "Bytes a process may read from one disallowed file before it is blocked (default: 0, read volume is not tracked)"),
+		rapidOpenThreshold:  flags.Uint("rapid-open-threshold", 0, "Block a process that opens more than this many files, any files, within -rapid-open-window (default: 0, disabled)"),
+		rapidOpenWindow:     flags.Duration("rapid-open-window", time.Second, "Window -rapid-open-threshold is counted over, e.g. 500ms"),
+	}
+}
+
+// parse validates the policy flags once the flag set was parsed. Patterns are
+// checked here, so a pattern that can never match fails before anything is
+// loaded.
+func (f *policyFlags) parse() error {
+	config := &f.parsed
+	if *f.disallowed != "" {
+		config.DisallowedPatterns = splitPatterns(*f.disallowed)
+	}
+	if *f.allowed != "" {
+		config.AllowedPatterns = splitPatterns(*f.allowed)
+	}
+	if *f.immediate != "" {
+		for _, pattern := range splitPatterns(*f.immediate) {
+			config.Rules = append(config.Rules, Rule{Pattern: pattern, Immediate: true})
+		}
+	}
+	if *f.policyFile != "" {
+		policies, err := loadPolicies(*f.policyFile)
+		if err != nil {
+			return err
+		}
+		config.Policies = policies
+	}
+	if err := config.ValidatePatterns(); err != nil {
+		return err
+	}
+	for _, warning := range config.DirectoryPatternWarnings() {
+		log.Printf("WARNING: %s", warning)
+	}
+
+	if *f.ignoreFlags != "" {
+		config.IgnoreFlags = splitPatterns(*f.ignoreFlags)
+		if _, err := parseOpenFlags(config.IgnoreFlags); err != nil {
+			return fmt.Errorf("invalid -ignore-flags: %w", err)
+		}
+	}
+	if *f.mounts != "" {
+		config.MountFilter = splitPatterns(*f.mounts)
+		for _, mount := range config.MountFilter {
+			if !filepath.IsAbs(mount) {
+				return fmt.Errorf("invalid -mounts: %q is not an absolute path", mount)
+			}
+		}
+	}
+
+	if *f.policyMode != PolicyDenylist && *f.policyMode != PolicyAllowlist {
+		return fmt.Errorf("invalid -policy-mode %q: must be %q or %q", *f.policyMode, PolicyDenylist, PolicyAllowlist)
+	}
+	if err := validateThreshold(*f.threshold); err != nil {
+		return err
+	}
+	overrides, err := parsePIDThresholds(*f.pidThresholds)
+	if err != nil {
+		return fmt.Errorf("invalid -pid-thresholds: %w", err)
+	}
+	commThresholds, err := parseCommThresholds(*f.commThresholds)
+	if err != nil {
+		return fmt.Errorf("invalid -comm-thresholds: %w", err)
+	}
+	if *f.rapidOpenThreshold > math.MaxUint32-1 {
+		return fmt.Errorf("invalid -rapid-open-threshold: %d is too large", *f.rapidOpenThreshold)
+	}
+	if *f.rapidOpenThreshold > 0 && *f.rapidOpenWindow <= 0 {
+		return fmt.Errorf("invalid -rapid-open-window %v: must be positive", *f.rapidOpenWindow)
+	}
+
+	config.PolicyMode = *f.policyMode
+	config.Threshold = uint32(*f.threshold)
+	config.PIDThresholdOverrides = overrides
+	config.CommThresholds = commThresholds
+	config.GracePeriodOpens = uint32(*f.graceOpens)
+	config.SuccessfulOpensOnly = *f.successfulOpensOnly
+	config.IgnoreDirectoryOpens = *f.ignoreDirs
+	config.CaseInsensitive = *f.ignoreCase
+	config.GlobOnly = *f.globOnly
+	config.ByteThreshold = *f.byteThreshold
+	config.RapidOpenThreshold = uint32(*f.rapidOpenThreshold)
+	config.RapidOpenWindow = *f.rapidOpenWindow
+	return nil
+}
+
+// apply sets the policy fields of config from the flags parse validated
+func (f *policyFlags) apply(config *EventHandlerConfig) {
+	p := f.parsed
+	config.DisallowedPatterns = p.DisallowedPatterns
+	config.AllowedPatterns = p.AllowedPatterns
+	config.Rules = p.Rules
+	config.Policies = p.Policies
+	config.IgnoreFlags = p.IgnoreFlags
+	config.MountFilter = p.MountFilter
+	config.PolicyMode = p.PolicyMode
+	config.Threshold = p.Threshold
+	config.PIDThresholdOverrides = p.PIDThresholdOverrides
+	config.CommThresholds = p.CommThresholds
+	config.GracePeriodOpens = p.GracePeriodOpens
+	config.SuccessfulOpensOnly = p.SuccessfulOpensOnly
+	config.IgnoreDirectoryOpens = p.IgnoreDirectoryOpens
+	config.CaseInsensitive = p.CaseInsensitive
+	config.GlobOnly = p.GlobOnly
+	config.ByteThreshold = p.ByteThreshold
+	config.RapidOpenThreshold = p.RapidOpenThreshold
+	config.RapidOpenWindow = p.RapidOpenWindow
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestActivePolicy_Selects(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		expected bool
+	}{
+		{"no selectors", Policy{}, true},
+		{"pid matches", Policy{PIDs: []uint32{1234}}, true},
+		{"pid differs", Policy{PIDs: []uint32{99}}, false},
+		{"uid matches", Policy{UIDs: []uint32{1000}}, true},
+		{"uid differs", Policy{UIDs: []uint32{0}}, false},
+		{"both match", Policy{PIDs: []uint32{1234}, UIDs: []uint32{1000}}, true},
+		{"only pid matches", Policy{PIDs: []uint32{1234}, UIDs: []uint32{0}}, false},
+	}
+
+	event := CreateMockEvent(1234, 1000, "app", "/etc/passwd")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newActivePolicy(0, tt.policy, false, false).selects(event); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestNewActivePolicy_Name(t *testing.T) {
+	if got := newActivePolicy(0, Policy{Name: "web"}, false, false).name; got != "web" {
+		t.Errorf("expected web, got %q", got)
+	}
+	if got := newActivePolicy(2, Policy{}, false, false).name; got != "policy-3" {
+		t.Errorf("expected a positional name for an unnamed policy, got %q", got)
+	}
+}
+
+func TestLoadPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.json")
+	data := `[{"name": "web", "patterns": ["/etc/shadow", "/var/www/*.key"], "threshold": 1, "uids": [33]},
+		{"patterns": ["/etc/*"], "threshold": 5, "pids": [1234]}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := loadPolicies(path)
+	if err != nil {
+		t.Fatalf("loadPolicies: %v", err)
+	}
+	expected := []Policy{
+		{Name: "web", Patterns: []string{"/etc/shadow", "/var/www/*.key"}, Threshold: 1, UIDs: []uint32{33}},
+		{Patterns: []string{"/etc/*"}, Threshold: 5, PIDs: []uint32{1234}},
+	}
+	if !reflect.DeepEqual(policies, expected) {
+		t.Errorf("expected %+v, got %+v", expected, policies)
+	}
+}
+
+func TestLoadPolicies_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"not an array", `{"name": "web"}`, "parse policy file"},
+		{"unknown field", `[{"name": "web", "patterns": ["/etc/shadow"], "threshold": 1, "uid": [33]}]`, "unknown field"},
+		{"no patterns", `[{"name": "web", "threshold": 1}]`, "policy 1"},
+		{"zero threshold", `[{"patterns": ["/etc/shadow"]}]`, "threshold must be at least 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policies.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadPolicies(path); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestFormatPolicyStats(t *testing.T) {
+	stats := map[string]PolicyStats{
+		"web":     {Violations: 5, Blocks: 2},
+		"default": {Violations: 3, Blocks: 1},
+	}
+	if got, want := formatPolicyStats(stats), "default:3/1,web:5/2"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
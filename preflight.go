@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CheckResult is the outcome of one preflight check
+type CheckResult struct {
+	Check  string `json:"check"`
+	Passed bool   `json:"passed"`
+	Detail string `json:"detail"`
+}
+
+// PreflightReport is the JSON output of the preflight command
+type PreflightReport struct {
+	OK     bool          `json:"ok"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// errPreflightFailed makes the preflight command exit non-zero once its
+// results are written
+var errPreflightFailed = errors.New("preflight: some checks failed")
+
+// hostSys is the host preflight checks. The root is configurable so tests
+// can use a faked /sys tree.
+type hostSys struct {
+	root string // directory /sys is found under
+	euid int
+}
+
+// preflight checks that the host can run eBPFence with enforcement: root
+// privileges, kernel BTF and the BPF LSM enabled
+func (s hostSys) preflight() []CheckResult {
+	results := []CheckResult{
+		{Check: "root", Passed: s.euid == 0, Detail: fmt.Sprintf("effective UID %d", s.euid)},
+	}
+
+	btf := filepath.Join(s.root, "sys/kernel/btf/vmlinux")
+	if _, err := os.Stat(btf); err != nil {
+		results = append(results, CheckResult{Check: "btf", Detail: fmt.Sprintf("kernel BTF not found: %v", err)})
+	} else {
+		results = append(results, CheckResult{Check: "btf", Passed: true, Detail: "/sys/kernel/btf/vmlinux"})
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.root, "sys/kernel/security/lsm"))
+	switch {
+	case err != nil:
+		results = append(results, CheckResult{Check: "bpf-lsm", Detail: fmt.Sprintf("read active LSMs: %v", err)})
+	case !strings.Contains(","+strings.TrimSpace(string(data))+",", ",bpf,"):
+		results = append(results, CheckResult{
+			Check:  "bpf-lsm",
+			Detail: fmt.Sprintf("bpf is not an active LSM (%s), add lsm=...,bpf to the kernel command line", strings.TrimSpace(string(data))),
+		})
+	default:
+		results = append(results, CheckResult{Check: "bpf-lsm", Passed: true, Detail: strings.TrimSpace(string(data))})
+	}
+	return results
+}
+
+// detachedEffects is what is lost when a hook fails to attach
+var detachedEffects = map[string]string{
+	"lsm":       "blocked processes are not denied",
+	"openat":    "opens are not monitored",
+	"openat2":   "opens through openat2 are not monitored",
+	"rename":    "renames through rename are not monitored",
+	"renameat":  "renames through renameat are not monitored",
+	"renameat2": "renames through renameat2 are not monitored",
+}
+
+// attachChecks loads the BPF programs with load and reports, as one check
+// per hook, which ones attached
+func attachChecks(load func() (*RealEBPFProvider, error)) []CheckResult {
+	provider, err := load()
+	if err != nil {
+		return []CheckResult{{Check: "attach", Detail: err.Error()}}
+	}
+	status := provider.AttachmentStatus()
+	if err := provider.Close(); err != nil {
+		return []CheckResult{{Check: "attach", Detail: fmt.Sprintf("detach: %v", err)}}
+	}
+	return attachmentResults(status)
+}
+
+// attachmentResults turns an attachment status into checks, sorted by hook
+func attachmentResults(status map[string]bool) []CheckResult {
+	var names []string
+	for name := range status {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var results []CheckResult
+	for _, name := range names {
+		r := CheckResult{Check: "attach-" + name, Passed: status[name], Detail: "attached"}
+		if !r.Passed {
+			r.Detail = "not attached"
+			if effect, ok := detachedEffects[name]; ok {
+				r.Detail += ", " + effect
+			}
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// writePreflight writes the results as text, one line per check, or as a
+// PreflightReport if format is LogFormatJSON. It reports whether every check
+// passed.
+func writePreflight(w io.Writer, results []CheckResult, format string) (bool, error) {
+	report := PreflightReport{OK: true, Checks: results}
+	for _, r := range results {
+		report.OK = report.OK && r.Passed
+	}
+
+	if format == LogFormatJSON {
+		return report.OK, json.NewEncoder(w).Encode(report)
+	}
+	for _, r := range results {
+		status := "ok"
+		if !r.Passed {
+			status = "FAIL"
+		}
+		if _, err := fmt.Fprintf(w, "%-4s %-8s %s\n", status, r.Check, r.Detail); err != nil {
+			return report.OK, err
+		}
+	}
+	return report.OK, nil
+}
+
+// preflightCommand checks the host is ready to run eBPFence, exiting
+// non-zero if it is not
+func preflightCommand(args []string) error {
+	flags := flag.NewFlagSet("preflight", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	format := flags.String("format", LogFormatText, "Output format: 'text' or 'json'")
+	attach := flags.Bool("attach", false, "Also load the BPF programs and report which hooks attach, then detach them")
+	if err := applyEnv(flags); err != nil {
+		return fmt.Errorf("preflight: %w", err)
+	}
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("preflight: %w", err)
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("usage: ebpfence preflight [-format text|json] [-attach]")
+	}
+	if *format != LogFormatText && *format != LogFormatJSON {
+		return fmt.Errorf("invalid -format %q: must be %q or %q", *format, LogFormatText, LogFormatJSON)
+	}
+
+	results := hostSys{root: "/", euid: os.Geteuid()}.preflight()
+	if *attach {
+		results = append(results, attachChecks(func() (*RealEBPFProvider, error) { return NewRealEBPFProvider() })...)
+	}
+	ok, err := writePreflight(os.Stdout, results, *format)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errPreflightFailed
+	}
+	return nil
+}
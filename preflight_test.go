@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeSys creates a fake /sys tree with the given files
+func fakeSys(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestHostSys_Preflight(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		euid     int
+		expected map[string]bool // check -> passed
+	}{
+		{
+			name: "ready",
+			files: map[string]string{
+				"sys/kernel/btf/vmlinux":  "",
+				"sys/kernel/security/lsm": "lockdown,capability,landlock,yama,bpf\n",
+			},
+			expected: map[string]bool{"root": true, "btf": true, "bpf-lsm": true},
+		},
+		{
+			name: "bpf LSM not enabled",
+			files: map[string]string{
+				"sys/kernel/btf/vmlinux":  "",
+				"sys/kernel/security/lsm": "lockdown,capability,bpfilter\n",
+			},
+			expected: map[string]bool{"root": true, "btf": true, "bpf-lsm": false},
+		},
+		{
+			name:     "unprivileged without BTF or securityfs",
+			euid:     1000,
+			expected: map[string]bool{"root": false, "btf": false, "bpf-lsm": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := hostSys{root: fakeSys(t, tt.files), euid: tt.euid}
+			got := make(map[string]bool)
+			for _, r := range s.preflight() {
+				got[r.Check] = r.Passed
+				if r.Detail == "" {
+					t.Errorf("check %s has no detail", r.Check)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWritePreflight_JSON(t *testing.T) {
+	results := []CheckResult{
+		{Check: "root", Passed: true, Detail: "effective UID 0"},
+		{Check: "bpf-lsm", Passed: false, Detail: "bpf is not an active LSM"},
+	}
+
+	var buf bytes.Buffer
+	ok, err := writePreflight(&buf, results, LogFormatJSON)
+	if err != nil {
+		t.Fatalf("writePreflight: %v", err)
+	}
+	if ok {
+		t.Error("expected a failed check to fail the preflight")
+	}
+
+	// Decode generically to assert the field names automation relies on
+	var report map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, buf.String())
+	}
+	expected := map[string]any{
+		"ok": false,
+		"checks": []any{
+			map[string]any{"check": "root", "passed": true, "detail": "effective UID 0"},
+			map[string]any{"check": "bpf-lsm", "passed": false, "detail": "bpf is not an active LSM"},
+		},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("expected %v, got %v", expected, report)
+	}
+
+	buf.Reset()
+	if ok, err := writePreflight(&buf, results[:1], LogFormatJSON); err != nil || !ok {
+		t.Errorf("expected passing checks to pass, got ok=%v err=%v", ok, err)
+	}
+	if want := `{"ok":true,"checks":[{"check":"root","passed":true,"detail":"effective UID 0"}]}` + "\n"; buf.String() != want {
+		t.Errorf("expected %s, got %s", want, buf.String())
+	}
+}
+
+func TestWritePreflight_Text(t *testing.T) {
+	results := []CheckResult{
+		{Check: "root", Passed: true, Detail: "effective UID 0"},
+		{Check: "btf", Passed: false, Detail: "kernel BTF not found"},
+	}
+
+	var buf bytes.Buffer
+	if ok, err := writePreflight(&buf, results, LogFormatText); err != nil || ok {
+		t.Fatalf("expected a failed preflight, got ok=%v err=%v", ok, err)
+	}
+	want := "ok   root     effective UID 0\n" +
+		"FAIL btf      kernel BTF not found\n"
+	if buf.String() != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestPreflightCommand_InvalidFormat(t *testing.T) {
+	if err := preflightCommand([]string{"-format", "yaml"}); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+}
+
+func TestAttachChecks(t *testing.T) {
+	attacher := &fakeAttacher{failAt: "openat2"}
+	results := attachChecks(func() (*RealEBPFProvider, error) {
+		return newRealEBPFProvider(attacher, providerOptions{})
+	})
+	expected := []CheckResult{
+		{Check: "attach-lsm", Passed: true, Detail: "attached"},
+		{Check: "attach-openat", Passed: true, Detail: "attached"},
+		{Check: "attach-openat2", Detail: "not attached, opens through openat2 are not monitored"},
+		{Check: "attach-rename", Passed: true, Detail: "attached"},
+		{Check: "attach-renameat", Passed: true, Detail: "attached"},
+		{Check: "attach-renameat2", Passed: true, Detail: "attached"},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+	for _, l := range attacher.links {
+		if l.closes != 1 {
+			t.Errorf("link %s closed %d times, want exactly 1", l.name, l.closes)
+		}
+	}
+
+	// A provider that cannot be loaded fails a single check
+	results = attachChecks(func() (*RealEBPFProvider, error) {
+		return newRealEBPFProvider(&fakeAttacher{failAt: "load"}, providerOptions{})
+	})
+	if len(results) != 1 || results[0].Check != "attach" || results[0].Passed {
+		t.Errorf("expected one failed attach check, got %v", results)
+	}
+}
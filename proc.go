@@ -0,0 +1,313 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// atFDCWD is AT_FDCWD, the dirfd resolving relative paths against the
+// working directory
+const atFDCWD = -100
+
+// procFS reads process information from a proc filesystem. The root is
+// configurable so tests can use a faked tree.
+type procFS struct {
+	root string
+}
+
+// hostProc is the proc filesystem of the host
+var hostProc = procFS{root: "/proc"}
+
+// pidNamespace returns the inode number of the PID namespace of a process
+func (p procFS) pidNamespace(pid uint32) (uint32, error) {
+	return p.namespace(pid, "pid")
+}
+
+// mountNamespace returns the inode number of the mount namespace of a process
+func (p procFS) mountNamespace(pid uint32) (uint32, error) {
+	return p.namespace(pid, "mnt")
+}
+
+// namespace returns the inode number of a namespace of a process, by its
+// name under /proc/<pid>/ns
+func (p procFS) namespace(pid uint32, name string) (uint32, error) {
+	path := filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10), "ns", name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("stat %s namespace: %w", name, err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("stat %s namespace: unexpected stat type %T", name, info.Sys())
+	}
+	return uint32(stat.Ino), nil
+}
+
+// containerID returns the ID of the container a process runs in, taken from
+// its cgroup path as Docker, containerd, CRI-O and Podman name them, e.g.
+// docker-<id>.scope. It is empty for processes outside a container.
+func (p procFS) containerID(pid uint32) (string, error) {
+	data, err := os.ReadFile(filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10), "cgroup"))
+	if err != nil {
+		return "", fmt.Errorf("read cgroup: %w", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		// hierarchy-ID:controllers:path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		segments := strings.Split(parts[2], "/")
+		for i := len(segments) - 1; i >= 0; i-- {
+			if id := cgroupContainerID(segments[i]); id != "" {
+				return id, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// containerIDLen is the length of a full container ID in hex
+const containerIDLen = 64
+
+// cgroupContainerID returns the container ID a cgroup path segment names,
+// e.g. "cri-containerd-<id>.scope" or a bare "<id>", or "" if none
+func cgroupContainerID(segment string) string {
+	segment = strings.TrimSuffix(segment, ".scope")
+	if i := strings.LastIndexAny(segment, "-:"); i >= 0 {
+		segment = segment[i+1:]
+	}
+	if len(segment) != containerIDLen {
+		return ""
+	}
+	for _, c := range segment {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return ""
+		}
+	}
+	return segment
+}
+
+// comm returns the command name of a process
+func (p procFS) comm(pid uint32) (string, error) {
+	data, err := os.ReadFile(filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10), "comm"))
+	if err != nil {
+		return "", fmt.Errorf("read comm: %w", err)
+	}
+	return strings.TrimRight(string(data), "\n"), nil
+}
+
+// cmdlineName returns the basename of a process's argv[0]
+func (p procFS) cmdlineName(pid uint32) (string, error) {
+	data, err := os.ReadFile(filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10), "cmdline"))
+	if err != nil {
+		return "", fmt.Errorf("read cmdline: %w", err)
+	}
+
+	argv0, _, _ := strings.Cut(string(data), "\x00")
+	if argv0 == "" {
+		// Kernel threads and zombies have an empty cmdline
+		return "", fmt.Errorf("read cmdline: empty")
+	}
+	return filepath.Base(argv0), nil
+}
+
+// fdPath returns the path of the file a process has open as fd
+func (p procFS) fdPath(pid, fd uint32) (string, error) {
+	path, err := os.Readlink(filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10), "fd", strconv.FormatUint(uint64(fd), 10)))
+	if err != nil {
+		return "", fmt.Errorf("read fd link: %w", err)
+	}
+	return path, nil
+}
+
+// dirPath returns the path of the directory a process resolves paths
+// relative to dirfd against: its working directory for AT_FDCWD, otherwise
+// the directory it has open as dirfd
+func (p procFS) dirPath(pid, dirfd uint32) (string, error) {
+	if int32(dirfd) != atFDCWD {
+		return p.fdPath(pid, dirfd)
+	}
+	path, err := os.Readlink(filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10), "cwd"))
+	if err != nil {
+		return "", fmt.Errorf("read cwd link: %w", err)
+	}
+	return path, nil
+}
+
+// fileInode returns the file filename names as a process sees it: through
+// its root directory if the path is absolute, its working directory
+// otherwise. The path is not cleaned, so ".." is resolved by the kernel from
+// there. Absolute symlinks along the path still resolve against the root of
+// the caller.
+func (p procFS) fileInode(pid uint32, filename string) (FileID, error) {
+	base := "cwd/"
+	if filepath.IsAbs(filename) {
+		base = "root"
+	}
+	return resolveInode(filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10)) + "/" + base + filename)
+}
+
+// fdInode returns the file open as fd in a process
+func (p procFS) fdInode(pid, fd uint32) (FileID, error) {
+	return resolveInode(filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10), "fd", strconv.FormatUint(uint64(fd), 10)))
+}
+
+// pids returns the IDs of the processes in the proc filesystem
+func (p procFS) pids() ([]uint32, error) {
+	entries, err := os.ReadDir(p.root)
+	if err != nil {
+		return nil, fmt.Errorf("list processes: %w", err)
+	}
+
+	var pids []uint32
+	for _, entry := range entries {
+		pid, err := strconv.ParseUint(entry.Name(), 10, 32)
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		pids = append(pids, uint32(pid))
+	}
+	return pids, nil
+}
+
+// pidsOfUID returns the IDs of the processes whose real or effective UID is
+// uid. Processes that exit while they are listed are left out.
+func (p procFS) pidsOfUID(uid uint32) ([]uint32, error) {
+	pids, err := p.pids()
+	if err != nil {
+		return nil, err
+	}
+
+	var owned []uint32
+	for _, pid := range pids {
+		real, effective, err := p.statusUIDs(pid)
+		if err == nil && (real == uid || effective == uid) {
+			owned = append(owned, pid)
+		}
+	}
+	return owned, nil
+}
+
+// statusUIDs returns the real and effective UID of a process, from its status
+func (p procFS) statusUIDs(pid uint32) (uint32, uint32, error) {
+	data, err := os.ReadFile(filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10), "status"))
+	if err != nil {
+		return 0, 0, fmt.Errorf("read status: %w", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		value, ok := strings.CutPrefix(line, "Uid:")
+		if !ok {
+			continue
+		}
+		// real, effective, saved set and filesystem UIDs
+		fields := strings.Fields(value)
+		if len(fields) < 2 {
+			return 0, 0, fmt.Errorf("parse status: invalid Uid line %q", line)
+		}
+		real, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse status: %w", err)
+		}
+		effective, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse status: %w", err)
+		}
+		return uint32(real), uint32(effective), nil
+	}
+	return 0, 0, fmt.Errorf("read status: no Uid")
+}
+
+// startTime returns when a process started, in clock ticks since boot: the
+// 22nd field of its stat. Together with the PID it tells processes apart
+// once PIDs are reused.
+func (p procFS) startTime(pid uint32) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10), "stat"))
+	if err != nil {
+		return 0, fmt.Errorf("read stat: %w", err)
+	}
+
+	// The comm in parentheses may itself contain spaces and parentheses;
+	// the fields after it start with the 3rd, the state
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return 0, fmt.Errorf("parse stat: no comm")
+	}
+	const startField = 22 - 3
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) <= startField {
+		return 0, fmt.Errorf("parse stat: %d fields after the comm", len(fields))
+	}
+	start, err := strconv.ParseUint(fields[startField], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse stat: %w", err)
+	}
+	return start, nil
+}
+
+// uid returns the effective UID of a process, which owns its proc directory
+func (p procFS) uid(pid uint32) (uint32, error) {
+	info, err := os.Stat(filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10)))
+	if err != nil {
+		return 0, fmt.Errorf("stat process: %w", err)
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("stat process: unexpected stat type %T", info.Sys())
+	}
+	return stat.Uid, nil
+}
+
+// openFiles returns the paths of the files a process has open, by fd.
+// Sockets, pipes and other descriptors without a path are left out.
+func (p procFS) openFiles(pid uint32) (map[uint32]string, error) {
+	dir := filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10), "fd")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("list fds: %w", err)
+	}
+
+	files := make(map[uint32]string)
+	for _, entry := range entries {
+		fd, err := strconv.ParseUint(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		// The fd may have been closed since the directory was listed
+		path, err := os.Readlink(filepath.Join(dir, entry.Name()))
+		if err != nil || !strings.HasPrefix(path, "/") {
+			continue
+		}
+		files[uint32(fd)] = path
+	}
+	return files, nil
+}
+
+// fdFlags returns the open(2) flags of a process's fd, from its fdinfo
+func (p procFS) fdFlags(pid, fd uint32) (int32, error) {
+	data, err := os.ReadFile(filepath.Join(p.root, strconv.FormatUint(uint64(pid), 10), "fdinfo", strconv.FormatUint(uint64(fd), 10)))
+	if err != nil {
+		return 0, fmt.Errorf("read fdinfo: %w", err)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		value, ok := strings.CutPrefix(line, "flags:")
+		if !ok {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimSpace(value), 8, 32)
+		if err != nil {
+			return 0, fmt.Errorf("parse fdinfo flags: %w", err)
+		}
+		return int32(flags), nil
+	}
+	return 0, fmt.Errorf("read fdinfo: no flags")
+}
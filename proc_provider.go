@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// procPollInterval is how often the fallback provider scans /proc
+const procPollInterval = 500 * time.Millisecond
+
+// ProcEBPFProvider is an EBPFProvider that needs no eBPF, for locked-down
+// kernels, gVisor sandboxes and unprivileged CI containers. It polls the
+// /proc/<pid>/fd links for the files processes hold open and reports each
+// newly seen one as an open: a degraded but portable detection mode. Opens
+// shorter than the poll interval are missed, and nothing is enforced:
+// blocking only records the PID or file.
+type ProcEBPFProvider struct {
+	mu           sync.Mutex
+	proc         procFS
+	interval     time.Duration
+	ctx          context.Context
+	seen         map[procOpen]bool // files open at the last scan
+	pending      []*Event          // opens found by the last scan, not yet read
+	blockedPIDs  map[uint32]bool
+	blockedFiles map[FileID]bool
+	targetUIDs   map[uint32]bool // nil when files opened by every UID are reported
+	closed       bool
+}
+
+// procOpen is a file a process has open
+type procOpen struct {
+	pid  uint32
+	fd   uint32
+	path string
+}
+
+// NewProcEBPFProvider creates a provider that scans /proc every interval
+// until ctx is cancelled. Files already open when it is created are not
+// reported.
+func NewProcEBPFProvider(ctx context.Context, interval time.Duration) *ProcEBPFProvider {
+	return newProcEBPFProvider(ctx, hostProc, interval)
+}
+
+func newProcEBPFProvider(ctx context.Context, proc procFS, interval time.Duration) *ProcEBPFProvider {
+	p := &ProcEBPFProvider{
+		proc:         proc,
+		interval:     interval,
+		ctx:          ctx,
+		seen:         make(map[procOpen]bool),
+		blockedPIDs:  make(map[uint32]bool),
+		blockedFiles: make(map[FileID]bool),
+	}
+	p.scan(false)
+	return p
+}
+
+// ReadEvent returns the next newly opened file, scanning /proc every
+// interval until there is one
+func (p *ProcEBPFProvider) ReadEvent() (*Event, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, fmt.Errorf("provider is closed: %w", ringbuf.ErrClosed)
+		}
+		if len(p.pending) > 0 {
+			event := p.pending[0]
+			p.pending = p.pending[1:]
+			p.mu.Unlock()
+			return event, nil
+		}
+		p.mu.Unlock()
+
+		timer := time.NewTimer(p.interval)
+		select {
+		case <-p.ctx.Done():
+			timer.Stop()
+			return nil, context.Canceled
+		case <-timer.C:
+		}
+		p.scan(true)
+	}
+}
+
+// scan lists the files every process has open and, if report is set, queues
+// an open event for each one that was not open at the last scan
+func (p *ProcEBPFProvider) scan(report bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pids, err := p.proc.pids()
+	if err != nil {
+		return
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+
+	current := make(map[procOpen]bool)
+	for _, pid := range pids {
+		// The process may have exited, or not be ours to inspect
+		files, err := p.proc.openFiles(pid)
+		if err != nil {
+			continue
+		}
+		fds := make([]uint32, 0, len(files))
+		for fd := range files {
+			fds = append(fds, fd)
+		}
+		sort.Slice(fds, func(i, j int) bool { return fds[i] < fds[j] })
+
+		for _, fd := range fds {
+			open := procOpen{pid: pid, fd: fd, path: files[fd]}
+			current[open] = true
+			if report && !p.seen[open] {
+				if event, ok := p.openEvent(open); ok {
+					p.pending = append(p.pending, event)
+				}
+			}
+		}
+	}
+	p.seen = current
+}
+
+// openEvent builds the event for a newly seen open, ok false if its process
+// is filtered out or gone
+func (p *ProcEBPFProvider) openEvent(open procOpen) (*Event, bool) {
+	uid, err := p.proc.uid(open.pid)
+	if err != nil || (p.targetUIDs != nil && !p.targetUIDs[uid]) {
+		return nil, false
+	}
+	comm, err := p.proc.comm(open.pid)
+	if err != nil {
+		return nil, false
+	}
+	// Flags are only used to spot directory opens; go without if unreadable
+	flags, _ := p.proc.fdFlags(open.pid, open.fd)
+
+	event := &Event{Pid: open.pid, Uid: uid, Flags: flags, Type: EventOpen, Fd: open.fd}
+	copy(event.Comm[:], comm)
+	copy(event.Filename[:], open.path)
+	return event, true
+}
+
+// BlockPID records the PID as blocked; nothing is enforced without eBPF
+func (p *ProcEBPFProvider) BlockPID(pid uint32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return fmt.Errorf("provider is closed")
+	}
+	p.blockedPIDs[pid] = true
+	return nil
+}
+
+// UnblockPID removes a PID from the blocked list
+func (p *ProcEBPFProvider) UnblockPID(pid uint32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return fmt.Errorf("provider is closed")
+	}
+	delete(p.blockedPIDs, pid)
+	return nil
+}
+
+// EnforcementActive reports that nothing is enforced: /proc can only be
+// observed
+func (p *ProcEBPFProvider) EnforcementActive() (bool, string) {
+	return false, "proc"
+}
+
+// IsBlocked reports whether a PID was blocked
+func (p *ProcEBPFProvider) IsBlocked(pid uint32) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.blockedPIDs[pid]
+}
+
+// BlockInode records the file as blocked; nothing is enforced without eBPF
+func (p *ProcEBPFProvider) BlockInode(dev, ino uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return fmt.Errorf("provider is closed")
+	}
+	p.blockedFiles[FileID{Dev: dev, Ino: ino}] = true
+	return nil
+}
+
+// SetTargetUIDs makes scans skip files opened by other UIDs
+func (p *ProcEBPFProvider) SetTargetUIDs(uids []uint32) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return fmt.Errorf("provider is closed")
+	}
+
+	p.targetUIDs = nil
+	if len(uids) > 0 {
+		p.targetUIDs = make(map[uint32]bool)
+		for _, uid := range uids {
+			p.targetUIDs[uid] = true
+		}
+	}
+	return nil
+}
+
+// SetCountFailedOpens is a no-op: every open found in /proc succeeded
+func (p *ProcEBPFProvider) SetCountFailedOpens(count bool) error {
+	return nil
+}
+
+// ListBlockedPIDs returns the blocked list, sorted
+func (p *ProcEBPFProvider) ListBlockedPIDs() ([]uint32, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, fmt.Errorf("provider is closed")
+	}
+
+	pids := make([]uint32, 0, len(p.blockedPIDs))
+	for pid := range p.blockedPIDs {
+		pids = append(pids, pid)
+	}
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+	return pids, nil
+}
+
+// DumpBlockedPIDs writes the blocked list to w
+func (p *ProcEBPFProvider) DumpBlockedPIDs(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return fmt.Errorf("provider is closed")
+	}
+
+	entries := make(map[uint32]uint32)
+	for pid := range p.blockedPIDs {
+		entries[pid] = 1
+	}
+	return writeMapDump(w, "blocked_pids", entries)
+}
+
+// Close stops the provider; ReadEvent then fails with ringbuf.ErrClosed
+func (p *ProcEBPFProvider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cilium/ebpf/ringbuf"
+)
+
+// fakeProcess adds a process to a fake proc tree
+func fakeProcess(t *testing.T, root string, pid uint32, comm string) {
+	t.Helper()
+	dir := filepath.Join(root, strconv.FormatUint(uint64(pid), 10))
+	for _, sub := range []string{"fd", "fdinfo"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatalf("create fake process: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "comm"), []byte(comm+"\n"), 0644); err != nil {
+		t.Fatalf("create fake comm: %v", err)
+	}
+}
+
+// fakeOpen makes a fake process hold path open as fd with the given flags
+func fakeOpen(t *testing.T, root string, pid, fd uint32, path string, flags int) {
+	t.Helper()
+	dir := filepath.Join(root, strconv.FormatUint(uint64(pid), 10))
+	name := strconv.FormatUint(uint64(fd), 10)
+	if err := os.Symlink(path, filepath.Join(dir, "fd", name)); err != nil {
+		t.Fatalf("create fake fd: %v", err)
+	}
+	info := "pos:\t0\nflags:\t" + strconv.FormatInt(int64(flags), 8) + "\nmnt_id:\t1\n"
+	if err := os.WriteFile(filepath.Join(dir, "fdinfo", name), []byte(info), 0644); err != nil {
+		t.Fatalf("create fake fdinfo: %v", err)
+	}
+}
+
+// fakeClose removes a fake process's fd
+func fakeClose(t *testing.T, root string, pid, fd uint32) {
+	t.Helper()
+	if err := os.Remove(filepath.Join(root, strconv.FormatUint(uint64(pid), 10), "fd", strconv.FormatUint(uint64(fd), 10))); err != nil {
+		t.Fatalf("close fake fd: %v", err)
+	}
+}
+
+func TestProcEBPFProvider_ReportsNewOpens(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root := t.TempDir()
+	fakeProcess(t, root, 1234, "cat")
+	fakeOpen(t, root, 1234, 0, "/dev/pts/0", os.O_RDWR)
+	fakeOpen(t, root, 1234, 1, "pipe:[4242]", os.O_WRONLY)
+
+	// What is open already is the baseline
+	provider := newProcEBPFProvider(ctx, procFS{root: root}, time.Millisecond)
+	defer provider.Close()
+
+	fakeOpen(t, root, 1234, 3, "/etc/passwd", os.O_RDONLY)
+	event, err := provider.ReadEvent()
+	if err != nil {
+		t.Fatalf("ReadEvent: %v", err)
+	}
+	filename := string(event.Filename[:len("/etc/passwd")])
+	if event.Pid != 1234 || event.Fd != 3 || filename != "/etc/passwd" || event.Uid != uint32(os.Getuid()) {
+		t.Errorf("unexpected event: pid=%d fd=%d uid=%d filename=%q", event.Pid, event.Fd, event.Uid, filename)
+	}
+	if comm := string(event.Comm[:3]); comm != "cat" {
+		t.Errorf("expected comm cat, got %q", comm)
+	}
+
+	// A file still open is not reported again; closing and reopening it is
+	fakeClose(t, root, 1234, 3)
+	fakeOpen(t, root, 1234, 4, "/etc", os.O_RDONLY|syscall.O_DIRECTORY)
+	event, err = provider.ReadEvent()
+	if err != nil {
+		t.Fatalf("ReadEvent: %v", err)
+	}
+	if event.Fd != 4 || !event.IsDirectoryOpen() {
+		t.Errorf("expected a directory open of fd 4, got fd %d flags %o", event.Fd, event.Flags)
+	}
+
+	cancel()
+	if _, err := provider.ReadEvent(); err != context.Canceled {
+		t.Errorf("expected context.Canceled after cancel, got %v", err)
+	}
+}
+
+func TestProcEBPFProvider_TargetUIDs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	root := t.TempDir()
+	fakeProcess(t, root, 1234, "cat")
+	provider := newProcEBPFProvider(ctx, procFS{root: root}, time.Millisecond)
+	defer provider.Close()
+
+	// The fake process runs as the test's UID, which is not targeted
+	provider.SetTargetUIDs([]uint32{uint32(os.Getuid()) + 1})
+	fakeOpen(t, root, 1234, 3, "/etc/passwd", os.O_RDONLY)
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if event, err := provider.ReadEvent(); err != context.Canceled {
+		t.Errorf("expected the open to be filtered out, got %v, %v", event, err)
+	}
+}
+
+func TestProcEBPFProvider_Close(t *testing.T) {
+	provider := newProcEBPFProvider(context.Background(), procFS{root: t.TempDir()}, time.Millisecond)
+	provider.Close()
+
+	if _, err := provider.ReadEvent(); !errors.Is(err, ringbuf.ErrClosed) {
+		t.Errorf("expected ErrClosed from ReadEvent after Close, got %v", err)
+	}
+	if err := provider.BlockPID(1234); err == nil {
+		t.Error("expected BlockPID to fail after Close")
+	}
+}
+
+func TestProcEBPFProvider_EnforcementActive(t *testing.T) {
+	provider := newProcEBPFProvider(context.Background(), procFS{root: t.TempDir()}, time.Millisecond)
+	defer provider.Close()
+
+	if active, backend := provider.EnforcementActive(); active || backend != "proc" {
+		t.Errorf("expected inactive enforcement by proc, got %v and %q", active, backend)
+	}
+
+	handler := NewEventHandler(provider, EventHandlerConfig{DisallowedPatterns: []string{"/etc/*"}})
+	stats := handler.Stats()
+	if stats.EnforcementActive || !strings.Contains(stats.String(), "enforcement=none(proc)") {
+		t.Errorf("expected stats to report enforcement as inactive, got %s", stats)
+	}
+}
+
+func TestEventHandler_ProcEBPFProviderFallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root := t.TempDir()
+	fakeProcess(t, root, 1234, "cat")
+	fakeProcess(t, root, 5678, "vim")
+	provider := newProcEBPFProvider(ctx, procFS{root: root}, 5*time.Millisecond)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          2,
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+
+	fakeOpen(t, root, 1234, 3, "/etc/passwd", os.O_RDONLY)
+	fakeOpen(t, root, 1234, 4, "/etc/shadow", os.O_RDONLY)
+	fakeOpen(t, root, 5678, 3, "/tmp/notes.txt", os.O_RDWR)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !provider.IsBlocked(1234) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if !provider.IsBlocked(1234) {
+		t.Error("expected PID 1234 to be blocked")
+	}
+	if provider.IsBlocked(5678) {
+		t.Error("PID 5678 opened nothing disallowed")
+	}
+	if got := handler.GetViolationCountForPID(1234); got != 2 {
+		t.Errorf("expected 2 violations, got %d", got)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestProcFS_PIDNamespace(t *testing.T) {
+	root := t.TempDir()
+	nsDir := filepath.Join(root, "1234", "ns")
+	if err := os.MkdirAll(nsDir, 0755); err != nil {
+		t.Fatalf("create fake proc: %v", err)
+	}
+	nsFile := filepath.Join(nsDir, "pid")
+	if err := os.WriteFile(nsFile, nil, 0644); err != nil {
+		t.Fatalf("create fake ns file: %v", err)
+	}
+
+	info, err := os.Stat(nsFile)
+	if err != nil {
+		t.Fatalf("stat fake ns file: %v", err)
+	}
+	expected := uint32(info.Sys().(*syscall.Stat_t).Ino)
+
+	proc := procFS{root: root}
+	ns, err := proc.pidNamespace(1234)
+	if err != nil {
+		t.Fatalf("pidNamespace: %v", err)
+	}
+	if ns != expected {
+		t.Errorf("expected namespace inode %d, got %d", expected, ns)
+	}
+
+	if _, err := proc.pidNamespace(9999); err == nil {
+		t.Error("expected an error for a missing process")
+	}
+}
+
+func TestProcFS_Comm(t *testing.T) {
+	proc := fakeProc(t, map[uint32]string{42: "sshd"})
+
+	comm, err := proc.comm(42)
+	if err != nil {
+		t.Fatalf("comm: %v", err)
+	}
+	if comm != "sshd" {
+		t.Errorf("expected sshd, got %q", comm)
+	}
+
+	if _, err := proc.comm(43); err == nil {
+		t.Error("expected an error for a missing process")
+	}
+}
+
+func TestProcFS_FdPath(t *testing.T) {
+	proc := fakeProc(t, map[uint32]string{42: "cat"})
+	fakeFd(t, proc, 42, 3, "/etc/shadow")
+
+	path, err := proc.fdPath(42, 3)
+	if err != nil {
+		t.Fatalf("fdPath: %v", err)
+	}
+	if path != "/etc/shadow" {
+		t.Errorf("expected /etc/shadow, got %q", path)
+	}
+
+	if _, err := proc.fdPath(42, 4); err == nil {
+		t.Error("expected an error for a closed fd")
+	}
+}
+
+func TestProcFS_CmdlineName(t *testing.T) {
+	proc := fakeProc(t, map[uint32]string{42: "systemd-journal", 43: "kworker/0:1"})
+	fakeCmdline(t, proc, 42, "/usr/lib/systemd/systemd-journald\x00--user\x00")
+	fakeCmdline(t, proc, 43, "")
+
+	name, err := proc.cmdlineName(42)
+	if err != nil {
+		t.Fatalf("cmdlineName: %v", err)
+	}
+	if name != "systemd-journald" {
+		t.Errorf("expected systemd-journald, got %q", name)
+	}
+
+	if _, err := proc.cmdlineName(43); err == nil {
+		t.Error("expected an error for an empty cmdline")
+	}
+}
+
+func TestProcFS_OpenFiles(t *testing.T) {
+	root := t.TempDir()
+	fakeProcess(t, root, 1234, "cat")
+	fakeOpen(t, root, 1234, 0, "/dev/null", os.O_RDONLY)
+	fakeOpen(t, root, 1234, 3, "/etc/passwd", os.O_RDONLY|syscall.O_CLOEXEC)
+	fakeOpen(t, root, 1234, 4, "socket:[1234]", os.O_RDWR)
+	fakeOpen(t, root, 1234, 5, "anon_inode:[eventpoll]", os.O_RDWR)
+	fakeProcess(t, root, 42, "init")
+	// Not processes
+	if err := os.MkdirAll(filepath.Join(root, "sys"), 0755); err != nil {
+		t.Fatalf("create fake proc: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "99"), nil, 0644); err != nil {
+		t.Fatalf("create fake proc: %v", err)
+	}
+
+	proc := procFS{root: root}
+	pids, err := proc.pids()
+	if err != nil {
+		t.Fatalf("pids: %v", err)
+	}
+	// In directory order, which is by name
+	if !reflect.DeepEqual(pids, []uint32{1234, 42}) {
+		t.Errorf("expected PIDs 42 and 1234, got %v", pids)
+	}
+
+	files, err := proc.openFiles(1234)
+	if err != nil {
+		t.Fatalf("openFiles: %v", err)
+	}
+	expected := map[uint32]string{0: "/dev/null", 3: "/etc/passwd"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+
+	flags, err := proc.fdFlags(1234, 3)
+	if err != nil {
+		t.Fatalf("fdFlags: %v", err)
+	}
+	if flags != int32(os.O_RDONLY|syscall.O_CLOEXEC) {
+		t.Errorf("expected flags %o, got %o", os.O_RDONLY|syscall.O_CLOEXEC, flags)
+	}
+
+	uid, err := proc.uid(1234)
+	if err != nil {
+		t.Fatalf("uid: %v", err)
+	}
+	if uid != uint32(os.Getuid()) {
+		t.Errorf("expected UID %d, got %d", os.Getuid(), uid)
+	}
+
+	if _, err := proc.openFiles(9999); err == nil {
+		t.Error("expected an error for a missing process")
+	}
+	if _, err := proc.fdFlags(1234, 9); err == nil {
+		t.Error("expected an error for a missing fd")
+	}
+}
+
+func TestProcFS_ContainerID(t *testing.T) {
+	const id = "3f4e8d2c1b0a99887766554433221100ffeeddccbbaa00112233445566778899"
+	tests := []struct {
+		name   string
+		cgroup string
+		want   string
+	}{
+		{"docker systemd", "0::/system.slice/docker-" + id + ".scope\n", id},
+		{"containerd", "0::/kubepods.slice/kubepods-pod1.slice/cri-containerd-" + id + ".scope\n", id},
+		{"crio", "0::/kubepods/besteffort/pod1/crio-" + id + "\n", id},
+		{"podman", "0::/machine.slice/libpod-" + id + ".scope/container\n", id},
+		{"docker cgroupfs v1", "12:pids:/docker/" + id + "\n1:name=systemd:/docker/" + id + "\n", id},
+		{"host", "0::/user.slice/user-1000.slice/session-2.scope\n", ""},
+		{"not hex", "0::/docker/" + strings.Repeat("z", 64) + "\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proc := fakeProc(t, map[uint32]string{42: "cat"})
+			if err := os.WriteFile(filepath.Join(proc.root, "42", "cgroup"), []byte(tt.cgroup), 0644); err != nil {
+				t.Fatalf("create fake cgroup: %v", err)
+			}
+
+			got, err := proc.containerID(42)
+			if err != nil {
+				t.Fatalf("containerID: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("containerID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := fakeProc(t, nil).containerID(43); err == nil {
+		t.Error("expected an error for a missing process")
+	}
+}
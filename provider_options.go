@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// ProviderOption configures NewRealEBPFProvider
+type ProviderOption func(*providerOptions) error
+
+// providerOptions is the configuration ProviderOptions build up
+type providerOptions struct {
+	pinPath      string // bpffs directory to pin the shared maps under, empty to not pin
+	ringbufBytes uint32 // size of the events ring buffer, 0 for the built-in size
+	enforceOnly  bool   // attach only the LSM hook, collecting no events
+	eventShards  int    // extra ring buffers events are spread over by CPU, 0 for none
+	objectPath   string // BPF object file to load instead of the embedded one
+
+	// Coalesce blocked_pids updates for this long, 0 to write each block at once
+	blockBatch time.Duration
+
+	// Events read ahead of processing, 0 to read them as they are processed
+	readBuffer int
+}
+
+// newProviderOptions applies opts, in order, to the default configuration
+func newProviderOptions(opts []ProviderOption) (providerOptions, error) {
+	var o providerOptions
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return providerOptions{}, err
+		}
+	}
+	return o, nil
+}
+
+// WithPinPath pins the blocked_pids and pid_violation_count maps under dir
+// while the provider is open, so other processes can inspect and update
+// them. An empty dir pins nothing.
+func WithPinPath(dir string) ProviderOption {
+	return func(o *providerOptions) error {
+		o.pinPath = dir
+		return nil
+	}
+}
+
+// WithRingbufBytes sizes the events ring buffer, trading memory for
+// headroom against dropped events during bursts. The kernel requires a
+// power of two that is a multiple of the page size; 0 keeps the built-in
+// 256 KB.
+func WithRingbufBytes(n uint32) ProviderOption {
+	return func(o *providerOptions) error {
+		pageSize := uint32(os.Getpagesize())
+		if n != 0 && (n&(n-1) != 0 || n%pageSize != 0) {
+			return fmt.Errorf("ring buffer size %d must be a power of two and a multiple of the %d byte page size", n, pageSize)
+		}
+		o.ringbufBytes = n
+		return nil
+	}
+}
+
+// maxEventShards bounds WithEventShards
+const maxEventShards = 256
+
+// WithEventShards spreads events over n ring buffers by CPU, each with its
+// own reader, instead of sending them all through one. This avoids contention
+// on the ring buffer on machines with many busy CPUs, at the cost of n times
+// the ring buffer memory and of events being merged back by timestamp, which
+// may delay them slightly. 0 or 1 keeps the single ring buffer.
+func WithEventShards(n int) ProviderOption {
+	return func(o *providerOptions) error {
+		if n < 0 || n > maxEventShards {
+			return fmt.Errorf("event shard count %d must be between 0 and %d", n, maxEventShards)
+		}
+		o.eventShards = n
+		return nil
+	}
+}
+
+// WithBPFObject loads the BPF programs from the object file at path, e.g. one
+// built where clang is available, instead of the object embedded at build
+// time. The object must define the programs and maps the provider uses and
+// the same event_t layout. An empty path keeps the embedded object.
+func WithBPFObject(path string) ProviderOption {
+	return func(o *providerOptions) error {
+		o.objectPath = path
+		return nil
+	}
+}
+
+// WithBlockBatching coalesces blocked_pids updates made within interval of
+// each other into one batch update, e.g. 5ms, so that many PIDs blocked at
+// once cost a few syscalls instead of one each. A blocked PID is only
+// enforced once its batch is written, up to interval later. 0 writes every
+// block at once.
+func WithBlockBatching(interval time.Duration) ProviderOption {
+	return func(o *providerOptions) error {
+		if interval < 0 {
+			return fmt.Errorf("block batch interval %v must not be negative", interval)
+		}
+		o.blockBatch = interval
+		return nil
+	}
+}
+
+// WithReadBuffer reads events in a goroutine of their own into a buffer of n
+// events, which ReadEvent takes them from. Slow processing, e.g. of alerts
+// sent over the network, then only holds up draining the ring buffer once
+// the buffer is full, so bursts are less likely to overflow the ring buffer
+// and drop events in the kernel. 0 reads events as they are processed.
+func WithReadBuffer(n int) ProviderOption {
+	return func(o *providerOptions) error {
+		if n < 0 || n > maxReadBuffer {
+			return fmt.Errorf("read buffer size %d must be between 0 and %d", n, maxReadBuffer)
+		}
+		o.readBuffer = n
+		return nil
+	}
+}
+
+// WithEnforceOnly attaches only the LSM hook that denies blocked PIDs, for
+// when the PIDs to block come from elsewhere, e.g. through the pinned
+// blocked_pids map. No tracepoints or ring buffer are set up, so there is no
+// event collection overhead, and ReadEvent fails with ErrEventsDisabled.
+func WithEnforceOnly() ProviderOption {
+	return func(o *providerOptions) error {
+		o.enforceOnly = true
+		return nil
+	}
+}
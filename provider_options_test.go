@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNewProviderOptions(t *testing.T) {
+	pageSize := uint32(os.Getpagesize())
+
+	tests := []struct {
+		name      string
+		opts      []ProviderOption
+		expected  providerOptions
+		expectErr bool
+	}{
+		{name: "defaults", expected: providerOptions{}},
+		{
+			name:     "pin path",
+			opts:     []ProviderOption{WithPinPath("/sys/fs/bpf/ebpfence")},
+			expected: providerOptions{pinPath: "/sys/fs/bpf/ebpfence"},
+		},
+		{
+			name:     "ring buffer size",
+			opts:     []ProviderOption{WithRingbufBytes(1 << 20)},
+			expected: providerOptions{ringbufBytes: 1 << 20},
+		},
+		{
+			name:     "enforce only",
+			opts:     []ProviderOption{WithEnforceOnly()},
+			expected: providerOptions{enforceOnly: true},
+		},
+		{
+			name:     "event shards",
+			opts:     []ProviderOption{WithEventShards(8)},
+			expected: providerOptions{eventShards: 8},
+		},
+		{
+			name:     "bpf object",
+			opts:     []ProviderOption{WithBPFObject("/opt/ebpfence/deny_new_reads.bpf.o")},
+			expected: providerOptions{objectPath: "/opt/ebpfence/deny_new_reads.bpf.o"},
+		},
+		{
+			name:     "block batching",
+			opts:     []ProviderOption{WithBlockBatching(5 * time.Millisecond)},
+			expected: providerOptions{blockBatch: 5 * time.Millisecond},
+		},
+		{
+			name:     "read buffer",
+			opts:     []ProviderOption{WithReadBuffer(4096)},
+			expected: providerOptions{readBuffer: 4096},
+		},
+		{
+			name:     "later options win",
+			opts:     []ProviderOption{WithPinPath("/a"), WithRingbufBytes(1 << 20), WithPinPath("/b"), WithRingbufBytes(0)},
+			expected: providerOptions{pinPath: "/b"},
+		},
+		{name: "negative block batch interval", opts: []ProviderOption{WithBlockBatching(-time.Second)}, expectErr: true},
+		{name: "negative read buffer", opts: []ProviderOption{WithReadBuffer(-1)}, expectErr: true},
+		{name: "too large read buffer", opts: []ProviderOption{WithReadBuffer(maxReadBuffer + 1)}, expectErr: true},
+		{name: "negative event shards", opts: []ProviderOption{WithEventShards(-1)}, expectErr: true},
+		{name: "too many event shards", opts: []ProviderOption{WithEventShards(maxEventShards + 1)}, expectErr: true},
+		{name: "not a power of two", opts: []ProviderOption{WithRingbufBytes(3 * pageSize)}, expectErr: true},
+		{name: "smaller than a page", opts: []ProviderOption{WithRingbufBytes(pageSize / 2)}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := newProviderOptions(tt.opts)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", o)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if o != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, o)
+			}
+		})
+	}
+}
+
+func TestNewRealEBPFProvider_InvalidOption(t *testing.T) {
+	failing := func(*providerOptions) error { return errors.New("bad option") }
+	if _, err := NewRealEBPFProvider(WithPinPath("/tmp/pins"), failing); err == nil || err.Error() != "bad option" {
+		t.Errorf("expected the option's error before loading anything, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// EnforcementAction values
+const (
+	ActionDeny       = "deny"       // blocked PIDs are denied by the provider
+	ActionQuarantine = "quarantine" // blocked PIDs are moved into QuarantineCgroup instead
+)
+
+// moveToCgroup moves pid into the cgroup at dir, e.g. a frozen one, by
+// writing it to the cgroup's cgroup.procs. The file is not created, so a
+// path that is not a cgroup fails.
+func moveToCgroup(dir string, pid uint32) error {
+	f, err := os.OpenFile(filepath.Join(dir, "cgroup.procs"), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(strconv.FormatUint(uint64(pid), 10))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// inCgroup reports whether pid is in the cgroup at dir
+func inCgroup(dir string, pid uint32) (bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return false, err
+	}
+	want := strconv.FormatUint(uint64(pid), 10)
+	for _, member := range strings.Fields(string(data)) {
+		if member == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// quarantine moves pid into QuarantineCgroup, reporting whether it was
+// moved. A failure is logged rather than returned, and the caller denies the
+// PID through the provider instead: one process that cannot be moved, e.g.
+// because it already exited, must not stop the handler.
+func (h *EventHandler) quarantine(pid uint32) bool {
+	if err := moveToCgroup(h.config.QuarantineCgroup, pid); err != nil {
+		log.Printf("could not quarantine PID %d in cgroup %s, denying its opens instead: %v", pid, h.config.QuarantineCgroup, err)
+		return false
+	}
+	return true
+}
+
+// release moves an unblocked pid out of QuarantineCgroup into ReleaseCgroup.
+// Membership is checked rather than taken from the block record, so a PID
+// that was never moved, or was reused by a process outside the quarantine,
+// is left where it is. Without ReleaseCgroup the PID stays quarantined.
+func (h *EventHandler) release(pid uint32) error {
+	if h.config.EnforcementAction != ActionQuarantine {
+		return nil
+	}
+	quarantined, err := inCgroup(h.config.QuarantineCgroup, pid)
+	if err != nil {
+		return fmt.Errorf("checking whether PID %d is quarantined: %w", pid, err)
+	}
+	if !quarantined {
+		return nil
+	}
+	if h.config.ReleaseCgroup == "" {
+		log.Printf("PID %d is unblocked but stays quarantined in cgroup %s, no release cgroup is set", pid, h.config.QuarantineCgroup)
+		return nil
+	}
+	if err := moveToCgroup(h.config.ReleaseCgroup, pid); err != nil {
+		return fmt.Errorf("releasing PID %d into cgroup %s: %w", pid, h.config.ReleaseCgroup, err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeCgroupDir creates a fake cgroup directory with an empty cgroup.procs
+func fakeCgroupDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMoveToCgroup(t *testing.T) {
+	dir := fakeCgroupDir(t)
+	if err := moveToCgroup(dir, 4242); err != nil {
+		t.Fatalf("moveToCgroup: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "cgroup.procs")); err != nil || string(data) != "4242" {
+		t.Errorf("cgroup.procs = %q, %v, want 4242", data, err)
+	}
+
+	// A directory that is not a cgroup is left alone
+	notCgroup := t.TempDir()
+	if err := moveToCgroup(notCgroup, 4242); err == nil {
+		t.Error("expected an error for a directory without cgroup.procs")
+	}
+	if _, err := os.Stat(filepath.Join(notCgroup, "cgroup.procs")); !os.IsNotExist(err) {
+		t.Errorf("expected cgroup.procs not to be created, got %v", err)
+	}
+}
+
+func TestEventHandler_Quarantine(t *testing.T) {
+	tests := []struct {
+		name   string
+		cgroup func(t *testing.T) string
+		moved  bool
+	}{
+		{name: "moved", cgroup: fakeCgroupDir, moved: true},
+		// Failing to move the process is logged, not returned
+		{name: "missing cgroup", cgroup: func(t *testing.T) string { return filepath.Join(t.TempDir(), "missing") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewMockEBPFProvider(context.Background(), nil)
+			defer provider.Close()
+
+			cgroup := tt.cgroup(t)
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/shadow"},
+				Threshold:          1,
+				EnforcementAction:  ActionQuarantine,
+				QuarantineCgroup:   cgroup,
+			})
+
+			var result ProcessResult
+			var err error
+			output := captureStdout(t, func() {
+				result, err = handler.processEvent(CreateMockEvent(100, 1000, "cat", "/etc/shadow"))
+			})
+			if err != nil {
+				t.Fatalf("processEvent: %v", err)
+			}
+			if got := strings.Contains(output, "BLOCKED"); got == tt.moved {
+				t.Errorf("expected a block alert only if the PID was not moved (%v), got:\n%s", tt.moved, output)
+			}
+			if got := strings.Contains(output, "QUARANTINED in "+cgroup); got != tt.moved {
+				t.Errorf("expected a quarantine alert only if the PID was moved (%v), got:\n%s", tt.moved, output)
+			}
+			if !result.Blocked {
+				t.Error("expected the PID to be recorded as blocked")
+			}
+			// A PID that could not be moved is denied instead
+			if provider.IsBlocked(100) == tt.moved {
+				t.Errorf("expected the PID denied by the provider only if it was not moved (%v)", tt.moved)
+			}
+
+			data, _ := os.ReadFile(filepath.Join(cgroup, "cgroup.procs"))
+			if moved := string(data) == "100"; moved != tt.moved {
+				t.Errorf("cgroup.procs = %q, want the PID moved: %v", data, tt.moved)
+			}
+		})
+	}
+}
+
+func TestEventHandler_ReleaseQuarantined(t *testing.T) {
+	tests := []struct {
+		name     string
+		release  func(t *testing.T) string
+		released bool
+	}{
+		{name: "released", release: fakeCgroupDir, released: true},
+		{name: "no release cgroup", release: func(t *testing.T) string { return "" }},
+		// A failed move is a failed unblock
+		{name: "missing release cgroup", release: func(t *testing.T) string { return filepath.Join(t.TempDir(), "missing") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewMockEBPFProvider(context.Background(), nil)
+			defer provider.Close()
+
+			quarantine := fakeCgroupDir(t)
+			release := tt.release(t)
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns: []string{"/etc/shadow"},
+				Threshold:          1,
+				EnforcementAction:  ActionQuarantine,
+				QuarantineCgroup:   quarantine,
+				ReleaseCgroup:      release,
+			})
+			captureStdout(t, func() {
+				if _, err := handler.processEvent(CreateMockEvent(100, 1000, "cat", "/etc/shadow")); err != nil {
+					t.Fatalf("processEvent: %v", err)
+				}
+			})
+
+			err := handler.Reset(true)
+			failed := release != "" && !tt.released
+			if (err != nil) != failed {
+				t.Errorf("Reset error = %v, want one: %v", err, failed)
+			}
+			if got := handler.isBlocked(100); got != failed {
+				t.Errorf("expected the PID to stay recorded as blocked only if it failed to move (%v), got %v", failed, got)
+			}
+			if tt.released {
+				if data, err := os.ReadFile(filepath.Join(release, "cgroup.procs")); err != nil || string(data) != "100" {
+					t.Errorf("release cgroup.procs = %q, %v, want 100", data, err)
+				}
+			}
+		})
+	}
+}
+
+func TestInCgroup(t *testing.T) {
+	dir := fakeCgroupDir(t)
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte("12\n4242\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for pid, want := range map[uint32]bool{4242: true, 12: true, 424: false} {
+		if got, err := inCgroup(dir, pid); err != nil || got != want {
+			t.Errorf("inCgroup(%d) = %v, %v, want %v", pid, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// recordOpen notes that pid opened a file at now and reports whether that
+// makes more than RapidOpenThreshold opens within RapidOpenWindow. Only the
+// times of the last RapidOpenThreshold+1 opens are kept per PID.
+func (h *EventHandler) recordOpen(pid uint32, now time.Time) bool {
+	limit := int(h.config.RapidOpenThreshold) + 1
+	opens := h.openTimes[pid]
+	if opens == nil {
+		opens = &slidingWindow{window: h.config.RapidOpenWindow, max: limit}
+		h.openTimes[pid] = opens
+	}
+	return opens.add(now) == limit
+}
+
+// processRapidOpen blocks a PID that opens files faster than
+// RapidOpenThreshold per RapidOpenWindow, whichever files they are: opening
+// many files in a burst is how ransomware behaves. It reports whether the PID
+// was blocked.
+func (h *EventHandler) processRapidOpen(event *Event, comm, filename string) (bool, error) {
+	if h.config.RapidOpenThreshold == 0 || event.Type != EventOpen || h.isBlocked(event.Pid) {
+		return false, nil
+	}
+	if event.Pid == h.selfPID && !h.config.MonitorSelf {
+		return false, nil
+	}
+	if !h.recordOpen(event.Pid, h.clock.Now()) {
+		return false, nil
+	}
+
+	// Only report the burst once per window
+	delete(h.openTimes, event.Pid)
+
+	// Learning and trusted processes are never blocked, canaries only noted
+	if h.learner != nil || h.hasTrustedParent(event) {
+		return false, nil
+	}
+	fmt.Printf("%s[RAPID-OPEN] PID %d (%s) opened more than %d files within %v\n", h.relativeTime(),
+		event.Pid, comm, h.config.RapidOpenThreshold, h.config.RapidOpenWindow)
+	if h.reportOnlyPIDs[event.Pid] {
+		fmt.Printf("%s[REPORT-ONLY] PID %d (%s) reached the rapid-open limit and is not blocked\n", h.relativeTime(), event.Pid, comm)
+		return false, nil
+	}
+
+	h.blockedPIDs[event.Pid] = blockRecord{comm: comm}
+	if err := h.blockPID(event.Pid, BlockReasonRapidOpen, ""); err != nil {
+		return false, fmt.Errorf("failed to block PID: %w", err)
+	}
+	h.printBlocked(event.Pid)
+	h.policyFor(event).stats.Blocks++
+	h.audit("block", event, comm, filename, h.config.RapidOpenThreshold+1, h.config.RapidOpenThreshold, ProcessResult{Blocked: true})
+	return true, h.recordBlock(event, comm, filename)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEventHandler_RapidOpen(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow"},
+		Threshold:          2,
+		RapidOpenThreshold: 3,
+		RapidOpenWindow:    100 * time.Millisecond,
+		ReportOnlyPIDs:     []uint32{3000},
+	})
+	clock := newFakeClock(time.Unix(1000, 0))
+	handler.clock = clock
+
+	open := func(pid uint32, i int) ProcessResult {
+		t.Helper()
+		result, err := handler.processEvent(CreateMockEvent(pid, 1000, "app", fmt.Sprintf("/home/user/doc%d", i)))
+		if err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+		return result
+	}
+
+	// Opens spread out over more than the window are fine
+	for i := 0; i < 8; i++ {
+		if open(1000, i).Blocked {
+			t.Fatalf("open %d: expected PID 1000 not to be blocked", i)
+		}
+		clock.Advance(40 * time.Millisecond)
+	}
+
+	// A burst of more than 3 opens within 100ms blocks, whichever files
+	for i := 0; i < 3; i++ {
+		if open(2000, i).Blocked {
+			t.Fatalf("open %d: expected PID 2000 not to be blocked yet", i)
+		}
+		clock.Advance(10 * time.Millisecond)
+	}
+	if !open(2000, 3).Blocked {
+		t.Error("expected the 4th open within the window to block PID 2000")
+	}
+	if !provider.IsBlocked(2000) {
+		t.Error("expected PID 2000 to be blocked in the provider")
+	}
+	if provider.IsBlocked(1000) {
+		t.Error("expected other PIDs not to be affected")
+	}
+	if blocks := handler.PolicyStats()[defaultPolicyName].Blocks; blocks != 1 {
+		t.Errorf("expected the block to be credited to the default policy, got %d blocks", blocks)
+	}
+
+	// Report-only PIDs are never blocked
+	for i := 0; i < 8; i++ {
+		open(3000, i)
+	}
+	if provider.IsBlocked(3000) {
+		t.Error("expected the report-only PID not to be blocked")
+	}
+}
+
+func TestEventHandler_RapidOpenDisabled(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow"},
+		Threshold:          2,
+	})
+	handler.clock = newFakeClock(time.Unix(1000, 0))
+
+	for i := 0; i < 100; i++ {
+		if _, err := handler.processEvent(CreateMockEvent(1000, 1000, "app", fmt.Sprintf("/tmp/f%d", i))); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+	if provider.IsBlocked(1000) {
+		t.Error("expected no blocking without RapidOpenThreshold")
+	}
+}
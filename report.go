@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LogFormat values
+const (
+	LogFormatText = "text" // human-readable lines
+	LogFormatJSON = "json" // a single JSON document
+)
+
+// ShutdownReport summarises a run of the handler, for printing on exit
+type ShutdownReport struct {
+	Uptime     time.Duration    `json:"-"`
+	UptimeSecs float64          `json:"uptime_seconds"`
+	Events     uint64           `json:"events"`
+	Processed  uint64           `json:"processed"`
+	Violations uint32           `json:"violations"`
+	Blocked    []BlockedProcess `json:"blocked"`
+
+	Policies map[string]PolicyStats `json:"policies,omitempty"` // per policy and "default", only with Policies
+}
+
+// BlockedProcess is a PID blocked during the run and the disallowed files
+// that led to the block
+type BlockedProcess struct {
+	PID   uint32   `json:"pid"`
+	Comm  string   `json:"comm"`
+	Files []string `json:"files"`
+}
+
+// shutdownReport builds the report, with blocked PIDs sorted
+func (h *EventHandler) shutdownReport() ShutdownReport {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	report := ShutdownReport{Events: h.eventsRead, Processed: h.eventsProcessed, Blocked: []BlockedProcess{}}
+	if !h.startedAt.IsZero() {
+		report.Uptime = h.clock.Now().Sub(h.startedAt)
+		report.UptimeSecs = report.Uptime.Seconds()
+	}
+	for _, count := range h.violationCounts {
+		report.Violations += count
+	}
+	for pid, record := range h.blockedPIDs {
+		report.Blocked = append(report.Blocked, BlockedProcess{
+			PID:   pid,
+			Comm:  record.comm,
+			Files: h.sortedAccessedFiles(pid),
+		})
+	}
+	sort.Slice(report.Blocked, func(i, j int) bool { return report.Blocked[i].PID < report.Blocked[j].PID })
+	if len(h.policies) > 0 {
+		report.Policies = h.policyStats()
+	}
+	return report
+}
+
+// Report writes a summary of the run to w: uptime, events read and processed,
+// violations, those and the blocks of each policy if there are Policies, and
+// every PID blocked with the files that triggered it. It is
+// JSON if LogFormat is LogFormatJSON, text otherwise.
+func (h *EventHandler) Report(w io.Writer) error {
+	report := h.shutdownReport()
+	if h.config.LogFormat == LogFormatJSON {
+		return json.NewEncoder(w).Encode(report)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Shutdown report:\n")
+	fmt.Fprintf(&b, "  Uptime: %v\n", report.Uptime.Round(time.Second))
+	fmt.Fprintf(&b, "  Events read: %d\n", report.Events)
+	fmt.Fprintf(&b, "  Events processed: %d\n", report.Processed)
+	fmt.Fprintf(&b, "  Violations: %d\n", report.Violations)
+	names := make([]string, 0, len(report.Policies))
+	for name := range report.Policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "    Policy %s: %d violations, %d blocks\n", name, report.Policies[name].Violations, report.Policies[name].Blocks)
+	}
+	fmt.Fprintf(&b, "  Blocked PIDs: %d\n", len(report.Blocked))
+	for _, blocked := range report.Blocked {
+		files := make([]string, len(blocked.Files))
+		for i, filename := range blocked.Files {
+			files[i] = h.quotePath(filename)
+		}
+		fmt.Fprintf(&b, "    PID %d (%s): %s\n", blocked.PID, blocked.Comm, strings.Join(files, ", "))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
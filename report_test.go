@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runReported runs a handler over a known set of events for 90 seconds of
+// fake time and returns it for reporting
+func runReported(t *testing.T, format string) *EventHandler {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := []*Event{
+		CreateMockEvent(3000, 1000, "curl", "/etc/shadow"),
+		CreateMockEvent(1000, 1000, "cat", "/etc/passwd"),
+		CreateMockEvent(1000, 1000, "cat", "/tmp/notes.txt"),
+		CreateMockEvent(1000, 1000, "cat", "/etc/shadow"),
+		CreateMockEvent(2000, 1000, "vim", "/etc/hosts"),
+	}
+	provider := NewMockEBPFProvider(ctx, events)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Rules:              []Rule{{Pattern: "/etc/shadow", Immediate: true}},
+		Threshold:          2,
+		LogFormat:          format,
+	})
+	clock := newFakeClock(time.Unix(1000, 0))
+	handler.clock = clock
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.Run(ctx)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for handler.Stats().EventsRead < uint64(len(events)) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	clock.Advance(90 * time.Second)
+	cancel()
+	<-done
+
+	return handler
+}
+
+func TestEventHandler_ReportText(t *testing.T) {
+	handler := runReported(t, "")
+
+	var buf bytes.Buffer
+	if err := handler.Report(&buf); err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+
+	want := "Shutdown report:\n" +
+		"  Uptime: 1m30s\n" +
+		"  Events read: 5\n" +
+		"  Events processed: 5\n" +
+		"  Violations: 4\n" +
+		"  Blocked PIDs: 2\n" +
+		"    PID 1000 (cat): /etc/passwd, /etc/shadow\n" +
+		"    PID 3000 (curl): /etc/shadow\n"
+	if buf.String() != want {
+		t.Errorf("unexpected report:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestEventHandler_ReportJSON(t *testing.T) {
+	handler := runReported(t, LogFormatJSON)
+
+	var buf bytes.Buffer
+	if err := handler.Report(&buf); err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+
+	var report ShutdownReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("report is not JSON: %v\n%s", err, buf.String())
+	}
+	expected := ShutdownReport{
+		UptimeSecs: 90,
+		Events:     5,
+		Processed:  5,
+		Violations: 4,
+		Blocked: []BlockedProcess{
+			{PID: 1000, Comm: "cat", Files: []string{"/etc/passwd", "/etc/shadow"}},
+			{PID: 3000, Comm: "curl", Files: []string{"/etc/shadow"}},
+		},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("expected %+v, got %+v", expected, report)
+	}
+}
+
+func TestEventHandler_ReportPolicies(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          2,
+		Policies:           []Policy{{Name: "web", UIDs: []uint32{33}, Patterns: []string{"/var/www/*.key"}, Threshold: 1}},
+	})
+	for _, event := range []*Event{
+		CreateMockEvent(1000, 33, "nginx", "/var/www/site.key"),
+		CreateMockEvent(2000, 1000, "cat", "/etc/passwd"),
+	} {
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := handler.Report(&buf); err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	want := "  Violations: 2\n" +
+		"    Policy default: 1 violations, 0 blocks\n" +
+		"    Policy web: 1 violations, 1 blocks\n" +
+		"  Blocked PIDs: 1\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Errorf("expected the report to contain:\n%s\ngot:\n%s", want, buf.String())
+	}
+
+	stats := handler.Stats().Policies
+	if expected := map[string]PolicyStats{"web": {Violations: 1, Blocks: 1}, "default": {Violations: 1}}; !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected stats %v, got %v", expected, stats)
+	}
+}
+
+func TestEventHandler_ReportBeforeRun(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+	handler := NewEventHandler(provider, EventHandlerConfig{LogFormat: LogFormatJSON})
+
+	var buf bytes.Buffer
+	if err := handler.Report(&buf); err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	if want := `{"uptime_seconds":0,"events":0,"processed":0,"violations":0,"blocked":[]}` + "\n"; buf.String() != want {
+		t.Errorf("expected %s, got %s", want, buf.String())
+	}
+}
+
+func TestEventHandler_ReportEscapesFilenames(t *testing.T) {
+	const filename = "/tmp/a\n    PID 1 (init):\tüber"
+
+	for _, format := range []string{LogFormatText, LogFormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			provider := NewMockEBPFProvider(context.Background(), nil)
+			defer provider.Close()
+			handler := NewEventHandler(provider, EventHandlerConfig{
+				DisallowedPatterns: []string{"/tmp/*"},
+				Threshold:          1,
+				QuotePaths:         true,
+				LogFormat:          format,
+			})
+			if _, err := handler.processEvent(CreateMockEvent(1000, 1000, "cat", filename)); err != nil {
+				t.Fatalf("processEvent: %v", err)
+			}
+
+			var buf bytes.Buffer
+			if err := handler.Report(&buf); err != nil {
+				t.Fatalf("Report: %v", err)
+			}
+
+			if format == LogFormatJSON {
+				var report ShutdownReport
+				if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+					t.Fatalf("report is not JSON: %v\n%s", err, buf.String())
+				}
+				if files := report.Blocked[0].Files; len(files) != 1 || files[0] != filename {
+					t.Errorf("expected the filename to round-trip, got %q", files)
+				}
+				return
+			}
+			if want := `    PID 1000 (cat): "/tmp/a\n    PID 1 (init):\tüber"` + "\n"; !strings.HasSuffix(buf.String(), want) {
+				t.Errorf("expected the filename quoted on one line, got:\n%s", buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+// sampler keeps every rate-th event it is asked about, starting with the
+// first, the way the BPF programs sample events on each CPU
+type sampler struct {
+	rate uint32 // keep 1 in rate events, 0 or 1 to keep all
+	seen uint64
+}
+
+// keep counts one more event and reports whether it is sampled
+func (s *sampler) keep() bool {
+	if s.rate <= 1 {
+		return true
+	}
+	kept := s.seen%uint64(s.rate) == 0
+	s.seen++
+	return kept
+}
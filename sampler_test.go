@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSampler_Keep(t *testing.T) {
+	tests := []struct {
+		rate     uint32
+		expected []bool
+	}{
+		{rate: 0, expected: []bool{true, true, true}},
+		{rate: 1, expected: []bool{true, true, true}},
+		{rate: 2, expected: []bool{true, false, true, false}},
+		{rate: 3, expected: []bool{true, false, false, true, false, false, true}},
+	}
+
+	for _, tt := range tests {
+		s := &sampler{rate: tt.rate}
+		var kept []bool
+		for range tt.expected {
+			kept = append(kept, s.keep())
+		}
+		if !reflect.DeepEqual(kept, tt.expected) {
+			t.Errorf("rate %d: expected %v, got %v", tt.rate, tt.expected, kept)
+		}
+	}
+}
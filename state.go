@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// stateVersion is the version of the state file format
+const stateVersion = 1
+
+// handlerState is the handler state SaveState writes, for a restarted
+// instance to carry on counting where this one stopped
+type handlerState struct {
+	Version int        `json:"version"`
+	SavedAt time.Time  `json:"saved_at"`
+	PIDs    []pidState `json:"pids"`
+}
+
+// pidState is the state of one process
+type pidState struct {
+	PID        uint32      `json:"pid"`
+	Comm       string      `json:"comm"`
+	Violations uint32      `json:"violations"`
+	StartTime  uint64      `json:"start_time,omitempty"` // clock ticks since boot, 0 if unknown
+	Files      []string    `json:"files,omitempty"`      // distinct disallowed files accessed
+	Block      *blockState `json:"block,omitempty"`      // nil if not blocked
+}
+
+// blockState is why and when a process was blocked
+type blockState struct {
+	Reason BlockReason `json:"reason"`
+	Rule   uint32      `json:"rule"`
+	At     time.Time   `json:"at"`
+}
+
+// SaveState writes the violation counts and blocked PIDs as JSON, for
+// LoadState in a restarted instance. Processes that already exited, and PIDs
+// now held by another process than the one their state is for, are left out.
+func (h *EventHandler) SaveState(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	pids := make(map[uint32]bool)
+	for pid := range h.violationCounts {
+		pids[pid] = true
+	}
+	for pid := range h.blockedPIDs {
+		pids[pid] = true
+	}
+
+	state := handlerState{Version: stateVersion, SavedAt: h.clock.Now(), PIDs: []pidState{}}
+	for pid := range pids {
+		s := pidState{PID: pid, Violations: h.violationCounts[pid], Files: h.sortedAccessedFiles(pid)}
+		if record, ok := h.blockedPIDs[pid]; ok {
+			s.Comm = record.comm
+			s.Block = &blockState{Reason: record.info.Reason, Rule: record.info.Rule, At: time.Unix(0, int64(record.info.BlockedAt))}
+		} else {
+			comm, err := h.proc.comm(pid)
+			if err != nil {
+				continue
+			}
+			s.Comm = comm
+		}
+		// State for a process that exited, or whose PID was reused, would
+		// be restored to the wrong process
+		tracked, ok := h.pidStarts[pid]
+		if start, err := h.proc.startTime(pid); !ok || err != nil || start != tracked {
+			continue
+		}
+		s.StartTime = tracked
+		state.PIDs = append(state.PIDs, s)
+	}
+	sort.Slice(state.PIDs, func(i, j int) bool { return state.PIDs[i].PID < state.PIDs[j].PID })
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(state)
+}
+
+// LoadState restores state written by SaveState and reconciles the
+// provider's blocked list with it. A process with the same comm, as the
+// kernel truncates it, and the same start time gets its violations back and,
+// if it was blocked, is blocked again. A PID that exited, now runs another
+// command, belongs to a process started at another time or was saved without
+// a start time is dropped. Every PID the provider lists as blocked that was
+// not restored is unblocked, as the kernel map may have outlived it.
+func (h *EventHandler) LoadState(r io.Reader) error {
+	var state handlerState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return fmt.Errorf("decode state: %w", err)
+	}
+	if state.Version != stateVersion {
+		return fmt.Errorf("unsupported state version %d, want %d", state.Version, stateVersion)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var errs []error
+	for _, s := range state.PIDs {
+		comm, err := h.proc.comm(s.PID)
+		start, startErr := h.proc.startTime(s.PID)
+		if err != nil || startErr != nil || kernelComm(s.Comm) != comm || start != s.StartTime {
+			if s.Block != nil {
+				if err := h.provider.UnblockPID(s.PID); err != nil {
+					errs = append(errs, fmt.Errorf("unblock stale PID %d: %w", s.PID, err))
+				}
+			}
+			continue
+		}
+
+		h.pidStarts[s.PID] = start
+		if s.Violations > 0 {
+			h.violationCounts[s.PID] = s.Violations
+			h.recordViolationCount(s.PID)
+		}
+		for _, filename := range s.Files {
+			h.recordAccessedFile(s.PID, filename)
+		}
+		if s.Block == nil {
+			continue
+		}
+		info := BlockInfo{Reason: s.Block.Reason, Rule: s.Block.Rule, BlockedAt: uint64(s.Block.At.UnixNano())}
+		h.blockedPIDs[s.PID] = blockRecord{comm: s.Comm, info: info}
+		if h.config.EnforcementAction == ActionQuarantine {
+			record := h.blockedPIDs[s.PID]
+			if record.quarantined = h.quarantine(s.PID); record.quarantined {
+				h.blockedPIDs[s.PID] = record
+				continue
+			}
+		}
+		if err := h.provideBlock(s.PID, info); err != nil {
+			errs = append(errs, fmt.Errorf("block PID %d: %w", s.PID, err))
+		}
+	}
+
+	if lister, ok := h.provider.(blockLister); ok {
+		blocked, err := lister.ListBlockedPIDs()
+		if err != nil {
+			return errors.Join(append(errs, fmt.Errorf("list blocked PIDs: %w", err))...)
+		}
+		for _, pid := range blocked {
+			if _, ok := h.blockedPIDs[pid]; ok {
+				continue
+			}
+			if err := h.provider.UnblockPID(pid); err != nil {
+				errs = append(errs, fmt.Errorf("unblock stale PID %d: %w", pid, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// kernelComm truncates comm to the commLen bytes the kernel keeps
+func kernelComm(comm string) string {
+	if len(comm) > commLen {
+		return comm[:commLen]
+	}
+	return comm
+}
+
+// loadStateFile restores the handler state from path, if it exists
+func loadStateFile(h *EventHandler, path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return h.LoadState(f)
+}
+
+// saveStateFile writes the handler state to path, replacing it atomically so
+// a crash while saving leaves the previous state intact
+func saveStateFile(h *EventHandler, path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := h.SaveState(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stateHandler returns a handler on a fresh mock provider with processes
+// from comms, each started at ten times its PID
+func stateHandler(t *testing.T, comms map[uint32]string) (*EventHandler, *MockEBPFProvider) {
+	t.Helper()
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	t.Cleanup(func() { provider.Close() })
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/shadow", "/etc/passwd"},
+		Threshold:          2,
+	})
+	handler.proc = fakeProc(t, comms)
+	for pid, comm := range comms {
+		fakeStat(t, handler.proc, pid, comm, uint64(pid)*10)
+	}
+	handler.clock = newFakeClock(time.Unix(1000, 0))
+	return handler, provider
+}
+
+func TestEventHandler_StateRoundTrip(t *testing.T) {
+	comms := map[uint32]string{100: "cat", 200: "less"}
+	handler, _ := stateHandler(t, comms)
+	for _, event := range []*Event{
+		CreateMockEvent(100, 1000, "cat", "/etc/shadow"),
+		CreateMockEvent(100, 1000, "cat", "/etc/passwd"),
+		CreateMockEvent(200, 1000, "less", "/etc/shadow"),
+	} {
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := handler.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	if !strings.Contains(buf.String(), `"saved_at"`) {
+		t.Errorf("expected the state to be timestamped, got %s", buf.String())
+	}
+
+	// A restarted instance, with a fresh kernel map
+	restored, provider := stateHandler(t, comms)
+	if err := restored.LoadState(&buf); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	if got := restored.GetViolationCountForPID(100); got != 2 {
+		t.Errorf("PID 100 violations = %d, want 2", got)
+	}
+	if got := restored.GetViolationCountForPID(200); got != 1 {
+		t.Errorf("PID 200 violations = %d, want 1", got)
+	}
+	if !provider.IsBlocked(100) {
+		t.Error("expected PID 100 to be blocked again in the kernel")
+	}
+	if provider.IsBlocked(200) {
+		t.Error("expected PID 200 not to be blocked")
+	}
+	if summary := restored.GetBlockedSummary(); !reflect.DeepEqual(summary, map[string][]uint32{"cat": {100}}) {
+		t.Errorf("blocked summary = %v, want cat: [100]", summary)
+	}
+	want := BlockInfo{Reason: BlockReasonThreshold, Rule: 1, BlockedAt: uint64(time.Unix(1000, 0).UnixNano())}
+	if got := restored.blockedPIDs[100].info; got != want {
+		t.Errorf("block info = %+v, want %+v", got, want)
+	}
+	if got := restored.sortedAccessedFiles(100); !reflect.DeepEqual(got, []string{"/etc/passwd", "/etc/shadow"}) {
+		t.Errorf("accessed files = %v", got)
+	}
+
+	// The restored count carries on towards the threshold
+	event := CreateMockEvent(200, 1000, "less", "/etc/passwd")
+	if result, err := restored.processEvent(event); err != nil || !result.Blocked {
+		t.Errorf("expected PID 200 to be blocked at its second violation, got %+v, %v", result, err)
+	}
+}
+
+func TestEventHandler_SaveStateTrackedStart(t *testing.T) {
+	handler, _ := stateHandler(t, map[uint32]string{100: "cat", 200: "less", 300: "nc"})
+	for _, event := range []*Event{
+		CreateMockEvent(100, 1000, "cat", "/etc/shadow"),
+		CreateMockEvent(200, 1000, "less", "/etc/shadow"),
+	} {
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+	// Blocked outside event processing, and PID 200 reused since its
+	// violation, without TrackPIDReuse noticing
+	if err := handler.applyBlocklistEntry(BlocklistEntry{PID: 300}); err != nil {
+		t.Fatalf("applyBlocklistEntry: %v", err)
+	}
+	fakeStat(t, handler.proc, 200, "less", 9999)
+
+	var buf bytes.Buffer
+	if err := handler.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	var saved []uint32
+	for _, s := range decodeState(t, buf.Bytes()).PIDs {
+		saved = append(saved, s.PID)
+		if s.StartTime != uint64(s.PID)*10 {
+			t.Errorf("PID %d saved with start time %d, want %d", s.PID, s.StartTime, s.PID*10)
+		}
+	}
+	if !reflect.DeepEqual(saved, []uint32{100, 300}) {
+		t.Errorf("saved PIDs %v, want [100 300]", saved)
+	}
+}
+
+// decodeState decodes a state file SaveState wrote
+func decodeState(t *testing.T, data []byte) handlerState {
+	t.Helper()
+	var state handlerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("decoding state: %v", err)
+	}
+	return state
+}
+
+func TestEventHandler_LoadStateReconciles(t *testing.T) {
+	state := `{"version": 1, "saved_at": "2024-05-01T12:00:00Z", "pids": [
+		{"pid": 100, "comm": "cat", "violations": 2, "start_time": 1000, "block": {"reason": 1, "rule": 0, "at": "2024-05-01T11:00:00Z"}},
+		{"pid": 200, "comm": "nc", "violations": 3, "start_time": 2000, "block": {"reason": 1, "rule": 0, "at": "2024-05-01T11:00:00Z"}},
+		{"pid": 300, "comm": "curl", "violations": 5, "start_time": 3000, "block": {"reason": 1, "rule": 0, "at": "2024-05-01T11:00:00Z"}},
+		{"pid": 400, "comm": "less", "violations": 1, "start_time": 4000},
+		{"pid": 500, "comm": "systemd-journald", "violations": 1, "start_time": 5000},
+		{"pid": 700, "comm": "cat", "violations": 1, "start_time": 7000},
+		{"pid": 800, "comm": "vim", "violations": 1}
+	]}`
+
+	// PID 200 exited and PID 300 was reused by another command; both are
+	// still in the kernel map, which was pinned across the restart, as is
+	// PID 600, blocked after the state was saved. PID 700 now runs a command
+	// whose comm is only a prefix of the saved one, and PID 800 was saved
+	// without a start time.
+	handler, provider := stateHandler(t, map[uint32]string{100: "cat", 300: "bash", 400: "less", 500: "systemd-journal", 600: "nc", 700: "ca", 800: "vim"})
+	for _, pid := range []uint32{200, 300, 600} {
+		if err := provider.BlockPID(pid); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := handler.LoadState(strings.NewReader(state)); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	if !provider.IsBlocked(100) {
+		t.Error("expected the still running PID 100 to stay blocked")
+	}
+	for _, pid := range []uint32{200, 300, 600} {
+		if provider.IsBlocked(pid) {
+			t.Errorf("expected stale PID %d to be unblocked", pid)
+		}
+		if got := handler.GetViolationCountForPID(pid); got != 0 {
+			t.Errorf("stale PID %d violations = %d, want 0", pid, got)
+		}
+	}
+	if got := handler.GetViolationCountForPID(400); got != 1 {
+		t.Errorf("PID 400 violations = %d, want 1", got)
+	}
+	// A full command name matches the comm the kernel truncated
+	if got := handler.GetViolationCountForPID(500); got != 1 {
+		t.Errorf("PID 500 violations = %d, want 1", got)
+	}
+	for _, pid := range []uint32{700, 800} {
+		if got := handler.GetViolationCountForPID(pid); got != 0 {
+			t.Errorf("PID %d violations = %d, want 0", pid, got)
+		}
+	}
+	if summary := handler.GetBlockedSummary(); !reflect.DeepEqual(summary, map[string][]uint32{"cat": {100}}) {
+		t.Errorf("blocked summary = %v, want cat: [100]", summary)
+	}
+}
+
+func TestEventHandler_LoadStateVersion(t *testing.T) {
+	handler, _ := stateHandler(t, nil)
+	if err := handler.LoadState(strings.NewReader(`{"version": 2, "pids": []}`)); err == nil || !strings.Contains(err.Error(), "version 2") {
+		t.Errorf("expected an unsupported version error, got %v", err)
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	handler, _ := stateHandler(t, map[uint32]string{100: "cat"})
+
+	// A missing file is a first start
+	if err := loadStateFile(handler, path); err != nil {
+		t.Fatalf("loadStateFile: %v", err)
+	}
+
+	event := CreateMockEvent(100, 1000, "cat", "/etc/shadow")
+	if _, err := handler.processEvent(event); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveStateFile(handler, path); err != nil {
+		t.Fatalf("saveStateFile: %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected only the state file to be left, got %v, %v", entries, err)
+	}
+
+	restored, _ := stateHandler(t, map[uint32]string{100: "cat"})
+	if err := loadStateFile(restored, path); err != nil {
+		t.Fatalf("loadStateFile: %v", err)
+	}
+	if got := restored.GetViolationCountForPID(100); got != 1 {
+		t.Errorf("PID 100 violations = %d, want 1", got)
+	}
+}
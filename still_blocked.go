@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// stillBlocked is how many matching opens by a blocked PID the kernel denied
+// since the last summary
+type stillBlocked struct {
+	PID      uint32
+	Comm     string
+	Attempts uint64
+}
+
+// logStillBlocked prints a still-blocked summary every interval until the
+// context is cancelled
+func (h *EventHandler) logStillBlocked(ctx context.Context, interval time.Duration) {
+	ticks, stop := h.newTicker(interval)
+	defer stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticks:
+			h.printStillBlocked()
+		}
+	}
+}
+
+// printStillBlocked prints a [STILL BLOCKED] line for every blocked PID that
+// attempted matching opens since the last summary
+func (h *EventHandler) printStillBlocked() {
+	for _, s := range h.takeStillBlocked() {
+		fmt.Printf("%s[STILL BLOCKED] PID %d (%s) attempted %d more opens\n", h.relativeTime(), s.PID, s.Comm, s.Attempts)
+	}
+}
+
+// takeStillBlocked returns the attempts of blocked PIDs since it was last
+// called, by PID, and starts counting again from zero
+func (h *EventHandler) takeStillBlocked() []stillBlocked {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	summary := make([]stillBlocked, 0, len(h.blockedAttempts))
+	for pid, attempts := range h.blockedAttempts {
+		summary = append(summary, stillBlocked{PID: pid, Comm: h.blockedPIDs[pid].comm, Attempts: attempts})
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].PID < summary[j].PID })
+	h.blockedAttempts = make(map[uint32]uint64)
+	return summary
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventHandler_StillBlocked(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns:   []string{"/etc/*"},
+		Threshold:            1,
+		StillBlockedInterval: time.Minute,
+	})
+
+	// Only opens the kernel denied a blocked PID are attempts: not the opens
+	// that block it, and not denials of files no rule matches
+	for _, event := range []*Event{
+		CreateMockEvent(1000, 1000, "curl", "/etc/passwd"),
+		CreateMockDeniedEvent(1000, 1000, "curl", "/etc/shadow"),
+		CreateMockDeniedEvent(1000, 1000, "curl", "/tmp/safe"),
+		CreateMockDeniedEvent(1000, 1000, "curl", "/etc/hosts"),
+		CreateMockEvent(2000, 1000, "cat", "/etc/passwd"),
+		CreateMockDeniedEvent(2000, 1000, "cat", "/etc/passwd"),
+		CreateMockEvent(3000, 1000, "app", "/tmp/safe"),
+		// Blocked from outside this instance
+		CreateMockDeniedEvent(4000, 1000, "nc", "/etc/passwd"),
+	} {
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+
+	expected := []stillBlocked{
+		{PID: 1000, Comm: "curl", Attempts: 2},
+		{PID: 2000, Comm: "cat", Attempts: 1},
+	}
+	if got := handler.takeStillBlocked(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("takeStillBlocked() = %+v, want %+v", got, expected)
+	}
+
+	// Each summary covers the attempts since the previous one
+	if got := handler.takeStillBlocked(); len(got) != 0 {
+		t.Errorf("expected no attempts since the last summary, got %+v", got)
+	}
+	if _, err := handler.processEvent(CreateMockDeniedEvent(2000, 1000, "cat", "/etc/group")); err != nil {
+		t.Fatalf("processEvent: %v", err)
+	}
+	expected = []stillBlocked{{PID: 2000, Comm: "cat", Attempts: 1}}
+	if got := handler.takeStillBlocked(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("takeStillBlocked() = %+v, want %+v", got, expected)
+	}
+}
+
+func TestEventHandler_StillBlockedDisabled(t *testing.T) {
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/*"},
+		Threshold:          1,
+	})
+	for _, event := range []*Event{
+		CreateMockEvent(1000, 1000, "curl", "/etc/passwd"),
+		CreateMockDeniedEvent(1000, 1000, "curl", "/etc/shadow"),
+	} {
+		if _, err := handler.processEvent(event); err != nil {
+			t.Fatalf("processEvent: %v", err)
+		}
+	}
+	if got := handler.takeStillBlocked(); len(got) != 0 {
+		t.Errorf("expected attempts not to be tracked without StillBlockedInterval, got %+v", got)
+	}
+}
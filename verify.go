@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// blockDecision is a block the handler made while replaying events
+type blockDecision struct {
+	PID        uint32
+	Comm       string
+	Reason     BlockReason
+	Rule       string   // pattern of the event that triggered the block, empty if none
+	Violations uint32   // counted over the whole replay, including any after the block
+	Files      []string // disallowed files the PID accessed, sorted
+}
+
+// replayResult is the outcome of replaying a recorded event stream
+type replayResult struct {
+	Events int
+	Blocks []blockDecision // sorted by PID
+}
+
+// eventClock is the Clock of a replay, telling the time of the event being
+// replayed so windows and rates follow the recording's pace
+type eventClock struct {
+	now time.Time
+}
+
+func (c *eventClock) Now() time.Time {
+	return c.now
+}
+
+// replayEvents runs the events of a stream written by an EventEncoder
+// through a handler configured with config. Blocks only go to an in-memory
+// provider, and the PIDs of the recording are not looked up in /proc since
+// they are gone or are other processes by now.
+func replayEvents(config EventHandlerConfig, r io.Reader) (replayResult, error) {
+	var result replayResult
+	provider := NewMockEBPFProvider(context.Background(), nil)
+	defer provider.Close()
+
+	h := NewEventHandler(provider, config)
+	if h.configErr != nil {
+		return result, h.configErr
+	}
+	clock := &eventClock{}
+	h.clock = clock
+	h.proc = procFS{root: os.DevNull}
+	h.selfPID = 0
+
+	dec := NewEventDecoder(r)
+	for {
+		event, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("reading event %d: %w", result.Events+1, err)
+		}
+		result.Events++
+		clock.now = time.Unix(0, int64(event.Timestamp))
+		if _, err := h.processEvent(event); err != nil {
+			return result, fmt.Errorf("event %d: %w", result.Events, err)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for pid, record := range h.blockedPIDs {
+		result.Blocks = append(result.Blocks, blockDecision{
+			PID:        pid,
+			Comm:       record.comm,
+			Reason:     record.info.Reason,
+			Rule:       h.rulePattern(record.info.Rule),
+			Violations: h.violationCounts[pid],
+			Files:      h.sortedAccessedFiles(pid),
+		})
+	}
+	sort.Slice(result.Blocks, func(i, j int) bool {
+		return result.Blocks[i].PID < result.Blocks[j].PID
+	})
+	return result, nil
+}
+
+// writeReplayResult writes which PIDs a replay would have blocked and why,
+// one per line
+func writeReplayResult(w io.Writer, result replayResult) error {
+	if _, err := fmt.Fprintf(w, "Replayed %d event(s), %d PID(s) would be blocked\n", result.Events, len(result.Blocks)); err != nil {
+		return err
+	}
+	for _, block := range result.Blocks {
+		line := fmt.Sprintf("PID %d (%s): %s", block.PID, block.Comm, block.Reason)
+		if block.Rule != "" {
+			line += " on " + block.Rule
+		}
+		line += fmt.Sprintf(", %d violation(s)", block.Violations)
+		if len(block.Files) > 0 {
+			line += ", files: " + strings.Join(block.Files, ", ")
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseVerifyCommand parses the arguments of the verify command: the policy
+// flags of run and the recorded events to replay
+func parseVerifyCommand(args []string) (EventHandlerConfig, string, error) {
+	var config EventHandlerConfig
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	eventsPath := flags.String("events", "", "File of recorded events to replay")
+	policy := registerPolicyFlags(flags)
+	if err := applyEnv(flags); err != nil {
+		return config, "", fmt.Errorf("verify: %w", err)
+	}
+	if err := flags.Parse(args); err != nil {
+		return config, "", fmt.Errorf("verify: %w", err)
+	}
+	if flags.NArg() != 0 || *eventsPath == "" {
+		return config, "", fmt.Errorf("usage: ebpfence verify -events file [-disallowed patterns] [-immediate patterns] [-threshold n] ...")
+	}
+
+	if err := policy.parse(); err != nil {
+		return config, "", err
+	}
+	if policy.parsed.PolicyMode == PolicyDenylist && len(policy.parsed.Policies) == 0 {
+		if err := requireFileRules(policy.parsed.DisallowedPatterns, policy.parsed.Rules, nil); err != nil {
+			return config, "", err
+		}
+	}
+	policy.apply(&config)
+	return config, *eventsPath, nil
+}
+
+// verifyCommand replays recorded events against a policy and prints which
+// PIDs it would block and why, without loading BPF or blocking anything
+func verifyCommand(args []string) error {
+	config, eventsPath, err := parseVerifyCommand(args)
+	if err != nil {
+		return err
+	}
+	return verify(os.Stdout, config, eventsPath)
+}
+
+// verify replays the events recorded at path and writes the blocks to w
+func verify(w io.Writer, config EventHandlerConfig, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	defer f.Close()
+
+	result, err := replayEvents(config, bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	return writeReplayResult(w, result)
+}
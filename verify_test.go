@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeRecording writes events to a file the way a recording is made
+func writeRecording(t *testing.T, events []*Event) string {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := NewEventEncoder(&buf)
+	for _, event := range events {
+		if err := enc.Encode(event); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "recorded.bin")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerify(t *testing.T) {
+	var events []*Event
+	for i, e := range []struct {
+		pid      uint32
+		comm     string
+		filename string
+	}{
+		{100, "cat", "/etc/passwd"},
+		{300, "ls", "/tmp/notes"},
+		{200, "sh", "/etc/shadow"},
+		{400, "vim", "/etc/passwd"},
+		{100, "cat", "/etc/hosts"},
+		{100, "cat", "/etc/group"},
+	} {
+		event := CreateMockEvent(e.pid, 1000, e.comm, e.filename)
+		event.Timestamp = uint64(i+1) * 1e9
+		events = append(events, event)
+	}
+	path := writeRecording(t, events)
+
+	config, eventsPath, err := parseVerifyCommand([]string{
+		"-events", path,
+		"-disallowed", "/etc/passwd,/etc/hosts,/etc/group",
+		"-immediate", "/etc/shadow",
+		"-threshold", "2",
+	})
+	if err != nil {
+		t.Fatalf("parseVerifyCommand: %v", err)
+	}
+
+	// Replays are deterministic
+	var first string
+	for i := 0; i < 2; i++ {
+		var out bytes.Buffer
+		if err := verify(&out, config, eventsPath); err != nil {
+			t.Fatalf("verify: %v", err)
+		}
+		if i == 0 {
+			first = out.String()
+		} else if out.String() != first {
+			t.Errorf("expected the same decisions on every replay, got:\n%s\nthen:\n%s", first, out.String())
+		}
+	}
+
+	expected := "Replayed 6 event(s), 2 PID(s) would be blocked\n" +
+		"PID 100 (cat): threshold on /etc/hosts, 3 violation(s), files: /etc/group, /etc/hosts, /etc/passwd\n" +
+		"PID 200 (sh): immediate on /etc/shadow, 1 violation(s), files: /etc/shadow\n"
+	if first != expected {
+		t.Errorf("got:\n%s\nwant:\n%s", first, expected)
+	}
+}
+
+func TestVerify_Truncated(t *testing.T) {
+	path := writeRecording(t, []*Event{CreateMockEvent(100, 1000, "cat", "/etc/passwd")})
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data[:len(data)-3], 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = verify(&bytes.Buffer{}, EventHandlerConfig{DisallowedPatterns: []string{"/etc/passwd"}, Threshold: 1}, path)
+	if err == nil || !strings.Contains(err.Error(), "reading event 1") {
+		t.Errorf("expected an error reading the truncated event, got %v", err)
+	}
+}
+
+func TestParseVerifyCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		expectErr string
+	}{
+		{name: "no events", args: []string{"-disallowed", "/etc/passwd"}, expectErr: "usage"},
+		{name: "no patterns", args: []string{"-events", "x.bin"}, expectErr: "-disallowed"},
+		{name: "bad policy mode", args: []string{"-events", "x.bin", "-policy-mode", "maybe"}, expectErr: "-policy-mode"},
+		{name: "bad threshold", args: []string{"-events", "x.bin", "-disallowed", "/etc/passwd", "-threshold", "0"}, expectErr: "threshold"},
+		{name: "allowlist", args: []string{"-events", "x.bin", "-policy-mode", "allowlist", "-allowed", "/tmp/*"}},
+		{name: "bad ignore flags", args: []string{"-events", "x.bin", "-disallowed", "/etc/passwd", "-ignore-flags", "O_BOGUS"}, expectErr: "-ignore-flags"},
+		{name: "relative mount", args: []string{"-events", "x.bin", "-disallowed", "/etc/passwd", "-mounts", "data"}, expectErr: "-mounts"},
+		{name: "bad rapid-open window", args: []string{"-events", "x.bin", "-disallowed", "/etc/passwd", "-rapid-open-threshold", "5", "-rapid-open-window", "0s"}, expectErr: "-rapid-open-window"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseVerifyCommand(tt.args)
+			if tt.expectErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+				t.Errorf("expected an error mentioning %q, got %v", tt.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestParseVerifyCommand_RunPolicyFlags(t *testing.T) {
+	config, _, err := parseVerifyCommand([]string{
+		"-events", "x.bin",
+		"-disallowed", "/etc/passwd",
+		"-ignore-flags", "O_PATH",
+		"-mounts", "/data",
+		"-byte-threshold", "4096",
+		"-rapid-open-threshold", "50",
+		"-rapid-open-window", "500ms",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(config.IgnoreFlags) != 1 || config.IgnoreFlags[0] != "O_PATH" {
+		t.Errorf("expected IgnoreFlags [O_PATH], got %v", config.IgnoreFlags)
+	}
+	if len(config.MountFilter) != 1 || config.MountFilter[0] != "/data" {
+		t.Errorf("expected MountFilter [/data], got %v", config.MountFilter)
+	}
+	if config.ByteThreshold != 4096 {
+		t.Errorf("expected ByteThreshold 4096, got %d", config.ByteThreshold)
+	}
+	if config.RapidOpenThreshold != 50 || config.RapidOpenWindow != 500*time.Millisecond {
+		t.Errorf("expected a rapid-open limit of 50 within 500ms, got %d within %v", config.RapidOpenThreshold, config.RapidOpenWindow)
+	}
+}
+
+func TestVerify_Recorded(t *testing.T) {
+	events := []*Event{
+		CreateMockEvent(100, 1000, "cat", "/etc/passwd"),
+		CreateMockEvent(200, 1000, "sh", "/etc/hosts"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	provider := NewMockEBPFProvider(ctx, events)
+	defer provider.Close()
+
+	path := filepath.Join(t.TempDir(), "recorded.bin")
+	handler := NewEventHandler(provider, EventHandlerConfig{
+		DisallowedPatterns: []string{"/etc/passwd"},
+		Threshold:          2,
+		RecordPath:         path,
+	})
+	if err := handler.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if blocked := handler.GetBlockedPIDs(); len(blocked) != 0 {
+		t.Fatalf("expected nothing blocked under the recording policy, got %v", blocked)
+	}
+
+	// The recording replays against a stricter policy
+	config, eventsPath, err := parseVerifyCommand([]string{"-events", path, "-disallowed", "/etc/*", "-threshold", "1"})
+	if err != nil {
+		t.Fatalf("parseVerifyCommand: %v", err)
+	}
+	var out bytes.Buffer
+	if err := verify(&out, config, eventsPath); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "Replayed 2 event(s), 2 PID(s) would be blocked\n") {
+		t.Errorf("unexpected replay of the recording:\n%s", out.String())
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import "time"
+
+// slidingWindow counts events over the last window, e.g. the opens of one
+// PID or the blocks of all of them
+type slidingWindow struct {
+	window time.Duration
+	max    int         // keep only the latest max times, 0 for all in the window
+	times  []time.Time // oldest first
+}
+
+// add records an event at now and returns how many fell within the window,
+// up to max
+func (w *slidingWindow) add(now time.Time) int {
+	w.times = append(w.times, now)
+	if w.max > 0 && len(w.times) > w.max {
+		copy(w.times, w.times[len(w.times)-w.max:])
+		w.times = w.times[:w.max]
+	}
+	return w.count(now)
+}
+
+// count drops the times that fell out of the window and returns how many
+// are left
+func (w *slidingWindow) count(now time.Time) int {
+	expired := 0
+	for expired < len(w.times) && now.Sub(w.times[expired]) > w.window {
+		expired++
+	}
+	w.times = w.times[:copy(w.times, w.times[expired:])]
+	return len(w.times)
+}
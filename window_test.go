@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	start := time.Unix(1000, 0)
+	w := slidingWindow{window: time.Second}
+
+	for i, expected := range []int{1, 2, 3} {
+		if got := w.add(start.Add(time.Duration(i) * 400 * time.Millisecond)); got != expected {
+			t.Errorf("add %d: expected %d in the window, got %d", i, expected, got)
+		}
+	}
+
+	// At 1.2s the first add, at 0s, has fallen out
+	if got := w.count(start.Add(1200 * time.Millisecond)); got != 2 {
+		t.Errorf("expected 2 in the window, got %d", got)
+	}
+	if got := w.count(start.Add(time.Hour)); got != 0 {
+		t.Errorf("expected an empty window, got %d", got)
+	}
+}
+
+func TestSlidingWindow_Max(t *testing.T) {
+	start := time.Unix(1000, 0)
+	w := slidingWindow{window: time.Minute, max: 2}
+
+	for i := 0; i < 5; i++ {
+		if got := w.add(start.Add(time.Duration(i) * time.Second)); got > 2 {
+			t.Errorf("add %d: expected at most 2 times kept, got %d", i, got)
+		}
+	}
+	if len(w.times) != 2 || !w.times[0].Equal(start.Add(3*time.Second)) {
+		t.Errorf("expected the latest 2 times to be kept, got %v", w.times)
+	}
+}